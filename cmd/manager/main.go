@@ -13,6 +13,7 @@ import (
 
 	"github.com/toha10/rabbitmq-operator/pkg/apis"
 	"github.com/toha10/rabbitmq-operator/pkg/controller"
+	"github.com/toha10/rabbitmq-operator/pkg/webhook"
 
 	"github.com/operator-framework/operator-sdk/pkg/k8sutil"
 	kubemetrics "github.com/operator-framework/operator-sdk/pkg/kube-metrics"
@@ -53,6 +54,9 @@ func main() {
 	// controller-runtime)
 	pflag.CommandLine.AddGoFlagSet(flag.CommandLine)
 
+	enableWebhooks := pflag.Bool("enable-webhooks", true, "Serve the admission webhooks of the RabbitMQ resources")
+	webhookPort := pflag.Int32("webhook-port", 9443, "The port the admission webhooks listen on")
+
 	pflag.Parse()
 
 	// Use a zap logr.Logger implementation. If none of the zap
@@ -113,6 +117,24 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Setup the admission webhooks
+	if *enableWebhooks {
+		operatorNs, err := k8sutil.GetOperatorNamespace()
+		if err != nil {
+			log.Error(err, "Failed to get the operator namespace, run with --enable-webhooks=false outside of the cluster")
+			os.Exit(1)
+		}
+		err = webhook.AddToManager(mgr, webhook.Options{
+			Port:      *webhookPort,
+			Namespace: operatorNs,
+			Selector:  map[string]string{"name": "rabbitmq-operator"},
+		})
+		if err != nil {
+			log.Error(err, "")
+			os.Exit(1)
+		}
+	}
+
 	if err = serveCRMetrics(cfg); err != nil {
 		log.Info("Could not generate and serve custom resource metrics", "error", err.Error())
 	}
@@ -2,7 +2,9 @@ module github.com/toha10/rabbitmq-operator
 
 require (
 	github.com/NYTimes/gziphandler v1.0.1 // indirect
+	github.com/coreos/prometheus-operator v0.29.0
 	github.com/operator-framework/operator-sdk v0.10.1-0.20190820174346-abac23c897b8
+	github.com/prometheus/client_golang v0.9.3-0.20190127221311-3c4408c8b829
 	github.com/spf13/pflag v1.0.3
 	k8s.io/api v0.0.0-20190612125737-db0771252981
 	k8s.io/apimachinery v0.0.0-20190612125636-6a5db36e93ad
@@ -28,3 +30,6 @@ replace (
 )
 
 replace github.com/operator-framework/operator-sdk => github.com/operator-framework/operator-sdk v0.9.0
+
+// bitbucket.org/ww/goautoneg has been removed, the mirror has the same code
+replace bitbucket.org/ww/goautoneg => github.com/munnerz/goautoneg v0.0.0-20190414153302-2ae31c8b6b30
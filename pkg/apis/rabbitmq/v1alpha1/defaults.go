@@ -0,0 +1,35 @@
+package v1alpha1
+
+import (
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// DefaultImage is the RabbitMQ image of a cluster without spec.image
+	DefaultImage = "rabbitmq:3.8"
+	// DefaultServiceAccount is the service account of the pods of a cluster without spec.service_account
+	DefaultServiceAccount = "default"
+)
+
+// DefaultDataVolumeSize is the size of the data volumes of a cluster without spec.data_volume_size
+var DefaultDataVolumeSize = resource.MustParse("1Gi")
+
+// SetDefaults fills in the optional fields of the spec left empty. The CRDs of Kubernetes 1.13
+// can't declare defaults, so the operator applies them to the spec it reconciles.
+func (cr *RabbitMQ) SetDefaults() {
+	if cr.Spec.Image == "" {
+		cr.Spec.Image = DefaultImage
+	}
+	if cr.Spec.ServiceAccount == "" {
+		cr.Spec.ServiceAccount = DefaultServiceAccount
+	}
+	if cr.Spec.DataVolumeSize.IsZero() {
+		cr.Spec.DataVolumeSize = DefaultDataVolumeSize.DeepCopy()
+	}
+	if cr.Spec.Persistence == nil {
+		cr.Spec.Persistence = &PersistenceSpec{}
+	}
+	if cr.Spec.Persistence.ReclaimPolicy == "" {
+		cr.Spec.Persistence.ReclaimPolicy = ReclaimRetain
+	}
+}
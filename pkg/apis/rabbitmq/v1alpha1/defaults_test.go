@@ -0,0 +1,47 @@
+package v1alpha1
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestSetDefaults(t *testing.T) {
+	cr := &RabbitMQ{Spec: RabbitMQSpec{Replicas: 3}}
+	cr.SetDefaults()
+	want := RabbitMQSpec{
+		Replicas:       3,
+		Image:          DefaultImage,
+		ServiceAccount: DefaultServiceAccount,
+		DataVolumeSize: resource.MustParse("1Gi"),
+		Persistence:    &PersistenceSpec{ReclaimPolicy: ReclaimRetain},
+	}
+	if !reflect.DeepEqual(cr.Spec, want) {
+		t.Errorf("got %+v, want %+v", cr.Spec, want)
+	}
+}
+
+func TestSetDefaultsKeepsTheSpec(t *testing.T) {
+	spec := RabbitMQSpec{
+		Replicas:       5,
+		Image:          "rabbitmq:3.7.18",
+		ServiceAccount: "rabbitmq",
+		DataVolumeSize: resource.MustParse("10Gi"),
+		Persistence:    &PersistenceSpec{ReclaimPolicy: ReclaimDelete},
+	}
+	cr := &RabbitMQ{Spec: *spec.DeepCopy()}
+	cr.SetDefaults()
+	if !reflect.DeepEqual(cr.Spec, spec) {
+		t.Errorf("got %+v, want %+v", cr.Spec, spec)
+	}
+}
+
+func TestSetDefaultsDoesNotShareTheDefaultSize(t *testing.T) {
+	cr := &RabbitMQ{}
+	cr.SetDefaults()
+	cr.Spec.DataVolumeSize.Add(resource.MustParse("1Gi"))
+	if DefaultDataVolumeSize.Cmp(resource.MustParse("1Gi")) != 0 {
+		t.Errorf("the default size has been changed to %s", DefaultDataVolumeSize.String())
+	}
+}
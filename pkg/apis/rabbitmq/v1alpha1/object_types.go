@@ -0,0 +1,59 @@
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ObjectSynced means that the object declared by the resource exists in the RabbitMQ cluster as specified
+const ObjectSynced RabbitMQConditionType = "Synced"
+
+// ObjectConflict means that the object exists in the RabbitMQ cluster with properties which differ from
+// the resource and can't be changed without deleting it, e.g. the arguments of a queue
+const ObjectConflict RabbitMQConditionType = "Conflict"
+
+// ObjectStatus is the observed state of a resource declaring an object inside a RabbitMQ cluster,
+// e.g. a vhost or a user
+// +k8s:openapi-gen=true
+type ObjectStatus struct {
+	// ObservedGeneration is the most recent generation of the resource observed by the operator
+	ObservedGeneration int64 `json:"observed_generation,omitempty"`
+	// Conditions is the list of the current conditions of the object
+	Conditions []RabbitMQCondition `json:"conditions,omitempty"`
+}
+
+// SetCondition adds or replaces the condition of the same type, the transition time
+// is only moved forward when the status of the condition changes
+func SetCondition(conditions []RabbitMQCondition, condition RabbitMQCondition) []RabbitMQCondition {
+	for i := range conditions {
+		existing := &conditions[i]
+		if existing.Type != condition.Type {
+			continue
+		}
+		if existing.Status == condition.Status {
+			condition.LastTransitionTime = existing.LastTransitionTime
+		} else {
+			condition.LastTransitionTime = metav1.Now()
+		}
+		*existing = condition
+		return conditions
+	}
+	condition.LastTransitionTime = metav1.Now()
+	return append(conditions, condition)
+}
+
+// FindCondition returns the condition of the type or nil
+func FindCondition(conditions []RabbitMQCondition, t RabbitMQConditionType) *RabbitMQCondition {
+	for i := range conditions {
+		if conditions[i].Type == t {
+			return &conditions[i]
+		}
+	}
+	return nil
+}
+
+// IsConditionTrue reports whether the condition of the type is present and true
+func IsConditionTrue(conditions []RabbitMQCondition, t RabbitMQConditionType) bool {
+	condition := FindCondition(conditions, t)
+	return condition != nil && condition.Status == corev1.ConditionTrue
+}
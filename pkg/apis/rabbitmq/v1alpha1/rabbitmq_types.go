@@ -1,27 +1,314 @@
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 // RabbitMQSpec defines the desired state of RabbitMQ
 // +k8s:openapi-gen=true
 type RabbitMQSpec struct {
-	Replicas 			int32 	`json:"replicas"`
-	Image				string	`json:"image"`
-	ServiceAccount		string	`json:"service_account"`
-	DiscoveryService	string 	`json:"discovery_service"`
-	Vhost 				string 	`json:"vhost,omitempty"`
-	DataVolumeSize		resource.Quantity	`json:"data_volume_size"`
+	// Replicas is the number of cluster members
+	// +kubebuilder:validation:Minimum=1
+	Replicas int32 `json:"replicas"`
+	// Image is the RabbitMQ image, its tag is expected to start with the RabbitMQ version.
+	// Defaults to DefaultImage.
+	// +kubebuilder:validation:Pattern=^[a-zA-Z0-9][a-zA-Z0-9._\-/:]*(@sha256:[a-f0-9]{64})?$
+	Image string `json:"image,omitempty"`
+	// ServiceAccount is the service account of the pods, it needs permission to get endpoints
+	// for the peer discovery. Defaults to DefaultServiceAccount.
+	ServiceAccount string `json:"service_account,omitempty"`
+	// DiscoveryService is the name of the Service the clients connect to
+	// +kubebuilder:validation:MinLength=1
+	DiscoveryService string `json:"discovery_service"`
+	// Vhost is a virtual host created in the cluster
+	Vhost string `json:"vhost,omitempty"`
+	// DataVolumeSize is the size of the data volume of every member. Defaults to DefaultDataVolumeSize.
+	// When it is increased, the existing volumes are expanded in place if their storage class allows it.
+	DataVolumeSize resource.Quantity `json:"data_volume_size,omitempty"`
+	// Storage configures the data volumes of the members
+	Storage *StorageSpec `json:"storage,omitempty"`
+	// Persistence decides what happens to the data of the cluster when the resource is deleted
+	Persistence *PersistenceSpec `json:"persistence,omitempty"`
+	// ErlangCookieSecret is the name of an existing Secret with the Erlang cookie under the "cookie" key.
+	// If it is empty, the operator generates a random cookie into a Secret owned by the RabbitMQ resource.
+	ErlangCookieSecret string `json:"erlang_cookie_secret,omitempty"`
+	// AddressType is what the RabbitMQ node names are built from, "hostname" or "ip".
+	// Hostname based names are stable and used by default for new clusters. A cluster
+	// running with IP based names keeps them until "hostname" is set explicitly,
+	// then the operator renames the nodes one by one.
+	// +kubebuilder:validation:Enum=hostname,ip
+	AddressType string `json:"address_type,omitempty"`
+	// Plugins is the list of plugins enabled in addition to the ones the operator needs
+	Plugins []string `json:"plugins,omitempty"`
+	// AdditionalConfig holds "key = value" lines of rabbitmq.conf merged over the settings
+	// generated by the operator. A key replaces the default with the same name, the keys
+	// the operator manages, e.g. cluster_formation.*, can't be overridden.
+	AdditionalConfig string `json:"additional_config,omitempty"`
+	// AdvancedConfig is the content of advanced.config, an Erlang term for the settings
+	// rabbitmq.conf can't express
+	AdvancedConfig string `json:"advanced_config,omitempty"`
+	// TLS adds the TLS listeners for AMQP and the management API, the plain listeners stay
+	TLS *TLSSpec `json:"tls,omitempty"`
+	// Resources are the compute resources of the rabbitmq container. When a memory limit is set,
+	// the memory high watermark and the free disk limit of the nodes are derived from it.
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+	// NodeSelector restricts the nodes the pods are scheduled on to the nodes with the labels
+	NodeSelector map[string]string `json:"node_selector,omitempty"`
+	// Tolerations of the pods
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+	// Affinity of the pods. Unless it sets a pod anti-affinity, the operator adds a preferred
+	// anti-affinity between the members of the cluster, so they spread over the nodes and the
+	// zones; an empty podAntiAffinity turns that off.
+	Affinity *corev1.Affinity `json:"affinity,omitempty"`
+	// PriorityClassName is the priority class of the pods
+	PriorityClassName string `json:"priority_class_name,omitempty"`
+	// PodDisruptionBudget overrides the budget of the voluntary disruptions of the pods, e.g. the
+	// evictions of a node drain. By default a minority of the members may be unavailable at once.
+	PodDisruptionBudget *PodDisruptionBudgetSpec `json:"pod_disruption_budget,omitempty"`
+	// Monitoring exposes the Prometheus metrics of the nodes
+	Monitoring *MonitoringSpec `json:"monitoring,omitempty"`
+}
+
+// MonitoringSpec configures the Prometheus metrics of the nodes. The rabbitmq_prometheus plugin, available
+// since RabbitMQ 3.8, serves them on port 15692 of the client Service. When the Prometheus Operator is
+// installed, a ServiceMonitor scraping them is created too.
+// +k8s:openapi-gen=true
+type MonitoringSpec struct {
+	// Labels of the ServiceMonitor, they have to match the serviceMonitorSelector of the Prometheus resource
+	Labels map[string]string `json:"labels,omitempty"`
+	// Interval is how often the nodes are scraped, e.g. 30s. Defaults to the interval of Prometheus.
+	Interval string `json:"interval,omitempty"`
+}
+
+// PodDisruptionBudgetSpec overrides the PodDisruptionBudget of the cluster
+// +k8s:openapi-gen=true
+type PodDisruptionBudgetSpec struct {
+	// Disabled removes the PodDisruptionBudget
+	Disabled bool `json:"disabled,omitempty"`
+	// MaxUnavailable is the number or the percentage of the members which may be evicted at once.
+	// Defaults to the largest minority, a cluster of fewer than three members gets no budget then.
+	MaxUnavailable *intstr.IntOrString `json:"max_unavailable,omitempty"`
+}
+
+// StorageSpec configures the PersistentVolumeClaims of the data volumes
+// +k8s:openapi-gen=true
+type StorageSpec struct {
+	// StorageClassName is the storage class of the data volumes, the default storage class of the
+	// cluster is used if it is empty. The volumes of the existing members keep their storage class.
+	StorageClassName string `json:"storage_class_name,omitempty"`
+	// AccessModes of the data volumes. Defaults to ReadWriteOnce. The volumes of the existing members
+	// keep their access modes.
+	AccessModes []corev1.PersistentVolumeAccessMode `json:"access_modes,omitempty"`
+	// Labels added to the data volumes
+	Labels map[string]string `json:"labels,omitempty"`
+	// Annotations added to the data volumes
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// ReclaimPolicy is what happens to the data volumes of a deleted cluster
+type ReclaimPolicy string
+
+const (
+	// ReclaimRetain keeps the data volumes, a new RabbitMQ resource with the same name adopts them
+	ReclaimRetain ReclaimPolicy = "Retain"
+	// ReclaimDelete deletes the data volumes together with the cluster
+	ReclaimDelete ReclaimPolicy = "Delete"
+)
+
+// PersistenceSpec configures the lifecycle of the data of the cluster
+// +k8s:openapi-gen=true
+type PersistenceSpec struct {
+	// ReclaimPolicy is what happens to the data volumes when the resource is deleted, Retain or Delete.
+	// Defaults to Retain.
+	// +kubebuilder:validation:Enum=Retain,Delete
+	ReclaimPolicy ReclaimPolicy `json:"reclaim_policy,omitempty"`
+}
+
+// TLSSpec configures TLS for the cluster
+// +k8s:openapi-gen=true
+type TLSSpec struct {
+	// SecretName is the name of a Secret with the certificate and the private key of the nodes under
+	// the tls.crt and tls.key keys, as in a kubernetes.io/tls Secret, and optionally the CA certificate
+	// the peer certificates are verified with under ca.crt. The nodes are restarted one by one when
+	// the contents of the Secret change.
+	// +kubebuilder:validation:MinLength=1
+	SecretName string `json:"secret_name"`
+	// InterNode runs the Erlang distribution between the nodes and the CLI tools over TLS, the
+	// Secret has to hold a CA certificate then. The whole cluster is restarted when it is changed.
+	InterNode bool `json:"inter_node,omitempty"`
+}
+
+const (
+	// AddressTypeHostname builds the node names from the pod hostnames in the headless service
+	AddressTypeHostname = "hostname"
+	// AddressTypeIP builds the node names from the pod IP addresses
+	AddressTypeIP = "ip"
+)
+
+// RabbitMQConditionType is a valid value for RabbitMQCondition.Type
+type RabbitMQConditionType string
+
+const (
+	// RabbitMQAvailable means that a quorum of the cluster members is ready to serve clients
+	RabbitMQAvailable RabbitMQConditionType = "Available"
+	// RabbitMQProgressing means that the StatefulSet is being rolled out or scaled
+	RabbitMQProgressing RabbitMQConditionType = "Progressing"
+	// RabbitMQDegraded means that some of the desired cluster members are not ready
+	RabbitMQDegraded RabbitMQConditionType = "Degraded"
+	// RabbitMQReconcileError means that the last reconciliation of the resource has failed
+	RabbitMQReconcileError RabbitMQConditionType = "ReconcileError"
+	// RabbitMQNodeRenameBlocked means that a node can't be renamed to the address type of the spec
+	// yet, because the messages only it holds would be lost
+	RabbitMQNodeRenameBlocked RabbitMQConditionType = "NodeRenameBlocked"
+)
+
+// RabbitMQCondition describes the state of a RabbitMQ cluster at a certain point
+// +k8s:openapi-gen=true
+type RabbitMQCondition struct {
+	// Type of the condition
+	Type RabbitMQConditionType `json:"type"`
+	// Status of the condition, one of True, False, Unknown
+	Status corev1.ConditionStatus `json:"status"`
+	// Last time the condition transitioned from one status to another
+	LastTransitionTime metav1.Time `json:"last_transition_time,omitempty"`
+	// Machine-readable reason for the condition's last transition
+	Reason string `json:"reason,omitempty"`
+	// Human-readable message indicating details about the last transition
+	Message string `json:"message,omitempty"`
+}
+
+// ScalingStep is a step of removing a node from the cluster
+type ScalingStep string
+
+const (
+	// ScalingDraining moves the quorum queue replicas and the classic queue masters off the node
+	// being removed
+	ScalingDraining ScalingStep = "Draining"
+	// ScalingStoppingApp stops the RabbitMQ application on the node being removed
+	ScalingStoppingApp ScalingStep = "StoppingApp"
+	// ScalingForgettingNode removes the node from the cluster membership on a surviving node
+	ScalingForgettingNode ScalingStep = "ForgettingNode"
+	// ScalingShrinking removes the pod of the node from the StatefulSet
+	ScalingShrinking ScalingStep = "Shrinking"
+)
+
+// ScalingStatus describes a scale-down of the cluster in progress
+// +k8s:openapi-gen=true
+type ScalingStatus struct {
+	// From is the number of members the scale-down has started from
+	From int32 `json:"from"`
+	// To is the desired number of members
+	To int32 `json:"to"`
+	// Node is the name of the node being removed
+	Node string `json:"node,omitempty"`
+	// Pod is the name of the pod of the node being removed
+	Pod string `json:"pod,omitempty"`
+	// Step is the step of removing the node the operator is at
+	Step ScalingStep `json:"step,omitempty"`
+	// Message explains what the scale-down is waiting for
+	Message string `json:"message,omitempty"`
+}
+
+// RabbitMQPhase is a summary of the state of the cluster
+type RabbitMQPhase string
+
+const (
+	// RabbitMQPending means no member of the cluster is ready yet
+	RabbitMQPending RabbitMQPhase = "Pending"
+	// RabbitMQRunning means all members of the cluster are ready
+	RabbitMQRunning RabbitMQPhase = "Running"
+	// RabbitMQScaling means members are being added to or removed from the cluster
+	RabbitMQScaling RabbitMQPhase = "Scaling"
+	// RabbitMQUpgrading means the cluster is being upgraded to a new image
+	RabbitMQUpgrading RabbitMQPhase = "Upgrading"
+	// RabbitMQDegradedPhase means some members of the cluster are not ready
+	RabbitMQDegradedPhase RabbitMQPhase = "Degraded"
+	// RabbitMQFailed means the last reconciliation of the resource has failed
+	RabbitMQFailed RabbitMQPhase = "Failed"
+)
+
+// UpgradePhase is a phase of changing the RabbitMQ version of the cluster
+type UpgradePhase string
+
+const (
+	// UpgradeRefused means the version change is not supported, the cluster keeps running the old image
+	UpgradeRefused UpgradePhase = "Refused"
+	// UpgradeRollingOut restarts the pods one by one with the new image
+	UpgradeRollingOut UpgradePhase = "RollingOut"
+	// UpgradeEnablingFeatureFlags enables the stable feature flags of the new version
+	UpgradeEnablingFeatureFlags UpgradePhase = "EnablingFeatureFlags"
+	// UpgradeCompleted means all nodes run the new version
+	UpgradeCompleted UpgradePhase = "Completed"
+)
+
+// UpgradeStatus describes the last change of the image of the cluster
+// +k8s:openapi-gen=true
+type UpgradeStatus struct {
+	// FromImage and ToImage are the images the cluster is upgraded between
+	FromImage string `json:"from_image"`
+	ToImage   string `json:"to_image"`
+	// FromVersion and ToVersion are the RabbitMQ versions parsed from the image tags,
+	// empty if the tag doesn't carry a version
+	FromVersion string `json:"from_version,omitempty"`
+	ToVersion   string `json:"to_version,omitempty"`
+	// Phase is the phase of the upgrade
+	Phase UpgradePhase `json:"phase"`
+	// Message explains what the upgrade is waiting for or why it is refused
+	Message string `json:"message,omitempty"`
+}
+
+// StoragePhase is a phase of changing the data volumes of the cluster
+type StoragePhase string
+
+const (
+	// StorageRefused means the change of the data volumes is not supported, the volumes are left alone
+	StorageRefused StoragePhase = "Refused"
+	// StorageExpanding grows the PersistentVolumeClaims of the members to the new size
+	StorageExpanding StoragePhase = "Expanding"
+	// StorageRecreatingStatefulSet replaces the StatefulSet, keeping its pods, so its volume claim
+	// template matches the spec
+	StorageRecreatingStatefulSet StoragePhase = "RecreatingStatefulSet"
+)
+
+// StorageStatus describes a change of the data volumes in progress or refused
+// +k8s:openapi-gen=true
+type StorageStatus struct {
+	// Size is the size the data volumes are expanded to
+	Size string `json:"size,omitempty"`
+	// Phase is the phase of the change
+	Phase StoragePhase `json:"phase"`
+	// Message explains what the change is waiting for or why it is refused
+	Message string `json:"message,omitempty"`
 }
 
 // RabbitMQStatus defines the observed state of RabbitMQ
 // +k8s:openapi-gen=true
 type RabbitMQStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "operator-sdk generate k8s" to regenerate code after modifying this file
-	// Add custom validation using kubebuilder tags: https://book-v1.book.kubebuilder.io/beyond_basics/generating_crd.html
+	// ObservedGeneration is the most recent generation of the RabbitMQ resource observed by the operator
+	ObservedGeneration int64 `json:"observed_generation,omitempty"`
+	// Replicas is the number of desired members of the owned StatefulSet
+	Replicas int32 `json:"replicas"`
+	// ReadyReplicas is the number of ready members of the owned StatefulSet
+	ReadyReplicas int32 `json:"ready_replicas"`
+	// Nodes is the list of RabbitMQ node names of the cluster members
+	Nodes []string `json:"nodes,omitempty"`
+	// Image is the RabbitMQ image the owned StatefulSet is running
+	Image string `json:"image,omitempty"`
+	// Version is the RabbitMQ version parsed from the tag of the image
+	Version string `json:"version,omitempty"`
+	// Phase is a summary of the state of the cluster
+	Phase RabbitMQPhase `json:"phase,omitempty"`
+	// Conditions is the list of the current conditions of the cluster
+	Conditions []RabbitMQCondition `json:"conditions,omitempty"`
+	// Scaling is set while the cluster is being scaled down
+	Scaling *ScalingStatus `json:"scaling,omitempty"`
+	// Upgrade is the progress of the last change of the image
+	Upgrade *UpgradeStatus `json:"upgrade,omitempty"`
+	// Storage is set while the data volumes are being changed or when their change is refused
+	Storage *StorageStatus `json:"storage,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -29,6 +316,11 @@ type RabbitMQStatus struct {
 // RabbitMQ is the Schema for the rabbitmqs API
 // +k8s:openapi-gen=true
 // +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Replicas",type="integer",JSONPath=".spec.replicas",description="Desired number of members"
+// +kubebuilder:printcolumn:name="Ready",type="integer",JSONPath=".status.ready_replicas",description="Number of ready members"
+// +kubebuilder:printcolumn:name="Version",type="string",JSONPath=".status.version",description="RabbitMQ version"
+// +kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase",description="State of the cluster"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 type RabbitMQ struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
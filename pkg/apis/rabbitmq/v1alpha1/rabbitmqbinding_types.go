@@ -0,0 +1,79 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// RabbitMQBindingSpec defines the desired state of RabbitMQBinding. A binding is identified by all
+// its fields, none of them can be changed; a binding to another destination or with another routing
+// key needs another resource.
+// +k8s:openapi-gen=true
+type RabbitMQBindingSpec struct {
+	// Cluster is the name of the RabbitMQ resource in the same namespace the binding is created in
+	Cluster string `json:"cluster"`
+	// Vhost is the vhost of the exchange and the destination, defaults to "/"
+	Vhost string `json:"vhost,omitempty"`
+	// Source is the name of the exchange the messages are routed from
+	Source string `json:"source"`
+	// Destination is the name of the queue or the exchange the messages are routed to
+	Destination string `json:"destination"`
+	// DestinationType is queue or exchange, defaults to queue
+	DestinationType string `json:"destination_type,omitempty"`
+	// RoutingKey is the routing key of the binding
+	RoutingKey string `json:"routing_key,omitempty"`
+	// Arguments are the optional arguments of the binding, e.g. the headers matched by a headers exchange
+	Arguments runtime.RawExtension `json:"arguments,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// RabbitMQBinding is the Schema for the rabbitmqbindings API
+// +k8s:openapi-gen=true
+// +kubebuilder:subresource:status
+type RabbitMQBinding struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   RabbitMQBindingSpec `json:"spec,omitempty"`
+	Status ObjectStatus        `json:"status,omitempty"`
+}
+
+// ClusterName returns the name of the RabbitMQ resource the binding belongs to
+func (b *RabbitMQBinding) ClusterName() string {
+	return b.Spec.Cluster
+}
+
+// ObjectStatus returns the status of the resource
+func (b *RabbitMQBinding) ObjectStatus() *ObjectStatus {
+	return &b.Status
+}
+
+// BindingVhost returns the vhost of the binding
+func (b *RabbitMQBinding) BindingVhost() string {
+	if b.Spec.Vhost != "" {
+		return b.Spec.Vhost
+	}
+	return "/"
+}
+
+// BindingDestinationType returns whether the destination is a queue or an exchange
+func (b *RabbitMQBinding) BindingDestinationType() string {
+	if b.Spec.DestinationType != "" {
+		return b.Spec.DestinationType
+	}
+	return "queue"
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// RabbitMQBindingList contains a list of RabbitMQBinding
+type RabbitMQBindingList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []RabbitMQBinding `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&RabbitMQBinding{}, &RabbitMQBindingList{})
+}
@@ -0,0 +1,92 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// RabbitMQExchangeSpec defines the desired state of RabbitMQExchange. The type, the durability and
+// the arguments of an exchange can't be changed once it is declared.
+// +k8s:openapi-gen=true
+type RabbitMQExchangeSpec struct {
+	// Cluster is the name of the RabbitMQ resource in the same namespace the exchange is declared in
+	Cluster string `json:"cluster"`
+	// Name is the name of the exchange, defaults to the name of the resource. It can't be changed.
+	Name string `json:"name,omitempty"`
+	// Vhost is the vhost the exchange is declared in, defaults to "/". It can't be changed.
+	Vhost string `json:"vhost,omitempty"`
+	// Type is the type of the exchange: direct, fanout, topic, headers or the type of a plugin.
+	// Defaults to direct.
+	Type string `json:"type,omitempty"`
+	// Durable exchanges survive a restart of the broker, defaults to true
+	Durable *bool `json:"durable,omitempty"`
+	// AutoDelete exchanges are deleted when their last binding is removed
+	AutoDelete bool `json:"auto_delete,omitempty"`
+	// Arguments are the optional arguments of the exchange, e.g. {"alternate-exchange": "unrouted"}
+	Arguments runtime.RawExtension `json:"arguments,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// RabbitMQExchange is the Schema for the rabbitmqexchanges API
+// +k8s:openapi-gen=true
+// +kubebuilder:subresource:status
+type RabbitMQExchange struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   RabbitMQExchangeSpec `json:"spec,omitempty"`
+	Status ObjectStatus         `json:"status,omitempty"`
+}
+
+// ClusterName returns the name of the RabbitMQ resource the exchange belongs to
+func (e *RabbitMQExchange) ClusterName() string {
+	return e.Spec.Cluster
+}
+
+// ObjectStatus returns the status of the resource
+func (e *RabbitMQExchange) ObjectStatus() *ObjectStatus {
+	return &e.Status
+}
+
+// ExchangeName returns the name of the exchange in the cluster
+func (e *RabbitMQExchange) ExchangeName() string {
+	if e.Spec.Name != "" {
+		return e.Spec.Name
+	}
+	return e.Name
+}
+
+// ExchangeVhost returns the vhost the exchange is declared in
+func (e *RabbitMQExchange) ExchangeVhost() string {
+	if e.Spec.Vhost != "" {
+		return e.Spec.Vhost
+	}
+	return "/"
+}
+
+// ExchangeType returns the type of the exchange
+func (e *RabbitMQExchange) ExchangeType() string {
+	if e.Spec.Type != "" {
+		return e.Spec.Type
+	}
+	return "direct"
+}
+
+// IsDurable reports whether the exchange survives a restart of the broker
+func (e *RabbitMQExchange) IsDurable() bool {
+	return e.Spec.Durable == nil || *e.Spec.Durable
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// RabbitMQExchangeList contains a list of RabbitMQExchange
+type RabbitMQExchangeList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []RabbitMQExchange `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&RabbitMQExchange{}, &RabbitMQExchangeList{})
+}
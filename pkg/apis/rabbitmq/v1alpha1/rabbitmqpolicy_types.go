@@ -0,0 +1,103 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// PolicySpec defines the desired state of RabbitMQPolicy and RabbitMQOperatorPolicy
+// +k8s:openapi-gen=true
+type PolicySpec struct {
+	// Cluster is the name of the RabbitMQ resource in the same namespace the policy is set in
+	Cluster string `json:"cluster"`
+	// Name is the name of the policy, defaults to the name of the resource. It can't be changed.
+	Name string `json:"name,omitempty"`
+	// Vhost is the vhost the policy is set in, defaults to "/". It can't be changed.
+	Vhost string `json:"vhost,omitempty"`
+	// Pattern is the regular expression matching the names of the queues or exchanges the policy applies to
+	Pattern string `json:"pattern"`
+	// ApplyTo is what the policy applies to: queues, exchanges or all for policies, queues for
+	// operator policies. Defaults to all for policies and queues for operator policies.
+	ApplyTo string `json:"apply_to,omitempty"`
+	// Priority of the policy, the policy with the highest priority applies when several patterns match
+	Priority int32 `json:"priority,omitempty"`
+	// Definition is the map of the policy keys and values, e.g. {"ha-mode": "all"}
+	Definition runtime.RawExtension `json:"definition"`
+}
+
+// PolicyVhost returns the vhost the policy is set in
+func (s *PolicySpec) PolicyVhost() string {
+	if s.Vhost != "" {
+		return s.Vhost
+	}
+	return "/"
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// RabbitMQPolicy is the Schema for the rabbitmqpolicies API
+// +k8s:openapi-gen=true
+// +kubebuilder:subresource:status
+type RabbitMQPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   PolicySpec   `json:"spec,omitempty"`
+	Status ObjectStatus `json:"status,omitempty"`
+}
+
+// ClusterName returns the name of the RabbitMQ resource the policy belongs to
+func (p *RabbitMQPolicy) ClusterName() string {
+	return p.Spec.Cluster
+}
+
+// ObjectStatus returns the status of the resource
+func (p *RabbitMQPolicy) ObjectStatus() *ObjectStatus {
+	return &p.Status
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// RabbitMQPolicyList contains a list of RabbitMQPolicy
+type RabbitMQPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []RabbitMQPolicy `json:"items"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// RabbitMQOperatorPolicy is the Schema for the rabbitmqoperatorpolicies API. Operator policies
+// cap the queue settings the policies of the users can set, e.g. the maximum queue length.
+// +k8s:openapi-gen=true
+// +kubebuilder:subresource:status
+type RabbitMQOperatorPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   PolicySpec   `json:"spec,omitempty"`
+	Status ObjectStatus `json:"status,omitempty"`
+}
+
+// ClusterName returns the name of the RabbitMQ resource the operator policy belongs to
+func (p *RabbitMQOperatorPolicy) ClusterName() string {
+	return p.Spec.Cluster
+}
+
+// ObjectStatus returns the status of the resource
+func (p *RabbitMQOperatorPolicy) ObjectStatus() *ObjectStatus {
+	return &p.Status
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// RabbitMQOperatorPolicyList contains a list of RabbitMQOperatorPolicy
+type RabbitMQOperatorPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []RabbitMQOperatorPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&RabbitMQPolicy{}, &RabbitMQPolicyList{}, &RabbitMQOperatorPolicy{}, &RabbitMQOperatorPolicyList{})
+}
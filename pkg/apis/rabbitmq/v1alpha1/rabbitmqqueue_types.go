@@ -0,0 +1,97 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// queue types
+const (
+	ClassicQueue = "classic"
+	QuorumQueue  = "quorum"
+	StreamQueue  = "stream"
+)
+
+// RabbitMQQueueSpec defines the desired state of RabbitMQQueue. The type, the durability and the
+// arguments of a queue can't be changed once it is declared, a queue in the cluster with other
+// properties is reported with the Conflict condition and left as it is.
+// +k8s:openapi-gen=true
+type RabbitMQQueueSpec struct {
+	// Cluster is the name of the RabbitMQ resource in the same namespace the queue is declared in
+	Cluster string `json:"cluster"`
+	// Name is the name of the queue, defaults to the name of the resource. It can't be changed.
+	Name string `json:"name,omitempty"`
+	// Vhost is the vhost the queue is declared in, defaults to "/". It can't be changed.
+	Vhost string `json:"vhost,omitempty"`
+	// Type is the type of the queue: classic, quorum or stream. Defaults to the default queue type
+	// of the vhost.
+	Type string `json:"type,omitempty"`
+	// Durable queues survive a restart of the broker, defaults to true. Quorum queues and streams
+	// are always durable.
+	Durable *bool `json:"durable,omitempty"`
+	// AutoDelete queues are deleted when their last consumer unsubscribes
+	AutoDelete bool `json:"auto_delete,omitempty"`
+	// Arguments are the optional arguments of the queue, e.g. {"x-max-length": 1000}. The type of the
+	// queue is set with the type field rather than the x-queue-type argument.
+	Arguments runtime.RawExtension `json:"arguments,omitempty"`
+	// DeleteWithMessages allows the operator to delete the queue with the messages it still holds
+	// when the resource is deleted. Otherwise the resource is kept until the queue is drained.
+	DeleteWithMessages bool `json:"delete_with_messages,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// RabbitMQQueue is the Schema for the rabbitmqqueues API
+// +k8s:openapi-gen=true
+// +kubebuilder:subresource:status
+type RabbitMQQueue struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   RabbitMQQueueSpec `json:"spec,omitempty"`
+	Status ObjectStatus      `json:"status,omitempty"`
+}
+
+// ClusterName returns the name of the RabbitMQ resource the queue belongs to
+func (q *RabbitMQQueue) ClusterName() string {
+	return q.Spec.Cluster
+}
+
+// ObjectStatus returns the status of the resource
+func (q *RabbitMQQueue) ObjectStatus() *ObjectStatus {
+	return &q.Status
+}
+
+// QueueName returns the name of the queue in the cluster
+func (q *RabbitMQQueue) QueueName() string {
+	if q.Spec.Name != "" {
+		return q.Spec.Name
+	}
+	return q.Name
+}
+
+// QueueVhost returns the vhost the queue is declared in
+func (q *RabbitMQQueue) QueueVhost() string {
+	if q.Spec.Vhost != "" {
+		return q.Spec.Vhost
+	}
+	return "/"
+}
+
+// IsDurable reports whether the queue survives a restart of the broker
+func (q *RabbitMQQueue) IsDurable() bool {
+	return q.Spec.Durable == nil || *q.Spec.Durable
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// RabbitMQQueueList contains a list of RabbitMQQueue
+type RabbitMQQueueList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []RabbitMQQueue `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&RabbitMQQueue{}, &RabbitMQQueueList{})
+}
@@ -0,0 +1,77 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RabbitMQUserSpec defines the desired state of RabbitMQUser
+// +k8s:openapi-gen=true
+type RabbitMQUserSpec struct {
+	// Cluster is the name of the RabbitMQ resource in the same namespace the user is created in
+	Cluster string `json:"cluster"`
+	// Name is the name of the user, defaults to the name of the resource. It can't be changed.
+	Name string `json:"name,omitempty"`
+	// Tags of the user, e.g. administrator, monitoring or management
+	Tags []string `json:"tags,omitempty"`
+	// Permissions of the user, the permissions in the vhosts not listed here are revoked
+	Permissions []UserPermissions `json:"permissions,omitempty"`
+}
+
+// UserPermissions are the regular expressions matching the names of the resources
+// of a vhost the user can access
+// +k8s:openapi-gen=true
+type UserPermissions struct {
+	// Vhost is the name of the vhost
+	Vhost string `json:"vhost"`
+	// Configure matches the resources the user can create and delete
+	Configure string `json:"configure"`
+	// Write matches the resources the user can publish to
+	Write string `json:"write"`
+	// Read matches the resources the user can consume from
+	Read string `json:"read"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// RabbitMQUser is the Schema for the rabbitmqusers API. The credentials of the user
+// are kept in the Secret named after the resource with the "-credentials" suffix.
+// +k8s:openapi-gen=true
+// +kubebuilder:subresource:status
+type RabbitMQUser struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   RabbitMQUserSpec `json:"spec,omitempty"`
+	Status ObjectStatus     `json:"status,omitempty"`
+}
+
+// ClusterName returns the name of the RabbitMQ resource the user belongs to
+func (u *RabbitMQUser) ClusterName() string {
+	return u.Spec.Cluster
+}
+
+// ObjectStatus returns the status of the resource
+func (u *RabbitMQUser) ObjectStatus() *ObjectStatus {
+	return &u.Status
+}
+
+// Username returns the name of the user in the cluster
+func (u *RabbitMQUser) Username() string {
+	if u.Spec.Name != "" {
+		return u.Spec.Name
+	}
+	return u.Name
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// RabbitMQUserList contains a list of RabbitMQUser
+type RabbitMQUserList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []RabbitMQUser `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&RabbitMQUser{}, &RabbitMQUserList{})
+}
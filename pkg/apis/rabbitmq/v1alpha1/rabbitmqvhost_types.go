@@ -0,0 +1,72 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RabbitMQVhostSpec defines the desired state of RabbitMQVhost
+// +k8s:openapi-gen=true
+type RabbitMQVhostSpec struct {
+	// Cluster is the name of the RabbitMQ resource in the same namespace the vhost is created in
+	Cluster string `json:"cluster"`
+	// Name is the name of the vhost, defaults to the name of the resource. It can't be changed.
+	Name string `json:"name,omitempty"`
+	// DefaultQueueType is the type of the queues declared in the vhost without the x-queue-type
+	// argument: classic, quorum or stream
+	DefaultQueueType string `json:"default_queue_type,omitempty"`
+	// Limits of the vhost, an unset limit is removed
+	Limits *VhostLimits `json:"limits,omitempty"`
+}
+
+// VhostLimits are the limits of a vhost
+// +k8s:openapi-gen=true
+type VhostLimits struct {
+	// MaxConnections is the maximum number of client connections to the vhost
+	MaxConnections *int32 `json:"max_connections,omitempty"`
+	// MaxQueues is the maximum number of queues in the vhost
+	MaxQueues *int32 `json:"max_queues,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// RabbitMQVhost is the Schema for the rabbitmqvhosts API
+// +k8s:openapi-gen=true
+// +kubebuilder:subresource:status
+type RabbitMQVhost struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   RabbitMQVhostSpec `json:"spec,omitempty"`
+	Status ObjectStatus      `json:"status,omitempty"`
+}
+
+// ClusterName returns the name of the RabbitMQ resource the vhost belongs to
+func (v *RabbitMQVhost) ClusterName() string {
+	return v.Spec.Cluster
+}
+
+// ObjectStatus returns the status of the resource
+func (v *RabbitMQVhost) ObjectStatus() *ObjectStatus {
+	return &v.Status
+}
+
+// VhostName returns the name of the vhost in the cluster
+func (v *RabbitMQVhost) VhostName() string {
+	if v.Spec.Name != "" {
+		return v.Spec.Name
+	}
+	return v.Name
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// RabbitMQVhostList contains a list of RabbitMQVhost
+type RabbitMQVhostList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []RabbitMQVhost `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&RabbitMQVhost{}, &RabbitMQVhostList{})
+}
@@ -0,0 +1,10 @@
+package controller
+
+import (
+	"github.com/toha10/rabbitmq-operator/pkg/controller/rabbitmqqueue"
+)
+
+func init() {
+	// AddToManagerFuncs is a list of functions to create controllers and add them to a manager.
+	AddToManagerFuncs = append(AddToManagerFuncs, rabbitmqqueue.Add)
+}
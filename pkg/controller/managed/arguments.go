@@ -0,0 +1,56 @@
+package managed
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// DecodeArguments returns the arguments of an exchange, a queue or a binding declared as a JSON object
+func DecodeArguments(raw runtime.RawExtension) (map[string]interface{}, error) {
+	arguments := map[string]interface{}{}
+	if len(raw.Raw) == 0 || string(raw.Raw) == "null" {
+		return arguments, nil
+	}
+	if err := json.Unmarshal(raw.Raw, &arguments); err != nil {
+		return nil, fmt.Errorf("arguments are not a JSON object: %v", err)
+	}
+	return arguments, nil
+}
+
+// EqualArguments compares the arguments returned by the management API with the declared ones,
+// the numbers of both are decoded as float64
+func EqualArguments(a, b map[string]interface{}) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// FormatArguments returns the arguments for the messages of the conditions
+func FormatArguments(arguments map[string]interface{}) string {
+	if len(arguments) == 0 {
+		return "{}"
+	}
+	data, err := json.Marshal(arguments)
+	if err != nil {
+		return fmt.Sprint(arguments)
+	}
+	return string(data)
+}
+
+// EqualValues compares the value of a runtime parameter returned by the management API with the
+// desired one as it is encoded in JSON
+func EqualValues(current, desired map[string]interface{}) bool {
+	data, err := json.Marshal(desired)
+	if err != nil {
+		return false
+	}
+	encoded := map[string]interface{}{}
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(current, encoded)
+}
@@ -0,0 +1,323 @@
+// Package managed reconciles the resources declaring objects inside RabbitMQ clusters, e.g. vhosts
+// and users, through the management API of the cluster. The controllers of such resources only
+// implement a Handler, the lookup of the cluster, the finalizer and the status are shared.
+package managed
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/go-logr/logr"
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	"github.com/toha10/rabbitmq-operator/pkg/controller/rabbitmq"
+	"github.com/toha10/rabbitmq-operator/pkg/management"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+var log = logf.Log.WithName("managed")
+
+// Finalizer keeps the resource until its object is deleted from the cluster
+const Finalizer = "rabbitmq.mirantis.com/object"
+
+// ResyncInterval is how often the objects are compared with the cluster, so the changes made
+// behind the back of the operator, e.g. with rabbitmqctl, are reverted
+const ResyncInterval = 5 * time.Minute
+
+// ConflictError is returned by Sync when the object exists in the cluster with properties which can't
+// be changed in place. The resource gets the Conflict condition and is not retried with a backoff,
+// deleting the object from the cluster is left to the user.
+type ConflictError struct {
+	Message string
+}
+
+func (e *ConflictError) Error() string {
+	return e.Message
+}
+
+// NewConflict returns a ConflictError with the formatted message
+func NewConflict(format string, args ...interface{}) error {
+	return &ConflictError{Message: fmt.Sprintf(format, args...)}
+}
+
+// Object is a resource declaring an object inside a RabbitMQ cluster
+type Object interface {
+	runtime.Object
+	metav1.Object
+	// ClusterName returns the name of the RabbitMQ resource in the same namespace the object belongs to
+	ClusterName() string
+	// ObjectStatus returns the status of the resource
+	ObjectStatus() *rabbitmqv1alpha1.ObjectStatus
+}
+
+// Request is what a Handler needs to act on an object
+type Request struct {
+	Logger logr.Logger
+	// Client reads and writes the Kubernetes objects, e.g. the Secrets the object refers to
+	Client client.Client
+	Scheme *runtime.Scheme
+	// Cluster is the RabbitMQ resource the object belongs to
+	Cluster *rabbitmqv1alpha1.RabbitMQ
+	// Management is the client of the management API of the cluster
+	Management *management.Client
+}
+
+// Handler creates, updates and deletes the objects of one kind in the cluster
+type Handler interface {
+	// NewObject returns an empty resource of the kind
+	NewObject() Object
+	// NewList returns an empty list of the resources of the kind
+	NewList() runtime.Object
+	// Sync creates the object in the cluster or updates it to match the resource. It is called
+	// again every ResyncInterval, so it should only write what differs from the resource.
+	Sync(req *Request, obj Object) error
+	// Delete deletes the object from the cluster, it succeeds if the object doesn't exist
+	Delete(req *Request, obj Object) error
+}
+
+// Watcher is implemented by the Handlers which need to watch more resources, e.g. the Secrets
+// owned by their resources
+type Watcher interface {
+	Watch(mgr manager.Manager, c controller.Controller) error
+}
+
+// Add creates a new controller of the resources handled by h and adds it to the Manager
+func Add(mgr manager.Manager, name string, h Handler) error {
+	r := &Reconciler{
+		client:  mgr.GetClient(),
+		scheme:  mgr.GetScheme(),
+		handler: h,
+		log:     logf.Log.WithName(name),
+	}
+	c, err := controller.New(name, mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to primary resource
+	err = c.Watch(&source.Kind{Type: h.NewObject()}, &handler.EnqueueRequestForObject{})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to RabbitMQ and requeue the resources of the cluster,
+	// e.g. the objects declared before the cluster became ready
+	err = c.Watch(&source.Kind{Type: &rabbitmqv1alpha1.RabbitMQ{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(a handler.MapObject) []reconcile.Request {
+			return r.requestsForCluster(a.Meta)
+		}),
+	})
+	if err != nil {
+		return err
+	}
+
+	if w, ok := h.(Watcher); ok {
+		return w.Watch(mgr, c)
+	}
+	return nil
+}
+
+// requestsForCluster returns the requests for the resources which belong to the RabbitMQ cluster
+func (r *Reconciler) requestsForCluster(cluster metav1.Object) []reconcile.Request {
+	list := r.handler.NewList()
+	if err := r.client.List(context.TODO(), client.InNamespace(cluster.GetNamespace()), list); err != nil {
+		r.log.Error(err, "Failed to list resources", "RabbitMQ.Namespace", cluster.GetNamespace(), "RabbitMQ.Name", cluster.GetName())
+		return nil
+	}
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		r.log.Error(err, "Failed to extract resources")
+		return nil
+	}
+
+	requests := []reconcile.Request{}
+	for _, item := range items {
+		obj, ok := item.(Object)
+		if !ok || obj.ClusterName() != cluster.GetName() {
+			continue
+		}
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Name: obj.GetName(), Namespace: obj.GetNamespace()},
+		})
+	}
+	return requests
+}
+
+// blank assignment to verify that Reconciler implements reconcile.Reconciler
+var _ reconcile.Reconciler = &Reconciler{}
+
+// Reconciler reconciles the resources of one kind declaring objects inside RabbitMQ clusters
+type Reconciler struct {
+	// This client, initialized using mgr.Client() above, is a split client
+	// that reads objects from the cache and writes to the apiserver
+	client  client.Client
+	scheme  *runtime.Scheme
+	handler Handler
+	log     logr.Logger
+}
+
+// Reconcile creates or updates the object in the cluster the resource belongs to, or deletes
+// it from there when the resource is being deleted
+func (r *Reconciler) Reconcile(request reconcile.Request) (reconcile.Result, error) {
+	reqLogger := r.log.WithValues("Request.Namespace", request.Namespace, "Request.Name", request.Name)
+	reqLogger.Info("Reconciling")
+
+	obj := r.handler.NewObject()
+	err := r.client.Get(context.TODO(), request.NamespacedName, obj)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// the object is deleted from the cluster before the finalizer is removed
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+	previous := obj.ObjectStatus().DeepCopy()
+
+	cluster := &rabbitmqv1alpha1.RabbitMQ{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: obj.ClusterName(), Namespace: obj.GetNamespace()}, cluster)
+	if err != nil && !errors.IsNotFound(err) {
+		return reconcile.Result{}, err
+	}
+	// the objects of a deleted cluster went away with it; the objects of a cluster being deleted go
+	// away with its data or stay on its retained data volumes, they are neither synced nor deleted
+	clusterGone := errors.IsNotFound(err)
+	clusterDeleting := !clusterGone && cluster.DeletionTimestamp != nil
+
+	if obj.GetDeletionTimestamp() != nil {
+		if !hasFinalizer(obj) {
+			return reconcile.Result{}, nil
+		}
+		if !clusterGone && !clusterDeleting {
+			req, err := r.newRequest(reqLogger, cluster)
+			if err == nil {
+				err = r.handler.Delete(req, obj)
+			}
+			if err != nil {
+				r.setSynced(obj, corev1.ConditionFalse, "DeleteFailed", err.Error())
+				return reconcile.Result{}, r.updateStatus(obj, previous, err)
+			}
+		}
+		reqLogger.Info("Removing the finalizer")
+		removeFinalizer(obj)
+		return reconcile.Result{}, r.client.Update(context.TODO(), obj)
+	}
+
+	if clusterGone {
+		r.setSynced(obj, corev1.ConditionFalse, "ClusterNotFound", "RabbitMQ "+obj.ClusterName()+" not found")
+		return reconcile.Result{}, r.updateStatus(obj, previous, nil)
+	}
+	if clusterDeleting {
+		r.setSynced(obj, corev1.ConditionFalse, "ClusterDeleting", "RabbitMQ "+obj.ClusterName()+" is being deleted")
+		return reconcile.Result{}, r.updateStatus(obj, previous, nil)
+	}
+
+	if !hasFinalizer(obj) {
+		obj.SetFinalizers(append(obj.GetFinalizers(), Finalizer))
+		if err := r.client.Update(context.TODO(), obj); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+
+	req, err := r.newRequest(reqLogger, cluster)
+	if err == nil {
+		err = r.handler.Sync(req, obj)
+	}
+	if conflict, ok := err.(*ConflictError); ok {
+		reqLogger.Info("The object in the cluster conflicts with the resource", "Conflict", conflict.Message)
+		r.setSynced(obj, corev1.ConditionFalse, "Conflict", conflict.Message)
+		r.setConflict(obj, corev1.ConditionTrue, "PropertiesDiffer", conflict.Message)
+		return reconcile.Result{RequeueAfter: ResyncInterval}, r.updateStatus(obj, previous, nil)
+	}
+	if err != nil {
+		r.setSynced(obj, corev1.ConditionFalse, "SyncFailed", err.Error())
+		return reconcile.Result{}, r.updateStatus(obj, previous, err)
+	}
+	r.setSynced(obj, corev1.ConditionTrue, "Synced", "")
+	if rabbitmqv1alpha1.FindCondition(obj.ObjectStatus().Conditions, rabbitmqv1alpha1.ObjectConflict) != nil {
+		r.setConflict(obj, corev1.ConditionFalse, "Resolved", "")
+	}
+	return reconcile.Result{RequeueAfter: ResyncInterval}, r.updateStatus(obj, previous, nil)
+}
+
+func (r *Reconciler) newRequest(reqLogger logr.Logger, cluster *rabbitmqv1alpha1.RabbitMQ) (*Request, error) {
+	mgmt, err := rabbitmq.NewManagementClient(r.client, cluster)
+	if err != nil {
+		return nil, err
+	}
+	return &Request{
+		Logger:     reqLogger,
+		Client:     r.client,
+		Scheme:     r.scheme,
+		Cluster:    cluster,
+		Management: mgmt,
+	}, nil
+}
+
+func (r *Reconciler) setSynced(obj Object, status corev1.ConditionStatus, reason, message string) {
+	s := obj.ObjectStatus()
+	s.ObservedGeneration = obj.GetGeneration()
+	s.Conditions = rabbitmqv1alpha1.SetCondition(s.Conditions, rabbitmqv1alpha1.RabbitMQCondition{
+		Type:    rabbitmqv1alpha1.ObjectSynced,
+		Status:  status,
+		Reason:  reason,
+		Message: message,
+	})
+}
+
+func (r *Reconciler) setConflict(obj Object, status corev1.ConditionStatus, reason, message string) {
+	s := obj.ObjectStatus()
+	s.Conditions = rabbitmqv1alpha1.SetCondition(s.Conditions, rabbitmqv1alpha1.RabbitMQCondition{
+		Type:    rabbitmqv1alpha1.ObjectConflict,
+		Status:  status,
+		Reason:  reason,
+		Message: message,
+	})
+}
+
+// updateStatus writes the status of the resource if it has changed and returns reconcileErr,
+// or the error of the update if reconcileErr is nil
+func (r *Reconciler) updateStatus(obj Object, previous *rabbitmqv1alpha1.ObjectStatus, reconcileErr error) error {
+	if reflect.DeepEqual(previous, obj.ObjectStatus()) {
+		return reconcileErr
+	}
+	if err := r.client.Status().Update(context.TODO(), obj); err != nil {
+		if reconcileErr != nil {
+			r.log.Error(err, "Failed to update status", "Namespace", obj.GetNamespace(), "Name", obj.GetName())
+			return reconcileErr
+		}
+		return err
+	}
+	return reconcileErr
+}
+
+func hasFinalizer(obj metav1.Object) bool {
+	for _, f := range obj.GetFinalizers() {
+		if f == Finalizer {
+			return true
+		}
+	}
+	return false
+}
+
+func removeFinalizer(obj metav1.Object) {
+	finalizers := []string{}
+	for _, f := range obj.GetFinalizers() {
+		if f != Finalizer {
+			finalizers = append(finalizers, f)
+		}
+	}
+	obj.SetFinalizers(finalizers)
+}
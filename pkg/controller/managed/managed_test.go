@@ -0,0 +1,102 @@
+package managed
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/toha10/rabbitmq-operator/pkg/apis"
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+// refusingHandler refuses to delete the queues, as the queue handler does with the queues
+// still holding messages
+type refusingHandler struct {
+	deleted []string
+}
+
+func (h *refusingHandler) NewObject() Object {
+	return &rabbitmqv1alpha1.RabbitMQQueue{}
+}
+
+func (h *refusingHandler) NewList() runtime.Object {
+	return &rabbitmqv1alpha1.RabbitMQQueueList{}
+}
+
+func (h *refusingHandler) Sync(req *Request, obj Object) error {
+	return nil
+}
+
+func (h *refusingHandler) Delete(req *Request, obj Object) error {
+	h.deleted = append(h.deleted, obj.GetName())
+	return errors.New("queue orders still holds 3 messages")
+}
+
+func TestReconcileDeletedObject(t *testing.T) {
+	tests := []struct {
+		name string
+		// deleting is whether the cluster is being deleted
+		deleting      bool
+		wantErr       bool
+		wantFinalizer bool
+	}{
+		// the queue outlives the cluster with its data, e.g. on the retained data volumes
+		{name: "cluster being deleted", deleting: true},
+		// the refusal keeps the resource until the queue is drained
+		{name: "cluster running", wantErr: true, wantFinalizer: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := metav1.Now()
+			cluster := &rabbitmqv1alpha1.RabbitMQ{
+				ObjectMeta: metav1.ObjectMeta{Name: "rmq", Namespace: "ns"},
+				Spec: rabbitmqv1alpha1.RabbitMQSpec{
+					Persistence: &rabbitmqv1alpha1.PersistenceSpec{ReclaimPolicy: rabbitmqv1alpha1.ReclaimRetain},
+				},
+			}
+			if tt.deleting {
+				cluster.DeletionTimestamp = &now
+			}
+			adminSecret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "rmq-admin", Namespace: "ns"},
+				Data:       map[string][]byte{"username": []byte("admin"), "password": []byte("secret")},
+			}
+			queue := &rabbitmqv1alpha1.RabbitMQQueue{
+				ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "ns", Finalizers: []string{Finalizer}, DeletionTimestamp: &now},
+				Spec:       rabbitmqv1alpha1.RabbitMQQueueSpec{Cluster: "rmq"},
+			}
+			s := runtime.NewScheme()
+			if err := clientgoscheme.AddToScheme(s); err != nil {
+				t.Fatal(err)
+			}
+			if err := apis.AddToScheme(s); err != nil {
+				t.Fatal(err)
+			}
+			h := &refusingHandler{}
+			r := &Reconciler{client: fake.NewFakeClientWithScheme(s, cluster, adminSecret, queue), scheme: s, handler: h, log: logf.Log}
+
+			name := types.NamespacedName{Name: "orders", Namespace: "ns"}
+			if _, err := r.Reconcile(reconcile.Request{NamespacedName: name}); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want an error %v", err, tt.wantErr)
+			}
+			if deleted := len(h.deleted) > 0; deleted == tt.deleting {
+				t.Errorf("got %v deleted from the cluster, the cluster being deleted %v", h.deleted, tt.deleting)
+			}
+			found := &rabbitmqv1alpha1.RabbitMQQueue{}
+			if err := r.client.Get(context.TODO(), name, found); err != nil {
+				t.Fatal(err)
+			}
+			if hasFinalizer(found) != tt.wantFinalizer {
+				t.Errorf("got finalizers %v", found.Finalizers)
+			}
+		})
+	}
+}
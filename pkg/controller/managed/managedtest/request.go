@@ -0,0 +1,42 @@
+// Package managedtest builds the requests the handlers of managed objects are called with, for the
+// tests of the handlers
+package managedtest
+
+import (
+	"github.com/toha10/rabbitmq-operator/pkg/apis"
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	"github.com/toha10/rabbitmq-operator/pkg/controller/managed"
+	"github.com/toha10/rabbitmq-operator/pkg/management/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+// NewCluster returns the RabbitMQ resource the objects of the requests belong to
+func NewCluster() *rabbitmqv1alpha1.RabbitMQ {
+	return &rabbitmqv1alpha1.RabbitMQ{
+		ObjectMeta: metav1.ObjectMeta{Name: "rmq", Namespace: "ns"},
+		Spec:       rabbitmqv1alpha1.RabbitMQSpec{Replicas: 3, DiscoveryService: "rmq-client"},
+	}
+}
+
+// NewRequest returns a request of the objects of the cluster returned by NewCluster, with a fake client
+// holding the Kubernetes objects and a client of the management API served by server
+func NewRequest(server *fake.Server, objs ...runtime.Object) *managed.Request {
+	s := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(s); err != nil {
+		panic(err)
+	}
+	if err := apis.AddToScheme(s); err != nil {
+		panic(err)
+	}
+	return &managed.Request{
+		Logger:     logf.Log,
+		Client:     fakeclient.NewFakeClientWithScheme(s, objs...),
+		Scheme:     s,
+		Cluster:    NewCluster(),
+		Management: server.ManagementClient(),
+	}
+}
@@ -0,0 +1,72 @@
+package managed
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// SecretValue returns the value of the key of a Secret in the namespace
+func SecretValue(c client.Client, namespace string, selector corev1.SecretKeySelector) (string, error) {
+	secret := &corev1.Secret{}
+	if err := c.Get(context.TODO(), types.NamespacedName{Name: selector.Name, Namespace: namespace}, secret); err != nil {
+		return "", err
+	}
+	value, ok := secret.Data[selector.Key]
+	if !ok {
+		return "", fmt.Errorf("secret %s has no key %s", selector.Name, selector.Key)
+	}
+	return string(value), nil
+}
+
+// WatchReferencedSecrets requeues the resources of the handler referring to a Secret when it changes,
+// secretNames returns the names of the Secrets a resource refers to
+func WatchReferencedSecrets(mgr manager.Manager, c controller.Controller, h Handler, secretNames func(Object) []string) error {
+	cl := mgr.GetClient()
+	return c.Watch(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(a handler.MapObject) []reconcile.Request {
+			return requestsForSecret(cl, h, secretNames, a.Meta)
+		}),
+	})
+}
+
+// requestsForSecret returns the requests for the resources in the namespace of the Secret which refer to it
+func requestsForSecret(c client.Client, h Handler, secretNames func(Object) []string, secret metav1.Object) []reconcile.Request {
+	list := h.NewList()
+	if err := c.List(context.TODO(), client.InNamespace(secret.GetNamespace()), list); err != nil {
+		log.Error(err, "Failed to list resources", "Secret.Namespace", secret.GetNamespace(), "Secret.Name", secret.GetName())
+		return nil
+	}
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		log.Error(err, "Failed to extract resources")
+		return nil
+	}
+
+	requests := []reconcile.Request{}
+	for _, item := range items {
+		obj, ok := item.(Object)
+		if !ok {
+			continue
+		}
+		for _, name := range secretNames(obj) {
+			if name == secret.GetName() {
+				requests = append(requests, reconcile.Request{
+					NamespacedName: types.NamespacedName{Name: obj.GetName(), Namespace: obj.GetNamespace()},
+				})
+				break
+			}
+		}
+	}
+	return requests
+}
@@ -0,0 +1,142 @@
+package rabbitmq
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/go-logr/logr"
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	"github.com/toha10/rabbitmq-operator/pkg/management"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// adminUsername is the name of the administrator user the operator manages the cluster with
+	adminUsername = "rabbitmq-operator"
+
+	// the keys of the admin Secret
+	usernameKey = "username"
+	passwordKey = "password"
+)
+
+// adminSecretName returns the name of the Secret with the credentials of the operator administrator user
+func adminSecretName(cr *rabbitmqv1alpha1.RabbitMQ) string {
+	return cr.Name + "-admin"
+}
+
+// ClientHost returns the DNS name of the client Service of the cluster
+func ClientHost(cr *rabbitmqv1alpha1.RabbitMQ) string {
+	return fmt.Sprintf("%s.%s.svc.%s", cr.Spec.DiscoveryService, cr.Namespace, clusterDomain)
+}
+
+// managementURL returns the address of the management API behind the client Service
+func managementURL(cr *rabbitmqv1alpha1.RabbitMQ) string {
+	return fmt.Sprintf("http://%s:%d", ClientHost(cr), management.Port)
+}
+
+// GeneratePassword returns a random password safe to put into URIs
+func GeneratePassword() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func newAdminSecret(cr *rabbitmqv1alpha1.RabbitMQ, password string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      adminSecretName(cr),
+			Namespace: cr.Namespace,
+			Labels:    labelsForRabbitMQ(cr),
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			usernameKey: []byte(adminUsername),
+			passwordKey: []byte(password),
+		},
+	}
+}
+
+// reconcileAdminSecret makes sure the Secret with the credentials of the operator administrator exists
+func (r *ReconcileRabbitMQ) reconcileAdminSecret(reqLogger logr.Logger, cr *rabbitmqv1alpha1.RabbitMQ) (*corev1.Secret, error) {
+	secret := &corev1.Secret{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: adminSecretName(cr), Namespace: cr.Namespace}, secret)
+	if err == nil || !errors.IsNotFound(err) {
+		return secret, err
+	}
+
+	password, err := GeneratePassword()
+	if err != nil {
+		return nil, err
+	}
+	secret = newAdminSecret(cr, password)
+	if err := controllerutil.SetControllerReference(cr, secret, r.scheme); err != nil {
+		return nil, err
+	}
+	reqLogger.Info("Creating a new admin Secret", "Secret.Namespace", secret.Namespace, "Secret.Name", secret.Name)
+	if err := r.client.Create(context.TODO(), secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// NewManagementClient returns a client of the management API of the RabbitMQ cluster authenticated
+// as the operator administrator. The user is created by the RabbitMQ controller once the cluster is up.
+func NewManagementClient(c client.Client, cr *rabbitmqv1alpha1.RabbitMQ) (*management.Client, error) {
+	secret := &corev1.Secret{}
+	if err := c.Get(context.TODO(), types.NamespacedName{Name: adminSecretName(cr), Namespace: cr.Namespace}, secret); err != nil {
+		return nil, err
+	}
+	return management.NewClient(managementURL(cr), string(secret.Data[usernameKey]), string(secret.Data[passwordKey])), nil
+}
+
+// ensureAdminUser returns a management API client of the cluster. If the cluster doesn't know
+// the operator administrator yet, e.g. it was created by an older version of the operator or
+// its data was lost, the user is created with rabbitmqctl in a ready pod. It returns nil if
+// none of the pods is ready.
+func (r *ReconcileRabbitMQ) ensureAdminUser(reqLogger logr.Logger, cr *rabbitmqv1alpha1.RabbitMQ, secret *corev1.Secret, pods []corev1.Pod) (*management.Client, error) {
+	var ready *corev1.Pod
+	for i := range pods {
+		if isPodReady(&pods[i]) {
+			ready = &pods[i]
+			break
+		}
+	}
+	if ready == nil {
+		return nil, nil
+	}
+
+	username := string(secret.Data[usernameKey])
+	password := string(secret.Data[passwordKey])
+	mgmt := management.NewClient(managementURL(cr), username, password)
+	_, err := mgmt.Whoami()
+	if err == nil || !management.IsUnauthorized(err) {
+		return mgmt, err
+	}
+
+	reqLogger.Info("Creating the operator administrator user", "Pod.Namespace", ready.Namespace, "Pod.Name", ready.Name)
+	if _, err := r.executor.Exec(ready, "rabbitmqctl", "add_user", username, password); err != nil {
+		if !strings.Contains(err.Error(), "user_already_exists") {
+			return nil, err
+		}
+		if _, err := r.executor.Exec(ready, "rabbitmqctl", "change_password", username, password); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := r.executor.Exec(ready, "rabbitmqctl", "set_user_tags", username, "administrator"); err != nil {
+		return nil, err
+	}
+	if _, err := r.executor.Exec(ready, "rabbitmqctl", "set_permissions", "-p", "/", username, ".*", ".*", ".*"); err != nil {
+		return nil, err
+	}
+	return mgmt, nil
+}
@@ -0,0 +1,314 @@
+package rabbitmq
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// confEntry is a setting of rabbitmq.conf
+type confEntry struct {
+	// comment is written above the setting, every line has to start with "#"
+	comment string
+	key     string
+	value   string
+}
+
+// pluginNameRegexp matches valid plugin names, they become Erlang atoms
+var pluginNameRegexp = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// defaultPlugins are the plugins the operator can't work without
+var defaultPlugins = []string{"rabbitmq_management", "rabbitmq_peer_discovery_k8s"}
+
+// defaultConfig returns the settings of rabbitmq.conf generated by the operator
+func defaultConfig(cr *rabbitmqv1alpha1.RabbitMQ) []confEntry {
+	return []confEntry{
+		{
+			comment: "## Cluster formation. See https://www.rabbitmq.com/cluster-formation.html to learn more.",
+			key:     "cluster_formation.peer_discovery_backend",
+			value:   "rabbit_peer_discovery_k8s",
+		},
+		{key: "cluster_formation.k8s.host", value: "kubernetes.default.svc." + clusterDomain},
+		{
+			comment: `## Should RabbitMQ node name be computed from the pod's hostname or IP address?
+## IP addresses are not stable, so using [stable] hostnames is recommended when possible.
+## Set to "hostname" to use pod hostnames.
+## When this value is changed, so should the variable used to set the RABBITMQ_NODENAME
+## environment variable.`,
+			key:   "cluster_formation.k8s.address_type",
+			value: cr.Spec.AddressType,
+		},
+		{key: "cluster_formation.k8s.hostname_suffix", value: hostnameSuffix(cr)},
+		{
+			comment: "## How often should node cleanup checks run?",
+			key:     "cluster_formation.node_cleanup.interval",
+			value:   "30",
+		},
+		{
+			comment: `## Set to false if automatic removal of unknown/absent nodes
+## is desired. This can be dangerous, see
+##  * https://www.rabbitmq.com/cluster-formation.html#node-health-checks-and-cleanup
+##  * https://groups.google.com/forum/#!msg/rabbitmq-users/wuOfzEywHXo/k8z_HWIkBgAJ`,
+			key:   "cluster_formation.node_cleanup.only_log_warning",
+			value: "true",
+		},
+		{key: "cluster_partition_handling", value: "autoheal"},
+		{
+			comment: "## See https://www.rabbitmq.com/ha.html#master-migration-data-locality",
+			key:     "queue_master_locator",
+			value:   "min-masters",
+		},
+	}
+}
+
+// the part of the memory limit left for the Erlang VM above the high watermark:
+// 20% of the limit but no more than 2Gi
+const (
+	memoryHeadroomPercent = 20
+	maxMemoryHeadroom     = 2 << 30
+)
+
+// exclusiveConfigGroups are the settings whose keys are alternatives, e.g. the absolute and the relative
+// memory high watermark: a key of the group in spec.additional_config replaces all defaults of the group
+var exclusiveConfigGroups = []string{"vm_memory_high_watermark.", "disk_free_limit."}
+
+// resourceConfig returns the settings derived from the memory limit of the container. RabbitMQ computes
+// the relative watermark from the memory of the host rather than the cgroup, so without them a busy
+// node is killed for running out of memory before it starts to block the publishers.
+func resourceConfig(cr *rabbitmqv1alpha1.RabbitMQ) []confEntry {
+	limit, ok := cr.Spec.Resources.Limits[corev1.ResourceMemory]
+	if !ok || limit.IsZero() {
+		return nil
+	}
+	headroom := limit.Value() * memoryHeadroomPercent / 100
+	if headroom > maxMemoryHeadroom {
+		headroom = maxMemoryHeadroom
+	}
+	watermark := limit.Value() - headroom
+
+	// the node pages the messages out of memory, it needs as much free disk as the watermark;
+	// a small data volume would be in alarm from the start, at most half of it is reserved
+	diskFree := watermark
+	if volume := cr.Spec.DataVolumeSize.Value(); volume > 0 && diskFree > volume/2 {
+		diskFree = volume / 2
+	}
+
+	return []confEntry{
+		{
+			comment: `## Memory and disk thresholds derived from the memory limit of the container.
+## See https://www.rabbitmq.com/memory.html and https://www.rabbitmq.com/disk-alarms.html`,
+			key:   "vm_memory_high_watermark.absolute",
+			value: strconv.FormatInt(watermark, 10),
+		},
+		{key: "disk_free_limit.absolute", value: strconv.FormatInt(diskFree, 10)},
+	}
+}
+
+// configGroup returns the exclusive group of the key or an empty string
+func configGroup(key string) string {
+	for _, group := range exclusiveConfigGroups {
+		if strings.HasPrefix(key, group) {
+			return group
+		}
+	}
+	return ""
+}
+
+// protectedConfigPrefixes returns the prefixes of the rabbitmq.conf keys the operator owns,
+// overriding them would break the cluster management
+func protectedConfigPrefixes(cr *rabbitmqv1alpha1.RabbitMQ) []string {
+	prefixes := []string{"cluster_formation."}
+	if cr.Spec.TLS != nil {
+		prefixes = append(prefixes, tlsProtectedConfigPrefixes...)
+	}
+	if cr.Spec.Monitoring != nil {
+		prefixes = append(prefixes, monitoringProtectedConfigPrefixes...)
+	}
+	return prefixes
+}
+
+// parseAdditionalConfig parses the "key = value" lines of spec.additional_config,
+// blank lines and comments starting with "#" are skipped
+func parseAdditionalConfig(cr *rabbitmqv1alpha1.RabbitMQ) ([]confEntry, error) {
+	entries := []confEntry{}
+	seen := map[string]bool{}
+	for i, line := range strings.Split(cr.Spec.AdditionalConfig, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("additional_config line %d: expected \"key = value\", got %q", i+1, line)
+		}
+		key := strings.TrimSpace(parts[0])
+		value := strings.TrimSpace(parts[1])
+		if key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("additional_config line %d: invalid key %q", i+1, key)
+		}
+		if value == "" {
+			return nil, fmt.Errorf("additional_config line %d: %s has no value", i+1, key)
+		}
+		for _, prefix := range protectedConfigPrefixes(cr) {
+			if strings.HasPrefix(key, prefix) {
+				return nil, fmt.Errorf("additional_config line %d: %s is managed by the operator and can't be overridden", i+1, key)
+			}
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("additional_config line %d: %s is set more than once", i+1, key)
+		}
+		seen[key] = true
+		entries = append(entries, confEntry{key: key, value: value})
+	}
+	return entries, nil
+}
+
+// renderRabbitMQConf returns rabbitmq.conf, the settings of spec.additional_config
+// replace the defaults with the same key and are appended otherwise
+func renderRabbitMQConf(cr *rabbitmqv1alpha1.RabbitMQ, tls *tlsSecret) (string, error) {
+	additional, err := parseAdditionalConfig(cr)
+	if err != nil {
+		return "", err
+	}
+
+	entries := append(append(append(defaultConfig(cr), tlsConfig(tls)...), monitoringConfig(cr)...), resourceConfig(cr)...)
+	replaced := map[string]bool{}
+	for _, entry := range additional {
+		if group := configGroup(entry.key); group != "" {
+			replaced[group] = true
+		}
+	}
+	kept := []confEntry{}
+	for _, entry := range entries {
+		if !replaced[configGroup(entry.key)] {
+			kept = append(kept, entry)
+		}
+	}
+	entries = kept
+	index := map[string]int{}
+	for i, entry := range entries {
+		index[entry.key] = i
+	}
+	appended := false
+	for _, entry := range additional {
+		if i, ok := index[entry.key]; ok {
+			entries[i].value = entry.value
+			continue
+		}
+		if !appended {
+			entry.comment = "## Additional configuration from the RabbitMQ resource"
+			appended = true
+		}
+		entries = append(entries, entry)
+	}
+
+	lines := []string{}
+	for _, entry := range entries {
+		if entry.comment != "" {
+			lines = append(lines, entry.comment)
+		}
+		lines = append(lines, entry.key+" = "+entry.value)
+	}
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+// enabledPlugins returns the plugins to enable, the ones the operator needs go first
+func enabledPlugins(cr *rabbitmqv1alpha1.RabbitMQ) ([]string, error) {
+	plugins := []string{}
+	seen := map[string]bool{}
+	for _, plugin := range append(append([]string{}, defaultPlugins...), cr.Spec.Plugins...) {
+		if !pluginNameRegexp.MatchString(plugin) {
+			return nil, fmt.Errorf("invalid plugin name %q", plugin)
+		}
+		if seen[plugin] {
+			continue
+		}
+		seen[plugin] = true
+		plugins = append(plugins, plugin)
+	}
+	return plugins, nil
+}
+
+// renderEnabledPlugins returns the enabled_plugins file, an Erlang list of the plugin names
+func renderEnabledPlugins(cr *rabbitmqv1alpha1.RabbitMQ) (string, error) {
+	plugins, err := enabledPlugins(cr)
+	if err != nil {
+		return "", err
+	}
+	return "[" + strings.Join(plugins, ",") + "].", nil
+}
+
+// validateAdvancedConfig does a basic sanity check of spec.advanced_config: it has to be a single
+// Erlang term terminated by a dot with balanced brackets, braces and quotes
+func validateAdvancedConfig(config string) error {
+	config = strings.TrimSpace(config)
+	if config == "" {
+		return nil
+	}
+	if !strings.HasSuffix(config, ".") {
+		return fmt.Errorf("advanced_config has to end with a dot")
+	}
+
+	closing := map[rune]rune{']': '[', '}': '{', ')': '('}
+	stack := []rune{}
+	var quote rune
+	escaped := false
+	comment := false
+	for _, c := range config {
+		switch {
+		case comment:
+			comment = c != '\n'
+		case escaped && quote == 0:
+			// the character of a $c literal
+			escaped = false
+		case quote != 0:
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == quote:
+				quote = 0
+			}
+		case c == '%':
+			comment = true
+		case c == '$':
+			escaped = true
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{' || c == '(':
+			stack = append(stack, c)
+		case closing[c] != 0:
+			if len(stack) == 0 || stack[len(stack)-1] != closing[c] {
+				return fmt.Errorf("advanced_config has an unbalanced %q", c)
+			}
+			stack = stack[:len(stack)-1]
+		}
+	}
+	if quote != 0 {
+		return fmt.Errorf("advanced_config has an unterminated %q quote", quote)
+	}
+	if len(stack) != 0 {
+		return fmt.Errorf("advanced_config has an unclosed %q", stack[len(stack)-1])
+	}
+	return nil
+}
+
+// ValidateConfig checks the configuration settings of the RabbitMQ spec the same way
+// they are checked before the configuration files are generated
+func ValidateConfig(cr *rabbitmqv1alpha1.RabbitMQ) error {
+	if _, err := parseAdditionalConfig(cr); err != nil {
+		return err
+	}
+	if _, err := enabledPlugins(cr); err != nil {
+		return err
+	}
+	if _, err := monitoringPlugins(cr); err != nil {
+		return err
+	}
+	return validateAdvancedConfig(cr.Spec.AdvancedConfig)
+}
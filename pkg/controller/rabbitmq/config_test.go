@@ -0,0 +1,310 @@
+package rabbitmq
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestParseAdditionalConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		entries []confEntry
+		err     string
+	}{
+		{"empty", "", []confEntry{}, ""},
+		{"comments and blank lines", "# comment\n\n  # indented comment\n", []confEntry{}, ""},
+		{"settings", "channel_max = 64\n  heartbeat=30  \n", []confEntry{
+			{key: "channel_max", value: "64"},
+			{key: "heartbeat", value: "30"},
+		}, ""},
+		{"value with an equals sign", "management.path_prefix = /a=b", []confEntry{
+			{key: "management.path_prefix", value: "/a=b"},
+		}, ""},
+		{"no equals sign", "channel_max 64", nil, "line 1: expected"},
+		{"key with a space", "channel max = 64", nil, "line 1: invalid key"},
+		{"empty key", "= 64", nil, "line 1: invalid key"},
+		{"no value", "\nchannel_max =", nil, "line 2: channel_max has no value"},
+		{"protected key", "cluster_formation.k8s.host = example.com", nil, "managed by the operator"},
+		{"duplicate", "heartbeat = 30\nheartbeat = 60", nil, "line 2: heartbeat is set more than once"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := newTestCluster()
+			cr.Spec.AdditionalConfig = tt.config
+			entries, err := parseAdditionalConfig(cr)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(entries, tt.entries) {
+				t.Errorf("got %v, want %v", entries, tt.entries)
+			}
+		})
+	}
+}
+
+// confValues returns the settings of rabbitmq.conf
+func confValues(t *testing.T, conf string) map[string]string {
+	values := map[string]string{}
+	for _, line := range strings.Split(conf, "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, " = ", 2)
+		if len(parts) != 2 {
+			t.Fatalf("malformed line %q", line)
+		}
+		if _, ok := values[parts[0]]; ok {
+			t.Errorf("%s is set more than once", parts[0])
+		}
+		values[parts[0]] = parts[1]
+	}
+	return values
+}
+
+func TestRenderRabbitMQConf(t *testing.T) {
+	cr := newTestCluster()
+	cr.Spec.AddressType = "hostname"
+	cr.Spec.AdditionalConfig = "cluster_partition_handling = pause_minority\nchannel_max = 64"
+	conf, err := renderRabbitMQConf(cr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := confValues(t, conf)
+	for key, want := range map[string]string{
+		"cluster_formation.peer_discovery_backend": "rabbit_peer_discovery_k8s",
+		"cluster_formation.k8s.address_type":       "hostname",
+		"cluster_formation.k8s.hostname_suffix":    ".rmq.ns.svc.cluster.local",
+		"cluster_partition_handling":               "pause_minority",
+		"channel_max":                              "64",
+	} {
+		if values[key] != want {
+			t.Errorf("got %s = %q, want %q", key, values[key], want)
+		}
+	}
+	if !strings.Contains(conf, "## Additional configuration from the RabbitMQ resource\nchannel_max = 64\n") {
+		t.Errorf("the additional settings are not appended under their comment:\n%s", conf)
+	}
+	if strings.Count(conf, "## Additional configuration") != 1 {
+		t.Errorf("the comment of the additional settings is repeated")
+	}
+	if _, ok := values["loopback_users.guest"]; ok {
+		t.Errorf("the guest user is allowed to connect from other hosts")
+	}
+
+	cr.Spec.AdditionalConfig = "cluster_formation.k8s.host = example.com"
+	if _, err := renderRabbitMQConf(cr, nil); err == nil {
+		t.Errorf("protected key accepted")
+	}
+}
+
+func TestEnabledPlugins(t *testing.T) {
+	tests := []struct {
+		name    string
+		plugins []string
+		want    string
+		err     bool
+	}{
+		{"defaults", nil, "[rabbitmq_management,rabbitmq_peer_discovery_k8s].", false},
+		{"extra", []string{"rabbitmq_shovel"}, "[rabbitmq_management,rabbitmq_peer_discovery_k8s,rabbitmq_shovel].", false},
+		{"duplicates", []string{"rabbitmq_management", "rabbitmq_top", "rabbitmq_top"}, "[rabbitmq_management,rabbitmq_peer_discovery_k8s,rabbitmq_top].", false},
+		{"invalid name", []string{"rabbitmq_top]."}, "", true},
+		{"upper case", []string{"Rabbitmq_top"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := newTestCluster()
+			cr.Spec.Plugins = tt.plugins
+			got, err := renderEnabledPlugins(cr)
+			if (err != nil) != tt.err {
+				t.Fatalf("got error %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateAdvancedConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		valid  bool
+	}{
+		{"empty", "", true},
+		{"blank", " \n", true},
+		{"list", "[{rabbit, [{tcp_listeners, [5672]}]}].", true},
+		{"strings and atoms", `[{rabbit, [{default_user, <<"guest">>}, {'quoted atom]', "str}ing"}]}].`, true},
+		{"character literals", "[{a, [$], $[, ${]}].", true},
+		{"escaped quote", `[{a, "say \"hi\" ]"}].`, true},
+		{"comment", "% a [ comment\n[{rabbit, []}].", true},
+		{"no dot", "[{rabbit, []}]", false},
+		{"unclosed", "[{rabbit, []].", false},
+		{"mismatched", "[{rabbit, []]}.", false},
+		{"extra closing", "[]].", false},
+		{"unterminated string", `[{a, "b}].`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateAdvancedConfig(tt.config)
+			if (err == nil) != tt.valid {
+				t.Errorf("got error %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestResourceConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		resources corev1.ResourceRequirements
+		volume    string
+		// watermark and diskFree are the absolute thresholds, empty when not set
+		watermark string
+		diskFree  string
+	}{
+		{
+			name:   "no limit",
+			volume: "10Gi",
+		},
+		{
+			name: "request only",
+			resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Gi")},
+			},
+			volume: "10Gi",
+		},
+		{
+			name: "headroom of 20%",
+			resources: corev1.ResourceRequirements{
+				Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Gi")},
+			},
+			volume:    "10Gi",
+			watermark: "858993460",
+			diskFree:  "858993460",
+		},
+		{
+			name: "headroom capped at 2Gi",
+			resources: corev1.ResourceRequirements{
+				Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("20Gi")},
+			},
+			volume:    "100Gi",
+			watermark: "19327352832",
+			diskFree:  "19327352832",
+		},
+		{
+			name: "small volume",
+			resources: corev1.ResourceRequirements{
+				Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("4Gi")},
+			},
+			volume:    "1Gi",
+			watermark: "3435973837",
+			diskFree:  "536870912",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := newTestCluster()
+			cr.Spec.Resources = tt.resources
+			cr.Spec.DataVolumeSize = resource.MustParse(tt.volume)
+			values := map[string]string{}
+			for _, entry := range resourceConfig(cr) {
+				values[entry.key] = entry.value
+			}
+			if values["vm_memory_high_watermark.absolute"] != tt.watermark {
+				t.Errorf("got watermark %q, want %q", values["vm_memory_high_watermark.absolute"], tt.watermark)
+			}
+			if values["disk_free_limit.absolute"] != tt.diskFree {
+				t.Errorf("got disk free limit %q, want %q", values["disk_free_limit.absolute"], tt.diskFree)
+			}
+		})
+	}
+}
+
+func TestRenderRabbitMQConfExclusiveGroups(t *testing.T) {
+	tests := []struct {
+		name       string
+		additional string
+		want       map[string]string
+		absent     []string
+	}{
+		{
+			name: "derived",
+			want: map[string]string{
+				"vm_memory_high_watermark.absolute": "858993460",
+				"disk_free_limit.absolute":          "536870912",
+			},
+		},
+		{
+			name:       "relative watermark",
+			additional: "vm_memory_high_watermark.relative = 0.6",
+			want: map[string]string{
+				"vm_memory_high_watermark.relative": "0.6",
+				"disk_free_limit.absolute":          "536870912",
+			},
+			absent: []string{"vm_memory_high_watermark.absolute"},
+		},
+		{
+			name:       "absolute disk free limit",
+			additional: "disk_free_limit.absolute = 2GB",
+			want: map[string]string{
+				"vm_memory_high_watermark.absolute": "858993460",
+				"disk_free_limit.absolute":          "2GB",
+			},
+		},
+		{
+			name:       "relative disk free limit",
+			additional: "disk_free_limit.relative = 1.5",
+			want: map[string]string{
+				"vm_memory_high_watermark.absolute": "858993460",
+				"disk_free_limit.relative":          "1.5",
+			},
+			absent: []string{"disk_free_limit.absolute"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := newTestCluster()
+			cr.Spec.Resources.Limits = corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Gi")}
+			cr.Spec.AdditionalConfig = tt.additional
+			conf, err := renderRabbitMQConf(cr, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			values := confValues(t, conf)
+			for key, want := range tt.want {
+				if values[key] != want {
+					t.Errorf("got %s = %q, want %q", key, values[key], want)
+				}
+			}
+			for _, key := range tt.absent {
+				if _, ok := values[key]; ok {
+					t.Errorf("%s is set along with the setting of additional_config", key)
+				}
+			}
+		})
+	}
+}
+
+func TestContainerResources(t *testing.T) {
+	cr := newTestCluster()
+	cr.Spec.Resources = corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m")},
+		Limits:   corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("2Gi")},
+	}
+	ss := newStatefulSet(cr, nil)
+	if got := ss.Spec.Template.Spec.Containers[0].Resources; !reflect.DeepEqual(got, cr.Spec.Resources) {
+		t.Errorf("got resources %v, want %v", got, cr.Spec.Resources)
+	}
+}
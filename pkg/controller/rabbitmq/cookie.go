@@ -0,0 +1,234 @@
+package rabbitmq
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// erlangCookieKey is the key of the Erlang cookie in the cookie Secret
+	erlangCookieKey = "cookie"
+
+	// distributionHashAnnotation is set on the pod template to the hash of the settings
+	// every node of the cluster has to share, e.g. the Erlang cookie. Nodes started with
+	// different settings can't talk to each other, so they can't be rolled one by one.
+	distributionHashAnnotation = "rabbitmq.mirantis.com/distribution-hash"
+)
+
+// erlangCookieSecretName returns the name of the Secret the Erlang cookie of the cluster is read from
+func erlangCookieSecretName(cr *rabbitmqv1alpha1.RabbitMQ) string {
+	if cr.Spec.ErlangCookieSecret != "" {
+		return cr.Spec.ErlangCookieSecret
+	}
+	return cr.Name + "-erlang-cookie"
+}
+
+func newErlangCookieSecret(cr *rabbitmqv1alpha1.RabbitMQ, cookie string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      erlangCookieSecretName(cr),
+			Namespace: cr.Namespace,
+			Labels:    labelsForRabbitMQ(cr),
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			erlangCookieKey: []byte(cookie),
+		},
+	}
+}
+
+// generateErlangCookie returns a random cookie of 32 upper-case letters, the same
+// alphabet Erlang uses for the cookies it generates itself
+func generateErlangCookie() (string, error) {
+	const alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	// 234 is the largest multiple of the alphabet size below 256,
+	// bytes above it are dropped to keep the distribution uniform
+	const limit = 256 - 256%len(alphabet)
+
+	cookie := make([]byte, 0, 32)
+	buf := make([]byte, 64)
+	for len(cookie) < cap(cookie) {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if int(b) < limit && len(cookie) < cap(cookie) {
+				cookie = append(cookie, alphabet[int(b)%len(alphabet)])
+			}
+		}
+	}
+	return string(cookie), nil
+}
+
+// reconcileErlangCookie makes sure the Secret with the Erlang cookie exists and returns the
+// distribution hash of the cluster. The operator generates the Secret unless spec.erlang_cookie_secret
+// points to an existing one. A generated Secret is never overwritten, editing its cookie rotates it.
+func (r *ReconcileRabbitMQ) reconcileErlangCookie(reqLogger logr.Logger, cr *rabbitmqv1alpha1.RabbitMQ) (string, error) {
+	secret := &corev1.Secret{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: erlangCookieSecretName(cr), Namespace: cr.Namespace}, secret)
+	if err != nil && errors.IsNotFound(err) && cr.Spec.ErlangCookieSecret == "" {
+		cookie, err := r.initialErlangCookie(cr)
+		if err != nil {
+			return "", err
+		}
+		secret = newErlangCookieSecret(cr, cookie)
+		if err := controllerutil.SetControllerReference(cr, secret, r.scheme); err != nil {
+			return "", err
+		}
+		reqLogger.Info("Creating a new Erlang cookie Secret", "Secret.Namespace", secret.Namespace, "Secret.Name", secret.Name)
+		if err := r.client.Create(context.TODO(), secret); err != nil {
+			return "", err
+		}
+	} else if err != nil {
+		return "", err
+	}
+
+	cookie := secret.Data[erlangCookieKey]
+	if len(cookie) == 0 {
+		return "", fmt.Errorf("secret %s/%s has no Erlang cookie under the %q key", secret.Namespace, secret.Name, erlangCookieKey)
+	}
+	if tlsDist := interNodeTLSHash(cr); tlsDist != nil {
+		return distributionHash(cookie, tlsDist), nil
+	}
+	return distributionHash(cookie), nil
+}
+
+// initialErlangCookie returns the cookie for a new cookie Secret. A cluster created by the older
+// versions of the operator keeps the cookie its pods run with, so they don't have to be restarted.
+func (r *ReconcileRabbitMQ) initialErlangCookie(cr *rabbitmqv1alpha1.RabbitMQ) (string, error) {
+	ss := &v1.StatefulSet{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: statefulSetName(cr), Namespace: cr.Namespace}, ss)
+	if err != nil && !errors.IsNotFound(err) {
+		return "", err
+	}
+	for _, c := range ss.Spec.Template.Spec.Containers {
+		for _, env := range c.Env {
+			if env.Name == "RABBITMQ_ERLANG_COOKIE" && env.Value != "" {
+				return env.Value, nil
+			}
+		}
+	}
+	return generateErlangCookie()
+}
+
+// distributionHash returns a short hash of the settings shared by all nodes of the cluster.
+// It is put into the pod template, so it must not allow recovering the cookie.
+func distributionHash(values ...[]byte) string {
+	h := sha256.New()
+	for _, v := range values {
+		h.Write(v)
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:10]
+}
+
+// restartOnDistributionChange restarts the whole cluster when the distribution hash changes.
+//
+// A node restarted with a new cookie can't join the nodes still running with the old one,
+// so the pods can't be rolled one by one. All outdated pods are deleted, the one with the
+// lowest ordinal last, once all others are gone: a node which stopped last is allowed to
+// boot without waiting for its peers, the others join it when they come back.
+// It reports whether a restart is in progress.
+func (r *ReconcileRabbitMQ) restartOnDistributionChange(reqLogger logr.Logger, ss *v1.StatefulSet) (bool, error) {
+	hash := ss.Spec.Template.Annotations[distributionHashAnnotation]
+	if hash == "" {
+		return false, nil
+	}
+	pods, err := r.statefulSetPods(ss)
+	if err != nil {
+		return false, err
+	}
+
+	outdated := []corev1.Pod{}
+	terminating := false
+	for _, pod := range pods {
+		if pod.DeletionTimestamp != nil {
+			terminating = true
+			continue
+		}
+		podHash, err := r.podDistributionHash(&pod)
+		if err != nil {
+			return false, err
+		}
+		// a pod whose cookie is unknown is restarted too, it may not be able to join the others
+		if podHash != hash {
+			outdated = append(outdated, pod)
+		}
+	}
+	if len(outdated) == 0 {
+		return false, nil
+	}
+
+	if len(outdated) == 1 && terminating {
+		// wait for the rest of the cluster to stop
+		return true, nil
+	}
+	if len(outdated) > 1 {
+		// keep the lowest ordinal running until the others are gone
+		outdated = outdated[1:]
+	}
+	for i := len(outdated) - 1; i >= 0; i-- {
+		pod := &outdated[i]
+		reqLogger.Info("Restarting the pod to change distribution settings", "Pod.Namespace", pod.Namespace, "Pod.Name", pod.Name)
+		if err := r.client.Delete(context.TODO(), pod); err != nil && !errors.IsNotFound(err) {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// podDistributionHash returns the distribution hash the pod runs with. The pods created before the
+// annotation was introduced don't have it, the hash is computed from the cookie they were started
+// with then: the value of RABBITMQ_ERLANG_COOKIE or the Secret it refers to. It returns an empty
+// string if the cookie of the pod is unknown.
+func (r *ReconcileRabbitMQ) podDistributionHash(pod *corev1.Pod) (string, error) {
+	if hash, ok := pod.Annotations[distributionHashAnnotation]; ok {
+		return hash, nil
+	}
+	cookie, err := r.podErlangCookie(pod)
+	if err != nil || len(cookie) == 0 {
+		return "", err
+	}
+	if tlsDist := podInterNodeTLSHash(pod); tlsDist != nil {
+		return distributionHash(cookie, tlsDist), nil
+	}
+	return distributionHash(cookie), nil
+}
+
+// podErlangCookie returns the Erlang cookie set in the environment of the pod, or nil if the pod
+// doesn't set one or the Secret it refers to doesn't exist anymore
+func (r *ReconcileRabbitMQ) podErlangCookie(pod *corev1.Pod) ([]byte, error) {
+	for _, c := range pod.Spec.Containers {
+		for _, env := range c.Env {
+			if env.Name != "RABBITMQ_ERLANG_COOKIE" {
+				continue
+			}
+			if env.ValueFrom == nil || env.ValueFrom.SecretKeyRef == nil {
+				return []byte(env.Value), nil
+			}
+			ref := env.ValueFrom.SecretKeyRef
+			secret := &corev1.Secret{}
+			err := r.client.Get(context.TODO(), types.NamespacedName{Name: ref.Name, Namespace: pod.Namespace}, secret)
+			if err != nil {
+				if errors.IsNotFound(err) {
+					return nil, nil
+				}
+				return nil, err
+			}
+			return secret.Data[ref.Key], nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,203 @@
+package rabbitmq
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+func TestGenerateErlangCookie(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 10; i++ {
+		cookie, err := generateErlangCookie()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(cookie) != 32 {
+			t.Errorf("got a cookie of %d characters, want 32", len(cookie))
+		}
+		if strings.Trim(cookie, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+			t.Errorf("cookie %q has characters other than upper-case letters", cookie)
+		}
+		if seen[cookie] {
+			t.Errorf("cookie %q generated twice", cookie)
+		}
+		seen[cookie] = true
+	}
+}
+
+func TestDistributionHash(t *testing.T) {
+	hash := distributionHash([]byte("cookie"))
+	if len(hash) != 10 {
+		t.Errorf("got a hash of %d characters, want 10", len(hash))
+	}
+	if distributionHash([]byte("cookie")) != hash {
+		t.Errorf("hash is not stable")
+	}
+	if distributionHash([]byte("other")) == hash {
+		t.Errorf("hash doesn't change with the cookie")
+	}
+	if distributionHash([]byte("cookie"), []byte("tls")) == hash {
+		t.Errorf("hash doesn't change with the TLS settings")
+	}
+	if distributionHash([]byte("ab"), []byte("c")) == distributionHash([]byte("a"), []byte("bc")) {
+		t.Errorf("values are not separated")
+	}
+}
+
+func TestRestartOnDistributionChange(t *testing.T) {
+	current := distributionHash([]byte("COOKIE"))
+	tests := []struct {
+		name string
+		// podHashes are the distribution hashes of the pods, "-" for a terminating pod and "" for
+		// a pod without the annotation
+		podHashes []string
+		// cookie is the cookie in the Secret the pods refer to, the Secret is missing if it is empty
+		cookie     string
+		restarting bool
+		remaining  []string
+	}{
+		{"up to date", []string{current, current, current}, "COOKIE", false, []string{"rmq-0", "rmq-1", "rmq-2"}},
+		{"pods without the annotation", []string{"", "", ""}, "COOKIE", false, []string{"rmq-0", "rmq-1", "rmq-2"}},
+		{"pods without the annotation and another cookie", []string{"", "", ""}, "OTHER", true, []string{"rmq-0"}},
+		{"pods with an unknown cookie", []string{"", "", ""}, "", true, []string{"rmq-0"}},
+		{"all outdated", []string{"old", "old", "old"}, "COOKIE", true, []string{"rmq-0"}},
+		{"lowest ordinal waits", []string{"old", "-", current}, "COOKIE", true, []string{"rmq-0", "rmq-1", "rmq-2"}},
+		{"lowest ordinal last", []string{"old", current, current}, "COOKIE", true, []string{"rmq-1", "rmq-2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ss := newTestStatefulSet(3, map[string]string{distributionHashAnnotation: current})
+			objs := []runtime.Object{ss}
+			if tt.cookie != "" {
+				objs = append(objs, newErlangCookieSecret(newTestCluster(), tt.cookie))
+			}
+			for i, pod := range newTestPods(ss, nil) {
+				meta := pod.(metav1.Object)
+				switch hash := tt.podHashes[i]; hash {
+				case "":
+				case "-":
+					now := metav1.Now()
+					meta.SetDeletionTimestamp(&now)
+					meta.SetAnnotations(map[string]string{distributionHashAnnotation: current})
+				default:
+					meta.SetAnnotations(map[string]string{distributionHashAnnotation: hash})
+				}
+				objs = append(objs, pod)
+			}
+			r := newTestReconciler(objs...)
+
+			restarting, err := r.restartOnDistributionChange(logf.Log, ss)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if restarting != tt.restarting {
+				t.Errorf("got restarting %v, want %v", restarting, tt.restarting)
+			}
+			if got := podNames(r, ss); !reflect.DeepEqual(got, tt.remaining) {
+				t.Errorf("got pods %v, want %v", got, tt.remaining)
+			}
+		})
+	}
+}
+
+func TestPodDistributionHash(t *testing.T) {
+	cookieEnv := func(env ...corev1.EnvVar) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "rmq-0", Namespace: "ns"},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "rabbitmq", Env: env}}},
+		}
+	}
+	annotated := cookieEnv()
+	annotated.Annotations = map[string]string{distributionHashAnnotation: "abc"}
+	tests := []struct {
+		name string
+		pod  *corev1.Pod
+		want string
+	}{
+		{"annotation", annotated, "abc"},
+		{"cookie of an older operator", cookieEnv(corev1.EnvVar{Name: "RABBITMQ_ERLANG_COOKIE", Value: "OLDCOOKIE"}),
+			distributionHash([]byte("OLDCOOKIE"))},
+		{"inter-node TLS", cookieEnv(
+			corev1.EnvVar{Name: "RABBITMQ_ERLANG_COOKIE", Value: "OLDCOOKIE"},
+			corev1.EnvVar{Name: "RABBITMQ_SERVER_ADDITIONAL_ERL_ARGS", Value: tlsDistArg},
+		), distributionHash([]byte("OLDCOOKIE"), []byte("inter-node-tls"))},
+		{"cookie Secret", newTestPods(newTestStatefulSet(1, nil), nil)[0].(*corev1.Pod), distributionHash([]byte("COOKIE"))},
+		{"missing cookie Secret", cookieEnv(corev1.EnvVar{
+			Name: "RABBITMQ_ERLANG_COOKIE",
+			ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "missing"},
+				Key:                  erlangCookieKey,
+			}},
+		}), ""},
+		{"no cookie", cookieEnv(), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestReconciler(newErlangCookieSecret(newTestCluster(), "COOKIE"))
+			got, err := r.podDistributionHash(tt.pod)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReconcileErlangCookie(t *testing.T) {
+	cr := newTestCluster()
+	secretName := types.NamespacedName{Namespace: cr.Namespace, Name: erlangCookieSecretName(cr)}
+
+	t.Run("generated", func(t *testing.T) {
+		r := newTestReconciler(cr)
+		hash, err := r.reconcileErlangCookie(logf.Log, cr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		secret := &corev1.Secret{}
+		if err := r.client.Get(context.TODO(), secretName, secret); err != nil {
+			t.Fatal(err)
+		}
+		if len(secret.Data[erlangCookieKey]) != 32 {
+			t.Errorf("got cookie %q", secret.Data[erlangCookieKey])
+		}
+		if again, err := r.reconcileErlangCookie(logf.Log, cr); err != nil || again != hash {
+			t.Errorf("got hash %q, %v on the second run, want %q", again, err, hash)
+		}
+	})
+
+	t.Run("kept from the pods of an older operator", func(t *testing.T) {
+		ss := &v1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: statefulSetName(cr), Namespace: cr.Namespace}}
+		ss.Spec.Template.Spec.Containers = []corev1.Container{{
+			Name: "rabbitmq",
+			Env:  []corev1.EnvVar{{Name: "RABBITMQ_ERLANG_COOKIE", Value: "OLDCOOKIE"}},
+		}}
+		r := newTestReconciler(cr, ss)
+		hash, err := r.reconcileErlangCookie(logf.Log, cr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := distributionHash([]byte("OLDCOOKIE")); hash != want {
+			t.Errorf("got hash %q, want %q", hash, want)
+		}
+	})
+
+	t.Run("referenced Secret missing", func(t *testing.T) {
+		referencing := cr.DeepCopy()
+		referencing.Spec.ErlangCookieSecret = "missing"
+		r := newTestReconciler(referencing)
+		if _, err := r.reconcileErlangCookie(logf.Log, referencing); err == nil {
+			t.Errorf("no error for a missing Secret")
+		}
+	})
+}
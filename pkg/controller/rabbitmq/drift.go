@@ -0,0 +1,281 @@
+package rabbitmq
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// ownedField is a field of the JSON representation of an object managed by the operator.
+// The path may go through lists, "*" stands for every item the desired and the live list both have.
+type ownedField struct {
+	path []string
+	// exact fields have to be equal to the desired value, otherwise it is
+	// enough for the live value to contain everything set in the desired one
+	exact bool
+	// keyPrefix makes the keys of a map with the prefix exact, the other keys are left alone
+	keyPrefix string
+}
+
+// mergedField returns a field which the live value may extend
+func mergedField(path ...string) ownedField {
+	return ownedField{path: path}
+}
+
+// exactField returns a field which has to match the desired value exactly
+func exactField(path ...string) ownedField {
+	return ownedField{path: path, exact: true}
+}
+
+// prefixedKeysField returns a map field whose keys with the prefix have to match the desired ones
+// exactly, the keys without it belong to other parties
+func prefixedKeysField(prefix string, path ...string) ownedField {
+	return ownedField{path: path, keyPrefix: prefix}
+}
+
+var labelsField = mergedField("metadata", "labels")
+
+// syncOwnedFields copies the given fields from desired to found, when they are out of sync.
+//
+// A merged field is in sync when everything set in desired is present in found with the same
+// value, so the values defaulted or filled in by the API server are not treated as drift. Maps
+// are merged into the live ones to keep keys added by other parties (e.g. labels), lists and
+// scalars are replaced. Exact fields are compared and replaced as a whole, so what is removed from
+// desired is removed from found too; the fields defaulted by the API server can't be exact. The
+// fields are synced in order, an exact field may refine a merged field containing it.
+// It reports whether found has been changed and has to be updated.
+func syncOwnedFields(desired, found runtime.Object, fields ...ownedField) (bool, error) {
+	desiredFields, err := toFieldMap(desired)
+	if err != nil {
+		return false, err
+	}
+	foundFields, err := toFieldMap(found)
+	if err != nil {
+		return false, err
+	}
+
+	changed := false
+	for _, field := range fields {
+		for _, path := range expandPath(desiredFields, foundFields, field.path) {
+			want := getField(desiredFields, path)
+			live := getField(foundFields, path)
+			switch {
+			case field.keyPrefix != "":
+				if prefixedKeysEqual(want, live, field.keyPrefix) {
+					continue
+				}
+				setField(foundFields, path, mergePrefixedKeys(live, want, field.keyPrefix))
+			case field.exact:
+				if reflect.DeepEqual(want, live) {
+					continue
+				}
+				setField(foundFields, path, want)
+			default:
+				if isSubset(want, live) {
+					continue
+				}
+				setField(foundFields, path, mergeField(live, want))
+			}
+			changed = true
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+
+	data, err := json.Marshal(foundFields)
+	if err != nil {
+		return false, err
+	}
+	// decode into a zero value, so nothing of the old object survives in reused slices
+	updated := reflect.New(reflect.TypeOf(found).Elem())
+	if err := json.Unmarshal(data, updated.Interface()); err != nil {
+		return false, err
+	}
+	reflect.ValueOf(found).Elem().Set(updated.Elem())
+	return true, nil
+}
+
+func toFieldMap(obj runtime.Object) (map[string]interface{}, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// expandPath returns the paths "*" in path stands for, the items of the lists present in both
+// desired and found. The lists of different lengths are replaced by the merged field containing them.
+func expandPath(desired, found map[string]interface{}, path []string) [][]string {
+	for i, key := range path {
+		if key != "*" {
+			continue
+		}
+		want, _ := getField(desired, path[:i]).([]interface{})
+		live, _ := getField(found, path[:i]).([]interface{})
+		paths := [][]string{}
+		for j := 0; j < len(want) && j < len(live); j++ {
+			item := make([]string, 0, len(path))
+			item = append(item, path[:i]...)
+			item = append(item, strconv.Itoa(j))
+			item = append(item, path[i+1:]...)
+			paths = append(paths, expandPath(desired, found, item)...)
+		}
+		return paths
+	}
+	return [][]string{path}
+}
+
+func getField(fields map[string]interface{}, path []string) interface{} {
+	var current interface{} = fields
+	for _, key := range path {
+		switch c := current.(type) {
+		case map[string]interface{}:
+			current = c[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(c) {
+				return nil
+			}
+			current = c[i]
+		default:
+			return nil
+		}
+	}
+	return current
+}
+
+// setField sets the value at path, the missing maps on the way are created. The lists on the
+// way have to contain the items the path goes through.
+func setField(fields map[string]interface{}, path []string, value interface{}) {
+	var current interface{} = fields
+	for n, key := range path {
+		last := n == len(path)-1
+		switch c := current.(type) {
+		case map[string]interface{}:
+			if last {
+				c[key] = value
+				return
+			}
+			next := c[key]
+			switch next.(type) {
+			case map[string]interface{}, []interface{}:
+			default:
+				next = map[string]interface{}{}
+				c[key] = next
+			}
+			current = next
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(c) {
+				return
+			}
+			if last {
+				c[i] = value
+				return
+			}
+			current = c[i]
+		default:
+			return
+		}
+	}
+}
+
+// isSubset reports whether every value set in want is set to the same value in live
+func isSubset(want, live interface{}) bool {
+	switch w := want.(type) {
+	case nil:
+		return true
+	case map[string]interface{}:
+		if len(w) == 0 {
+			return true
+		}
+		l, ok := live.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for k, v := range w {
+			if !isSubset(v, l[k]) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		if len(w) == 0 {
+			return live == nil || reflect.DeepEqual(live, []interface{}{})
+		}
+		l, ok := live.([]interface{})
+		if !ok || len(l) != len(w) {
+			return false
+		}
+		for i := range w {
+			if !isSubset(w[i], l[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(want, live)
+	}
+}
+
+// mergeField merges the maps of want into live recursively, anything else is taken from want
+func mergeField(live, want interface{}) interface{} {
+	w, ok := want.(map[string]interface{})
+	if !ok {
+		return want
+	}
+	l, ok := live.(map[string]interface{})
+	if !ok {
+		return want
+	}
+	merged := make(map[string]interface{}, len(l))
+	for k, v := range l {
+		merged[k] = v
+	}
+	for k, v := range w {
+		if v == nil {
+			continue
+		}
+		merged[k] = mergeField(l[k], v)
+	}
+	return merged
+}
+
+// prefixedKeysEqual reports whether the keys with the prefix are the same in want and live
+func prefixedKeysEqual(want, live interface{}, prefix string) bool {
+	return reflect.DeepEqual(prefixedKeys(want, prefix), prefixedKeys(live, prefix))
+}
+
+func prefixedKeys(value interface{}, prefix string) map[string]interface{} {
+	keys := map[string]interface{}{}
+	m, _ := value.(map[string]interface{})
+	for k, v := range m {
+		if strings.HasPrefix(k, prefix) {
+			keys[k] = v
+		}
+	}
+	return keys
+}
+
+// mergePrefixedKeys replaces the keys with the prefix of live with the ones of want
+func mergePrefixedKeys(live, want interface{}, prefix string) interface{} {
+	merged := map[string]interface{}{}
+	l, _ := live.(map[string]interface{})
+	for k, v := range l {
+		if !strings.HasPrefix(k, prefix) {
+			merged[k] = v
+		}
+	}
+	for k, v := range prefixedKeys(want, prefix) {
+		merged[k] = v
+	}
+	return merged
+}
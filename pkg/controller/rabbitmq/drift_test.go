@@ -0,0 +1,262 @@
+package rabbitmq
+
+import (
+	"reflect"
+	"testing"
+
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newTestCluster() *rabbitmqv1alpha1.RabbitMQ {
+	cr := &rabbitmqv1alpha1.RabbitMQ{
+		ObjectMeta: metav1.ObjectMeta{Name: "rmq", Namespace: "ns"},
+		Spec: rabbitmqv1alpha1.RabbitMQSpec{
+			Replicas:         3,
+			DiscoveryService: "rmq-client",
+		},
+	}
+	cr.SetDefaults()
+	return cr
+}
+
+// withServerDefaults returns a copy of the StatefulSet with the values the API server fills in
+// and the changes made by other parties
+func withServerDefaults(ss *v1.StatefulSet) *v1.StatefulSet {
+	live := ss.DeepCopy()
+	live.Labels["team"] = "messaging"
+	live.Spec.RevisionHistoryLimit = newInt32(10)
+	spec := &live.Spec.Template.Spec
+	spec.RestartPolicy = corev1.RestartPolicyAlways
+	spec.DNSPolicy = corev1.DNSClusterFirst
+	spec.SchedulerName = corev1.DefaultSchedulerName
+	spec.SecurityContext = &corev1.PodSecurityContext{}
+	if live.Spec.Template.Annotations == nil {
+		live.Spec.Template.Annotations = map[string]string{}
+	}
+	live.Spec.Template.Annotations["kubectl.kubernetes.io/restartedAt"] = "2019-08-01T00:00:00Z"
+	for i := range spec.Containers {
+		c := &spec.Containers[i]
+		c.TerminationMessagePath = corev1.TerminationMessagePathDefault
+		c.TerminationMessagePolicy = corev1.TerminationMessageReadFile
+		c.ImagePullPolicy = corev1.PullIfNotPresent
+		for _, probe := range []*corev1.Probe{c.ReadinessProbe, c.LivenessProbe} {
+			probe.SuccessThreshold = 1
+			probe.FailureThreshold = 3
+		}
+	}
+	return live
+}
+
+func TestSyncStatefulSetIgnoresServerDefaults(t *testing.T) {
+	cr := newTestCluster()
+	cr.Spec.NodeSelector = map[string]string{"disk": "ssd"}
+	desired := newStatefulSet(cr, map[string]string{configHashAnnotation: "1"})
+	found := withServerDefaults(desired)
+	before := found.DeepCopy()
+
+	changed, err := syncOwnedFields(desired, found, statefulSetFields...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed {
+		t.Errorf("the values set by the API server are reported as drift")
+	}
+	if !reflect.DeepEqual(before, found) {
+		t.Errorf("the StatefulSet has been changed")
+	}
+}
+
+func TestSyncStatefulSetRemovesWhatTheSpecNoLongerHas(t *testing.T) {
+	tests := []struct {
+		name string
+		// old modifies the spec the live StatefulSet has been built from
+		old func(cr *rabbitmqv1alpha1.RabbitMQ)
+		// spec modifies the spec the desired StatefulSet is built from
+		spec  func(cr *rabbitmqv1alpha1.RabbitMQ)
+		check func(t *testing.T, found *v1.StatefulSet)
+	}{
+		{
+			name: "resource limits",
+			old: func(cr *rabbitmqv1alpha1.RabbitMQ) {
+				cr.Spec.Resources.Limits = corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("2Gi")}
+			},
+			check: func(t *testing.T, found *v1.StatefulSet) {
+				if limits := found.Spec.Template.Spec.Containers[0].Resources.Limits; len(limits) != 0 {
+					t.Errorf("limits kept: %v", limits)
+				}
+			},
+		},
+		{
+			name: "node selector entry",
+			old: func(cr *rabbitmqv1alpha1.RabbitMQ) {
+				cr.Spec.NodeSelector = map[string]string{"disk": "ssd", "zone": "a"}
+			},
+			spec: func(cr *rabbitmqv1alpha1.RabbitMQ) {
+				cr.Spec.NodeSelector = map[string]string{"disk": "ssd"}
+			},
+			check: func(t *testing.T, found *v1.StatefulSet) {
+				want := map[string]string{"disk": "ssd"}
+				if got := found.Spec.Template.Spec.NodeSelector; !reflect.DeepEqual(got, want) {
+					t.Errorf("got node selector %v, want %v", got, want)
+				}
+			},
+		},
+		{
+			name: "toleration",
+			old: func(cr *rabbitmqv1alpha1.RabbitMQ) {
+				cr.Spec.Tolerations = []corev1.Toleration{{Key: "dedicated", Operator: corev1.TolerationOpExists}}
+			},
+			check: func(t *testing.T, found *v1.StatefulSet) {
+				if got := found.Spec.Template.Spec.Tolerations; len(got) != 0 {
+					t.Errorf("tolerations kept: %v", got)
+				}
+			},
+		},
+		{
+			name: "affinity",
+			old: func(cr *rabbitmqv1alpha1.RabbitMQ) {
+				cr.Spec.Affinity = &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+						NodeSelectorTerms: []corev1.NodeSelectorTerm{{MatchExpressions: []corev1.NodeSelectorRequirement{
+							{Key: "disk", Operator: corev1.NodeSelectorOpIn, Values: []string{"ssd"}},
+						}}},
+					},
+				}}
+			},
+			check: func(t *testing.T, found *v1.StatefulSet) {
+				if affinity := found.Spec.Template.Spec.Affinity; affinity == nil || affinity.NodeAffinity != nil {
+					t.Errorf("node affinity kept: %v", affinity)
+				}
+			},
+		},
+		{
+			name: "priority class",
+			old: func(cr *rabbitmqv1alpha1.RabbitMQ) {
+				cr.Spec.PriorityClassName = "high"
+			},
+			check: func(t *testing.T, found *v1.StatefulSet) {
+				if got := found.Spec.Template.Spec.PriorityClassName; got != "" {
+					t.Errorf("priority class kept: %s", got)
+				}
+			},
+		},
+		{
+			name: "TLS",
+			old: func(cr *rabbitmqv1alpha1.RabbitMQ) {
+				cr.Spec.TLS = &rabbitmqv1alpha1.TLSSpec{SecretName: "tls", InterNode: true}
+			},
+			check: func(t *testing.T, found *v1.StatefulSet) {
+				spec := found.Spec.Template.Spec
+				if len(spec.Volumes) != 1 {
+					t.Errorf("got %d volumes, want 1", len(spec.Volumes))
+				}
+				c := spec.Containers[0]
+				for _, env := range c.Env {
+					if env.Name == "RABBITMQ_SERVER_ADDITIONAL_ERL_ARGS" {
+						t.Errorf("inter-node TLS arguments kept")
+					}
+				}
+				for _, port := range c.Ports {
+					if port.Name == "amqps" {
+						t.Errorf("TLS port kept")
+					}
+				}
+				for _, mount := range c.VolumeMounts {
+					if mount.Name == tlsVolume {
+						t.Errorf("TLS volume mount kept")
+					}
+				}
+				if _, ok := found.Spec.Template.Annotations[tlsHashAnnotation]; ok {
+					t.Errorf("TLS hash annotation kept")
+				}
+				if _, ok := found.Spec.Template.Annotations["kubectl.kubernetes.io/restartedAt"]; !ok {
+					t.Errorf("annotation of another party removed")
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := newTestCluster()
+			if tt.spec != nil {
+				tt.spec(cr)
+			}
+			desired := newStatefulSet(cr, map[string]string{configHashAnnotation: "1"})
+
+			old := newTestCluster()
+			tt.old(old)
+			annotations := map[string]string{configHashAnnotation: "1"}
+			if old.Spec.TLS != nil {
+				annotations[tlsHashAnnotation] = "1"
+			}
+			found := withServerDefaults(newStatefulSet(old, annotations))
+
+			changed, err := syncOwnedFields(desired, found, statefulSetFields...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !changed {
+				t.Fatalf("drift not detected")
+			}
+			tt.check(t, found)
+			if found.Labels["team"] != "messaging" {
+				t.Errorf("label of another party removed")
+			}
+
+			// the updated object is in sync once the API server has defaulted it again
+			changed, err = syncOwnedFields(desired, withServerDefaults(found), statefulSetFields...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed {
+				t.Errorf("drift detected after the update")
+			}
+		})
+	}
+}
+
+func TestIsSubset(t *testing.T) {
+	tests := []struct {
+		name string
+		want interface{}
+		live interface{}
+		ok   bool
+	}{
+		{"nil", nil, "x", true},
+		{"empty map", map[string]interface{}{}, nil, true},
+		{"map subset", map[string]interface{}{"a": "1"}, map[string]interface{}{"a": "1", "b": "2"}, true},
+		{"map value differs", map[string]interface{}{"a": "1"}, map[string]interface{}{"a": "2"}, false},
+		{"map missing", map[string]interface{}{"a": "1"}, nil, false},
+		{"empty list", []interface{}{}, nil, true},
+		{"empty list against items", []interface{}{}, []interface{}{"a"}, false},
+		{"list lengths differ", []interface{}{"a"}, []interface{}{"a", "b"}, false},
+		{"list items subsets", []interface{}{map[string]interface{}{"a": "1"}},
+			[]interface{}{map[string]interface{}{"a": "1", "b": "2"}}, true},
+		{"scalar", 1.0, 1.0, true},
+		{"scalar differs", 1.0, 2.0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isSubset(tt.want, tt.live); got != tt.ok {
+				t.Errorf("got %v, want %v", got, tt.ok)
+			}
+		})
+	}
+}
+
+func TestExpandPath(t *testing.T) {
+	desired := map[string]interface{}{"items": []interface{}{"a", "b", "c"}}
+	found := map[string]interface{}{"items": []interface{}{"a", "b"}}
+	got := expandPath(desired, found, []string{"items", "*", "name"})
+	want := [][]string{{"items", "0", "name"}, {"items", "1", "name"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := expandPath(desired, found, []string{"items"}); !reflect.DeepEqual(got, [][]string{{"items"}}) {
+		t.Errorf("path without a wildcard expanded to %v", got)
+	}
+}
@@ -0,0 +1,63 @@
+package rabbitmq
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// podExecutor runs commands in the rabbitmq container of the cluster pods
+type podExecutor interface {
+	// Exec runs the command and returns its standard output
+	Exec(pod *corev1.Pod, command ...string) (string, error)
+}
+
+// remoteExecutor runs commands through the exec subresource of the pods
+type remoteExecutor struct {
+	config    *rest.Config
+	clientset kubernetes.Interface
+}
+
+func newRemoteExecutor(config *rest.Config) (*remoteExecutor, error) {
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &remoteExecutor{config: config, clientset: clientset}, nil
+}
+
+// Exec runs the command in the rabbitmq container of the pod
+func (e *remoteExecutor) Exec(pod *corev1.Pod, command ...string) (string, error) {
+	req := e.clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Name(pod.Name).
+		Namespace(pod.Namespace).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: "rabbitmq",
+			Command:   command,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	exec, err := remotecommand.NewSPDYExecutor(e.config, "POST", req.URL())
+	if err != nil {
+		return "", err
+	}
+	var stdout, stderr bytes.Buffer
+	err = exec.Stream(remotecommand.StreamOptions{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+	if err != nil {
+		return stdout.String(), fmt.Errorf("%s in pod %s/%s failed: %v: %s",
+			strings.Join(command, " "), pod.Namespace, pod.Name, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
@@ -0,0 +1,201 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/go-logr/logr"
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// clusterFinalizer keeps the RabbitMQ resource, and so the running cluster, until it is torn down
+const clusterFinalizer = "rabbitmq.mirantis.com/cluster"
+
+// objectFinalizer is the finalizer of the resources declaring objects inside the clusters, the
+// managed package which sets it can't be imported here
+const objectFinalizer = "rabbitmq.mirantis.com/object"
+
+// retainedLabel marks the data volumes of a deleted cluster kept for a new RabbitMQ resource with
+// the same name, its value is the name of the deleted resource
+const retainedLabel = "rabbitmq.mirantis.com/retained-from"
+
+// newObjectLists returns empty lists of the resources declaring objects inside the clusters
+func newObjectLists() []runtime.Object {
+	return []runtime.Object{
+		&rabbitmqv1alpha1.RabbitMQBindingList{},
+		&rabbitmqv1alpha1.RabbitMQShovelList{},
+		&rabbitmqv1alpha1.RabbitMQFederationUpstreamList{},
+		&rabbitmqv1alpha1.RabbitMQQueueList{},
+		&rabbitmqv1alpha1.RabbitMQExchangeList{},
+		&rabbitmqv1alpha1.RabbitMQPolicyList{},
+		&rabbitmqv1alpha1.RabbitMQOperatorPolicyList{},
+		&rabbitmqv1alpha1.RabbitMQUserList{},
+		&rabbitmqv1alpha1.RabbitMQVhostList{},
+	}
+}
+
+func hasClusterFinalizer(cr *rabbitmqv1alpha1.RabbitMQ) bool {
+	for _, f := range cr.Finalizers {
+		if f == clusterFinalizer {
+			return true
+		}
+	}
+	return false
+}
+
+func removeClusterFinalizer(cr *rabbitmqv1alpha1.RabbitMQ) {
+	finalizers := []string{}
+	for _, f := range cr.Finalizers {
+		if f != clusterFinalizer {
+			finalizers = append(finalizers, f)
+		}
+	}
+	cr.Finalizers = finalizers
+}
+
+// finalize tears the cluster down before the RabbitMQ resource and the objects it owns are deleted.
+// The resources declaring objects inside the cluster belong to the users and are kept, only their
+// finalizers are removed: the users, vhosts, queues and the rest are not deleted from the cluster,
+// they go away with its data or stay on the retained data volumes. Then the data volumes, which the
+// StatefulSet doesn't own, are deleted or labeled for adoption by the reclaim policy.
+func (r *ReconcileRabbitMQ) finalize(reqLogger logr.Logger, instance *rabbitmqv1alpha1.RabbitMQ) (reconcile.Result, error) {
+	if !hasClusterFinalizer(instance) {
+		return reconcile.Result{}, nil
+	}
+
+	if err := r.releaseClusterObjects(reqLogger, instance); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	cr := instance.DeepCopy()
+	cr.SetDefaults()
+	if err := r.reclaimDataVolumes(reqLogger, cr); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	reqLogger.Info("Removing the finalizer")
+	removeClusterFinalizer(instance)
+	return reconcile.Result{}, r.client.Update(context.TODO(), instance)
+}
+
+// releaseClusterObjects removes the finalizer from the resources declaring objects inside the cluster,
+// so they can be deleted without the cluster
+func (r *ReconcileRabbitMQ) releaseClusterObjects(reqLogger logr.Logger, cr *rabbitmqv1alpha1.RabbitMQ) error {
+	for _, list := range newObjectLists() {
+		if err := r.client.List(context.TODO(), client.InNamespace(cr.Namespace), list); err != nil {
+			return err
+		}
+		items, err := meta.ExtractList(list)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			if obj, ok := item.(clusterObject); !ok || obj.ClusterName() != cr.Name {
+				continue
+			}
+			accessor, err := meta.Accessor(item)
+			if err != nil {
+				return err
+			}
+			finalizers := []string{}
+			for _, f := range accessor.GetFinalizers() {
+				if f != objectFinalizer {
+					finalizers = append(finalizers, f)
+				}
+			}
+			if len(finalizers) == len(accessor.GetFinalizers()) {
+				continue
+			}
+			reqLogger.Info("Removing the finalizer of a resource of the cluster", "Kind", fmt.Sprintf("%T", item), "Name", accessor.GetName())
+			accessor.SetFinalizers(finalizers)
+			if err := r.client.Update(context.TODO(), item); err != nil && !errors.IsNotFound(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// dataVolumeClaimRegexp returns the regexp matching the names of the data volume claims of the cluster
+func dataVolumeClaimRegexp(cr *rabbitmqv1alpha1.RabbitMQ) *regexp.Regexp {
+	return regexp.MustCompile(`^` + regexp.QuoteMeta(dataVolumeName+"-"+statefulSetName(cr)+"-") + `[0-9]+$`)
+}
+
+// clusterDataVolumeClaims returns the data volume claims of the StatefulSet of the cluster, including
+// the ones of the members removed without a scale-down
+func (r *ReconcileRabbitMQ) clusterDataVolumeClaims(cr *rabbitmqv1alpha1.RabbitMQ) ([]corev1.PersistentVolumeClaim, error) {
+	pvcs := &corev1.PersistentVolumeClaimList{}
+	if err := r.client.List(context.TODO(), client.InNamespace(cr.Namespace), pvcs); err != nil {
+		return nil, err
+	}
+	re := dataVolumeClaimRegexp(cr)
+	claims := []corev1.PersistentVolumeClaim{}
+	for _, pvc := range pvcs.Items {
+		if re.MatchString(pvc.Name) {
+			claims = append(claims, pvc)
+		}
+	}
+	return claims, nil
+}
+
+// reclaimDataVolumes applies the reclaim policy to the data volumes of the deleted cluster
+func (r *ReconcileRabbitMQ) reclaimDataVolumes(reqLogger logr.Logger, cr *rabbitmqv1alpha1.RabbitMQ) error {
+	pvcs, err := r.clusterDataVolumeClaims(cr)
+	if err != nil {
+		return err
+	}
+	for i := range pvcs {
+		pvc := &pvcs[i]
+		pvcLogger := reqLogger.WithValues("PersistentVolumeClaim.Namespace", pvc.Namespace, "PersistentVolumeClaim.Name", pvc.Name)
+		if cr.Spec.Persistence.ReclaimPolicy == rabbitmqv1alpha1.ReclaimDelete {
+			// the claim is protected from deletion until the pod using it is gone
+			pvcLogger.Info("Deleting the data volume")
+			if err := r.client.Delete(context.TODO(), pvc); err != nil && !errors.IsNotFound(err) {
+				return err
+			}
+			continue
+		}
+		if pvc.Labels[retainedLabel] == cr.Name {
+			continue
+		}
+		pvcLogger.Info("Retaining the data volume")
+		if pvc.Labels == nil {
+			pvc.Labels = map[string]string{}
+		}
+		pvc.Labels[retainedLabel] = cr.Name
+		if err := r.client.Update(context.TODO(), pvc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// adoptRetainedVolumes takes over the data volumes retained from a deleted cluster with the same name.
+// The StatefulSet finds them by name, the nodes start with the data and the cluster membership they had.
+func (r *ReconcileRabbitMQ) adoptRetainedVolumes(reqLogger logr.Logger, cr *rabbitmqv1alpha1.RabbitMQ) error {
+	pvcs := &corev1.PersistentVolumeClaimList{}
+	opts := client.InNamespace(cr.Namespace).MatchingLabels(map[string]string{retainedLabel: cr.Name})
+	if err := r.client.List(context.TODO(), opts, pvcs); err != nil {
+		return err
+	}
+	re := dataVolumeClaimRegexp(cr)
+	for i := range pvcs.Items {
+		pvc := &pvcs.Items[i]
+		if !re.MatchString(pvc.Name) {
+			continue
+		}
+		reqLogger.Info("Adopting the retained data volume", "PersistentVolumeClaim.Namespace", pvc.Namespace, "PersistentVolumeClaim.Name", pvc.Name)
+		delete(pvc.Labels, retainedLabel)
+		if err := r.client.Update(context.TODO(), pvc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
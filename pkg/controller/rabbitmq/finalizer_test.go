@@ -0,0 +1,207 @@
+package rabbitmq
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+func newTestClaim(name string, labels map[string]string) *corev1.PersistentVolumeClaim {
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns", Labels: labels},
+	}
+}
+
+// claimLabels returns the retained label of the claims in the namespace of the test cluster by name
+func claimLabels(t *testing.T, r *ReconcileRabbitMQ) map[string]string {
+	pvcs := &corev1.PersistentVolumeClaimList{}
+	if err := r.client.List(context.TODO(), client.InNamespace("ns"), pvcs); err != nil {
+		t.Fatal(err)
+	}
+	labels := map[string]string{}
+	for _, pvc := range pvcs.Items {
+		labels[pvc.Name] = pvc.Labels[retainedLabel]
+	}
+	return labels
+}
+
+func TestClusterFinalizer(t *testing.T) {
+	cr := newTestCluster()
+	if hasClusterFinalizer(cr) {
+		t.Errorf("a new cluster has the finalizer")
+	}
+	cr.Finalizers = []string{"other", clusterFinalizer}
+	if !hasClusterFinalizer(cr) {
+		t.Errorf("the finalizer is not found")
+	}
+	removeClusterFinalizer(cr)
+	if !reflect.DeepEqual(cr.Finalizers, []string{"other"}) {
+		t.Errorf("got finalizers %v", cr.Finalizers)
+	}
+}
+
+func TestDataVolumeClaimRegexp(t *testing.T) {
+	re := dataVolumeClaimRegexp(newTestCluster())
+	name := dataVolumeName + "-" + statefulSetName(newTestCluster()) + "-"
+	for claim, want := range map[string]bool{
+		name + "0":                 true,
+		name + "12":                true,
+		name + "a":                 false,
+		name + "0-backup":          false,
+		"other-" + name + "0":      false,
+		dataVolumeName + "-rmq2-0": false,
+	} {
+		if got := re.MatchString(claim); got != want {
+			t.Errorf("got %v for %s, want %v", got, claim, want)
+		}
+	}
+}
+
+func TestReclaimDataVolumes(t *testing.T) {
+	name := dataVolumeName + "-" + statefulSetName(newTestCluster())
+	other := dataVolumeName + "-other-0"
+	tests := []struct {
+		name   string
+		policy rabbitmqv1alpha1.ReclaimPolicy
+		want   map[string]string
+	}{
+		{
+			name:   "retain",
+			policy: rabbitmqv1alpha1.ReclaimRetain,
+			want:   map[string]string{name + "-0": "rmq", name + "-5": "rmq", other: ""},
+		},
+		{
+			name:   "delete",
+			policy: rabbitmqv1alpha1.ReclaimDelete,
+			want:   map[string]string{other: ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestReconciler(
+				newTestClaim(name+"-0", nil),
+				newTestClaim(name+"-5", map[string]string{"backup": "daily"}),
+				newTestClaim(other, nil),
+			)
+			cr := newTestCluster()
+			cr.Spec.Persistence.ReclaimPolicy = tt.policy
+			if err := r.reclaimDataVolumes(logf.Log, cr); err != nil {
+				t.Fatal(err)
+			}
+			if got := claimLabels(t, r); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got claims %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdoptRetainedVolumes(t *testing.T) {
+	name := dataVolumeName + "-" + statefulSetName(newTestCluster())
+	r := newTestReconciler(
+		newTestClaim(name+"-0", map[string]string{retainedLabel: "rmq"}),
+		newTestClaim(name+"-1", map[string]string{retainedLabel: "other"}),
+		newTestClaim("backup-rmq-0", map[string]string{retainedLabel: "rmq"}),
+	)
+	if err := r.adoptRetainedVolumes(logf.Log, newTestCluster()); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{name + "-0": "", name + "-1": "other", "backup-rmq-0": "rmq"}
+	if got := claimLabels(t, r); !reflect.DeepEqual(got, want) {
+		t.Errorf("got claims %v, want %v", got, want)
+	}
+}
+
+func TestFinalize(t *testing.T) {
+	user := &rabbitmqv1alpha1.RabbitMQUser{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "ns", Finalizers: []string{objectFinalizer}},
+		Spec:       rabbitmqv1alpha1.RabbitMQUserSpec{Cluster: "rmq"},
+	}
+	// the queue still holds messages, it must outlive the cluster with its data
+	queue := &rabbitmqv1alpha1.RabbitMQQueue{
+		ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "ns", Finalizers: []string{"other", objectFinalizer}},
+		Spec:       rabbitmqv1alpha1.RabbitMQQueueSpec{Cluster: "rmq"},
+	}
+	otherVhost := &rabbitmqv1alpha1.RabbitMQVhost{
+		ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "ns", Finalizers: []string{objectFinalizer}},
+		Spec:       rabbitmqv1alpha1.RabbitMQVhostSpec{Cluster: "other"},
+	}
+	claim := newTestClaim(dataVolumeName+"-"+statefulSetName(newTestCluster())+"-0", nil)
+	instance := newTestCluster()
+	instance.Finalizers = []string{clusterFinalizer}
+	instance.Spec.Persistence.ReclaimPolicy = rabbitmqv1alpha1.ReclaimRetain
+	r := newTestReconciler(user, queue, otherVhost, instance, claim)
+
+	result, err := r.finalize(logf.Log, instance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.RequeueAfter > 0 || result.Requeue {
+		t.Errorf("got result %+v", result)
+	}
+	found := &rabbitmqv1alpha1.RabbitMQ{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "rmq", Namespace: "ns"}, found); err != nil {
+		t.Fatal(err)
+	}
+	if hasClusterFinalizer(found) {
+		t.Errorf("got finalizers %v", found.Finalizers)
+	}
+
+	// the resources of the cluster are kept without the finalizer, the ones of other clusters are left alone
+	for _, tt := range []struct {
+		obj        runtime.Object
+		name       string
+		finalizers []string
+	}{
+		{&rabbitmqv1alpha1.RabbitMQUser{}, "app", []string{}},
+		{&rabbitmqv1alpha1.RabbitMQQueue{}, "orders", []string{"other"}},
+		{&rabbitmqv1alpha1.RabbitMQVhost{}, "shop", []string{objectFinalizer}},
+	} {
+		if err := r.client.Get(context.TODO(), types.NamespacedName{Name: tt.name, Namespace: "ns"}, tt.obj); err != nil {
+			t.Errorf("%s deleted: %v", tt.name, err)
+			continue
+		}
+		accessor, err := meta.Accessor(tt.obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := accessor.GetFinalizers(); len(got) != len(tt.finalizers) || (len(got) > 0 && !reflect.DeepEqual(got, tt.finalizers)) {
+			t.Errorf("got finalizers %v of %s, want %v", got, tt.name, tt.finalizers)
+		}
+	}
+	if labels := claimLabels(t, r); labels[claim.Name] != "rmq" {
+		t.Errorf("got claims %v", labels)
+	}
+}
+
+func TestNewObjectLists(t *testing.T) {
+	// the lists cover every kind declaring objects inside the clusters
+	kinds := []string{}
+	for _, list := range newObjectLists() {
+		kinds = append(kinds, reflect.TypeOf(list).Elem().Name())
+	}
+	sort.Strings(kinds)
+	want := []string{
+		"RabbitMQBindingList",
+		"RabbitMQExchangeList",
+		"RabbitMQFederationUpstreamList",
+		"RabbitMQOperatorPolicyList",
+		"RabbitMQPolicyList",
+		"RabbitMQQueueList",
+		"RabbitMQShovelList",
+		"RabbitMQUserList",
+		"RabbitMQVhostList",
+	}
+	if !reflect.DeepEqual(kinds, want) {
+		t.Errorf("got %v, want %v", kinds, want)
+	}
+}
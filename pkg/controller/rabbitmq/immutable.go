@@ -0,0 +1,39 @@
+package rabbitmq
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	v1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// recreateOnImmutableChange replaces a StatefulSet created by the older versions of the operator with
+// another pod management policy or governing Service. Both can't be updated: with the OrderedReady
+// policy a node restarted together with the others waits for a pod which is not created until it
+// is ready itself, and the stable host names of the pods are only resolved through the headless
+// Service. The StatefulSet is deleted with orphan propagation and created again on the next
+// reconciliation, the pods are adopted by the new StatefulSet and keep running. It reports whether
+// the StatefulSet is being recreated.
+func (r *ReconcileRabbitMQ) recreateOnImmutableChange(reqLogger logr.Logger, desired, live *v1.StatefulSet) (bool, error) {
+	if live.DeletionTimestamp != nil {
+		return true, nil
+	}
+	policy := live.Spec.PodManagementPolicy
+	if policy == "" {
+		policy = v1.OrderedReadyPodManagement
+	}
+	if policy == desired.Spec.PodManagementPolicy && live.Spec.ServiceName == desired.Spec.ServiceName {
+		return false, nil
+	}
+
+	reqLogger.Info("Deleting the StatefulSet and orphaning its pods to replace its immutable fields",
+		"StatefulSet.Namespace", live.Namespace, "StatefulSet.Name", live.Name,
+		"PodManagementPolicy", policy, "ServiceName", live.Spec.ServiceName)
+	if err := r.client.Delete(context.TODO(), live, client.PropagationPolicy(metav1.DeletePropagationOrphan)); err != nil && !errors.IsNotFound(err) {
+		return true, err
+	}
+	return true, nil
+}
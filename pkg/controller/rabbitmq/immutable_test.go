@@ -0,0 +1,100 @@
+package rabbitmq
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+func TestRecreateOnImmutableChange(t *testing.T) {
+	tests := []struct {
+		name       string
+		live       func(ss *v1.StatefulSet)
+		recreating bool
+	}{
+		{"up to date", nil, false},
+		{"OrderedReady", func(ss *v1.StatefulSet) {
+			ss.Spec.PodManagementPolicy = v1.OrderedReadyPodManagement
+		}, true},
+		{"policy left to the API server", func(ss *v1.StatefulSet) {
+			ss.Spec.PodManagementPolicy = ""
+		}, true},
+		{"governed by the client Service", func(ss *v1.StatefulSet) {
+			ss.Spec.ServiceName = "rmq-client"
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			live := newTestStatefulSet(3, nil)
+			if tt.live != nil {
+				tt.live(live)
+			}
+			pods := newTestPods(live, nil)
+			r := newTestReconciler(append(pods, live)...)
+
+			recreating, err := r.recreateOnImmutableChange(logf.Log, newTestStatefulSet(3, nil), live)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if recreating != tt.recreating {
+				t.Errorf("got recreating %v, want %v", recreating, tt.recreating)
+			}
+			err = r.client.Get(context.TODO(), types.NamespacedName{Name: live.Name, Namespace: live.Namespace}, &v1.StatefulSet{})
+			if deleted := errors.IsNotFound(err); deleted != tt.recreating {
+				t.Errorf("got the StatefulSet deleted %v, want %v", deleted, tt.recreating)
+			}
+			if got, want := podNames(r, live), []string{"rmq-0", "rmq-1", "rmq-2"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("got pods %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestReplaceOrderedReadyStatefulSet(t *testing.T) {
+	cr := newTestCluster()
+	live := newTestStatefulSet(3, nil)
+	live.Spec.PodManagementPolicy = v1.OrderedReadyPodManagement
+	r := newTestReconciler(append(newTestPods(live, nil), cr, live)...)
+	name := types.NamespacedName{Name: live.Name, Namespace: live.Namespace}
+
+	// the update leaves the immutable policy alone
+	found := &v1.StatefulSet{}
+	if err := r.createOrUpdate(logf.Log, cr, newTestStatefulSet(3, nil), found, statefulSetFields...); err != nil {
+		t.Fatal(err)
+	}
+	if found.Spec.PodManagementPolicy != v1.OrderedReadyPodManagement {
+		t.Fatalf("got pod management policy %s updated in place", found.Spec.PodManagementPolicy)
+	}
+
+	// the old StatefulSet is deleted, the pods keep running
+	if recreating, err := r.recreateOnImmutableChange(logf.Log, newTestStatefulSet(3, nil), found); err != nil || !recreating {
+		t.Fatalf("got recreating %v, %v", recreating, err)
+	}
+	if err := r.client.Get(context.TODO(), name, &v1.StatefulSet{}); !errors.IsNotFound(err) {
+		t.Fatalf("got %v getting the StatefulSet, want it deleted", err)
+	}
+
+	// the new one restarts the nodes in parallel
+	found = &v1.StatefulSet{}
+	if err := r.createOrUpdate(logf.Log, cr, newTestStatefulSet(3, nil), found, statefulSetFields...); err != nil {
+		t.Fatal(err)
+	}
+	ss := &v1.StatefulSet{}
+	if err := r.client.Get(context.TODO(), name, ss); err != nil {
+		t.Fatal(err)
+	}
+	if ss.Spec.PodManagementPolicy != v1.ParallelPodManagement {
+		t.Errorf("got pod management policy %s, want %s", ss.Spec.PodManagementPolicy, v1.ParallelPodManagement)
+	}
+	if recreating, err := r.recreateOnImmutableChange(logf.Log, newTestStatefulSet(3, nil), ss); err != nil || recreating {
+		t.Errorf("got recreating %v, %v for the new StatefulSet", recreating, err)
+	}
+	if got, want := podNames(r, live), []string{"rmq-0", "rmq-1", "rmq-2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got pods %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,136 @@
+package rabbitmq
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// metricsNamespace prefixes the names of the metrics of the operator
+const metricsNamespace = "rabbitmq_operator"
+
+// clusterLabels identify the RabbitMQ resource a metric is about
+var clusterLabels = []string{"namespace", "rabbitmq"}
+
+var (
+	reconcileDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "reconcile_duration_seconds",
+		Help:      "Duration of the reconciliations of the RabbitMQ resource",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}, clusterLabels)
+	reconcileErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "reconcile_errors_total",
+		Help:      "Number of the failed reconciliations of the RabbitMQ resource",
+	}, clusterLabels)
+
+	desiredReplicasDesc = prometheus.NewDesc(metricsNamespace+"_cluster_desired_replicas",
+		"Desired number of members of the cluster", clusterLabels, nil)
+	readyReplicasDesc = prometheus.NewDesc(metricsNamespace+"_cluster_ready_replicas",
+		"Number of ready members of the cluster", clusterLabels, nil)
+	operationDesc = prometheus.NewDesc(metricsNamespace+"_cluster_operation",
+		"Operation in progress in the cluster, one of upgrade, scaling and storage, with its current phase or step",
+		append(clusterLabels, "operation", "state"), nil)
+	conditionDesc = prometheus.NewDesc(metricsNamespace+"_cluster_condition",
+		"Condition of the cluster, 1 for the current status of the condition and 0 for the others",
+		append(clusterLabels, "condition", "status"), nil)
+)
+
+// clusterMetrics reports the state of the clusters as observed by the last reconciliation
+var clusterMetrics = &clusterCollector{clusters: map[types.NamespacedName]clusterState{}}
+
+func init() {
+	metrics.Registry.MustRegister(reconcileDuration, reconcileErrors, clusterMetrics)
+}
+
+// observeReconcile records the duration and the outcome of a reconciliation of the RabbitMQ resource
+func observeReconcile(name types.NamespacedName, duration time.Duration, err error) {
+	reconcileDuration.WithLabelValues(name.Namespace, name.Name).Observe(duration.Seconds())
+	if err != nil {
+		reconcileErrors.WithLabelValues(name.Namespace, name.Name).Inc()
+	}
+}
+
+// clusterState is what the collector knows about a cluster
+type clusterState struct {
+	desiredReplicas int32
+	status          *rabbitmqv1alpha1.RabbitMQStatus
+}
+
+// clusterCollector collects the state of the clusters, the metrics of a deleted cluster disappear with it
+type clusterCollector struct {
+	mu       sync.Mutex
+	clusters map[types.NamespacedName]clusterState
+}
+
+var _ prometheus.Collector = &clusterCollector{}
+
+// observe records the desired number of members and the status of the cluster
+func (c *clusterCollector) observe(cr *rabbitmqv1alpha1.RabbitMQ, status *rabbitmqv1alpha1.RabbitMQStatus) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.clusters[types.NamespacedName{Namespace: cr.Namespace, Name: cr.Name}] = clusterState{
+		desiredReplicas: cr.Spec.Replicas,
+		status:          status.DeepCopy(),
+	}
+}
+
+// forget drops the metrics of the deleted cluster
+func (c *clusterCollector) forget(name types.NamespacedName) {
+	c.mu.Lock()
+	delete(c.clusters, name)
+	c.mu.Unlock()
+	reconcileDuration.DeleteLabelValues(name.Namespace, name.Name)
+	reconcileErrors.DeleteLabelValues(name.Namespace, name.Name)
+}
+
+// Describe implements prometheus.Collector
+func (c *clusterCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- desiredReplicasDesc
+	ch <- readyReplicasDesc
+	ch <- operationDesc
+	ch <- conditionDesc
+}
+
+// Collect implements prometheus.Collector
+func (c *clusterCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, state := range c.clusters {
+		labels := []string{name.Namespace, name.Name}
+		status := state.status
+		ch <- prometheus.MustNewConstMetric(desiredReplicasDesc, prometheus.GaugeValue, float64(state.desiredReplicas), labels...)
+		ch <- prometheus.MustNewConstMetric(readyReplicasDesc, prometheus.GaugeValue, float64(status.ReadyReplicas), labels...)
+
+		operation := func(operation, state string) {
+			ch <- prometheus.MustNewConstMetric(operationDesc, prometheus.GaugeValue, 1, append(labels, operation, state)...)
+		}
+		if upgrade := status.Upgrade; upgrade != nil && upgrade.Phase != rabbitmqv1alpha1.UpgradeCompleted {
+			operation("upgrade", string(upgrade.Phase))
+		}
+		if scaling := status.Scaling; scaling != nil {
+			operation("scaling", string(scaling.Step))
+		}
+		if storage := status.Storage; storage != nil {
+			operation("storage", string(storage.Phase))
+		}
+
+		for _, condition := range status.Conditions {
+			for _, s := range []corev1.ConditionStatus{corev1.ConditionTrue, corev1.ConditionFalse, corev1.ConditionUnknown} {
+				value := 0.0
+				if condition.Status == s {
+					value = 1
+				}
+				ch <- prometheus.MustNewConstMetric(conditionDesc, prometheus.GaugeValue, value,
+					append(labels, string(condition.Type), strings.ToLower(string(s)))...)
+			}
+		}
+	}
+}
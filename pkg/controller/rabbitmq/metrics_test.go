@@ -0,0 +1,111 @@
+package rabbitmq
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestClusterCollector(t *testing.T) {
+	tests := []struct {
+		name   string
+		status rabbitmqv1alpha1.RabbitMQStatus
+		// want are the metrics after the ones of the replicas
+		want string
+	}{
+		{
+			name:   "idle",
+			status: rabbitmqv1alpha1.RabbitMQStatus{ReadyReplicas: 3},
+		},
+		{
+			name: "operations",
+			status: rabbitmqv1alpha1.RabbitMQStatus{
+				ReadyReplicas: 3,
+				Upgrade:       &rabbitmqv1alpha1.UpgradeStatus{Phase: rabbitmqv1alpha1.UpgradeRollingOut},
+				Scaling:       &rabbitmqv1alpha1.ScalingStatus{Step: rabbitmqv1alpha1.ScalingDraining},
+				Storage:       &rabbitmqv1alpha1.StorageStatus{Phase: rabbitmqv1alpha1.StorageExpanding},
+			},
+			want: `
+# HELP rabbitmq_operator_cluster_operation Operation in progress in the cluster, one of upgrade, scaling and storage, with its current phase or step
+# TYPE rabbitmq_operator_cluster_operation gauge
+rabbitmq_operator_cluster_operation{namespace="ns",operation="scaling",rabbitmq="rmq",state="Draining"} 1
+rabbitmq_operator_cluster_operation{namespace="ns",operation="storage",rabbitmq="rmq",state="Expanding"} 1
+rabbitmq_operator_cluster_operation{namespace="ns",operation="upgrade",rabbitmq="rmq",state="RollingOut"} 1
+`,
+		},
+		{
+			name: "completed upgrade",
+			status: rabbitmqv1alpha1.RabbitMQStatus{
+				ReadyReplicas: 3,
+				Upgrade:       &rabbitmqv1alpha1.UpgradeStatus{Phase: rabbitmqv1alpha1.UpgradeCompleted},
+			},
+		},
+		{
+			name: "conditions",
+			status: rabbitmqv1alpha1.RabbitMQStatus{
+				ReadyReplicas: 2,
+				Conditions: []rabbitmqv1alpha1.RabbitMQCondition{
+					{Type: rabbitmqv1alpha1.RabbitMQAvailable, Status: corev1.ConditionTrue},
+					{Type: rabbitmqv1alpha1.RabbitMQDegraded, Status: corev1.ConditionUnknown},
+				},
+			},
+			want: `
+# HELP rabbitmq_operator_cluster_condition Condition of the cluster, 1 for the current status of the condition and 0 for the others
+# TYPE rabbitmq_operator_cluster_condition gauge
+rabbitmq_operator_cluster_condition{condition="Available",namespace="ns",rabbitmq="rmq",status="false"} 0
+rabbitmq_operator_cluster_condition{condition="Available",namespace="ns",rabbitmq="rmq",status="true"} 1
+rabbitmq_operator_cluster_condition{condition="Available",namespace="ns",rabbitmq="rmq",status="unknown"} 0
+rabbitmq_operator_cluster_condition{condition="Degraded",namespace="ns",rabbitmq="rmq",status="false"} 0
+rabbitmq_operator_cluster_condition{condition="Degraded",namespace="ns",rabbitmq="rmq",status="true"} 0
+rabbitmq_operator_cluster_condition{condition="Degraded",namespace="ns",rabbitmq="rmq",status="unknown"} 1
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &clusterCollector{clusters: map[types.NamespacedName]clusterState{}}
+			c.observe(newTestCluster(), &tt.status)
+			want := fmt.Sprintf(`
+# HELP rabbitmq_operator_cluster_desired_replicas Desired number of members of the cluster
+# TYPE rabbitmq_operator_cluster_desired_replicas gauge
+rabbitmq_operator_cluster_desired_replicas{namespace="ns",rabbitmq="rmq"} 3
+# HELP rabbitmq_operator_cluster_ready_replicas Number of ready members of the cluster
+# TYPE rabbitmq_operator_cluster_ready_replicas gauge
+rabbitmq_operator_cluster_ready_replicas{namespace="ns",rabbitmq="rmq"} %d
+`, tt.status.ReadyReplicas) + tt.want
+			if err := testutil.CollectAndCompare(c, strings.NewReader(want)); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestClusterCollectorForget(t *testing.T) {
+	c := &clusterCollector{clusters: map[types.NamespacedName]clusterState{}}
+	cr := newTestCluster()
+	c.observe(cr, &rabbitmqv1alpha1.RabbitMQStatus{})
+	c.forget(types.NamespacedName{Namespace: cr.Namespace, Name: cr.Name})
+	if err := testutil.CollectAndCompare(c, strings.NewReader("")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestObserveReconcile(t *testing.T) {
+	name := types.NamespacedName{Namespace: "metrics-test", Name: "rmq"}
+	defer clusterMetrics.forget(name)
+	observeReconcile(name, time.Second, nil)
+	observeReconcile(name, time.Second, fmt.Errorf("failed"))
+	if failures := testutil.ToFloat64(reconcileErrors.WithLabelValues(name.Namespace, name.Name)); failures != 1 {
+		t.Errorf("got %v errors, want 1", failures)
+	}
+	clusterMetrics.forget(name)
+	if failures := testutil.ToFloat64(reconcileErrors.WithLabelValues(name.Namespace, name.Name)); failures != 0 {
+		t.Errorf("got %v errors after the cluster is forgotten", failures)
+	}
+}
@@ -0,0 +1,169 @@
+package rabbitmq
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+
+	monitoringv1 "github.com/coreos/prometheus-operator/pkg/apis/monitoring/v1"
+	"github.com/go-logr/logr"
+	"github.com/operator-framework/operator-sdk/pkg/k8sutil"
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// prometheusPlugin serves the metrics of the node in the Prometheus format
+	prometheusPlugin = "rabbitmq_prometheus"
+	// prometheusPort is the port the metrics are served on
+	prometheusPort = 15692
+	// prometheusPortName is the name of the metrics port of the container and the Service
+	prometheusPortName = "prometheus"
+)
+
+// monitoringProtectedConfigPrefixes are the settings of the metrics listener the ports depend on
+var monitoringProtectedConfigPrefixes = []string{"prometheus.tcp."}
+
+// serviceMonitorName returns the name of the ServiceMonitor of the cluster
+func serviceMonitorName(cr *rabbitmqv1alpha1.RabbitMQ) string {
+	return cr.Name
+}
+
+// monitoringPlugins returns the plugins the monitoring needs. The metrics plugin ships with RabbitMQ 3.8,
+// the nodes of older versions wouldn't start with it; the images without a version in the tag are trusted.
+func monitoringPlugins(cr *rabbitmqv1alpha1.RabbitMQ) ([]string, error) {
+	if cr.Spec.Monitoring == nil {
+		return nil, nil
+	}
+	if v, ok := imageVersion(cr.Spec.Image); ok && (v.major < 3 || v.major == 3 && v.minor < 8) {
+		return nil, fmt.Errorf("monitoring needs RabbitMQ 3.8 or later, the image runs %s", v)
+	}
+	return []string{prometheusPlugin}, nil
+}
+
+// monitoringConfig returns the settings of the metrics listener
+func monitoringConfig(cr *rabbitmqv1alpha1.RabbitMQ) []confEntry {
+	if cr.Spec.Monitoring == nil {
+		return nil
+	}
+	return []confEntry{
+		{
+			comment: "## Prometheus metrics. See https://www.rabbitmq.com/prometheus.html",
+			key:     "prometheus.tcp.port",
+			value:   strconv.Itoa(prometheusPort),
+		},
+	}
+}
+
+// monitoringServicePorts returns the metrics port of the client Service
+func monitoringServicePorts(cr *rabbitmqv1alpha1.RabbitMQ) []corev1.ServicePort {
+	if cr.Spec.Monitoring == nil {
+		return nil
+	}
+	return []corev1.ServicePort{
+		{
+			Name:       prometheusPortName,
+			Protocol:   corev1.ProtocolTCP,
+			Port:       prometheusPort,
+			TargetPort: intstr.FromInt(prometheusPort),
+		},
+	}
+}
+
+// addMonitoring adds the metrics port to the rabbitmq container
+func addMonitoring(cr *rabbitmqv1alpha1.RabbitMQ, container *corev1.Container) {
+	if cr.Spec.Monitoring == nil {
+		return
+	}
+	container.Ports = append(container.Ports, corev1.ContainerPort{
+		Name:          prometheusPortName,
+		Protocol:      corev1.ProtocolTCP,
+		ContainerPort: prometheusPort,
+	})
+}
+
+// newServiceMonitor returns the ServiceMonitor scraping every node behind the client Service
+func newServiceMonitor(cr *rabbitmqv1alpha1.RabbitMQ) *monitoringv1.ServiceMonitor {
+	labels := labelsForRabbitMQ(cr)
+	for k, v := range cr.Spec.Monitoring.Labels {
+		labels[k] = v
+	}
+	return &monitoringv1.ServiceMonitor{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      serviceMonitorName(cr),
+			Namespace: cr.Namespace,
+			Labels:    labels,
+		},
+		Spec: monitoringv1.ServiceMonitorSpec{
+			// the headless Service has the same labels but no metrics port, it is skipped
+			Selector: metav1.LabelSelector{
+				MatchLabels: labelsForRabbitMQ(cr),
+			},
+			NamespaceSelector: monitoringv1.NamespaceSelector{
+				MatchNames: []string{cr.Namespace},
+			},
+			Endpoints: []monitoringv1.Endpoint{
+				{
+					Port:     prometheusPortName,
+					Interval: cr.Spec.Monitoring.Interval,
+				},
+			},
+		},
+	}
+}
+
+// reconcileServiceMonitor creates, updates or deletes the ServiceMonitor of the cluster. Without the
+// Prometheus Operator there is nothing to do, the metrics can still be scraped from the Service.
+func (r *ReconcileRabbitMQ) reconcileServiceMonitor(reqLogger logr.Logger, instance, cr *rabbitmqv1alpha1.RabbitMQ) error {
+	installed, err := k8sutil.ResourceExists(r.discovery, monitoringv1.SchemeGroupVersion.String(), monitoringv1.ServiceMonitorsKind)
+	if err != nil || !installed {
+		return err
+	}
+	serviceMonitors := r.monitoring.ServiceMonitors(cr.Namespace)
+	smLogger := reqLogger.WithValues("ServiceMonitor.Namespace", cr.Namespace, "ServiceMonitor.Name", serviceMonitorName(cr))
+
+	found, err := serviceMonitors.Get(serviceMonitorName(cr), metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	exists := err == nil
+	if exists && !metav1.IsControlledBy(found, instance) {
+		if cr.Spec.Monitoring != nil {
+			smLogger.Info("ServiceMonitor already exists and is not controlled by the RabbitMQ instance, leaving it")
+		}
+		return nil
+	}
+
+	if cr.Spec.Monitoring == nil {
+		if !exists {
+			return nil
+		}
+		smLogger.Info("Deleting the ServiceMonitor")
+		if err := serviceMonitors.Delete(found.Name, &metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		return nil
+	}
+
+	desired := newServiceMonitor(cr)
+	if !exists {
+		if err := controllerutil.SetControllerReference(instance, desired, r.scheme); err != nil {
+			return err
+		}
+		smLogger.Info("Creating a new ServiceMonitor")
+		_, err := serviceMonitors.Create(desired)
+		return err
+	}
+	if reflect.DeepEqual(found.Labels, desired.Labels) && reflect.DeepEqual(found.Spec, desired.Spec) {
+		return nil
+	}
+	found.Labels = desired.Labels
+	found.Spec = desired.Spec
+	smLogger.Info("Updating the ServiceMonitor")
+	_, err = serviceMonitors.Update(found)
+	return err
+}
@@ -0,0 +1,171 @@
+package rabbitmq
+
+import (
+	"reflect"
+	"testing"
+
+	monitoringv1 "github.com/coreos/prometheus-operator/pkg/apis/monitoring/v1"
+	monitoringfake "github.com/coreos/prometheus-operator/pkg/client/versioned/fake"
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+func TestMonitoringPlugins(t *testing.T) {
+	tests := []struct {
+		name       string
+		monitoring *rabbitmqv1alpha1.MonitoringSpec
+		image      string
+		want       []string
+		wantErr    bool
+	}{
+		{name: "disabled", image: "rabbitmq:3.7.17"},
+		{name: "3.8", monitoring: &rabbitmqv1alpha1.MonitoringSpec{}, image: "rabbitmq:3.8.2-management", want: []string{prometheusPlugin}},
+		{name: "no version", monitoring: &rabbitmqv1alpha1.MonitoringSpec{}, image: "registry:5000/rabbitmq:latest", want: []string{prometheusPlugin}},
+		{name: "3.7", monitoring: &rabbitmqv1alpha1.MonitoringSpec{}, image: "rabbitmq:3.7.17", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := newTestCluster()
+			cr.Spec.Monitoring = tt.monitoring
+			cr.Spec.Image = tt.image
+			got, err := monitoringPlugins(cr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want an error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMonitoringSettings(t *testing.T) {
+	cr := newTestCluster()
+	cr.Spec.AdditionalConfig = "prometheus.tcp.port = 9419"
+	if _, err := parseAdditionalConfig(cr); err != nil {
+		t.Errorf("the metrics port can't be set without monitoring: %v", err)
+	}
+	if ports := servicePortNames(newService(cr)); ports[prometheusPortName] != 0 {
+		t.Errorf("metrics port without monitoring: %v", ports)
+	}
+
+	cr.Spec.Monitoring = &rabbitmqv1alpha1.MonitoringSpec{}
+	if _, err := parseAdditionalConfig(cr); err == nil {
+		t.Errorf("the metrics port of the operator can be overridden")
+	}
+	cr.Spec.AdditionalConfig = ""
+	conf, err := renderRabbitMQConf(cr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port := confValues(t, conf)["prometheus.tcp.port"]; port != "15692" {
+		t.Errorf("got prometheus.tcp.port %q", port)
+	}
+	if ports := servicePortNames(newService(cr)); ports[prometheusPortName] != prometheusPort {
+		t.Errorf("got ports %v", ports)
+	}
+	found := false
+	for _, p := range newStatefulSet(cr, nil).Spec.Template.Spec.Containers[0].Ports {
+		found = found || p.Name == prometheusPortName && p.ContainerPort == prometheusPort
+	}
+	if !found {
+		t.Errorf("the container has no metrics port")
+	}
+}
+
+func TestNewServiceMonitor(t *testing.T) {
+	cr := newTestCluster()
+	cr.Spec.Monitoring = &rabbitmqv1alpha1.MonitoringSpec{Labels: map[string]string{"release": "prometheus"}, Interval: "30s"}
+	sm := newServiceMonitor(cr)
+	if sm.Labels["release"] != "prometheus" {
+		t.Errorf("got labels %v", sm.Labels)
+	}
+	// the labels of the ServiceMonitor don't leak into the selector of the Services
+	if !reflect.DeepEqual(sm.Spec.Selector.MatchLabels, labelsForRabbitMQ(cr)) {
+		t.Errorf("got selector %v", sm.Spec.Selector.MatchLabels)
+	}
+	want := []monitoringv1.Endpoint{{Port: prometheusPortName, Interval: "30s"}}
+	if !reflect.DeepEqual(sm.Spec.Endpoints, want) {
+		t.Errorf("got endpoints %+v, want %+v", sm.Spec.Endpoints, want)
+	}
+}
+
+func TestReconcileServiceMonitor(t *testing.T) {
+	owner := newTestCluster()
+	owner.UID = "rmq-uid"
+	owned := func(interval string) runtime.Object {
+		cr := owner.DeepCopy()
+		cr.Spec.Monitoring = &rabbitmqv1alpha1.MonitoringSpec{Interval: interval}
+		sm := newServiceMonitor(cr)
+		controller := true
+		sm.OwnerReferences = []metav1.OwnerReference{{
+			APIVersion: rabbitmqv1alpha1.SchemeGroupVersion.String(),
+			Kind:       "RabbitMQ",
+			Name:       owner.Name,
+			UID:        owner.UID,
+			Controller: &controller,
+		}}
+		return sm
+	}
+	foreign := func() runtime.Object {
+		cr := owner.DeepCopy()
+		cr.Spec.Monitoring = &rabbitmqv1alpha1.MonitoringSpec{Interval: "1m"}
+		return newServiceMonitor(cr)
+	}
+
+	tests := []struct {
+		name       string
+		installed  bool
+		existing   []runtime.Object
+		monitoring *rabbitmqv1alpha1.MonitoringSpec
+		// want is the interval of the ServiceMonitor after the reconcile, nil for no ServiceMonitor
+		want *string
+	}{
+		{name: "no Prometheus Operator", monitoring: &rabbitmqv1alpha1.MonitoringSpec{}},
+		{name: "created", installed: true, monitoring: &rabbitmqv1alpha1.MonitoringSpec{Interval: "30s"}, want: newString("30s")},
+		{name: "updated", installed: true, existing: []runtime.Object{owned("1m")}, monitoring: &rabbitmqv1alpha1.MonitoringSpec{Interval: "30s"}, want: newString("30s")},
+		{name: "deleted", installed: true, existing: []runtime.Object{owned("1m")}},
+		{name: "not controlled", installed: true, existing: []runtime.Object{foreign()}, monitoring: &rabbitmqv1alpha1.MonitoringSpec{}, want: newString("1m")},
+		{name: "not controlled without monitoring", installed: true, existing: []runtime.Object{foreign()}, want: newString("1m")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := monitoringfake.NewSimpleClientset(tt.existing...)
+			if tt.installed {
+				cs.Resources = []*metav1.APIResourceList{{
+					GroupVersion: monitoringv1.SchemeGroupVersion.String(),
+					APIResources: []metav1.APIResource{{Name: "servicemonitors", Kind: monitoringv1.ServiceMonitorsKind}},
+				}}
+			}
+			r := newTestReconciler()
+			r.discovery = cs.Discovery()
+			r.monitoring = cs.MonitoringV1()
+			cr := owner.DeepCopy()
+			cr.Spec.Monitoring = tt.monitoring
+
+			if err := r.reconcileServiceMonitor(logf.Log, owner, cr); err != nil {
+				t.Fatal(err)
+			}
+			sm, err := cs.MonitoringV1().ServiceMonitors("ns").Get(serviceMonitorName(cr), metav1.GetOptions{})
+			if tt.want == nil {
+				if !errors.IsNotFound(err) {
+					t.Errorf("got %v, want no ServiceMonitor", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if interval := sm.Spec.Endpoints[0].Interval; interval != *tt.want {
+				t.Errorf("got interval %q, want %q", interval, *tt.want)
+			}
+		})
+	}
+}
+
+func newString(s string) *string {
+	return &s
+}
@@ -0,0 +1,42 @@
+package rabbitmq
+
+import (
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+)
+
+// configMapName returns the name of the ConfigMap with the configuration files of the cluster
+func configMapName(cr *rabbitmqv1alpha1.RabbitMQ) string {
+	return cr.Name + "-config"
+}
+
+// statefulSetName returns the name of the StatefulSet running the cluster
+func statefulSetName(cr *rabbitmqv1alpha1.RabbitMQ) string {
+	return cr.Name
+}
+
+// headlessServiceName returns the name of the governing Service of the StatefulSet.
+// The service name of a StatefulSet can't be changed, so it has to match the one
+// the older versions of the operator have set.
+func headlessServiceName(cr *rabbitmqv1alpha1.RabbitMQ) string {
+	return cr.Name
+}
+
+// labelsForRabbitMQ returns the labels of all objects owned by the RabbitMQ instance
+func labelsForRabbitMQ(cr *rabbitmqv1alpha1.RabbitMQ) map[string]string {
+	labels := selectorForRabbitMQ(cr)
+	labels["app.kubernetes.io/managed-by"] = "rabbitmq-operator"
+	return labels
+}
+
+// selectorForRabbitMQ returns the labels selecting the pods of the RabbitMQ instance only
+func selectorForRabbitMQ(cr *rabbitmqv1alpha1.RabbitMQ) map[string]string {
+	return map[string]string{
+		"app.kubernetes.io/name":     "rabbitmq",
+		"app.kubernetes.io/instance": cr.Name,
+	}
+}
+
+// podDisruptionBudgetName returns the name of the PodDisruptionBudget of the cluster
+func podDisruptionBudgetName(cr *rabbitmqv1alpha1.RabbitMQ) string {
+	return cr.Name
+}
@@ -0,0 +1,28 @@
+package rabbitmq
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestLabelsForRabbitMQ(t *testing.T) {
+	cr := newTestCluster()
+	other := newTestCluster()
+	other.Name = "other"
+
+	selector := labels.SelectorFromSet(selectorForRabbitMQ(cr))
+	if !selector.Matches(labels.Set(labelsForRabbitMQ(cr))) {
+		t.Errorf("the selector doesn't select the labels of the instance")
+	}
+	if selector.Matches(labels.Set(labelsForRabbitMQ(other))) {
+		t.Errorf("the selector selects the labels of another instance")
+	}
+
+	// the maps are returned fresh, the callers add their own labels
+	l := labelsForRabbitMQ(cr)
+	l["extra"] = "x"
+	if _, ok := labelsForRabbitMQ(cr)["extra"]; ok {
+		t.Errorf("the labels are shared between the calls")
+	}
+}
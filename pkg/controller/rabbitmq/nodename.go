@@ -0,0 +1,147 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/go-logr/logr"
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	"github.com/toha10/rabbitmq-operator/pkg/management"
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+// clusterDomain is the DNS domain of the Kubernetes cluster
+const clusterDomain = "cluster.local"
+
+// resolveAddressType returns the address type the node names of the cluster are built from.
+// Unless it is set in the spec, a cluster already running with IP based node names keeps
+// them, because renaming a node drops its local data; new clusters use stable hostnames.
+func resolveAddressType(cr *rabbitmqv1alpha1.RabbitMQ, live *v1.StatefulSet) string {
+	if cr.Spec.AddressType != "" {
+		return cr.Spec.AddressType
+	}
+	if live == nil {
+		return rabbitmqv1alpha1.AddressTypeHostname
+	}
+	return podSpecAddressType(&live.Spec.Template.Spec)
+}
+
+// nodeNameEnv returns the value of RABBITMQ_NODENAME for the address type
+func nodeNameEnv(cr *rabbitmqv1alpha1.RabbitMQ) string {
+	if cr.Spec.AddressType == rabbitmqv1alpha1.AddressTypeIP {
+		return "rabbit@$(MY_POD_IP)"
+	}
+	return "rabbit@$(MY_POD_NAME)" + hostnameSuffix(cr)
+}
+
+// hostnameSuffix returns the domain of the pods in the headless service including the leading dot
+func hostnameSuffix(cr *rabbitmqv1alpha1.RabbitMQ) string {
+	return fmt.Sprintf(".%s.%s.svc.%s", headlessServiceName(cr), cr.Namespace, clusterDomain)
+}
+
+// podSpecAddressType returns the address type the RABBITMQ_NODENAME of the pod spec is built from
+func podSpecAddressType(spec *corev1.PodSpec) string {
+	for _, c := range spec.Containers {
+		if c.Name != "rabbitmq" {
+			continue
+		}
+		for _, env := range c.Env {
+			if env.Name == "RABBITMQ_NODENAME" && strings.Contains(env.Value, "$(MY_POD_IP)") {
+				return rabbitmqv1alpha1.AddressTypeIP
+			}
+		}
+	}
+	return rabbitmqv1alpha1.AddressTypeHostname
+}
+
+// nodeNameForPod returns the RabbitMQ node name the pod is running with,
+// it matches the RABBITMQ_NODENAME environment variable of the rabbitmq container
+func nodeNameForPod(pod *corev1.Pod) string {
+	if podSpecAddressType(&pod.Spec) == rabbitmqv1alpha1.AddressTypeIP {
+		if pod.Status.PodIP == "" {
+			return ""
+		}
+		return "rabbit@" + pod.Status.PodIP
+	}
+	return fmt.Sprintf("rabbit@%s.%s.%s.svc.%s", pod.Name, pod.Spec.Subdomain, pod.Namespace, clusterDomain)
+}
+
+// migrateNodeNames moves the pods running with node names of another address type than the
+// StatefulSet template to the new names, one pod at a time starting from the highest ordinal.
+//
+// The node data is kept in a directory named after the node, so a renamed node starts empty.
+// Before its pod is restarted, the node is reset: it leaves the cluster and drops its data. The
+// same as a node removed by a scale-down, it is only reset when the cluster is healthy and no
+// durable queue has its only replica on it, otherwise the NodeRenameBlocked condition explains
+// what the migration waits for. The restarted node joins the cluster again by peer discovery under
+// its new name. It reports whether a migration is in progress.
+func (r *ReconcileRabbitMQ) migrateNodeNames(reqLogger logr.Logger, instance *rabbitmqv1alpha1.RabbitMQ, ss *v1.StatefulSet, pods []corev1.Pod, mgmt *management.Client) (bool, error) {
+	addressType := podSpecAddressType(&ss.Spec.Template.Spec)
+	var outdated *corev1.Pod
+	for i := range pods {
+		if podSpecAddressType(&pods[i].Spec) != addressType {
+			outdated = &pods[i]
+		}
+	}
+	if outdated == nil {
+		if rabbitmqv1alpha1.FindCondition(instance.Status.Conditions, rabbitmqv1alpha1.RabbitMQNodeRenameBlocked) != nil {
+			setCondition(&instance.Status, newCondition(rabbitmqv1alpha1.RabbitMQNodeRenameBlocked, corev1.ConditionFalse,
+				"NodesRenamed", "All nodes are named after the address type "+addressType))
+		}
+		return false, nil
+	}
+	if len(pods) < 2 {
+		return false, fmt.Errorf("node %s can't be renamed: it is the only member of the cluster and would lose all data", nodeNameForPod(outdated))
+	}
+	if ss.Spec.Replicas != nil && int32(len(pods)) < *ss.Spec.Replicas {
+		return true, nil
+	}
+	for i := range pods {
+		if !isPodReady(&pods[i]) {
+			// wait for the previously migrated node to join the cluster
+			return true, nil
+		}
+	}
+	if mgmt == nil {
+		return true, nil
+	}
+
+	node := nodeNameForPod(outdated)
+	blocked := func(reason, message string) (bool, error) {
+		setCondition(&instance.Status, newCondition(rabbitmqv1alpha1.RabbitMQNodeRenameBlocked, corev1.ConditionTrue, reason, message))
+		return true, nil
+	}
+	health, err := clusterHealth(mgmt, pods)
+	if err != nil {
+		return true, err
+	}
+	if health != "" {
+		return blocked("ClusterUnhealthy", fmt.Sprintf("node %s is not renamed until the cluster recovers: %s", node, health))
+	}
+	queues, err := mgmt.ListQueues()
+	if err != nil {
+		return true, err
+	}
+	if queue := unreplicatedQueue(queues, node); queue != nil {
+		return blocked("UnreplicatedQueue", fmt.Sprintf("queue %s in vhost %s has no replicas outside of node %s, move or delete it", queue.Name, queue.Vhost, node))
+	}
+	setCondition(&instance.Status, newCondition(rabbitmqv1alpha1.RabbitMQNodeRenameBlocked, corev1.ConditionFalse,
+		"Renaming", fmt.Sprintf("node %s is being renamed", node)))
+
+	podLogger := reqLogger.WithValues("Pod.Namespace", outdated.Namespace, "Pod.Name", outdated.Name)
+	podLogger.Info("Resetting the node to change its name", "Node", node, "AddressType", addressType)
+	if _, err := r.executor.Exec(outdated, "rabbitmqctl", "stop_app"); err != nil {
+		return true, err
+	}
+	if _, err := r.executor.Exec(outdated, "rabbitmqctl", "reset"); err != nil {
+		return true, err
+	}
+	podLogger.Info("Restarting the pod with the new node name")
+	if err := r.client.Delete(context.TODO(), outdated); err != nil && !errors.IsNotFound(err) {
+		return true, err
+	}
+	return true, nil
+}
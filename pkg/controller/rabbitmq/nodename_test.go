@@ -0,0 +1,195 @@
+package rabbitmq
+
+import (
+	"reflect"
+	"testing"
+
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	"github.com/toha10/rabbitmq-operator/pkg/management"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+func TestResolveAddressType(t *testing.T) {
+	ipCluster := newTestCluster()
+	ipCluster.Spec.AddressType = rabbitmqv1alpha1.AddressTypeIP
+	ipSS := newStatefulSet(ipCluster, nil)
+	hostnameSS := newStatefulSet(newTestCluster(), nil)
+
+	tests := []struct {
+		name     string
+		spec     string
+		live     bool
+		liveType string
+		want     string
+	}{
+		{"new cluster", "", false, "", rabbitmqv1alpha1.AddressTypeHostname},
+		{"new cluster with IPs", rabbitmqv1alpha1.AddressTypeIP, false, "", rabbitmqv1alpha1.AddressTypeIP},
+		{"running with IPs", "", true, rabbitmqv1alpha1.AddressTypeIP, rabbitmqv1alpha1.AddressTypeIP},
+		{"running with hostnames", "", true, rabbitmqv1alpha1.AddressTypeHostname, rabbitmqv1alpha1.AddressTypeHostname},
+		{"migrated to hostnames", rabbitmqv1alpha1.AddressTypeHostname, true, rabbitmqv1alpha1.AddressTypeIP, rabbitmqv1alpha1.AddressTypeHostname},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := newTestCluster()
+			cr.Spec.AddressType = tt.spec
+			live := hostnameSS
+			if tt.liveType == rabbitmqv1alpha1.AddressTypeIP {
+				live = ipSS
+			}
+			if !tt.live {
+				live = nil
+			}
+			if got := resolveAddressType(cr, live); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNodeNameForPod(t *testing.T) {
+	ss := newTestStatefulSet(1, nil)
+	pod := newTestPods(ss, nil)[0].(*corev1.Pod)
+	if got, want := nodeNameForPod(pod), "rabbit@rmq-0.rmq.ns.svc.cluster.local"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if want := "rabbit@$(MY_POD_NAME).rmq.ns.svc.cluster.local"; nodeNameEnv(newTestCluster()) != want {
+		t.Errorf("got RABBITMQ_NODENAME %s, want %s", nodeNameEnv(newTestCluster()), want)
+	}
+
+	ipCluster := newTestCluster()
+	ipCluster.Spec.AddressType = rabbitmqv1alpha1.AddressTypeIP
+	ipSS := newStatefulSet(ipCluster, nil)
+	ipPod := newTestPods(ipSS, nil)[0].(*corev1.Pod)
+	if got, want := nodeNameForPod(ipPod), "rabbit@10.0.0.1"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	ipPod.Status.PodIP = ""
+	if got := nodeNameForPod(ipPod); got != "" {
+		t.Errorf("got %s for a pod without an IP", got)
+	}
+}
+
+func TestMigrateNodeNames(t *testing.T) {
+	ipCluster := newTestCluster()
+	ipCluster.Spec.AddressType = rabbitmqv1alpha1.AddressTypeIP
+	ipPodSpec := newStatefulSet(ipCluster, nil).Spec.Template.Spec
+
+	tests := []struct {
+		name string
+		// ipPods are the ordinals of the pods still running with IP node names
+		ipPods   []int
+		replicas int32
+		notReady int
+		// queues are the queues of the cluster, "<node>" is replaced by the node of the highest ordinal
+		queues    []management.Queue
+		migrating bool
+		commands  []string
+		remaining []string
+		// blocked is the status of the NodeRenameBlocked condition, empty if it is not set
+		blocked corev1.ConditionStatus
+		err     bool
+	}{
+		{name: "migrated", replicas: 3, notReady: -1, remaining: []string{"rmq-0", "rmq-1", "rmq-2"}},
+		{name: "highest ordinal first", ipPods: []int{0, 1, 2}, replicas: 3, notReady: -1, migrating: true,
+			commands:  []string{"rmq-2: rabbitmqctl stop_app", "rmq-2: rabbitmqctl reset"},
+			remaining: []string{"rmq-0", "rmq-1"}, blocked: corev1.ConditionFalse},
+		{name: "replicated queues", ipPods: []int{0, 1, 2}, replicas: 3, notReady: -1, migrating: true,
+			queues: []management.Queue{
+				{Name: "mirrored", Vhost: "/", Durable: true, Node: "<node>", SlaveNodes: []string{"a"}, SynchronisedSlaveNodes: []string{"a"}},
+				{Name: "quorum", Vhost: "/", Durable: true, Members: []string{"<node>", "a"}, Online: []string{"<node>", "a"}},
+				{Name: "transient", Vhost: "/", Node: "<node>"},
+			},
+			commands:  []string{"rmq-2: rabbitmqctl stop_app", "rmq-2: rabbitmqctl reset"},
+			remaining: []string{"rmq-0", "rmq-1"}, blocked: corev1.ConditionFalse},
+		{name: "unreplicated queue", ipPods: []int{0, 1, 2}, replicas: 3, notReady: -1, migrating: true,
+			queues:    []management.Queue{{Name: "orders", Vhost: "/", Durable: true, Node: "<node>"}},
+			remaining: []string{"rmq-0", "rmq-1", "rmq-2"}, blocked: corev1.ConditionTrue},
+		{name: "mirror not synchronised", ipPods: []int{0, 1, 2}, replicas: 3, notReady: -1, migrating: true,
+			queues: []management.Queue{
+				{Name: "orders", Vhost: "/", Durable: true, Node: "<node>", SlaveNodes: []string{"a"}},
+			},
+			remaining: []string{"rmq-0", "rmq-1", "rmq-2"}, blocked: corev1.ConditionTrue},
+		{name: "waits for the restarted node", ipPods: []int{0, 1}, replicas: 3, notReady: 2, migrating: true,
+			remaining: []string{"rmq-0", "rmq-1", "rmq-2"}},
+		{name: "single node", ipPods: []int{0}, replicas: 1, notReady: -1, remaining: []string{"rmq-0"}, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ss := newTestStatefulSet(tt.replicas, nil)
+			objs := []runtime.Object{ss}
+			for i, obj := range newTestPods(ss, nil) {
+				pod := obj.(*corev1.Pod)
+				for _, ordinal := range tt.ipPods {
+					if ordinal == i {
+						pod.Spec = *ipPodSpec.DeepCopy()
+					}
+				}
+				if i == tt.notReady {
+					pod.Status.Conditions = nil
+				}
+				objs = append(objs, pod)
+			}
+			r := newTestReconciler(objs...)
+			executor := &fakeExecutor{}
+			r.executor = executor
+			pods, err := r.statefulSetPods(ss)
+			if err != nil {
+				t.Fatal(err)
+			}
+			node := nodeNameForPod(&pods[len(pods)-1])
+			queues := []management.Queue{}
+			for _, queue := range tt.queues {
+				if queue.Node == "<node>" {
+					queue.Node = node
+				}
+				if len(queue.Members) > 0 {
+					queue.Members = []string{node, "a"}
+					queue.Online = []string{node, "a"}
+				}
+				queues = append(queues, queue)
+			}
+			instance := newTestCluster()
+
+			migrating, err := r.migrateNodeNames(logf.Log, instance, ss, pods, newTestManagement(t, runningNodes(pods), queues))
+			if (err != nil) != tt.err {
+				t.Fatalf("got error %v", err)
+			}
+			if migrating != tt.migrating {
+				t.Errorf("got migrating %v, want %v", migrating, tt.migrating)
+			}
+			if !reflect.DeepEqual(executor.commands, tt.commands) {
+				t.Errorf("got commands %v, want %v", executor.commands, tt.commands)
+			}
+			if got := podNames(r, ss); !reflect.DeepEqual(got, tt.remaining) {
+				t.Errorf("got pods %v, want %v", got, tt.remaining)
+			}
+			var blocked corev1.ConditionStatus
+			if condition := rabbitmqv1alpha1.FindCondition(instance.Status.Conditions, rabbitmqv1alpha1.RabbitMQNodeRenameBlocked); condition != nil {
+				blocked = condition.Status
+			}
+			if blocked != tt.blocked {
+				t.Errorf("got NodeRenameBlocked %q, want %q", blocked, tt.blocked)
+			}
+		})
+	}
+}
+
+func TestMigrateNodeNamesClearsTheBlockedCondition(t *testing.T) {
+	ss := newTestStatefulSet(3, nil)
+	r := newTestReconciler(append(newTestPods(ss, nil), ss)...)
+	pods, err := r.statefulSetPods(ss)
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance := newTestCluster()
+	setCondition(&instance.Status, newCondition(rabbitmqv1alpha1.RabbitMQNodeRenameBlocked, corev1.ConditionTrue, "UnreplicatedQueue", ""))
+
+	if migrating, err := r.migrateNodeNames(logf.Log, instance, ss, pods, nil); err != nil || migrating {
+		t.Fatalf("got migrating %v, %v", migrating, err)
+	}
+	if rabbitmqv1alpha1.IsConditionTrue(instance.Status.Conditions, rabbitmqv1alpha1.RabbitMQNodeRenameBlocked) {
+		t.Errorf("the migration is still reported blocked")
+	}
+}
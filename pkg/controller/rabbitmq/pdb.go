@@ -0,0 +1,96 @@
+package rabbitmq
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/go-logr/logr"
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// maxUnavailable returns how many members may be evicted at once, nil if the cluster gets no budget.
+// A majority of the members has to stay for the cluster and the quorum queues to be available.
+func maxUnavailable(cr *rabbitmqv1alpha1.RabbitMQ) *intstr.IntOrString {
+	budget := cr.Spec.PodDisruptionBudget
+	if budget != nil && budget.Disabled {
+		return nil
+	}
+	if budget != nil && budget.MaxUnavailable != nil {
+		value := *budget.MaxUnavailable
+		return &value
+	}
+	// a budget of zero would block the node drains forever
+	minority := (cr.Spec.Replicas - 1) / 2
+	if minority < 1 {
+		return nil
+	}
+	value := intstr.FromInt(int(minority))
+	return &value
+}
+
+// newPodDisruptionBudget returns the PodDisruptionBudget of the cluster or nil
+func newPodDisruptionBudget(cr *rabbitmqv1alpha1.RabbitMQ) *policyv1beta1.PodDisruptionBudget {
+	max := maxUnavailable(cr)
+	if max == nil {
+		return nil
+	}
+	return &policyv1beta1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      podDisruptionBudgetName(cr),
+			Namespace: cr.Namespace,
+			Labels:    labelsForRabbitMQ(cr),
+		},
+		Spec: policyv1beta1.PodDisruptionBudgetSpec{
+			MaxUnavailable: max,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: selectorForRabbitMQ(cr),
+			},
+		},
+	}
+}
+
+// reconcilePodDisruptionBudget creates, replaces or deletes the PodDisruptionBudget of the cluster.
+// The spec of a PodDisruptionBudget can't be updated before Kubernetes 1.15, so a budget which
+// differs from the desired one is deleted and created again.
+func (r *ReconcileRabbitMQ) reconcilePodDisruptionBudget(reqLogger logr.Logger, instance, cr *rabbitmqv1alpha1.RabbitMQ) error {
+	desired := newPodDisruptionBudget(cr)
+	found := &policyv1beta1.PodDisruptionBudget{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: podDisruptionBudgetName(cr), Namespace: cr.Namespace}, found)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	exists := err == nil
+	pdbLogger := reqLogger.WithValues("PodDisruptionBudget.Namespace", cr.Namespace, "PodDisruptionBudget.Name", podDisruptionBudgetName(cr))
+
+	if exists {
+		if !metav1.IsControlledBy(found, instance) {
+			if desired != nil {
+				pdbLogger.Info("PodDisruptionBudget already exists and is not controlled by the RabbitMQ instance, leaving it")
+			}
+			return nil
+		}
+		if desired != nil && reflect.DeepEqual(found.Spec.MaxUnavailable, desired.Spec.MaxUnavailable) &&
+			reflect.DeepEqual(found.Spec.Selector, desired.Spec.Selector) {
+			return nil
+		}
+		pdbLogger.Info("Deleting the outdated PodDisruptionBudget")
+		if err := r.client.Delete(context.TODO(), found); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	if desired == nil {
+		return nil
+	}
+
+	if err := controllerutil.SetControllerReference(instance, desired, r.scheme); err != nil {
+		return err
+	}
+	pdbLogger.Info("Creating a new PodDisruptionBudget", "MaxUnavailable", desired.Spec.MaxUnavailable.String())
+	return r.client.Create(context.TODO(), desired)
+}
@@ -0,0 +1,137 @@
+package rabbitmq
+
+import (
+	"context"
+	"testing"
+
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+func TestMaxUnavailable(t *testing.T) {
+	two := intstr.FromInt(2)
+	percent := intstr.FromString("50%")
+	tests := []struct {
+		name     string
+		replicas int32
+		budget   *rabbitmqv1alpha1.PodDisruptionBudgetSpec
+		// want is the max unavailable, empty for no budget
+		want string
+	}{
+		{name: "one member", replicas: 1},
+		{name: "two members", replicas: 2},
+		{name: "three members", replicas: 3, want: "1"},
+		{name: "four members", replicas: 4, want: "1"},
+		{name: "five members", replicas: 5, want: "2"},
+		{name: "seven members", replicas: 7, want: "3"},
+		{
+			name:     "number",
+			replicas: 5,
+			budget:   &rabbitmqv1alpha1.PodDisruptionBudgetSpec{MaxUnavailable: &two},
+			want:     "2",
+		},
+		{
+			name:     "percentage",
+			replicas: 1,
+			budget:   &rabbitmqv1alpha1.PodDisruptionBudgetSpec{MaxUnavailable: &percent},
+			want:     "50%",
+		},
+		{
+			name:     "disabled",
+			replicas: 3,
+			budget:   &rabbitmqv1alpha1.PodDisruptionBudgetSpec{Disabled: true, MaxUnavailable: &two},
+		},
+		{
+			name:     "empty override",
+			replicas: 3,
+			budget:   &rabbitmqv1alpha1.PodDisruptionBudgetSpec{},
+			want:     "1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := newTestCluster()
+			cr.Spec.Replicas = tt.replicas
+			cr.Spec.PodDisruptionBudget = tt.budget
+			max := maxUnavailable(cr)
+			got := ""
+			if max != nil {
+				got = max.String()
+			}
+			if got != tt.want {
+				t.Errorf("got max unavailable %q, want %q", got, tt.want)
+			}
+			if pdb := newPodDisruptionBudget(cr); (pdb != nil) != (tt.want != "") {
+				t.Errorf("got PodDisruptionBudget %v", pdb)
+			}
+		})
+	}
+}
+
+func TestReconcilePodDisruptionBudget(t *testing.T) {
+	owner := newTestCluster()
+	owner.UID = "rmq-uid"
+	owned := func(replicas int32) runtime.Object {
+		cr := newTestCluster()
+		cr.Spec.Replicas = replicas
+		pdb := newPodDisruptionBudget(cr)
+		controller := true
+		pdb.OwnerReferences = []metav1.OwnerReference{{
+			APIVersion: rabbitmqv1alpha1.SchemeGroupVersion.String(),
+			Kind:       "RabbitMQ",
+			Name:       owner.Name,
+			UID:        owner.UID,
+			Controller: &controller,
+		}}
+		return pdb
+	}
+	foreign := func() runtime.Object {
+		pdb := newPodDisruptionBudget(newTestCluster())
+		pdb.Spec.MaxUnavailable = &intstr.IntOrString{Type: intstr.Int, IntVal: 2}
+		return pdb
+	}
+
+	tests := []struct {
+		name     string
+		existing []runtime.Object
+		replicas int32
+		// want is the max unavailable of the budget after the reconcile, empty for no budget
+		want string
+	}{
+		{name: "created", replicas: 3, want: "1"},
+		{name: "in sync", existing: []runtime.Object{owned(3)}, replicas: 3, want: "1"},
+		{name: "replaced", existing: []runtime.Object{owned(3)}, replicas: 5, want: "2"},
+		{name: "deleted", existing: []runtime.Object{owned(3)}, replicas: 1},
+		{name: "not controlled", existing: []runtime.Object{foreign()}, replicas: 3, want: "2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestReconciler(tt.existing...)
+			cr := owner.DeepCopy()
+			cr.Spec.Replicas = tt.replicas
+			if err := r.reconcilePodDisruptionBudget(logf.Log, owner, cr); err != nil {
+				t.Fatal(err)
+			}
+			found := &policyv1beta1.PodDisruptionBudget{}
+			err := r.client.Get(context.TODO(), types.NamespacedName{Name: podDisruptionBudgetName(cr), Namespace: cr.Namespace}, found)
+			if tt.want == "" {
+				if !errors.IsNotFound(err) {
+					t.Errorf("got %v, want no PodDisruptionBudget", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := found.Spec.MaxUnavailable.String(); got != tt.want {
+				t.Errorf("got max unavailable %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
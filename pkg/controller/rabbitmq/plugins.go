@@ -0,0 +1,85 @@
+package rabbitmq
+
+import (
+	"context"
+
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// clusterObject is a resource declaring an object inside a RabbitMQ cluster
+type clusterObject interface {
+	ClusterName() string
+}
+
+// objectPlugin is a plugin the objects of a kind need, it is enabled in the clusters with at least
+// one resource of the kind, including the resources being deleted
+type objectPlugin struct {
+	newObject func() runtime.Object
+	newList   func() runtime.Object
+	plugin    string
+}
+
+var objectPlugins = []objectPlugin{
+	{
+		newObject: func() runtime.Object { return &rabbitmqv1alpha1.RabbitMQShovel{} },
+		newList:   func() runtime.Object { return &rabbitmqv1alpha1.RabbitMQShovelList{} },
+		plugin:    "rabbitmq_shovel",
+	},
+	{
+		newObject: func() runtime.Object { return &rabbitmqv1alpha1.RabbitMQFederationUpstream{} },
+		newList:   func() runtime.Object { return &rabbitmqv1alpha1.RabbitMQFederationUpstreamList{} },
+		plugin:    "rabbitmq_federation",
+	},
+}
+
+// watchObjectPlugins requeues the RabbitMQ instance when a resource of a kind needing a plugin
+// is created or deleted in the cluster
+func watchObjectPlugins(c controller.Controller) error {
+	for _, p := range objectPlugins {
+		err := c.Watch(&source.Kind{Type: p.newObject()}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(func(a handler.MapObject) []reconcile.Request {
+				obj, ok := a.Object.(clusterObject)
+				if !ok {
+					return nil
+				}
+				return []reconcile.Request{{
+					NamespacedName: types.NamespacedName{Name: obj.ClusterName(), Namespace: a.Meta.GetNamespace()},
+				}}
+			}),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveObjectPlugins returns the plugins needed by the resources declaring objects in the cluster
+func (r *ReconcileRabbitMQ) resolveObjectPlugins(cr *rabbitmqv1alpha1.RabbitMQ) ([]string, error) {
+	plugins := []string{}
+	for _, p := range objectPlugins {
+		list := p.newList()
+		if err := r.client.List(context.TODO(), client.InNamespace(cr.Namespace), list); err != nil {
+			return nil, err
+		}
+		items, err := meta.ExtractList(list)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			if obj, ok := item.(clusterObject); ok && obj.ClusterName() == cr.Name {
+				plugins = append(plugins, p.plugin)
+				break
+			}
+		}
+	}
+	return plugins, nil
+}
@@ -0,0 +1,58 @@
+package rabbitmq
+
+import (
+	"strings"
+	"testing"
+
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestObjectPlugins(t *testing.T) {
+	shovel := &rabbitmqv1alpha1.RabbitMQShovel{
+		ObjectMeta: metav1.ObjectMeta{Name: "archive", Namespace: "ns"},
+		Spec:       rabbitmqv1alpha1.RabbitMQShovelSpec{Cluster: "rmq"},
+	}
+	otherUpstream := &rabbitmqv1alpha1.RabbitMQFederationUpstream{
+		ObjectMeta: metav1.ObjectMeta{Name: "eu", Namespace: "ns"},
+		Spec:       rabbitmqv1alpha1.RabbitMQFederationUpstreamSpec{Cluster: "other"},
+	}
+	upstream := otherUpstream.DeepCopy()
+	upstream.Spec.Cluster = "rmq"
+
+	tests := []struct {
+		name    string
+		objs    []runtime.Object
+		enabled []string
+		missing []string
+	}{
+		{"no objects", nil, nil, []string{"rabbitmq_shovel", "rabbitmq_federation"}},
+		{"shovel", []runtime.Object{shovel, otherUpstream}, []string{"rabbitmq_shovel"}, []string{"rabbitmq_federation"}},
+		{"shovel and upstream", []runtime.Object{shovel, upstream}, []string{"rabbitmq_shovel", "rabbitmq_federation"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestReconciler(tt.objs...)
+			cr, err := r.resolveSpec(newTestCluster())
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the plugins end up in the ConfigMap, whose hash restarts the nodes
+			rendered, err := renderEnabledPlugins(cr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, plugin := range tt.enabled {
+				if !strings.Contains(rendered, plugin) {
+					t.Errorf("%s not enabled in %s", plugin, rendered)
+				}
+			}
+			for _, plugin := range tt.missing {
+				if strings.Contains(rendered, plugin) {
+					t.Errorf("%s enabled in %s", plugin, rendered)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package rabbitmq
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// statefulSetPods returns the pods controlled by the StatefulSet sorted by their ordinal
+func (r *ReconcileRabbitMQ) statefulSetPods(ss *v1.StatefulSet) ([]corev1.Pod, error) {
+	pods := &corev1.PodList{}
+	opts := client.InNamespace(ss.Namespace).MatchingLabels(ss.Spec.Selector.MatchLabels)
+	if err := r.client.List(context.TODO(), opts, pods); err != nil {
+		return nil, err
+	}
+
+	owned := []corev1.Pod{}
+	for _, pod := range pods.Items {
+		if owner := metav1.GetControllerOf(&pod); owner == nil || owner.UID != ss.UID {
+			continue
+		}
+		owned = append(owned, pod)
+	}
+	sort.Slice(owned, func(i, j int) bool {
+		return podOrdinal(&owned[i]) < podOrdinal(&owned[j])
+	})
+	return owned, nil
+}
+
+// podOrdinal returns the ordinal the StatefulSet has assigned to the pod or -1
+func podOrdinal(pod *corev1.Pod) int {
+	return podNameOrdinal(pod.Name)
+}
+
+// podNameOrdinal returns the ordinal in the name of a pod of a StatefulSet or -1
+func podNameOrdinal(name string) int {
+	i := strings.LastIndex(name, "-")
+	if i < 0 {
+		return -1
+	}
+	ordinal, err := strconv.Atoi(name[i+1:])
+	if err != nil {
+		return -1
+	}
+	return ordinal
+}
+
+// isPodReady reports whether the pod is running, ready and not being deleted
+func isPodReady(pod *corev1.Pod) bool {
+	if pod.DeletionTimestamp != nil || pod.Status.Phase != corev1.PodRunning {
+		return false
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
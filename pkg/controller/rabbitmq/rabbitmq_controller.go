@@ -2,13 +2,21 @@ package rabbitmq
 
 import (
 	"context"
+	"fmt"
+	"reflect"
+	"time"
 
+	monitoringclient "github.com/coreos/prometheus-operator/pkg/client/versioned/typed/monitoring/v1"
+	"github.com/go-logr/logr"
 	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
 	v1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
@@ -21,15 +29,46 @@ import (
 
 var log = logf.Log.WithName("controller_rabbitmq")
 
+// requeueInterval is how often a RabbitMQ instance is checked while a multi-step operation is in progress
+const requeueInterval = 10 * time.Second
+
 // Add creates a new RabbitMQ Controller and adds it to the Manager. The Manager will set fields on the Controller
 // and Start it when the Manager is Started.
 func Add(mgr manager.Manager) error {
-	return add(mgr, newReconciler(mgr))
+	r, err := newReconciler(mgr)
+	if err != nil {
+		return err
+	}
+	return add(mgr, r)
 }
 
 // newReconciler returns a new reconcile.Reconciler
-func newReconciler(mgr manager.Manager) reconcile.Reconciler {
-	return &ReconcileRabbitMQ{client: mgr.GetClient(), scheme: mgr.GetScheme()}
+func newReconciler(mgr manager.Manager) (reconcile.Reconciler, error) {
+	executor, err := newRemoteExecutor(mgr.GetConfig())
+	if err != nil {
+		return nil, err
+	}
+	// the cache of the manager holds the objects of the watched namespace only
+	apiReader, err := client.New(mgr.GetConfig(), client.Options{Scheme: mgr.GetScheme(), Mapper: mgr.GetRESTMapper()})
+	if err != nil {
+		return nil, err
+	}
+	dc, err := discovery.NewDiscoveryClientForConfig(mgr.GetConfig())
+	if err != nil {
+		return nil, err
+	}
+	monitoring, err := monitoringclient.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return nil, err
+	}
+	return &ReconcileRabbitMQ{
+		client:     mgr.GetClient(),
+		apiReader:  apiReader,
+		scheme:     mgr.GetScheme(),
+		executor:   executor,
+		discovery:  dc,
+		monitoring: monitoring,
+	}, nil
 }
 
 // add adds a new Controller to mgr with r as the reconcile.Reconciler
@@ -73,7 +112,69 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		return err
 	}
 
-	return nil
+	// Watch for changes to secondary resource ConfigMap and requeue the owner RabbitMQ
+	err = c.Watch(&source.Kind{Type: &corev1.ConfigMap{}}, &handler.EnqueueRequestForOwner{
+		IsController: true,
+		OwnerType:    &rabbitmqv1alpha1.RabbitMQ{},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to secondary resource PodDisruptionBudget and requeue the owner RabbitMQ
+	err = c.Watch(&source.Kind{Type: &policyv1beta1.PodDisruptionBudget{}}, &handler.EnqueueRequestForOwner{
+		IsController: true,
+		OwnerType:    &rabbitmqv1alpha1.RabbitMQ{},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to Secrets and requeue the RabbitMQ instances using them,
+	// whether the Secret is owned by the instance or referenced in its spec
+	err = c.Watch(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(a handler.MapObject) []reconcile.Request {
+			return requestsForSecret(mgr.GetClient(), a.Meta)
+		}),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Watch for the resources needing a plugin and requeue the RabbitMQ instance they belong to
+	return watchObjectPlugins(c)
+}
+
+// requestsForSecret returns the requests for the RabbitMQ instances in the namespace of the Secret which use it
+func requestsForSecret(c client.Client, secret metav1.Object) []reconcile.Request {
+	instances := &rabbitmqv1alpha1.RabbitMQList{}
+	if err := c.List(context.TODO(), client.InNamespace(secret.GetNamespace()), instances); err != nil {
+		log.Error(err, "Failed to list RabbitMQ instances", "Secret.Namespace", secret.GetNamespace(), "Secret.Name", secret.GetName())
+		return nil
+	}
+
+	requests := []reconcile.Request{}
+	for i := range instances.Items {
+		cr := &instances.Items[i]
+		for _, name := range secretsUsedBy(cr) {
+			if name == secret.GetName() {
+				requests = append(requests, reconcile.Request{
+					NamespacedName: types.NamespacedName{Name: cr.Name, Namespace: cr.Namespace},
+				})
+				break
+			}
+		}
+	}
+	return requests
+}
+
+// secretsUsedBy returns the names of the Secrets the RabbitMQ instance depends on
+func secretsUsedBy(cr *rabbitmqv1alpha1.RabbitMQ) []string {
+	secrets := []string{erlangCookieSecretName(cr), adminSecretName(cr)}
+	if cr.Spec.TLS != nil {
+		secrets = append(secrets, cr.Spec.TLS.SecretName)
+	}
+	return secrets
 }
 
 // blank assignment to verify that ReconcileRabbitMQ implements reconcile.Reconciler
@@ -84,7 +185,15 @@ type ReconcileRabbitMQ struct {
 	// This client, initialized using mgr.Client() above, is a split client
 	// that reads objects from the cache and writes to the apiserver
 	client client.Client
-	scheme *runtime.Scheme
+	// apiReader reads the cluster scoped objects directly from the apiserver
+	apiReader client.Reader
+	scheme    *runtime.Scheme
+	// executor runs rabbitmqctl in the cluster pods
+	executor podExecutor
+	// discovery finds out whether the Prometheus Operator is installed
+	discovery discovery.DiscoveryInterface
+	// monitoring manages the ServiceMonitors, the Prometheus Operator may be installed after the operator starts
+	monitoring monitoringclient.MonitoringV1Interface
 }
 
 // Reconcile reads that state of the cluster for a RabbitMQ object and makes changes based on the state read
@@ -92,6 +201,7 @@ type ReconcileRabbitMQ struct {
 func (r *ReconcileRabbitMQ) Reconcile(request reconcile.Request) (reconcile.Result, error) {
 	reqLogger := log.WithValues("Request.Namespace", request.Namespace, "Request.Name", request.Name)
 	reqLogger.Info("Reconciling RabbitMQ")
+	start := time.Now()
 
 	// Fetch the RabbitMQ instance
 	instance := &rabbitmqv1alpha1.RabbitMQ{}
@@ -99,87 +209,289 @@ func (r *ReconcileRabbitMQ) Reconcile(request reconcile.Request) (reconcile.Resu
 	if err != nil {
 		if errors.IsNotFound(err) {
 			// Request object not found, could have been deleted after reconcile request.
-			// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
+			// Owned objects are automatically garbage collected, the rest is cleaned up by finalize.
 			// Return and don't requeue
+			clusterMetrics.forget(request.NamespacedName)
 			return reconcile.Result{}, nil
 		}
 		// Error reading the object - requeue the request.
+		observeReconcile(request.NamespacedName, time.Since(start), err)
 		return reconcile.Result{}, err
 	}
 
-	// Define a new ConfigMap object
-	cm := newConfigMap(instance)
+	result, err := r.reconcileInstance(reqLogger, instance)
+	observeReconcile(request.NamespacedName, time.Since(start), err)
+	return result, err
+}
 
-	// Set RabbitMQ instance as the owner and controller
-	if err := controllerutil.SetControllerReference(instance, cm, r.scheme); err != nil {
-		return reconcile.Result{}, err
+// reconcileInstance tears the cluster down when the RabbitMQ instance is deleted, otherwise it
+// reconciles the owned objects and updates the status
+func (r *ReconcileRabbitMQ) reconcileInstance(reqLogger logr.Logger, instance *rabbitmqv1alpha1.RabbitMQ) (reconcile.Result, error) {
+	if instance.DeletionTimestamp != nil {
+		return r.finalize(reqLogger, instance)
 	}
-
-	// Check if this ConfigMap already exists
-	foundCM := &corev1.ConfigMap{}
-	err = r.client.Get(context.TODO(), types.NamespacedName{Name: cm.Name, Namespace: cm.Namespace}, foundCM)
-	if err != nil && errors.IsNotFound(err) {
-		reqLogger.Info("Creating a new ConfigMap", "ConfigMap.Namespace", cm.Namespace, "Pod.Name", cm.Name)
-		err = r.client.Create(context.TODO(), cm)
-		if err != nil {
-			reqLogger.Error(err, "Config Map creation has been failed")
+	if !hasClusterFinalizer(instance) {
+		instance.Finalizers = append(instance.Finalizers, clusterFinalizer)
+		if err := r.client.Update(context.TODO(), instance); err != nil {
 			return reconcile.Result{}, err
 		}
-		// ConfigMap created successfully - don't requeue
-	} else if err != nil {
-		return reconcile.Result{}, err
-	} else {
-		reqLogger.Info("Skip reconcile: ConfigMap already exists", "ConfigMap.Namespace", foundCM.Namespace, "StatefulSet.Name", foundCM.Name)
+	}
+
+	previous := instance.Status.DeepCopy()
+	result, ss, err := r.reconcileResources(reqLogger, instance)
+	if statusErr := r.updateStatus(instance, previous, ss, err); statusErr != nil {
+		reqLogger.Error(statusErr, "Failed to update RabbitMQ status")
+		if err == nil {
+			err = statusErr
+		}
+	}
+	return result, err
+}
+
+// reconcileResources creates or updates the objects owned by the RabbitMQ instance and returns
+// the StatefulSet running the cluster
+func (r *ReconcileRabbitMQ) reconcileResources(reqLogger logr.Logger, instance *rabbitmqv1alpha1.RabbitMQ) (reconcile.Result, *v1.StatefulSet, error) {
+	// Resolve the settings the owned objects are built from
+	cr, err := r.resolveSpec(instance)
+	if err != nil {
+		return reconcile.Result{}, nil, err
+	}
+
+	// Make sure the Erlang cookie Secret exists
+	distHash, err := r.reconcileErlangCookie(reqLogger, cr)
+	if err != nil {
+		return reconcile.Result{}, nil, err
+	}
+
+	// Make sure the Secret with the operator administrator credentials exists
+	adminSecret, err := r.reconcileAdminSecret(reqLogger, cr)
+	if err != nil {
+		return reconcile.Result{}, nil, err
+	}
+
+	// Check the Secret with the TLS certificates
+	tls, err := r.reconcileTLS(cr)
+	if err != nil {
+		return reconcile.Result{}, nil, err
+	}
+
+	// Define a new ConfigMap object
+	cm, err := newConfigMap(cr, tls)
+	if err != nil {
+		return reconcile.Result{}, nil, err
+	}
+	if err := r.createOrUpdate(reqLogger, instance, cm, &corev1.ConfigMap{},
+		labelsField, exactField("data")); err != nil {
+		return reconcile.Result{}, nil, err
 	}
 
 	// Define a new Service object
-	rmqService := newService(instance)
+	if instance.Spec.DiscoveryService == headlessServiceName(instance) {
+		return reconcile.Result{}, nil, fmt.Errorf("discovery_service %q clashes with the headless service of the StatefulSet", instance.Spec.DiscoveryService)
+	}
+	rmqService := newService(cr)
+	if err := r.createOrUpdate(reqLogger, instance, rmqService, &corev1.Service{},
+		labelsField, mergedField("spec", "type"), exactField("spec", "selector"), mergedField("spec", "ports")); err != nil {
+		return reconcile.Result{}, nil, err
+	}
 
-	// Set RabbitMQ instance as the owner and controller
-	if err := controllerutil.SetControllerReference(instance, rmqService, r.scheme); err != nil {
-		return reconcile.Result{}, err
+	// Define a new headless Service object governing the StatefulSet
+	headlessService := newHeadlessService(cr)
+	if err := r.createOrUpdate(reqLogger, instance, headlessService, &corev1.Service{},
+		labelsField, exactField("spec", "selector"), mergedField("spec", "ports"), mergedField("spec", "publishNotReadyAddresses")); err != nil {
+		return reconcile.Result{}, nil, err
 	}
 
-	// Check if this Service already exists
-	foundRMQService := &corev1.Service{}
-	err = r.client.Get(context.TODO(), types.NamespacedName{Name: rmqService.Name, Namespace: rmqService.Namespace}, foundRMQService)
-	if err != nil && errors.IsNotFound(err) {
-		reqLogger.Info("Creating a new Service", "StatefulSet.Namespace", rmqService.Namespace, "Pod.Name", rmqService.Name)
-		err = r.client.Create(context.TODO(), rmqService)
-		if err != nil {
-			return reconcile.Result{}, err
+	// Let the Prometheus Operator scrape the nodes
+	if err := r.reconcileServiceMonitor(reqLogger, instance, cr); err != nil {
+		return reconcile.Result{}, nil, err
+	}
+
+	// The data volumes retained from a deleted cluster with the same name are reused
+	if err := r.adoptRetainedVolumes(reqLogger, cr); err != nil {
+		return reconcile.Result{}, nil, err
+	}
+
+	// Define a new StatefulSet object, its immutable fields are not synchronized: the selector
+	// is kept, the StatefulSet is replaced for the others below
+	podAnnotations := map[string]string{
+		distributionHashAnnotation: distHash,
+		configHashAnnotation:       configHash(cm),
+	}
+	if tls != nil {
+		podAnnotations[tlsHashAnnotation] = tls.hash
+	}
+	ss := newStatefulSet(cr, podAnnotations)
+	if err := r.keepImmutableSelector(reqLogger, ss); err != nil {
+		return reconcile.Result{}, nil, err
+	}
+	foundSS := &v1.StatefulSet{}
+	if err := r.createOrUpdate(reqLogger, instance, ss, foundSS, statefulSetFields...); err != nil {
+		return reconcile.Result{}, nil, err
+	}
+
+	// Limit the number of members evicted at once
+	if err := r.reconcilePodDisruptionBudget(reqLogger, instance, cr); err != nil {
+		return reconcile.Result{}, foundSS, err
+	}
+
+	// Expand the data volumes and replace the StatefulSet if its volume claim template is outdated
+	recreating, err := r.reconcileStorage(reqLogger, instance, ss, foundSS)
+	if err != nil || recreating {
+		return reconcile.Result{RequeueAfter: requeueInterval}, foundSS, err
+	}
+
+	// Replace the StatefulSet if its pod management policy or governing Service are outdated
+	recreating, err = r.recreateOnImmutableChange(reqLogger, ss, foundSS)
+	if err != nil || recreating {
+		return reconcile.Result{RequeueAfter: requeueInterval}, foundSS, err
+	}
+
+	// Restart the cluster if the nodes have to agree on new distribution settings
+	restarting, err := r.restartOnDistributionChange(reqLogger, foundSS)
+	if err != nil || restarting {
+		return reconcile.Result{RequeueAfter: requeueInterval}, foundSS, err
+	}
+
+	// Make sure the operator can use the management API of the cluster
+	pods, err := r.statefulSetPods(foundSS)
+	if err != nil {
+		return reconcile.Result{}, foundSS, err
+	}
+	mgmt, err := r.ensureAdminUser(reqLogger, cr, adminSecret, pods)
+	if err != nil {
+		return reconcile.Result{}, foundSS, err
+	}
+
+	// Rename the nodes if the address type of the node names has changed
+	migrating, err := r.migrateNodeNames(reqLogger, instance, foundSS, pods, mgmt)
+	if err != nil || migrating {
+		return reconcile.Result{RequeueAfter: requeueInterval}, foundSS, err
+	}
+
+	// Create the vhost of the spec
+	if mgmt != nil && cr.Spec.Vhost != "" {
+		if err := ensureVhost(reqLogger, mgmt, cr.Spec.Vhost); err != nil {
+			return reconcile.Result{}, foundSS, err
 		}
-		// Service created successfully - don't requeue
-	} else if err != nil {
-		return reconcile.Result{}, err
-	} else {
-		reqLogger.Info("Skip reconcile: Service already exists", "Service.Namespace", foundRMQService.Namespace, "Service.Name", foundRMQService.Name)
 	}
 
-	// Define a new StatefulSet object
-	ss := newStatefulSet(instance)
+	// Restart the pods one by one to apply the new revision of the StatefulSet
+	restarting, err = r.rollingRestart(reqLogger, foundSS, pods, mgmt)
+	if err != nil || restarting {
+		return reconcile.Result{RequeueAfter: requeueInterval}, foundSS, err
+	}
+
+	// Enable the feature flags of the new version once the upgrade has been rolled out
+	upgrading, err := r.finishUpgrade(reqLogger, instance, foundSS, pods, mgmt)
+	if err != nil || upgrading {
+		return reconcile.Result{RequeueAfter: requeueInterval}, foundSS, err
+	}
+
+	// Remove the nodes above spec.replicas from the cluster
+	scaling, err := r.scaleDown(reqLogger, instance, foundSS, pods, mgmt)
+	if err != nil || scaling {
+		return reconcile.Result{RequeueAfter: requeueInterval}, foundSS, err
+	}
+
+	return reconcile.Result{}, foundSS, nil
+}
+
+// resolveSpec returns a copy of the RabbitMQ instance with the settings left for the operator
+// to decide filled in, the owned objects are built from it
+func (r *ReconcileRabbitMQ) resolveSpec(instance *rabbitmqv1alpha1.RabbitMQ) (*rabbitmqv1alpha1.RabbitMQ, error) {
+	cr := instance.DeepCopy()
+	cr.SetDefaults()
+
+	// the StatefulSet as it is now or a stand-in built from the pods it has orphaned, nil for a new cluster
+	var live *v1.StatefulSet
+	found := &v1.StatefulSet{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: statefulSetName(cr), Namespace: cr.Namespace}, found)
+	if err == nil {
+		live = found
+	} else if !errors.IsNotFound(err) {
+		return nil, err
+	} else if live, err = r.orphanedStatefulSet(cr); err != nil {
+		return nil, err
+	}
+
+	cr.Spec.AddressType = resolveAddressType(cr, live)
+	resolveImage(instance, cr, live)
+	if err := r.resolveStorage(instance, cr, live); err != nil {
+		return nil, err
+	}
+
+	plugins, err := monitoringPlugins(cr)
+	if err != nil {
+		return nil, err
+	}
+	cr.Spec.Plugins = append(cr.Spec.Plugins, plugins...)
+
+	// the plugins of the shovels and federation upstreams declared in the cluster
+	plugins, err = r.resolveObjectPlugins(cr)
+	if err != nil {
+		return nil, err
+	}
+	cr.Spec.Plugins = append(cr.Spec.Plugins, plugins...)
+
+	// the nodes above spec.replicas are removed by the scale-down one by one
+	if live != nil && live.Spec.Replicas != nil && *live.Spec.Replicas > cr.Spec.Replicas {
+		cr.Spec.Replicas = *live.Spec.Replicas
+	}
+
+	return cr, nil
+}
+
+// ownedObject is an object the RabbitMQ instance can be set as the controller of
+type ownedObject interface {
+	metav1.Object
+	runtime.Object
+}
+
+// createOrUpdate creates the desired object with the RabbitMQ instance set as its owner and controller.
+// If the object already exists, the given owned fields are brought in sync with desired while
+// the fields set by the API server or other parties are left alone. found receives the live object.
+func (r *ReconcileRabbitMQ) createOrUpdate(reqLogger logr.Logger, instance *rabbitmqv1alpha1.RabbitMQ, desired, found ownedObject, fields ...ownedField) error {
+	kind := reflect.TypeOf(desired).Elem().Name()
+	objLogger := reqLogger.WithValues("Kind", kind, "Namespace", desired.GetNamespace(), "Name", desired.GetName())
 
 	// Set RabbitMQ instance as the owner and controller
-	if err := controllerutil.SetControllerReference(instance, ss, r.scheme); err != nil {
-		return reconcile.Result{}, err
+	if err := controllerutil.SetControllerReference(instance, desired, r.scheme); err != nil {
+		return err
 	}
 
-	// Check if this StatefulSet already exists
-	foundSS := &v1.StatefulSet{}
-	err = r.client.Get(context.TODO(), types.NamespacedName{Name: ss.Name, Namespace: ss.Namespace}, foundSS)
+	// Check if this object already exists
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: desired.GetName(), Namespace: desired.GetNamespace()}, found)
 	if err != nil && errors.IsNotFound(err) {
-		reqLogger.Info("Creating a new StatefulSet", "StatefulSet.Namespace", ss.Namespace, "Pod.Name", ss.Name)
-		err = r.client.Create(context.TODO(), ss)
-		if err != nil {
-			return reconcile.Result{}, err
+		objLogger.Info("Creating a new object")
+		if err := r.client.Create(context.TODO(), desired); err != nil {
+			objLogger.Error(err, "Object creation has been failed")
+			return err
 		}
-		// StatefulSet created successfully - don't requeue
+		reflect.ValueOf(found).Elem().Set(reflect.ValueOf(desired).Elem())
+		return nil
 	} else if err != nil {
-		return reconcile.Result{}, err
-	} else {
-		reqLogger.Info("Skip reconcile: StatefulSet already exists", "StatefulSet.Namespace", foundSS.Namespace, "StatefulSet.Name", foundSS.Name)
+		return err
 	}
 
-	return reconcile.Result{}, nil
-}
+	if owner := metav1.GetControllerOf(found); owner != nil && owner.UID != instance.UID {
+		return fmt.Errorf("%s %s/%s already exists and is controlled by %s %s", kind, found.GetNamespace(), found.GetName(), owner.Kind, owner.Name)
+	}
 
+	changed, err := syncOwnedFields(desired, found, fields...)
+	if err != nil {
+		return err
+	}
+	if metav1.GetControllerOf(found) == nil {
+		if err := controllerutil.SetControllerReference(instance, found, r.scheme); err != nil {
+			return err
+		}
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+
+	objLogger.Info("Updating drifted object")
+	return r.client.Update(context.TODO(), found)
+}
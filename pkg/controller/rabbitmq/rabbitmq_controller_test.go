@@ -0,0 +1,100 @@
+package rabbitmq
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/toha10/rabbitmq-operator/pkg/apis"
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// newTestReconciler returns a reconciler reading and writing the given objects
+func newTestReconciler(objs ...runtime.Object) *ReconcileRabbitMQ {
+	s := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(s); err != nil {
+		panic(err)
+	}
+	if err := apis.AddToScheme(s); err != nil {
+		panic(err)
+	}
+	c := fake.NewFakeClientWithScheme(s, objs...)
+	return &ReconcileRabbitMQ{client: c, apiReader: c, scheme: s}
+}
+
+// newTestStatefulSet returns the StatefulSet of the test cluster with the pod template annotations
+func newTestStatefulSet(replicas int32, annotations map[string]string) *v1.StatefulSet {
+	cr := newTestCluster()
+	cr.Spec.Replicas = replicas
+	ss := newStatefulSet(cr, annotations)
+	ss.UID = "ss-uid"
+	return ss
+}
+
+// newTestPods returns the ready pods of the StatefulSet with the annotations
+func newTestPods(ss *v1.StatefulSet, annotations map[string]string) []runtime.Object {
+	pods := []runtime.Object{}
+	controller := true
+	for i := int32(0); i < *ss.Spec.Replicas; i++ {
+		podAnnotations := map[string]string{}
+		for k, v := range annotations {
+			podAnnotations[k] = v
+		}
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        fmt.Sprintf("%s-%d", ss.Name, i),
+				Namespace:   ss.Namespace,
+				Labels:      ss.Spec.Template.Labels,
+				Annotations: podAnnotations,
+				OwnerReferences: []metav1.OwnerReference{{
+					APIVersion: "apps/v1",
+					Kind:       "StatefulSet",
+					Name:       ss.Name,
+					UID:        ss.UID,
+					Controller: &controller,
+				}},
+			},
+			Spec: *ss.Spec.Template.Spec.DeepCopy(),
+			Status: corev1.PodStatus{
+				Phase:      corev1.PodRunning,
+				PodIP:      fmt.Sprintf("10.0.0.%d", i+1),
+				Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+			},
+		}
+		pod.Spec.Hostname = pod.Name
+		pod.Spec.Subdomain = ss.Spec.ServiceName
+		pods = append(pods, pod)
+	}
+	return pods
+}
+
+// podNames returns the names of the pods of the StatefulSet which exist
+func podNames(r *ReconcileRabbitMQ, ss *v1.StatefulSet) []string {
+	pods, err := r.statefulSetPods(ss)
+	if err != nil {
+		panic(err)
+	}
+	names := []string{}
+	for _, pod := range pods {
+		names = append(names, pod.Name)
+	}
+	return names
+}
+
+// fakeExecutor records the commands run in the pods and answers them from outputs and errors,
+// both keyed by "<pod>: <command>"
+type fakeExecutor struct {
+	commands []string
+	outputs  map[string]string
+	errors   map[string]error
+}
+
+func (e *fakeExecutor) Exec(pod *corev1.Pod, command ...string) (string, error) {
+	key := pod.Name + ": " + strings.Join(command, " ")
+	e.commands = append(e.commands, key)
+	return e.outputs[key], e.errors[key]
+}
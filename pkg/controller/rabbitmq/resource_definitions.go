@@ -1,93 +1,162 @@
 package rabbitmq
 
 import (
+	"strings"
+
 	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
 	v1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 func newService(cr *rabbitmqv1alpha1.RabbitMQ) *corev1.Service {
-	labels := map[string]string{
-		"app": "rabbitmq",
-		"type": "LoadBalancer",
-	}
-	selector := map[string]string{"app": "rabbitmq"}
+	labels := labelsForRabbitMQ(cr)
+	selector := selectorForRabbitMQ(cr)
 	return &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      cr.Spec.DiscoveryService,
 			Namespace: cr.Namespace,
-			Labels: labels,
+			Labels:    labels,
 		},
 		Spec: corev1.ServiceSpec{
 			Type:     corev1.ServiceTypeClusterIP,
 			Selector: selector,
-			Ports: []corev1.ServicePort{
+			Ports: append([]corev1.ServicePort{
 				{
 					Name:       "http",
 					Protocol:   corev1.ProtocolTCP,
 					Port:       15672,
+					TargetPort: intstr.FromInt(15672),
 				},
 				{
 					Name:       "amqp",
 					Protocol:   corev1.ProtocolTCP,
 					Port:       5672,
+					TargetPort: intstr.FromInt(5672),
 				},
-			},
+			}, append(tlsServicePorts(cr), monitoringServicePorts(cr)...)...),
 		},
 	}
 }
 
-func newConfigMap(cr *rabbitmqv1alpha1.RabbitMQ) *corev1.ConfigMap {
-	rabbitmqPlugins := "[rabbitmq_management,rabbitmq_peer_discovery_k8s]."
-
-	rabbitmqConf := `## Cluster formation. See https://www.rabbitmq.com/cluster-formation.html to learn more.
-cluster_formation.peer_discovery_backend  = rabbit_peer_discovery_k8s
-cluster_formation.k8s.host = kubernetes.default.svc.cluster.local
-## Should RabbitMQ node name be computed from the pod's hostname or IP address?
-## IP addresses are not stable, so using [stable] hostnames is recommended when possible.
-## Set to "hostname" to use pod hostnames.
-## When this value is changed, so should the variable used to set the RABBITMQ_NODENAME
-## environment variable.
-cluster_formation.k8s.address_type = ip
-## How often should node cleanup checks run?
-cluster_formation.node_cleanup.interval = 30
-## Set to false if automatic removal of unknown/absent nodes
-## is desired. This can be dangerous, see
-##  * https://www.rabbitmq.com/cluster-formation.html#node-health-checks-and-cleanup
-##  * https://groups.google.com/forum/#!msg/rabbitmq-users/wuOfzEywHXo/k8z_HWIkBgAJ
-cluster_formation.node_cleanup.only_log_warning = true
-cluster_partition_handling = autoheal
-## See https://www.rabbitmq.com/ha.html#master-migration-data-locality
-queue_master_locator=min-masters
-## See https://www.rabbitmq.com/access-control.html#loopback-users
-loopback_users.guest = false`
-
-
-	return &corev1.ConfigMap{
+// newHeadlessService returns the governing Service of the StatefulSet, it gives the pods stable
+// DNS names and lists the pods before they are ready, so the peers can discover each other
+func newHeadlessService(cr *rabbitmqv1alpha1.RabbitMQ) *corev1.Service {
+	return &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "rabbitmq-config",
+			Name:      headlessServiceName(cr),
 			Namespace: cr.Namespace,
+			Labels:    labelsForRabbitMQ(cr),
 		},
-		Data: map[string]string{
-			"rabbitmq.conf":   rabbitmqConf,
-			"enabled_plugins": rabbitmqPlugins,
+		Spec: corev1.ServiceSpec{
+			Type:                     corev1.ServiceTypeClusterIP,
+			ClusterIP:                corev1.ClusterIPNone,
+			Selector:                 selectorForRabbitMQ(cr),
+			PublishNotReadyAddresses: true,
+			Ports: append([]corev1.ServicePort{
+				{
+					Name:       "epmd",
+					Protocol:   corev1.ProtocolTCP,
+					Port:       4369,
+					TargetPort: intstr.FromInt(4369),
+				},
+				{
+					Name:       "cluster-links",
+					Protocol:   corev1.ProtocolTCP,
+					Port:       25672,
+					TargetPort: intstr.FromInt(25672),
+				},
+				{
+					Name:       "amqp",
+					Protocol:   corev1.ProtocolTCP,
+					Port:       5672,
+					TargetPort: intstr.FromInt(5672),
+				},
+				{
+					Name:       "http",
+					Protocol:   corev1.ProtocolTCP,
+					Port:       15672,
+					TargetPort: intstr.FromInt(15672),
+				},
+			}, tlsServicePorts(cr)...),
 		},
 	}
 }
 
+func newConfigMap(cr *rabbitmqv1alpha1.RabbitMQ, tls *tlsSecret) (*corev1.ConfigMap, error) {
+	rabbitmqPlugins, err := renderEnabledPlugins(cr)
+	if err != nil {
+		return nil, err
+	}
+
+	rabbitmqConf, err := renderRabbitMQConf(cr, tls)
+	if err != nil {
+		return nil, err
+	}
 
-func newStatefulSet(cr *rabbitmqv1alpha1.RabbitMQ) *v1.StatefulSet {
-	labels := map[string]string{
-		"app": "rabbitmq",
+	data := map[string]string{
+		"rabbitmq.conf":   rabbitmqConf,
+		"enabled_plugins": rabbitmqPlugins,
+	}
+	if strings.TrimSpace(cr.Spec.AdvancedConfig) != "" {
+		if err := validateAdvancedConfig(cr.Spec.AdvancedConfig); err != nil {
+			return nil, err
+		}
+		data["advanced.config"] = cr.Spec.AdvancedConfig
+	}
+	if cr.Spec.TLS != nil && cr.Spec.TLS.InterNode {
+		data[interNodeTLSConfigFile] = renderInterNodeTLSConfig()
 	}
 
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      configMapName(cr),
+			Namespace: cr.Namespace,
+			Labels:    labelsForRabbitMQ(cr),
+		},
+		Data: data,
+	}, nil
+}
+
+// podAnnotationPrefix is the prefix of the annotations the operator sets on the pod template
+const podAnnotationPrefix = "rabbitmq.mirantis.com/"
+
+// volumeDefaultMode is the mode of the files of the ConfigMap and Secret volumes. It is the
+// default of the API server, set explicitly so the volumes can be compared exactly.
+const volumeDefaultMode int32 = 0644
+
+// statefulSetFields are the fields of the StatefulSet kept in sync with the spec. The pod template
+// is merged, so the values the API server fills in (e.g. the probe thresholds) are not drift, then
+// the parts built from the spec are replaced exactly, so what is removed from the spec is removed
+// from the pods too.
+var statefulSetFields = []ownedField{
+	labelsField,
+	mergedField("spec", "replicas"),
+	mergedField("spec", "updateStrategy"),
+	mergedField("spec", "template"),
+	prefixedKeysField(podAnnotationPrefix, "spec", "template", "metadata", "annotations"),
+	exactField("spec", "template", "spec", "nodeSelector"),
+	exactField("spec", "template", "spec", "tolerations"),
+	exactField("spec", "template", "spec", "affinity"),
+	exactField("spec", "template", "spec", "priorityClassName"),
+	exactField("spec", "template", "spec", "volumes"),
+	exactField("spec", "template", "spec", "containers", "*", "resources"),
+	exactField("spec", "template", "spec", "containers", "*", "env"),
+	exactField("spec", "template", "spec", "containers", "*", "ports"),
+	exactField("spec", "template", "spec", "containers", "*", "volumeMounts"),
+}
+
+func newStatefulSet(cr *rabbitmqv1alpha1.RabbitMQ, podAnnotations map[string]string) *v1.StatefulSet {
+	labels := labelsForRabbitMQ(cr)
+
 	podContainers := []corev1.Container{}
 
 	// container with rabbitmq
 	rabbitmqContainer := corev1.Container{
-		Name:  "rabbitmq",
-		Image: cr.Spec.Image,
+		Name:      "rabbitmq",
+		Image:     cr.Spec.Image,
+		Resources: cr.Spec.Resources,
 		Env: []corev1.EnvVar{
 			{
 				Name: "MY_POD_IP",
@@ -98,21 +167,37 @@ func newStatefulSet(cr *rabbitmqv1alpha1.RabbitMQ) *v1.StatefulSet {
 					},
 				},
 			},
+			{
+				Name: "MY_POD_NAME",
+				ValueFrom: &corev1.EnvVarSource{
+					FieldRef: &corev1.ObjectFieldSelector{
+						APIVersion: "v1",
+						FieldPath:  "metadata.name",
+					},
+				},
+			},
 			{
 				Name:  "RABBITMQ_USE_LONGNAME",
 				Value: "true",
 			},
 			{
 				Name:  "RABBITMQ_NODENAME",
-				Value: "rabbit@$(MY_POD_IP)",
+				Value: nodeNameEnv(cr),
 			},
 			{
 				Name:  "K8S_SERVICE_NAME",
-				Value: cr.Spec.DiscoveryService,
+				Value: headlessServiceName(cr),
 			},
 			{
-				Name:  "RABBITMQ_ERLANG_COOKIE",
-				Value: "mycookie",
+				Name: "RABBITMQ_ERLANG_COOKIE",
+				ValueFrom: &corev1.EnvVarSource{
+					SecretKeyRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{
+							Name: erlangCookieSecretName(cr),
+						},
+						Key: erlangCookieKey,
+					},
+				},
 			},
 		},
 		Ports: []corev1.ContainerPort{
@@ -122,8 +207,8 @@ func newStatefulSet(cr *rabbitmqv1alpha1.RabbitMQ) *v1.StatefulSet {
 				ContainerPort: 15672,
 			},
 			{
-				Name:	"amqp",
-				Protocol: corev1.ProtocolTCP,
+				Name:          "amqp",
+				Protocol:      corev1.ProtocolTCP,
 				ContainerPort: 5672,
 			},
 		},
@@ -133,7 +218,7 @@ func newStatefulSet(cr *rabbitmqv1alpha1.RabbitMQ) *v1.StatefulSet {
 				MountPath: "/etc/rabbitmq",
 			},
 			{
-				Name:      "rabbitmq-data",
+				Name:      dataVolumeName,
 				MountPath: "/var/lib/rabbitmq",
 			},
 		},
@@ -169,54 +254,34 @@ func newStatefulSet(cr *rabbitmqv1alpha1.RabbitMQ) *v1.StatefulSet {
 
 	podTemplate := corev1.PodTemplateSpec{
 		ObjectMeta: metav1.ObjectMeta{
-			Labels: labels,
+			Labels:      labels,
+			Annotations: podAnnotations,
 		},
 		Spec: corev1.PodSpec{
 			ServiceAccountName: cr.Spec.ServiceAccount,
-			Containers: podContainers,
+			Containers:         podContainers,
+			NodeSelector:       cr.Spec.NodeSelector,
+			Tolerations:        cr.Spec.Tolerations,
+			Affinity:           podAffinity(cr),
+			PriorityClassName:  cr.Spec.PriorityClassName,
 			Volumes: []corev1.Volume{
 				{
 					Name: "config-volume",
 					VolumeSource: corev1.VolumeSource{
 						ConfigMap: &corev1.ConfigMapVolumeSource{
+							// every file of the ConfigMap is mounted
 							LocalObjectReference: corev1.LocalObjectReference{
-								Name: "rabbitmq-config",
-							},
-							Items: []corev1.KeyToPath{
-								{
-									Key: "rabbitmq.conf",
-									Path: "rabbitmq.conf",
-								},
-								{
-									Key: "enabled_plugins",
-									Path: "enabled_plugins",
-								},
+								Name: configMapName(cr),
 							},
+							DefaultMode: newInt32(volumeDefaultMode),
 						},
 					},
 				},
 			},
 		},
 	}
-
-	pvcTemplate := []corev1.PersistentVolumeClaim{
-		{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:   "rabbitmq-data",
-			},
-			Spec: corev1.PersistentVolumeClaimSpec{
-				AccessModes: []corev1.PersistentVolumeAccessMode{
-					corev1.ReadWriteOnce,
-				},
-
-				Resources: corev1.ResourceRequirements{
-					Requests: corev1.ResourceList{
-						corev1.ResourceStorage: cr.Spec.DataVolumeSize,
-					},
-				},
-			},
-		},
-	}
+	addTLS(cr, &podTemplate.Spec, &podTemplate.Spec.Containers[0])
+	addMonitoring(cr, &podTemplate.Spec.Containers[0])
 
 	return &v1.StatefulSet{
 		TypeMeta: metav1.TypeMeta{
@@ -224,21 +289,27 @@ func newStatefulSet(cr *rabbitmqv1alpha1.RabbitMQ) *v1.StatefulSet {
 			APIVersion: "apps/v1",
 		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      	cr.Name,
-			Namespace: 	cr.Namespace,
-			Labels:		labels,
+			Name:      statefulSetName(cr),
+			Namespace: cr.Namespace,
+			Labels:    labels,
 		},
 		Spec: v1.StatefulSetSpec{
-			Replicas:		&cr.Spec.Replicas,
-			Template:   	podTemplate,
-			ServiceName: 	cr.Name,
-			VolumeClaimTemplates: pvcTemplate,
+			Replicas:             &cr.Spec.Replicas,
+			Template:             podTemplate,
+			ServiceName:          headlessServiceName(cr),
+			VolumeClaimTemplates: []corev1.PersistentVolumeClaim{dataVolumeClaimTemplate(cr)},
 			Selector: &metav1.LabelSelector{
-				MatchLabels: labels,
+				MatchLabels: selectorForRabbitMQ(cr),
 			},
 			UpdateStrategy: v1.StatefulSetUpdateStrategy{
 				Type: v1.OnDeleteStatefulSetStrategyType,
 			},
+			// nodes restarted together have to be able to wait for each other
+			PodManagementPolicy: v1.ParallelPodManagement,
 		},
 	}
 }
+
+func newInt32(i int32) *int32 {
+	return &i
+}
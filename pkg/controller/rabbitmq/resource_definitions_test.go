@@ -0,0 +1,45 @@
+package rabbitmq
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func servicePortNames(svc *corev1.Service) map[string]int32 {
+	ports := map[string]int32{}
+	for _, p := range svc.Spec.Ports {
+		ports[p.Name] = p.Port
+	}
+	return ports
+}
+
+func TestNewHeadlessService(t *testing.T) {
+	cr := newTestCluster()
+	svc := newHeadlessService(cr)
+	ss := newStatefulSet(cr, nil)
+
+	if svc.Name != ss.Spec.ServiceName {
+		t.Errorf("got Service %s, the StatefulSet is governed by %s", svc.Name, ss.Spec.ServiceName)
+	}
+	if svc.Name == cr.Spec.DiscoveryService {
+		t.Errorf("the headless Service clashes with the client Service")
+	}
+	if svc.Spec.ClusterIP != corev1.ClusterIPNone {
+		t.Errorf("got cluster IP %q, want None", svc.Spec.ClusterIP)
+	}
+	if !svc.Spec.PublishNotReadyAddresses {
+		t.Errorf("the peers can't discover each other before they are ready")
+	}
+	ports := servicePortNames(svc)
+	for name, port := range map[string]int32{"epmd": 4369, "cluster-links": 25672} {
+		if ports[name] != port {
+			t.Errorf("got port %s %d, want %d", name, ports[name], port)
+		}
+	}
+	for k, v := range selectorForRabbitMQ(cr) {
+		if ss.Spec.Template.Labels[k] != v || svc.Spec.Selector[k] != v {
+			t.Errorf("the Service doesn't select the pods by %s", k)
+		}
+	}
+}
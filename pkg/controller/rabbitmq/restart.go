@@ -0,0 +1,127 @@
+package rabbitmq
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"github.com/go-logr/logr"
+	"github.com/toha10/rabbitmq-operator/pkg/management"
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+// configHashAnnotation is set on the pod template to the hash of the generated configuration files,
+// so a change of the configuration produces a new revision of the StatefulSet
+const configHashAnnotation = "rabbitmq.mirantis.com/config-hash"
+
+// configHash returns the hash of the ConfigMap contents
+func configHash(cm *corev1.ConfigMap) string {
+	keys := make([]string, 0, len(cm.Data))
+	for key := range cm.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, key := range keys {
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		h.Write([]byte(cm.Data[key]))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:10]
+}
+
+// outdatedPods returns the pods which don't run the update revision of the StatefulSet
+func outdatedPods(ss *v1.StatefulSet, pods []corev1.Pod) []corev1.Pod {
+	outdated := []corev1.Pod{}
+	for _, pod := range pods {
+		if pod.Labels[v1.StatefulSetRevisionLabel] != ss.Status.UpdateRevision {
+			outdated = append(outdated, pod)
+		}
+	}
+	return outdated
+}
+
+// clusterHealth returns the reason the cluster can't lose a node now, or an empty string
+// if all expected nodes are running without alarms and all replicated queues are synchronised
+func clusterHealth(mgmt *management.Client, pods []corev1.Pod) (string, error) {
+	nodes, err := mgmt.ListNodes()
+	if err != nil {
+		return "", err
+	}
+	running := map[string]bool{}
+	for _, node := range nodes {
+		running[node.Name] = node.Running
+		if node.MemAlarm {
+			return fmt.Sprintf("node %s reports a memory alarm", node.Name), nil
+		}
+		if node.DiskFreeAlarm {
+			return fmt.Sprintf("node %s reports a disk alarm", node.Name), nil
+		}
+	}
+	for i := range pods {
+		name := nodeNameForPod(&pods[i])
+		if !running[name] {
+			return fmt.Sprintf("node %s is not running in the cluster", name), nil
+		}
+	}
+
+	queues, err := mgmt.ListQueues()
+	if err != nil {
+		return "", err
+	}
+	for _, queue := range queues {
+		if queue.Unsynchronised() {
+			return fmt.Sprintf("queue %s in vhost %s is not synchronised", queue.Name, queue.Vhost), nil
+		}
+	}
+	return "", nil
+}
+
+// rollingRestart restarts the pods running an outdated revision of the StatefulSet one by one,
+// starting from the highest ordinal. The StatefulSet uses the OnDelete update strategy, so the
+// operator decides when the next pod goes: only when every pod is ready, the restarted node
+// has rejoined the cluster and all replicated queues are synchronised again.
+// It reports whether a restart is in progress.
+func (r *ReconcileRabbitMQ) rollingRestart(reqLogger logr.Logger, ss *v1.StatefulSet, pods []corev1.Pod, mgmt *management.Client) (bool, error) {
+	if ss.Status.ObservedGeneration < ss.Generation || ss.Status.UpdateRevision == "" {
+		// the StatefulSet controller hasn't computed the new revision yet
+		return true, nil
+	}
+	outdated := outdatedPods(ss, pods)
+	if len(outdated) == 0 {
+		return false, nil
+	}
+
+	if ss.Spec.Replicas != nil && int32(len(pods)) < *ss.Spec.Replicas {
+		return true, nil
+	}
+	for i := range pods {
+		if !isPodReady(&pods[i]) {
+			return true, nil
+		}
+	}
+	if mgmt == nil {
+		return true, nil
+	}
+	reason, err := clusterHealth(mgmt, pods)
+	if err != nil {
+		return true, err
+	}
+	if reason != "" {
+		reqLogger.Info("Waiting for the cluster to recover before restarting the next pod", "Reason", reason)
+		return true, nil
+	}
+
+	pod := &outdated[len(outdated)-1]
+	reqLogger.Info("Restarting the pod to apply the new revision", "Pod.Namespace", pod.Namespace, "Pod.Name", pod.Name, "Revision", ss.Status.UpdateRevision)
+	if err := r.client.Delete(context.TODO(), pod); err != nil && !errors.IsNotFound(err) {
+		return true, err
+	}
+	return true, nil
+}
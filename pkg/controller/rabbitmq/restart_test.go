@@ -0,0 +1,163 @@
+package rabbitmq
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/toha10/rabbitmq-operator/pkg/management"
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+// newTestManagement returns a client of a management API listing the nodes and the queues
+func newTestManagement(t *testing.T, nodes []management.Node, queues []management.Queue) *management.Client {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/api/nodes":
+			json.NewEncoder(w).Encode(nodes)
+		case "/api/queues":
+			json.NewEncoder(w).Encode(queues)
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return management.NewClient(server.URL, "admin", "secret")
+}
+
+// runningNodes returns the nodes of the pods, all running
+func runningNodes(pods []corev1.Pod) []management.Node {
+	nodes := []management.Node{}
+	for i := range pods {
+		nodes = append(nodes, management.Node{Name: nodeNameForPod(&pods[i]), Running: true})
+	}
+	return nodes
+}
+
+func TestConfigHash(t *testing.T) {
+	cm := &corev1.ConfigMap{Data: map[string]string{"rabbitmq.conf": "a = 1", "enabled_plugins": "[]."}}
+	hash := configHash(cm)
+	if len(hash) != 10 {
+		t.Errorf("got a hash of %d characters, want 10", len(hash))
+	}
+	for i := 0; i < 5; i++ {
+		if configHash(cm) != hash {
+			t.Fatalf("hash depends on the order of the keys")
+		}
+	}
+	changed := cm.DeepCopy()
+	changed.Data["rabbitmq.conf"] = "a = 2"
+	if configHash(changed) == hash {
+		t.Errorf("hash doesn't change with the contents")
+	}
+	moved := &corev1.ConfigMap{Data: map[string]string{"rabbitmq.conf": "a = 1enabled_plugins", "": "[]."}}
+	if configHash(moved) == hash {
+		t.Errorf("keys and values are not separated")
+	}
+}
+
+func TestRollingRestart(t *testing.T) {
+	tests := []struct {
+		name string
+		// revisions are the revisions of the pods, the StatefulSet is at "new"
+		revisions []string
+		// notReady is the ordinal of a pod which isn't ready, or -1
+		notReady   int
+		nodes      func(nodes []management.Node)
+		queues     []management.Queue
+		restarting bool
+		remaining  []string
+	}{
+		{"up to date", []string{"new", "new", "new"}, -1, nil, nil, false, []string{"rmq-0", "rmq-1", "rmq-2"}},
+		{"highest ordinal first", []string{"old", "old", "old"}, -1, nil, nil, true, []string{"rmq-0", "rmq-1"}},
+		{"next outdated pod", []string{"old", "old", "new"}, -1, nil, nil, true, []string{"rmq-0", "rmq-2"}},
+		{"pod not ready", []string{"old", "old", "new"}, 2, nil, nil, true, []string{"rmq-0", "rmq-1", "rmq-2"}},
+		{"node not running", []string{"old", "old", "new"}, -1, func(nodes []management.Node) {
+			nodes[2].Running = false
+		}, nil, true, []string{"rmq-0", "rmq-1", "rmq-2"}},
+		{"node not in the cluster", []string{"old", "old", "new"}, -1, func(nodes []management.Node) {
+			nodes[2].Name = "rabbit@other"
+		}, nil, true, []string{"rmq-0", "rmq-1", "rmq-2"}},
+		{"memory alarm", []string{"old", "old", "new"}, -1, func(nodes []management.Node) {
+			nodes[0].MemAlarm = true
+		}, nil, true, []string{"rmq-0", "rmq-1", "rmq-2"}},
+		{"mirror not synchronised", []string{"old", "old", "new"}, -1, nil, []management.Queue{
+			{Name: "q", Vhost: "/", SlaveNodes: []string{"a", "b"}, SynchronisedSlaveNodes: []string{"a"}},
+		}, true, []string{"rmq-0", "rmq-1", "rmq-2"}},
+		{"quorum member offline", []string{"old", "old", "new"}, -1, nil, []management.Queue{
+			{Name: "q", Vhost: "/", Members: []string{"a", "b", "c"}, Online: []string{"a", "b"}},
+		}, true, []string{"rmq-0", "rmq-1", "rmq-2"}},
+		{"queues synchronised", []string{"old", "old", "new"}, -1, nil, []management.Queue{
+			{Name: "q", Vhost: "/", SlaveNodes: []string{"a"}, SynchronisedSlaveNodes: []string{"a"}},
+			{Name: "qq", Vhost: "/", Members: []string{"a", "b"}, Online: []string{"a", "b"}},
+		}, true, []string{"rmq-0", "rmq-2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ss := newTestStatefulSet(3, nil)
+			ss.Status.UpdateRevision = "new"
+			objs := []runtime.Object{ss}
+			for i, obj := range newTestPods(ss, nil) {
+				pod := obj.(*corev1.Pod)
+				pod.Labels = map[string]string{v1.StatefulSetRevisionLabel: tt.revisions[i]}
+				for k, v := range ss.Spec.Template.Labels {
+					pod.Labels[k] = v
+				}
+				if i == tt.notReady {
+					pod.Status.Conditions[0].Status = corev1.ConditionFalse
+				}
+				objs = append(objs, pod)
+			}
+			r := newTestReconciler(objs...)
+			pods, err := r.statefulSetPods(ss)
+			if err != nil {
+				t.Fatal(err)
+			}
+			nodes := runningNodes(pods)
+			if tt.nodes != nil {
+				tt.nodes(nodes)
+			}
+			mgmt := newTestManagement(t, nodes, tt.queues)
+
+			restarting, err := r.rollingRestart(logf.Log, ss, pods, mgmt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if restarting != tt.restarting {
+				t.Errorf("got restarting %v, want %v", restarting, tt.restarting)
+			}
+			if got := podNames(r, ss); !reflect.DeepEqual(got, tt.remaining) {
+				t.Errorf("got pods %v, want %v", got, tt.remaining)
+			}
+		})
+	}
+}
+
+func TestRollingRestartWaitsForTheRevision(t *testing.T) {
+	ss := newTestStatefulSet(3, nil)
+	ss.Generation = 2
+	ss.Status.ObservedGeneration = 1
+	ss.Status.UpdateRevision = "new"
+	objs := append([]runtime.Object{ss}, newTestPods(ss, nil)...)
+	r := newTestReconciler(objs...)
+	pods, err := r.statefulSetPods(ss)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restarting, err := r.rollingRestart(logf.Log, ss, pods, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !restarting {
+		t.Errorf("restart reported done before the StatefulSet controller observed the change")
+	}
+	if got := podNames(r, ss); len(got) != 3 {
+		t.Errorf("got pods %v, want all of them", got)
+	}
+}
@@ -0,0 +1,339 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/go-logr/logr"
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	"github.com/toha10/rabbitmq-operator/pkg/management"
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// dataVolumeName is the name of the volume claim template with the node data
+const dataVolumeName = "rabbitmq-data"
+
+// dataVolumeClaimName returns the name of the PersistentVolumeClaim the StatefulSet creates for the pod
+func dataVolumeClaimName(ss *v1.StatefulSet, ordinal int32) string {
+	return fmt.Sprintf("%s-%s-%d", dataVolumeName, ss.Name, ordinal)
+}
+
+// scaleDown removes the nodes above spec.replicas from the cluster one at a time, starting from the
+// highest ordinal. Lowering the replicas of the StatefulSet alone would leave the dead nodes in the
+// cluster membership, so the node is first drained of its quorum queue replicas and of the masters
+// of the mirrored classic queues, then its application is stopped and it is forgotten by a
+// surviving member, and only then the StatefulSet shrinks. Every step is reported in
+// status.scaling. It reports whether a scale-down is in progress.
+//
+// A node which has started leaving the cluster is never abandoned: if spec.replicas is raised
+// while it is drained or its application is stopped, the node is brought back into the cluster,
+// and once the StatefulSet is shrinking the removal is finished.
+func (r *ReconcileRabbitMQ) scaleDown(reqLogger logr.Logger, instance *rabbitmqv1alpha1.RabbitMQ, ss *v1.StatefulSet, pods []corev1.Pod, mgmt *management.Client) (bool, error) {
+	current := int32(0)
+	if ss.Spec.Replicas != nil {
+		current = *ss.Spec.Replicas
+	}
+	desired := instance.Spec.Replicas
+	scaling := instance.Status.Scaling
+	if scaling != nil && scaling.Pod != "" {
+		switch scaling.Step {
+		case rabbitmqv1alpha1.ScalingShrinking:
+			return r.shrinkStatefulSet(reqLogger, ss, scaling)
+		case rabbitmqv1alpha1.ScalingDraining:
+			if desired >= current {
+				if reviving, err := r.reviveNode(reqLogger, pods, scaling, mgmt); reviving || err != nil {
+					return true, err
+				}
+			}
+		case rabbitmqv1alpha1.ScalingStoppingApp, rabbitmqv1alpha1.ScalingForgettingNode:
+			if desired >= current {
+				return r.rejoinNode(reqLogger, pods, scaling)
+			}
+		}
+	}
+	if desired >= current {
+		instance.Status.Scaling = nil
+		return false, nil
+	}
+
+	if scaling == nil {
+		scaling = &rabbitmqv1alpha1.ScalingStatus{From: current}
+		instance.Status.Scaling = scaling
+	}
+	scaling.To = desired
+	wait := func(format string, args ...interface{}) (bool, error) {
+		scaling.Message = fmt.Sprintf(format, args...)
+		return true, nil
+	}
+
+	ordinal := current - 1
+	var doomed, survivor *corev1.Pod
+	for i := range pods {
+		switch podOrdinal(&pods[i]) {
+		case int(ordinal):
+			doomed = &pods[i]
+		case 0:
+			survivor = &pods[i]
+		}
+	}
+	if doomed == nil {
+		// the node can't be forgotten before its pod is back
+		return wait("waiting for pod %s-%d to be created", ss.Name, ordinal)
+	}
+	node := nodeNameForPod(doomed)
+	if scaling.Node != node {
+		scaling.Node = node
+		scaling.Step = rabbitmqv1alpha1.ScalingDraining
+	}
+	scaling.Pod = doomed.Name
+	if desired < 1 || survivor == nil || ordinal == 0 {
+		return false, fmt.Errorf("can't scale down to %d members: at least one member has to stay to forget the removed nodes", desired)
+	}
+
+	podLogger := reqLogger.WithValues("Pod.Namespace", doomed.Namespace, "Pod.Name", doomed.Name, "Node", node)
+	switch scaling.Step {
+	case rabbitmqv1alpha1.ScalingDraining:
+		for i := range pods {
+			if !isPodReady(&pods[i]) {
+				return wait("waiting for pod %s to be ready", pods[i].Name)
+			}
+		}
+		if mgmt == nil {
+			return wait("waiting for the management API")
+		}
+		reason, err := clusterHealth(mgmt, pods)
+		if err != nil {
+			return true, err
+		}
+		if reason != "" {
+			return wait("waiting for the cluster to recover: %s", reason)
+		}
+
+		queues, err := mgmt.ListQueues()
+		if err != nil {
+			return true, err
+		}
+		if queue := unreplicatedQueue(queues, node); queue != nil {
+			return wait("queue %s in vhost %s has no replicas outside of node %s, move or delete it", queue.Name, queue.Vhost, node)
+		}
+		drainQuorum := false
+		for _, queue := range queues {
+			if queue.HasMember(node) && len(queue.Members) > 0 {
+				drainQuorum = true
+			}
+		}
+		if drainQuorum {
+			podLogger.Info("Removing the quorum queue members from the node")
+			if _, err := r.executor.Exec(survivor, "rabbitmq-queues", "shrink", node); err != nil {
+				scaling.Message = err.Error()
+				return true, err
+			}
+		}
+		// the masters of the mirrored classic queues are moved to their synchronised mirrors by
+		// putting the node into maintenance mode, which also keeps new masters off it
+		if queue := mirroredMaster(queues, node); queue != nil {
+			drained, err := nodeBeingDrained(mgmt, node)
+			if err != nil {
+				return true, err
+			}
+			if !drained {
+				podLogger.Info("Putting the node into maintenance mode to move the queue masters off it")
+				if _, err := r.executor.Exec(doomed, "rabbitmq-upgrade", "drain"); err != nil {
+					scaling.Message = err.Error()
+					return true, err
+				}
+			}
+			return wait("waiting for the master of queue %s in vhost %s to move off node %s", queue.Name, queue.Vhost, node)
+		}
+		scaling.Step = rabbitmqv1alpha1.ScalingStoppingApp
+		fallthrough
+
+	case rabbitmqv1alpha1.ScalingStoppingApp:
+		podLogger.Info("Stopping the RabbitMQ application on the node")
+		if _, err := r.executor.Exec(doomed, "rabbitmqctl", "stop_app"); err != nil {
+			scaling.Message = err.Error()
+			return true, err
+		}
+		scaling.Step = rabbitmqv1alpha1.ScalingForgettingNode
+		fallthrough
+
+	case rabbitmqv1alpha1.ScalingForgettingNode:
+		podLogger.Info("Forgetting the node on a surviving member", "Survivor", survivor.Name)
+		if err := r.forgetNode(survivor, node); err != nil {
+			scaling.Message = err.Error()
+			return true, err
+		}
+		scaling.Step = rabbitmqv1alpha1.ScalingShrinking
+		return r.shrinkStatefulSet(reqLogger, ss, scaling)
+	}
+	return true, nil
+}
+
+// unreplicatedQueue returns a durable queue without replicas outside of the node, its messages are
+// lost when the node leaves the cluster or drops its data; nil if there is none
+func unreplicatedQueue(queues []management.Queue, node string) *management.Queue {
+	for i := range queues {
+		if queues[i].Durable && queues[i].HasMember(node) && !queues[i].Replicated() {
+			return &queues[i]
+		}
+	}
+	return nil
+}
+
+// mirroredMaster returns a mirrored classic queue with its master on the node; nil if there is none
+func mirroredMaster(queues []management.Queue, node string) *management.Queue {
+	for i := range queues {
+		if queues[i].Node == node && len(queues[i].SlaveNodes) > 0 {
+			return &queues[i]
+		}
+	}
+	return nil
+}
+
+// nodeBeingDrained reports whether the node is in maintenance mode
+func nodeBeingDrained(mgmt *management.Client, node string) (bool, error) {
+	nodes, err := mgmt.ListNodes()
+	if err != nil {
+		return false, err
+	}
+	for _, n := range nodes {
+		if n.Name == node {
+			return n.BeingDrained, nil
+		}
+	}
+	return false, nil
+}
+
+// forgetNode removes the node from the cluster membership on the surviving member
+func (r *ReconcileRabbitMQ) forgetNode(survivor *corev1.Pod, node string) error {
+	if _, err := r.executor.Exec(survivor, "rabbitmqctl", "forget_cluster_node", node); err != nil && !strings.Contains(err.Error(), "not_in_cluster") {
+		return err
+	}
+	return nil
+}
+
+// rejoinNode brings the node whose removal has been cancelled back into the cluster. A node with
+// only its application stopped is started again. A node which may have been forgotten already is
+// forgotten for sure, reset and joined to a surviving member: it loses its data, which the other
+// members have copies of since the node has been drained.
+func (r *ReconcileRabbitMQ) rejoinNode(reqLogger logr.Logger, pods []corev1.Pod, scaling *rabbitmqv1alpha1.ScalingStatus) (bool, error) {
+	var pod, survivor *corev1.Pod
+	for i := range pods {
+		switch {
+		case pods[i].Name == scaling.Pod:
+			pod = &pods[i]
+		case survivor == nil && isPodReady(&pods[i]):
+			survivor = &pods[i]
+		}
+	}
+	if pod == nil {
+		scaling.Message = fmt.Sprintf("waiting for pod %s to be created", scaling.Pod)
+		return true, nil
+	}
+
+	podLogger := reqLogger.WithValues("Pod.Namespace", pod.Namespace, "Pod.Name", pod.Name, "Node", scaling.Node)
+	commands := [][]string{}
+	if scaling.Step == rabbitmqv1alpha1.ScalingForgettingNode {
+		if survivor == nil {
+			scaling.Message = "waiting for a member of the cluster to be ready"
+			return true, nil
+		}
+		podLogger.Info("Scale-down cancelled, joining the forgotten node to the cluster again", "Survivor", survivor.Name)
+		if err := r.forgetNode(survivor, scaling.Node); err != nil {
+			scaling.Message = err.Error()
+			return true, err
+		}
+		commands = append(commands,
+			[]string{"rabbitmqctl", "force_reset"},
+			[]string{"rabbitmqctl", "join_cluster", nodeNameForPod(survivor)})
+	} else {
+		podLogger.Info("Scale-down cancelled, starting the RabbitMQ application on the node again")
+	}
+	// the node may still be in maintenance mode from the drain
+	commands = append(commands, []string{"rabbitmqctl", "start_app"}, []string{"rabbitmq-upgrade", "revive"})
+	for _, command := range commands {
+		if _, err := r.executor.Exec(pod, command...); err != nil {
+			scaling.Message = err.Error()
+			return true, err
+		}
+	}
+	scaling.Node = ""
+	scaling.Pod = ""
+	scaling.Step = rabbitmqv1alpha1.ScalingDraining
+	scaling.Message = ""
+	return true, nil
+}
+
+// reviveNode takes the node whose removal has been cancelled while it was being drained out of
+// maintenance mode, its application is still running. It reports whether the node can't be
+// revived yet.
+func (r *ReconcileRabbitMQ) reviveNode(reqLogger logr.Logger, pods []corev1.Pod, scaling *rabbitmqv1alpha1.ScalingStatus, mgmt *management.Client) (bool, error) {
+	if mgmt == nil {
+		scaling.Message = "waiting for the management API"
+		return true, nil
+	}
+	drained, err := nodeBeingDrained(mgmt, scaling.Node)
+	if err != nil {
+		return true, err
+	}
+	if drained {
+		var pod *corev1.Pod
+		for i := range pods {
+			if pods[i].Name == scaling.Pod {
+				pod = &pods[i]
+			}
+		}
+		if pod == nil {
+			scaling.Message = fmt.Sprintf("waiting for pod %s to be created", scaling.Pod)
+			return true, nil
+		}
+		reqLogger.Info("Scale-down cancelled, taking the node out of maintenance mode",
+			"Pod.Namespace", pod.Namespace, "Pod.Name", pod.Name, "Node", scaling.Node)
+		if _, err := r.executor.Exec(pod, "rabbitmq-upgrade", "revive"); err != nil {
+			scaling.Message = err.Error()
+			return true, err
+		}
+	}
+	return false, nil
+}
+
+// shrinkStatefulSet removes the pod of the forgotten node from the StatefulSet and deletes its data
+// volume. The data of a forgotten node must not be reused: a pod started on it again would believe
+// it is still a member of the cluster and would be refused. The step is kept until the volume
+// claim is deleted, so a failed deletion is retried even when spec.replicas is raised meanwhile.
+func (r *ReconcileRabbitMQ) shrinkStatefulSet(reqLogger logr.Logger, ss *v1.StatefulSet, scaling *rabbitmqv1alpha1.ScalingStatus) (bool, error) {
+	ordinal := int32(podNameOrdinal(scaling.Pod))
+	if ordinal < 1 {
+		return true, fmt.Errorf("can't remove pod %s from the StatefulSet", scaling.Pod)
+	}
+	scaling.Message = ""
+	if ss.Spec.Replicas != nil && *ss.Spec.Replicas > ordinal {
+		reqLogger.Info("Shrinking the StatefulSet", "StatefulSet.Namespace", ss.Namespace, "StatefulSet.Name", ss.Name, "Replicas", ordinal)
+		ss.Spec.Replicas = &ordinal
+		if err := r.client.Update(context.TODO(), ss); err != nil {
+			scaling.Message = err.Error()
+			return true, err
+		}
+	}
+
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      dataVolumeClaimName(ss, ordinal),
+			Namespace: ss.Namespace,
+		},
+	}
+	// the claim is protected from deletion until the pod using it is gone
+	reqLogger.Info("Deleting the data volume of the removed node", "PersistentVolumeClaim.Namespace", pvc.Namespace, "PersistentVolumeClaim.Name", pvc.Name)
+	if err := r.client.Delete(context.TODO(), pvc); err != nil && !errors.IsNotFound(err) {
+		scaling.Message = err.Error()
+		return true, err
+	}
+	scaling.Node = ""
+	scaling.Pod = ""
+	scaling.Step = rabbitmqv1alpha1.ScalingDraining
+	return true, nil
+}
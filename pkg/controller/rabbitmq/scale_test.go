@@ -0,0 +1,406 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	"github.com/toha10/rabbitmq-operator/pkg/management"
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+// failingDeleteClient fails the deletions
+type failingDeleteClient struct {
+	client.Client
+	err error
+}
+
+func (c failingDeleteClient) Delete(ctx context.Context, obj runtime.Object, opts ...client.DeleteOptionFunc) error {
+	return c.err
+}
+
+// scaleTest is a cluster of three members being scaled down
+type scaleTest struct {
+	r        *ReconcileRabbitMQ
+	executor *fakeExecutor
+	instance *rabbitmqv1alpha1.RabbitMQ
+	ss       *v1.StatefulSet
+	pods     []corev1.Pod
+	nodes    []string
+	// drained is the node in maintenance mode
+	drained string
+}
+
+func newScaleTest(t *testing.T, desired int32) *scaleTest {
+	ss := newTestStatefulSet(3, nil)
+	objs := append([]runtime.Object{ss}, newTestPods(ss, nil)...)
+	for i := int32(0); i < 3; i++ {
+		objs = append(objs, &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+			Name: dataVolumeClaimName(ss, i), Namespace: ss.Namespace,
+		}})
+	}
+	r := newTestReconciler(objs...)
+	executor := &fakeExecutor{}
+	r.executor = executor
+	pods, err := r.statefulSetPods(ss)
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance := newTestCluster()
+	instance.Spec.Replicas = desired
+	nodes := []string{}
+	for i := range pods {
+		nodes = append(nodes, nodeNameForPod(&pods[i]))
+	}
+	return &scaleTest{r: r, executor: executor, instance: instance, ss: ss, pods: pods, nodes: nodes}
+}
+
+// scaleDown runs a reconciliation of the scale-down
+func (st *scaleTest) scaleDown(t *testing.T, queues []management.Queue) (bool, error) {
+	st.executor.commands = nil
+	nodes := runningNodes(st.pods)
+	for i := range nodes {
+		nodes[i].BeingDrained = nodes[i].Name == st.drained
+	}
+	return st.r.scaleDown(logf.Log, st.instance, st.ss, st.pods, newTestManagement(t, nodes, queues))
+}
+
+// claimExists reports whether the data volume claim of the pod with the ordinal exists
+func (st *scaleTest) claimExists(t *testing.T, ordinal int32) bool {
+	pvc := &corev1.PersistentVolumeClaim{}
+	err := st.r.client.Get(context.TODO(), types.NamespacedName{Namespace: st.ss.Namespace, Name: dataVolumeClaimName(st.ss, ordinal)}, pvc)
+	if err != nil && !apierrors.IsNotFound(err) {
+		t.Fatal(err)
+	}
+	return err == nil
+}
+
+func TestScaleDown(t *testing.T) {
+	st := newScaleTest(t, 2)
+	scaling, err := st.scaleDown(t, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !scaling {
+		t.Errorf("scale-down reported done")
+	}
+	want := []string{
+		"rmq-2: rabbitmqctl stop_app",
+		"rmq-0: rabbitmqctl forget_cluster_node " + st.nodes[2],
+	}
+	if !reflect.DeepEqual(st.executor.commands, want) {
+		t.Errorf("got commands %v, want %v", st.executor.commands, want)
+	}
+	if *st.ss.Spec.Replicas != 2 {
+		t.Errorf("got %d replicas of the StatefulSet, want 2", *st.ss.Spec.Replicas)
+	}
+	if st.claimExists(t, 2) {
+		t.Errorf("data volume of the removed node kept")
+	}
+	if !st.claimExists(t, 1) {
+		t.Errorf("data volume of a remaining node deleted")
+	}
+
+	if scaling, err := st.scaleDown(t, nil); err != nil || scaling {
+		t.Errorf("got scaling %v, %v after the node has been removed", scaling, err)
+	}
+	if st.instance.Status.Scaling != nil {
+		t.Errorf("scaling status kept: %+v", st.instance.Status.Scaling)
+	}
+}
+
+func TestScaleDownDrainsTheNode(t *testing.T) {
+	st := newScaleTest(t, 2)
+	queues := []management.Queue{
+		{Name: "qq", Vhost: "/", Durable: true, Node: st.nodes[0], Members: []string{st.nodes[0], st.nodes[2]}, Online: []string{st.nodes[0], st.nodes[2]}},
+	}
+	if _, err := st.scaleDown(t, queues); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"rmq-0: rabbitmq-queues shrink " + st.nodes[2],
+		"rmq-2: rabbitmqctl stop_app",
+		"rmq-0: rabbitmqctl forget_cluster_node " + st.nodes[2],
+	}
+	if !reflect.DeepEqual(st.executor.commands, want) {
+		t.Errorf("got commands %v, want %v", st.executor.commands, want)
+	}
+}
+
+func TestScaleDownMovesTheQueueMasters(t *testing.T) {
+	st := newScaleTest(t, 2)
+	queues := []management.Queue{
+		{Name: "q", Vhost: "/", Durable: true, Node: st.nodes[2], SlaveNodes: []string{st.nodes[0]}, SynchronisedSlaveNodes: []string{st.nodes[0]}},
+	}
+	message := "waiting for the master of queue q in vhost / to move off node " + st.nodes[2]
+	if scaling, err := st.scaleDown(t, queues); err != nil || !scaling {
+		t.Fatalf("got scaling %v, %v", scaling, err)
+	}
+	if want := []string{"rmq-2: rabbitmq-upgrade drain"}; !reflect.DeepEqual(st.executor.commands, want) {
+		t.Errorf("got commands %v, want %v", st.executor.commands, want)
+	}
+	if status := st.instance.Status.Scaling; status.Step != rabbitmqv1alpha1.ScalingDraining || status.Message != message {
+		t.Errorf("got %s: %s, want %s: %s", status.Step, status.Message, rabbitmqv1alpha1.ScalingDraining, message)
+	}
+
+	// the node is in maintenance mode, the master has not moved yet
+	st.drained = st.nodes[2]
+	if scaling, err := st.scaleDown(t, queues); err != nil || !scaling {
+		t.Fatalf("got scaling %v, %v", scaling, err)
+	}
+	if len(st.executor.commands) != 0 {
+		t.Errorf("got commands %v", st.executor.commands)
+	}
+	if status := st.instance.Status.Scaling; status.Step != rabbitmqv1alpha1.ScalingDraining || status.Message != message {
+		t.Errorf("got %s: %s, want %s: %s", status.Step, status.Message, rabbitmqv1alpha1.ScalingDraining, message)
+	}
+
+	// the master has moved to the mirror
+	queues[0].Node, queues[0].SlaveNodes, queues[0].SynchronisedSlaveNodes = st.nodes[0], []string{st.nodes[2]}, []string{st.nodes[2]}
+	if _, err := st.scaleDown(t, queues); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"rmq-2: rabbitmqctl stop_app",
+		"rmq-0: rabbitmqctl forget_cluster_node " + st.nodes[2],
+	}
+	if !reflect.DeepEqual(st.executor.commands, want) {
+		t.Errorf("got commands %v, want %v", st.executor.commands, want)
+	}
+}
+
+func TestScaleDownCancelledWhileDraining(t *testing.T) {
+	st := newScaleTest(t, 2)
+	queues := []management.Queue{
+		{Name: "q", Vhost: "/", Durable: true, Node: st.nodes[2], SlaveNodes: []string{st.nodes[0]}, SynchronisedSlaveNodes: []string{st.nodes[0]}},
+	}
+	if _, err := st.scaleDown(t, queues); err != nil {
+		t.Fatal(err)
+	}
+
+	st.drained = st.nodes[2]
+	st.instance.Spec.Replicas = 3
+	if scaling, err := st.scaleDown(t, queues); err != nil || scaling {
+		t.Errorf("got scaling %v, %v", scaling, err)
+	}
+	if want := []string{"rmq-2: rabbitmq-upgrade revive"}; !reflect.DeepEqual(st.executor.commands, want) {
+		t.Errorf("got commands %v, want %v", st.executor.commands, want)
+	}
+	if st.instance.Status.Scaling != nil {
+		t.Errorf("scaling status kept: %+v", st.instance.Status.Scaling)
+	}
+}
+
+func TestScaleDownWaits(t *testing.T) {
+	tests := []struct {
+		name    string
+		queues  func(nodes []string) []management.Queue
+		prepare func(st *scaleTest)
+		message func(nodes []string) string
+	}{
+		{
+			name: "queue without replicas",
+			queues: func(nodes []string) []management.Queue {
+				return []management.Queue{{Name: "q", Vhost: "/", Durable: true, Node: nodes[2]}}
+			},
+			message: func(nodes []string) string {
+				return "queue q in vhost / has no replicas outside of node " + nodes[2] + ", move or delete it"
+			},
+		},
+		{
+			name: "mirror not synchronised",
+			queues: func(nodes []string) []management.Queue {
+				return []management.Queue{{Name: "q", Vhost: "/", Durable: true, Node: nodes[0], SlaveNodes: []string{nodes[2]}}}
+			},
+			message: func(nodes []string) string {
+				return "waiting for the cluster to recover: queue q in vhost / is not synchronised"
+			},
+		},
+		{
+			name: "pod not ready",
+			prepare: func(st *scaleTest) {
+				st.pods[1].Status.Conditions[0].Status = corev1.ConditionFalse
+			},
+			message: func(nodes []string) string {
+				return "waiting for pod rmq-1 to be ready"
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := newScaleTest(t, 2)
+			if tt.prepare != nil {
+				tt.prepare(st)
+			}
+			var queues []management.Queue
+			if tt.queues != nil {
+				queues = tt.queues(st.nodes)
+			}
+			scaling, err := st.scaleDown(t, queues)
+			if err != nil || !scaling {
+				t.Fatalf("got scaling %v, %v", scaling, err)
+			}
+			if len(st.executor.commands) != 0 {
+				t.Errorf("got commands %v", st.executor.commands)
+			}
+			status := st.instance.Status.Scaling
+			message := tt.message(st.nodes)
+			if status.Step != rabbitmqv1alpha1.ScalingDraining || status.Message != message {
+				t.Errorf("got %s: %s, want %s: %s", status.Step, status.Message, rabbitmqv1alpha1.ScalingDraining, message)
+			}
+			if *st.ss.Spec.Replicas != 3 {
+				t.Errorf("StatefulSet shrunk")
+			}
+		})
+	}
+}
+
+func TestScaleDownCancelled(t *testing.T) {
+	tests := []struct {
+		name string
+		// failing is the command which fails in the first reconciliation
+		failing  func(nodes []string) string
+		step     rabbitmqv1alpha1.ScalingStep
+		commands func(nodes []string) []string
+	}{
+		{
+			name: "application stopped",
+			failing: func(nodes []string) string {
+				return "rmq-2: rabbitmqctl stop_app"
+			},
+			step: rabbitmqv1alpha1.ScalingStoppingApp,
+			commands: func(nodes []string) []string {
+				return []string{"rmq-2: rabbitmqctl start_app", "rmq-2: rabbitmq-upgrade revive"}
+			},
+		},
+		{
+			name: "node forgotten",
+			failing: func(nodes []string) string {
+				return "rmq-0: rabbitmqctl forget_cluster_node " + nodes[2]
+			},
+			step: rabbitmqv1alpha1.ScalingForgettingNode,
+			commands: func(nodes []string) []string {
+				return []string{
+					"rmq-0: rabbitmqctl forget_cluster_node " + nodes[2],
+					"rmq-2: rabbitmqctl force_reset",
+					"rmq-2: rabbitmqctl join_cluster " + nodes[0],
+					"rmq-2: rabbitmqctl start_app",
+					"rmq-2: rabbitmq-upgrade revive",
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := newScaleTest(t, 2)
+			st.executor.errors = map[string]error{tt.failing(st.nodes): errors.New("timeout")}
+			if _, err := st.scaleDown(t, nil); err == nil {
+				t.Fatalf("no error")
+			}
+			if step := st.instance.Status.Scaling.Step; step != tt.step {
+				t.Fatalf("got step %s, want %s", step, tt.step)
+			}
+
+			// spec.replicas is raised back while the node is out of the cluster
+			st.executor.errors = nil
+			st.instance.Spec.Replicas = 3
+			scaling, err := st.scaleDown(t, nil)
+			if err != nil || !scaling {
+				t.Fatalf("got scaling %v, %v", scaling, err)
+			}
+			if want := tt.commands(st.nodes); !reflect.DeepEqual(st.executor.commands, want) {
+				t.Errorf("got commands %v, want %v", st.executor.commands, want)
+			}
+			if scaling, err := st.scaleDown(t, nil); err != nil || scaling {
+				t.Errorf("got scaling %v, %v after the node has joined again", scaling, err)
+			}
+			if st.instance.Status.Scaling != nil {
+				t.Errorf("scaling status kept: %+v", st.instance.Status.Scaling)
+			}
+			if *st.ss.Spec.Replicas != 3 || !st.claimExists(t, 2) {
+				t.Errorf("node removed")
+			}
+		})
+	}
+}
+
+func TestScaleDownCancelledBeforeTheNodeLeaves(t *testing.T) {
+	st := newScaleTest(t, 2)
+	queues := []management.Queue{{Name: "q", Vhost: "/", Durable: true, Node: st.nodes[2]}}
+	if _, err := st.scaleDown(t, queues); err != nil {
+		t.Fatal(err)
+	}
+	st.instance.Spec.Replicas = 3
+	if scaling, err := st.scaleDown(t, nil); err != nil || scaling {
+		t.Errorf("got scaling %v, %v", scaling, err)
+	}
+	if len(st.executor.commands) != 0 {
+		t.Errorf("got commands %v", st.executor.commands)
+	}
+	if st.instance.Status.Scaling != nil {
+		t.Errorf("scaling status kept: %+v", st.instance.Status.Scaling)
+	}
+}
+
+func TestScaleDownRetriesTheVolumeDeletion(t *testing.T) {
+	st := newScaleTest(t, 2)
+	c := st.r.client
+	st.r.client = failingDeleteClient{Client: c, err: errors.New("timeout")}
+	if _, err := st.scaleDown(t, nil); err == nil {
+		t.Fatalf("no error")
+	}
+	if step := st.instance.Status.Scaling.Step; step != rabbitmqv1alpha1.ScalingShrinking {
+		t.Fatalf("got step %s, want %s", step, rabbitmqv1alpha1.ScalingShrinking)
+	}
+
+	// the StatefulSet has shrunk, the deletion is retried even though spec.replicas is raised
+	st.r.client = c
+	st.instance.Spec.Replicas = 3
+	st.pods = st.pods[:2]
+	scaling, err := st.scaleDown(t, nil)
+	if err != nil || !scaling {
+		t.Fatalf("got scaling %v, %v", scaling, err)
+	}
+	if st.claimExists(t, 2) {
+		t.Errorf("data volume of the removed node kept")
+	}
+	if len(st.executor.commands) != 0 {
+		t.Errorf("got commands %v", st.executor.commands)
+	}
+	if scaling, err := st.scaleDown(t, nil); err != nil || scaling {
+		t.Errorf("got scaling %v, %v after the volume has been deleted", scaling, err)
+	}
+}
+
+func TestScaleDownToLowerTargetKeepsTheStep(t *testing.T) {
+	st := newScaleTest(t, 2)
+	st.executor.errors = map[string]error{"rmq-2: rabbitmqctl stop_app": errors.New("timeout")}
+	if _, err := st.scaleDown(t, nil); err == nil {
+		t.Fatalf("no error")
+	}
+
+	st.executor.errors = nil
+	st.instance.Spec.Replicas = 1
+	if _, err := st.scaleDown(t, nil); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"rmq-2: rabbitmqctl stop_app",
+		"rmq-0: rabbitmqctl forget_cluster_node " + st.nodes[2],
+	}
+	if !reflect.DeepEqual(st.executor.commands, want) {
+		t.Errorf("got commands %v, want %v", st.executor.commands, want)
+	}
+	if status := st.instance.Status.Scaling; status.From != 3 || status.To != 1 {
+		t.Errorf("got scaling from %d to %d, want from 3 to 1", status.From, status.To)
+	}
+}
@@ -0,0 +1,49 @@
+package rabbitmq
+
+import (
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	hostnameTopologyKey = "kubernetes.io/hostname"
+	// zoneTopologyKey is the zone label of the nodes in the Kubernetes versions the operator supports
+	zoneTopologyKey = "failure-domain.beta.kubernetes.io/zone"
+)
+
+// podAffinity returns the affinity of the pods: the affinity of the spec with the default pod
+// anti-affinity added unless the spec sets one. The members of the cluster prefer other nodes
+// and, with a lower weight, other zones than the members already running, so losing a node or
+// a zone takes down as few of them as possible. Topology spread constraints are not available
+// in the Kubernetes versions the operator supports.
+func podAffinity(cr *rabbitmqv1alpha1.RabbitMQ) *corev1.Affinity {
+	affinity := &corev1.Affinity{}
+	if cr.Spec.Affinity != nil {
+		affinity = cr.Spec.Affinity.DeepCopy()
+	}
+	if affinity.PodAntiAffinity != nil {
+		return affinity
+	}
+
+	members := &metav1.LabelSelector{MatchLabels: selectorForRabbitMQ(cr)}
+	affinity.PodAntiAffinity = &corev1.PodAntiAffinity{
+		PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{
+			{
+				Weight: 100,
+				PodAffinityTerm: corev1.PodAffinityTerm{
+					LabelSelector: members,
+					TopologyKey:   hostnameTopologyKey,
+				},
+			},
+			{
+				Weight: 50,
+				PodAffinityTerm: corev1.PodAffinityTerm{
+					LabelSelector: members,
+					TopologyKey:   zoneTopologyKey,
+				},
+			},
+		},
+	}
+	return affinity
+}
@@ -0,0 +1,112 @@
+package rabbitmq
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPodAffinity(t *testing.T) {
+	nodeAffinity := &corev1.NodeAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+			NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+				MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "disk", Operator: corev1.NodeSelectorOpIn, Values: []string{"ssd"}}},
+			}},
+		},
+	}
+	ownAntiAffinity := &corev1.PodAntiAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{{
+			LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "other"}},
+			TopologyKey:   hostnameTopologyKey,
+		}},
+	}
+	tests := []struct {
+		name     string
+		affinity *corev1.Affinity
+		// wantTopologyKeys are the topology keys of the default anti-affinity, nil when it isn't added
+		wantTopologyKeys []string
+		wantNodeAffinity *corev1.NodeAffinity
+		wantAntiAffinity *corev1.PodAntiAffinity
+	}{
+		{
+			name:             "default",
+			wantTopologyKeys: []string{hostnameTopologyKey, zoneTopologyKey},
+		},
+		{
+			name:             "node affinity",
+			affinity:         &corev1.Affinity{NodeAffinity: nodeAffinity},
+			wantTopologyKeys: []string{hostnameTopologyKey, zoneTopologyKey},
+			wantNodeAffinity: nodeAffinity,
+		},
+		{
+			name:             "own anti-affinity",
+			affinity:         &corev1.Affinity{PodAntiAffinity: ownAntiAffinity},
+			wantAntiAffinity: ownAntiAffinity,
+		},
+		{
+			name:             "anti-affinity turned off",
+			affinity:         &corev1.Affinity{PodAntiAffinity: &corev1.PodAntiAffinity{}},
+			wantAntiAffinity: &corev1.PodAntiAffinity{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := newTestCluster()
+			cr.Spec.Affinity = tt.affinity
+			affinity := podAffinity(cr)
+			if !reflect.DeepEqual(affinity.NodeAffinity, tt.wantNodeAffinity) {
+				t.Errorf("got node affinity %+v, want %+v", affinity.NodeAffinity, tt.wantNodeAffinity)
+			}
+			if tt.wantTopologyKeys == nil {
+				if !reflect.DeepEqual(affinity.PodAntiAffinity, tt.wantAntiAffinity) {
+					t.Errorf("got anti-affinity %+v, want %+v", affinity.PodAntiAffinity, tt.wantAntiAffinity)
+				}
+				return
+			}
+			keys := []string{}
+			for _, term := range affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution {
+				if !reflect.DeepEqual(term.PodAffinityTerm.LabelSelector.MatchLabels, selectorForRabbitMQ(cr)) {
+					t.Errorf("got selector %v, want the members of the cluster", term.PodAffinityTerm.LabelSelector)
+				}
+				keys = append(keys, term.PodAffinityTerm.TopologyKey)
+			}
+			if !reflect.DeepEqual(keys, tt.wantTopologyKeys) {
+				t.Errorf("got topology keys %v, want %v", keys, tt.wantTopologyKeys)
+			}
+			if affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution != nil {
+				t.Errorf("the default anti-affinity is required")
+			}
+		})
+	}
+}
+
+func TestPodAffinityKeepsTheSpec(t *testing.T) {
+	cr := newTestCluster()
+	cr.Spec.Affinity = &corev1.Affinity{}
+	podAffinity(cr)
+	if cr.Spec.Affinity.PodAntiAffinity != nil {
+		t.Errorf("the default anti-affinity is added to the spec")
+	}
+}
+
+func TestSchedulingSettings(t *testing.T) {
+	cr := newTestCluster()
+	cr.Spec.NodeSelector = map[string]string{"role": "messaging"}
+	cr.Spec.Tolerations = []corev1.Toleration{{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "messaging", Effect: corev1.TaintEffectNoSchedule}}
+	cr.Spec.PriorityClassName = "critical"
+	spec := newStatefulSet(cr, nil).Spec.Template.Spec
+	if !reflect.DeepEqual(spec.NodeSelector, cr.Spec.NodeSelector) {
+		t.Errorf("got node selector %v, want %v", spec.NodeSelector, cr.Spec.NodeSelector)
+	}
+	if !reflect.DeepEqual(spec.Tolerations, cr.Spec.Tolerations) {
+		t.Errorf("got tolerations %v, want %v", spec.Tolerations, cr.Spec.Tolerations)
+	}
+	if spec.PriorityClassName != "critical" {
+		t.Errorf("got priority class %q", spec.PriorityClassName)
+	}
+	if spec.Affinity == nil || spec.Affinity.PodAntiAffinity == nil {
+		t.Errorf("no default anti-affinity")
+	}
+}
@@ -0,0 +1,64 @@
+package rabbitmq
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/go-logr/logr"
+	v1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// keepImmutableSelector preserves the selector of a StatefulSet created by the older versions of
+// the operator, which selected the pods by the bare "app: rabbitmq" label. The selector of a
+// StatefulSet can't be changed, so the desired StatefulSet keeps it and its pod template keeps the
+// selected labels. The running pods get the new labels right away, so the Services selecting them
+// by the instance labels don't lose their endpoints until the pods are restarted.
+func (r *ReconcileRabbitMQ) keepImmutableSelector(reqLogger logr.Logger, ss *v1.StatefulSet) error {
+	found := &v1.StatefulSet{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: ss.Name, Namespace: ss.Namespace}, found)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if found.Spec.Selector == nil || reflect.DeepEqual(found.Spec.Selector, ss.Spec.Selector) {
+		return nil
+	}
+
+	ss.Spec.Selector = found.Spec.Selector.DeepCopy()
+	for k, v := range found.Spec.Selector.MatchLabels {
+		ss.Spec.Template.Labels[k] = v
+	}
+
+	pods, err := r.statefulSetPods(found)
+	if err != nil {
+		return err
+	}
+	for i := range pods {
+		pod := &pods[i]
+		missing := false
+		for k, v := range ss.Spec.Template.Labels {
+			if pod.Labels[k] != v {
+				missing = true
+				break
+			}
+		}
+		if !missing {
+			continue
+		}
+		if pod.Labels == nil {
+			pod.Labels = map[string]string{}
+		}
+		for k, v := range ss.Spec.Template.Labels {
+			pod.Labels[k] = v
+		}
+		reqLogger.Info("Labeling the pod of a StatefulSet with a legacy selector", "Pod.Namespace", pod.Namespace, "Pod.Name", pod.Name)
+		if err := r.client.Update(context.TODO(), pod); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package rabbitmq
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+func TestKeepImmutableSelector(t *testing.T) {
+	legacy := map[string]string{"app": "rabbitmq"}
+
+	t.Run("new StatefulSet", func(t *testing.T) {
+		ss := newTestStatefulSet(3, nil)
+		want := ss.Spec.Selector.DeepCopy()
+		r := newTestReconciler()
+		if err := r.keepImmutableSelector(logf.Log, ss); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(ss.Spec.Selector, want) {
+			t.Errorf("selector changed to %v", ss.Spec.Selector)
+		}
+	})
+
+	t.Run("legacy selector", func(t *testing.T) {
+		found := newTestStatefulSet(2, nil)
+		found.Spec.Selector = &metav1.LabelSelector{MatchLabels: legacy}
+		found.Spec.Template.Labels = legacy
+		pods := newTestPods(found, nil)
+		r := newTestReconciler(append(pods, found)...)
+
+		ss := newTestStatefulSet(2, nil)
+		if err := r.keepImmutableSelector(logf.Log, ss); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(ss.Spec.Selector.MatchLabels, legacy) {
+			t.Errorf("got selector %v, want %v", ss.Spec.Selector.MatchLabels, legacy)
+		}
+		for k, v := range legacy {
+			if ss.Spec.Template.Labels[k] != v {
+				t.Errorf("pod template lost the selected label %s", k)
+			}
+		}
+
+		relabeled, err := r.statefulSetPods(found)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(relabeled) != 2 {
+			t.Fatalf("got %d pods, want 2", len(relabeled))
+		}
+		for _, pod := range relabeled {
+			if !hasLabels(&pod, selectorForRabbitMQ(newTestCluster())) {
+				t.Errorf("pod %s has not got the instance labels: %v", pod.Name, pod.Labels)
+			}
+		}
+	})
+}
+
+func hasLabels(pod *corev1.Pod, labels map[string]string) bool {
+	for k, v := range labels {
+		if pod.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
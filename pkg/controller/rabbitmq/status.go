@@ -0,0 +1,171 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// updateStatus observes the owned StatefulSet and its pods and writes the result together with
+// the outcome of the reconciliation into the RabbitMQ status subresource, if it differs from the
+// previous status. The progress of the operations is already recorded in the status of cr.
+func (r *ReconcileRabbitMQ) updateStatus(cr *rabbitmqv1alpha1.RabbitMQ, previous *rabbitmqv1alpha1.RabbitMQStatus, ss *v1.StatefulSet, reconcileErr error) error {
+	status := cr.Status.DeepCopy()
+	status.ObservedGeneration = cr.Generation
+
+	if ss != nil {
+		status.Replicas = 0
+		if ss.Spec.Replicas != nil {
+			status.Replicas = *ss.Spec.Replicas
+		}
+		status.ReadyReplicas = ss.Status.ReadyReplicas
+		status.Image = statefulSetImage(ss)
+		status.Version = ""
+		if v, ok := imageVersion(status.Image); ok {
+			status.Version = v.String()
+		}
+
+		nodes, err := r.clusterNodes(ss)
+		if err != nil {
+			return err
+		}
+		status.Nodes = nodes
+	}
+
+	setCondition(status, availableCondition(status))
+	setCondition(status, progressingCondition(ss))
+	setCondition(status, degradedCondition(status))
+	setCondition(status, reconcileErrorCondition(reconcileErr))
+	status.Phase = clusterPhase(status, ss, reconcileErr)
+	clusterMetrics.observe(cr, status)
+
+	if reflect.DeepEqual(previous, status) {
+		return nil
+	}
+	cr.Status = *status
+	return r.client.Status().Update(context.TODO(), cr)
+}
+
+// clusterNodes returns the sorted RabbitMQ node names of the pods controlled by the StatefulSet
+func (r *ReconcileRabbitMQ) clusterNodes(ss *v1.StatefulSet) ([]string, error) {
+	pods, err := r.statefulSetPods(ss)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := []string{}
+	for i := range pods {
+		if node := nodeNameForPod(&pods[i]); node != "" {
+			nodes = append(nodes, node)
+		}
+	}
+	sort.Strings(nodes)
+	return nodes, nil
+}
+
+// statefulSetImage returns the image of the rabbitmq container of the StatefulSet
+func statefulSetImage(ss *v1.StatefulSet) string {
+	for _, c := range ss.Spec.Template.Spec.Containers {
+		if c.Name == "rabbitmq" {
+			return c.Image
+		}
+	}
+	return ""
+}
+
+// clusterPhase summarizes the status in a single word for the printer column, the operations
+// in progress take precedence over the readiness of the members they cause
+func clusterPhase(status *rabbitmqv1alpha1.RabbitMQStatus, ss *v1.StatefulSet, reconcileErr error) rabbitmqv1alpha1.RabbitMQPhase {
+	upgrade := status.Upgrade
+	switch {
+	case reconcileErr != nil:
+		return rabbitmqv1alpha1.RabbitMQFailed
+	case ss == nil || status.ReadyReplicas == 0:
+		return rabbitmqv1alpha1.RabbitMQPending
+	case upgrade != nil && (upgrade.Phase == rabbitmqv1alpha1.UpgradeRollingOut || upgrade.Phase == rabbitmqv1alpha1.UpgradeEnablingFeatureFlags):
+		return rabbitmqv1alpha1.RabbitMQUpgrading
+	case status.Scaling != nil || ss.Status.Replicas != status.Replicas:
+		return rabbitmqv1alpha1.RabbitMQScaling
+	case status.ReadyReplicas < status.Replicas:
+		return rabbitmqv1alpha1.RabbitMQDegradedPhase
+	default:
+		return rabbitmqv1alpha1.RabbitMQRunning
+	}
+}
+
+func availableCondition(status *rabbitmqv1alpha1.RabbitMQStatus) rabbitmqv1alpha1.RabbitMQCondition {
+	quorum := status.Replicas/2 + 1
+	switch {
+	case status.Replicas == 0:
+		return newCondition(rabbitmqv1alpha1.RabbitMQAvailable, corev1.ConditionFalse,
+			"NoReplicas", "Cluster has no desired members")
+	case status.ReadyReplicas >= quorum:
+		return newCondition(rabbitmqv1alpha1.RabbitMQAvailable, corev1.ConditionTrue,
+			"QuorumReady", fmt.Sprintf("%d of %d members are ready", status.ReadyReplicas, status.Replicas))
+	default:
+		return newCondition(rabbitmqv1alpha1.RabbitMQAvailable, corev1.ConditionFalse,
+			"QuorumNotReady", fmt.Sprintf("%d of %d members are ready, %d required", status.ReadyReplicas, status.Replicas, quorum))
+	}
+}
+
+func progressingCondition(ss *v1.StatefulSet) rabbitmqv1alpha1.RabbitMQCondition {
+	if ss == nil {
+		return newCondition(rabbitmqv1alpha1.RabbitMQProgressing, corev1.ConditionTrue,
+			"Creating", "StatefulSet is being created")
+	}
+	replicas := int32(0)
+	if ss.Spec.Replicas != nil {
+		replicas = *ss.Spec.Replicas
+	}
+	switch {
+	case ss.Status.ObservedGeneration < ss.Generation:
+		return newCondition(rabbitmqv1alpha1.RabbitMQProgressing, corev1.ConditionTrue,
+			"StatefulSetUpdating", "StatefulSet update has not been observed yet")
+	case ss.Status.Replicas != replicas:
+		return newCondition(rabbitmqv1alpha1.RabbitMQProgressing, corev1.ConditionTrue,
+			"Scaling", fmt.Sprintf("Scaling from %d to %d members", ss.Status.Replicas, replicas))
+	case ss.Status.UpdateRevision != "" && ss.Status.CurrentRevision != ss.Status.UpdateRevision:
+		return newCondition(rabbitmqv1alpha1.RabbitMQProgressing, corev1.ConditionTrue,
+			"RollingOut", fmt.Sprintf("%d of %d members are updated", ss.Status.UpdatedReplicas, replicas))
+	default:
+		return newCondition(rabbitmqv1alpha1.RabbitMQProgressing, corev1.ConditionFalse,
+			"Stable", "StatefulSet is up to date")
+	}
+}
+
+func degradedCondition(status *rabbitmqv1alpha1.RabbitMQStatus) rabbitmqv1alpha1.RabbitMQCondition {
+	if status.ReadyReplicas < status.Replicas {
+		return newCondition(rabbitmqv1alpha1.RabbitMQDegraded, corev1.ConditionTrue,
+			"MembersNotReady", fmt.Sprintf("%d of %d members are not ready", status.Replicas-status.ReadyReplicas, status.Replicas))
+	}
+	return newCondition(rabbitmqv1alpha1.RabbitMQDegraded, corev1.ConditionFalse,
+		"AllMembersReady", "All members are ready")
+}
+
+func reconcileErrorCondition(err error) rabbitmqv1alpha1.RabbitMQCondition {
+	if err != nil {
+		return newCondition(rabbitmqv1alpha1.RabbitMQReconcileError, corev1.ConditionTrue,
+			"ReconcileFailed", err.Error())
+	}
+	return newCondition(rabbitmqv1alpha1.RabbitMQReconcileError, corev1.ConditionFalse,
+		"ReconcileSucceeded", "")
+}
+
+func newCondition(t rabbitmqv1alpha1.RabbitMQConditionType, status corev1.ConditionStatus, reason, message string) rabbitmqv1alpha1.RabbitMQCondition {
+	return rabbitmqv1alpha1.RabbitMQCondition{
+		Type:    t,
+		Status:  status,
+		Reason:  reason,
+		Message: message,
+	}
+}
+
+// setCondition adds or replaces the condition of the same type in the status
+func setCondition(status *rabbitmqv1alpha1.RabbitMQStatus, condition rabbitmqv1alpha1.RabbitMQCondition) {
+	status.Conditions = rabbitmqv1alpha1.SetCondition(status.Conditions, condition)
+}
@@ -0,0 +1,140 @@
+package rabbitmq
+
+import (
+	"errors"
+	"testing"
+
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestAvailableCondition(t *testing.T) {
+	tests := []struct {
+		name          string
+		replicas      int32
+		readyReplicas int32
+		status        corev1.ConditionStatus
+		reason        string
+	}{
+		{"no replicas", 0, 0, corev1.ConditionFalse, "NoReplicas"},
+		{"single node ready", 1, 1, corev1.ConditionTrue, "QuorumReady"},
+		{"single node not ready", 1, 0, corev1.ConditionFalse, "QuorumNotReady"},
+		{"quorum of three", 3, 2, corev1.ConditionTrue, "QuorumReady"},
+		{"minority of three", 3, 1, corev1.ConditionFalse, "QuorumNotReady"},
+		{"half of four", 4, 2, corev1.ConditionFalse, "QuorumNotReady"},
+		{"quorum of four", 4, 3, corev1.ConditionTrue, "QuorumReady"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := &rabbitmqv1alpha1.RabbitMQStatus{Replicas: tt.replicas, ReadyReplicas: tt.readyReplicas}
+			c := availableCondition(status)
+			if c.Status != tt.status || c.Reason != tt.reason {
+				t.Errorf("got %s/%s, want %s/%s", c.Status, c.Reason, tt.status, tt.reason)
+			}
+		})
+	}
+}
+
+func TestDegradedCondition(t *testing.T) {
+	if c := degradedCondition(&rabbitmqv1alpha1.RabbitMQStatus{Replicas: 3, ReadyReplicas: 3}); c.Status != corev1.ConditionFalse {
+		t.Errorf("all members ready: got %s, want %s", c.Status, corev1.ConditionFalse)
+	}
+	if c := degradedCondition(&rabbitmqv1alpha1.RabbitMQStatus{Replicas: 3, ReadyReplicas: 2}); c.Status != corev1.ConditionTrue {
+		t.Errorf("member not ready: got %s, want %s", c.Status, corev1.ConditionTrue)
+	}
+}
+
+func TestProgressingCondition(t *testing.T) {
+	replicas := int32(3)
+	tests := []struct {
+		name   string
+		ss     *v1.StatefulSet
+		reason string
+	}{
+		{"creating", nil, "Creating"},
+		{"generation not observed", &v1.StatefulSet{
+			Spec:   v1.StatefulSetSpec{Replicas: &replicas},
+			Status: v1.StatefulSetStatus{ObservedGeneration: 1, Replicas: 3},
+		}, "StatefulSetUpdating"},
+		{"scaling", &v1.StatefulSet{
+			Spec:   v1.StatefulSetSpec{Replicas: &replicas},
+			Status: v1.StatefulSetStatus{Replicas: 2},
+		}, "Scaling"},
+		{"rolling out", &v1.StatefulSet{
+			Spec:   v1.StatefulSetSpec{Replicas: &replicas},
+			Status: v1.StatefulSetStatus{Replicas: 3, CurrentRevision: "a", UpdateRevision: "b"},
+		}, "RollingOut"},
+		{"stable", &v1.StatefulSet{
+			Spec:   v1.StatefulSetSpec{Replicas: &replicas},
+			Status: v1.StatefulSetStatus{Replicas: 3, CurrentRevision: "a", UpdateRevision: "a"},
+		}, "Stable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.ss != nil {
+				tt.ss.Generation = 2
+				if tt.ss.Status.ObservedGeneration == 0 {
+					tt.ss.Status.ObservedGeneration = 2
+				}
+			}
+			if c := progressingCondition(tt.ss); c.Reason != tt.reason {
+				t.Errorf("got %s, want %s", c.Reason, tt.reason)
+			}
+		})
+	}
+}
+
+func TestClusterPhase(t *testing.T) {
+	ss := &v1.StatefulSet{Status: v1.StatefulSetStatus{Replicas: 3}}
+	tests := []struct {
+		name   string
+		status rabbitmqv1alpha1.RabbitMQStatus
+		ss     *v1.StatefulSet
+		err    error
+		phase  rabbitmqv1alpha1.RabbitMQPhase
+	}{
+		{"failed", rabbitmqv1alpha1.RabbitMQStatus{Replicas: 3, ReadyReplicas: 3}, ss, errors.New("boom"), rabbitmqv1alpha1.RabbitMQFailed},
+		{"no StatefulSet", rabbitmqv1alpha1.RabbitMQStatus{}, nil, nil, rabbitmqv1alpha1.RabbitMQPending},
+		{"nothing ready", rabbitmqv1alpha1.RabbitMQStatus{Replicas: 3}, ss, nil, rabbitmqv1alpha1.RabbitMQPending},
+		{"upgrading", rabbitmqv1alpha1.RabbitMQStatus{
+			Replicas: 3, ReadyReplicas: 2,
+			Upgrade: &rabbitmqv1alpha1.UpgradeStatus{Phase: rabbitmqv1alpha1.UpgradeRollingOut},
+		}, ss, nil, rabbitmqv1alpha1.RabbitMQUpgrading},
+		{"upgrade refused", rabbitmqv1alpha1.RabbitMQStatus{
+			Replicas: 3, ReadyReplicas: 3,
+			Upgrade: &rabbitmqv1alpha1.UpgradeStatus{Phase: rabbitmqv1alpha1.UpgradeRefused},
+		}, ss, nil, rabbitmqv1alpha1.RabbitMQRunning},
+		{"scaling down", rabbitmqv1alpha1.RabbitMQStatus{
+			Replicas: 3, ReadyReplicas: 3,
+			Scaling: &rabbitmqv1alpha1.ScalingStatus{Step: rabbitmqv1alpha1.ScalingDraining},
+		}, ss, nil, rabbitmqv1alpha1.RabbitMQScaling},
+		{"scaling up", rabbitmqv1alpha1.RabbitMQStatus{Replicas: 5, ReadyReplicas: 3}, ss, nil, rabbitmqv1alpha1.RabbitMQScaling},
+		{"degraded", rabbitmqv1alpha1.RabbitMQStatus{Replicas: 3, ReadyReplicas: 2}, ss, nil, rabbitmqv1alpha1.RabbitMQDegradedPhase},
+		{"running", rabbitmqv1alpha1.RabbitMQStatus{Replicas: 3, ReadyReplicas: 3}, ss, nil, rabbitmqv1alpha1.RabbitMQRunning},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if phase := clusterPhase(&tt.status, tt.ss, tt.err); phase != tt.phase {
+				t.Errorf("got %s, want %s", phase, tt.phase)
+			}
+		})
+	}
+}
+
+func TestSetConditionKeepsTransitionTime(t *testing.T) {
+	status := &rabbitmqv1alpha1.RabbitMQStatus{}
+	setCondition(status, newCondition(rabbitmqv1alpha1.RabbitMQDegraded, corev1.ConditionFalse, "AllMembersReady", ""))
+	first := status.Conditions[0].LastTransitionTime
+
+	setCondition(status, newCondition(rabbitmqv1alpha1.RabbitMQDegraded, corev1.ConditionFalse, "AllMembersReady", "again"))
+	if len(status.Conditions) != 1 {
+		t.Fatalf("got %d conditions, want 1", len(status.Conditions))
+	}
+	if !status.Conditions[0].LastTransitionTime.Equal(&first) {
+		t.Errorf("transition time moved without a change of the status")
+	}
+	if status.Conditions[0].Message != "again" {
+		t.Errorf("condition has not been replaced")
+	}
+}
@@ -0,0 +1,343 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/go-logr/logr"
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// dataVolumeClaimTemplate returns the volume claim template of the data volumes
+func dataVolumeClaimTemplate(cr *rabbitmqv1alpha1.RabbitMQ) corev1.PersistentVolumeClaim {
+	pvc := corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: dataVolumeName,
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{
+				corev1.ReadWriteOnce,
+			},
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceStorage: cr.Spec.DataVolumeSize,
+				},
+			},
+		},
+	}
+	if storage := cr.Spec.Storage; storage != nil {
+		pvc.Labels = storage.Labels
+		pvc.Annotations = storage.Annotations
+		if storage.StorageClassName != "" {
+			pvc.Spec.StorageClassName = &storage.StorageClassName
+		}
+		if len(storage.AccessModes) > 0 {
+			pvc.Spec.AccessModes = storage.AccessModes
+		}
+	}
+	return pvc
+}
+
+// statefulSetDataVolumeClaimTemplate returns the volume claim template of the data volumes of the
+// StatefulSet, nil if it has none
+func statefulSetDataVolumeClaimTemplate(ss *v1.StatefulSet) *corev1.PersistentVolumeClaim {
+	for i := range ss.Spec.VolumeClaimTemplates {
+		if ss.Spec.VolumeClaimTemplates[i].Name == dataVolumeName {
+			return &ss.Spec.VolumeClaimTemplates[i]
+		}
+	}
+	return nil
+}
+
+// claimTemplateMatches reports whether the live volume claim template has the desired metadata and spec
+func claimTemplateMatches(live, desired *corev1.PersistentVolumeClaim) bool {
+	liveSize := live.Spec.Resources.Requests[corev1.ResourceStorage]
+	desiredSize := desired.Spec.Resources.Requests[corev1.ResourceStorage]
+	return liveSize.Cmp(desiredSize) == 0 &&
+		storageClassOf(live) == storageClassOf(desired) &&
+		reflect.DeepEqual(live.Spec.AccessModes, desired.Spec.AccessModes) &&
+		mapsEqual(live.Labels, desired.Labels) &&
+		mapsEqual(live.Annotations, desired.Annotations)
+}
+
+// mapsEqual compares the maps treating nil and empty maps as equal
+func mapsEqual(a, b map[string]string) bool {
+	return len(a) == 0 && len(b) == 0 || reflect.DeepEqual(a, b)
+}
+
+// storageClassOf returns the storage class name of the claim, empty for the default storage class
+func storageClassOf(pvc *corev1.PersistentVolumeClaim) string {
+	if pvc.Spec.StorageClassName == nil {
+		return ""
+	}
+	return *pvc.Spec.StorageClassName
+}
+
+// dataVolumeClaims returns the existing PersistentVolumeClaims of the data volumes of the StatefulSet pods
+func (r *ReconcileRabbitMQ) dataVolumeClaims(ss *v1.StatefulSet) ([]corev1.PersistentVolumeClaim, error) {
+	replicas := int32(0)
+	if ss.Spec.Replicas != nil {
+		replicas = *ss.Spec.Replicas
+	}
+	pvcs := []corev1.PersistentVolumeClaim{}
+	for ordinal := int32(0); ordinal < replicas; ordinal++ {
+		pvc := corev1.PersistentVolumeClaim{}
+		err := r.client.Get(context.TODO(), types.NamespacedName{Name: dataVolumeClaimName(ss, ordinal), Namespace: ss.Namespace}, &pvc)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		pvcs = append(pvcs, pvc)
+	}
+	return pvcs, nil
+}
+
+// orphanedStatefulSet returns a stand-in for the StatefulSet of the cluster built from the pods it has
+// orphaned, nil if there are none. While the StatefulSet is recreated, its pods keep running on their own
+// and the settings the spec is resolved against, e.g. the address type, the image and the number of
+// members, are taken from them.
+func (r *ReconcileRabbitMQ) orphanedStatefulSet(cr *rabbitmqv1alpha1.RabbitMQ) (*v1.StatefulSet, error) {
+	pods := &corev1.PodList{}
+	opts := client.InNamespace(cr.Namespace).MatchingLabels(selectorForRabbitMQ(cr))
+	if err := r.client.List(context.TODO(), opts, pods); err != nil {
+		return nil, err
+	}
+
+	var first *corev1.Pod
+	replicas := int32(0)
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		ordinal := podOrdinal(pod)
+		if metav1.GetControllerOf(pod) != nil || ordinal < 0 {
+			continue
+		}
+		if first == nil || ordinal < podOrdinal(first) {
+			first = pod
+		}
+		if int32(ordinal) >= replicas {
+			replicas = int32(ordinal) + 1
+		}
+	}
+	if first == nil {
+		return nil, nil
+	}
+	return &v1.StatefulSet{
+		Spec: v1.StatefulSetSpec{
+			Replicas: &replicas,
+			Template: corev1.PodTemplateSpec{
+				Spec: *first.Spec.DeepCopy(),
+			},
+		},
+	}, nil
+}
+
+// resolveStorage keeps the data volume settings of the live StatefulSet which can't be applied to the
+// existing volumes: they can't shrink or change their storage class or access modes, and they grow only
+// if their storage classes allow the expansion. A refused change is recorded in the status of the
+// instance and leaves the volumes alone.
+func (r *ReconcileRabbitMQ) resolveStorage(instance, cr *rabbitmqv1alpha1.RabbitMQ, live *v1.StatefulSet) error {
+	if live == nil {
+		return nil
+	}
+	template := statefulSetDataVolumeClaimTemplate(live)
+	if template == nil {
+		return nil
+	}
+	desired := dataVolumeClaimTemplate(cr)
+
+	refused := []string{}
+	if class := storageClassOf(template); storageClassOf(&desired) != class {
+		refused = append(refused, fmt.Sprintf("the storage class of the data volumes can't be changed from %q to %q", class, storageClassOf(&desired)))
+	}
+	if !reflect.DeepEqual(desired.Spec.AccessModes, template.Spec.AccessModes) {
+		refused = append(refused, fmt.Sprintf("the access modes of the data volumes can't be changed from %v to %v", template.Spec.AccessModes, desired.Spec.AccessModes))
+	}
+
+	current := template.Spec.Resources.Requests[corev1.ResourceStorage]
+	switch cr.Spec.DataVolumeSize.Cmp(current) {
+	case -1:
+		refused = append(refused, fmt.Sprintf("the data volumes can't shrink from %s to %s", current.String(), cr.Spec.DataVolumeSize.String()))
+	case 1:
+		reason, err := r.checkVolumeExpansion(live, cr.Spec.DataVolumeSize)
+		if err != nil {
+			return err
+		}
+		if reason != "" {
+			refused = append(refused, reason)
+		}
+	}
+
+	if len(refused) == 0 {
+		if instance.Status.Storage != nil && instance.Status.Storage.Phase == rabbitmqv1alpha1.StorageRefused {
+			instance.Status.Storage = nil
+		}
+		return nil
+	}
+	instance.Status.Storage = &rabbitmqv1alpha1.StorageStatus{
+		Size:    cr.Spec.DataVolumeSize.String(),
+		Phase:   rabbitmqv1alpha1.StorageRefused,
+		Message: strings.Join(refused, ", "),
+	}
+	// the StatefulSet keeps its volume claim template as it is
+	cr.Spec.DataVolumeSize = current.DeepCopy()
+	if cr.Spec.Storage == nil {
+		cr.Spec.Storage = &rabbitmqv1alpha1.StorageSpec{}
+	}
+	cr.Spec.Storage.StorageClassName = storageClassOf(template)
+	cr.Spec.Storage.AccessModes = template.Spec.AccessModes
+	cr.Spec.Storage.Labels = template.Labels
+	cr.Spec.Storage.Annotations = template.Annotations
+	return nil
+}
+
+// checkVolumeExpansion returns the reason the data volumes of the StatefulSet can't grow to the size,
+// or an empty string. The storage class of every volume to expand has to allow the expansion.
+func (r *ReconcileRabbitMQ) checkVolumeExpansion(ss *v1.StatefulSet, size resource.Quantity) (string, error) {
+	pvcs, err := r.dataVolumeClaims(ss)
+	if err != nil {
+		return "", err
+	}
+	allowed := map[string]bool{}
+	for i := range pvcs {
+		requested := pvcs[i].Spec.Resources.Requests[corev1.ResourceStorage]
+		if requested.Cmp(size) >= 0 {
+			continue
+		}
+		class := storageClassOf(&pvcs[i])
+		if class == "" {
+			return fmt.Sprintf("the data volume %s has no storage class and can't be expanded", pvcs[i].Name), nil
+		}
+		if _, ok := allowed[class]; !ok {
+			sc := &storagev1.StorageClass{}
+			// storage classes are cluster scoped, they are not in the cache of the watched namespace
+			err := r.apiReader.Get(context.TODO(), types.NamespacedName{Name: class}, sc)
+			if err != nil && !errors.IsNotFound(err) {
+				return "", err
+			}
+			allowed[class] = err == nil && sc.AllowVolumeExpansion != nil && *sc.AllowVolumeExpansion
+		}
+		if !allowed[class] {
+			return fmt.Sprintf("the storage class %s of the data volume %s doesn't allow volume expansion", class, pvcs[i].Name), nil
+		}
+	}
+	return "", nil
+}
+
+// reconcileStorage brings the data volumes of the existing members and the volume claim template of the
+// StatefulSet in line with the spec. The claims are expanded and relabeled in place, then the StatefulSet,
+// whose volume claim templates can't be updated, is deleted with orphan propagation and created again on
+// the next reconciliation. The pods are adopted by the new StatefulSet and keep running. It reports whether
+// the StatefulSet is being recreated.
+func (r *ReconcileRabbitMQ) reconcileStorage(reqLogger logr.Logger, instance *rabbitmqv1alpha1.RabbitMQ, desired, live *v1.StatefulSet) (bool, error) {
+	storage := instance.Status.Storage
+	if live.DeletionTimestamp != nil {
+		// the StatefulSet is created again once it is gone
+		if storage != nil && storage.Phase == rabbitmqv1alpha1.StorageRecreatingStatefulSet {
+			storage.Message = "waiting for the old StatefulSet to be deleted"
+		}
+		return true, nil
+	}
+
+	template := statefulSetDataVolumeClaimTemplate(live)
+	wanted := statefulSetDataVolumeClaimTemplate(desired)
+	if storage != nil && storage.Phase == rabbitmqv1alpha1.StorageRefused || template == nil || wanted == nil {
+		return false, nil
+	}
+	if claimTemplateMatches(template, wanted) {
+		instance.Status.Storage = nil
+		return false, nil
+	}
+
+	size := wanted.Spec.Resources.Requests[corev1.ResourceStorage]
+	if storage == nil || storage.Size != size.String() {
+		storage = &rabbitmqv1alpha1.StorageStatus{Size: size.String()}
+		instance.Status.Storage = storage
+	}
+	storage.Phase = rabbitmqv1alpha1.StorageExpanding
+
+	pvcs, err := r.dataVolumeClaims(live)
+	if err != nil {
+		return false, err
+	}
+	waiting := ""
+	for i := range pvcs {
+		pvc := &pvcs[i]
+		changed := false
+		requested := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+		if requested.Cmp(size) < 0 {
+			if pvc.Spec.Resources.Requests == nil {
+				pvc.Spec.Resources.Requests = corev1.ResourceList{}
+			}
+			pvc.Spec.Resources.Requests[corev1.ResourceStorage] = size
+			changed = true
+		}
+		for k, v := range wanted.Labels {
+			if pvc.Labels[k] != v {
+				if pvc.Labels == nil {
+					pvc.Labels = map[string]string{}
+				}
+				pvc.Labels[k] = v
+				changed = true
+			}
+		}
+		for k, v := range wanted.Annotations {
+			if pvc.Annotations[k] != v {
+				if pvc.Annotations == nil {
+					pvc.Annotations = map[string]string{}
+				}
+				pvc.Annotations[k] = v
+				changed = true
+			}
+		}
+		if changed {
+			reqLogger.Info("Updating the data volume", "PersistentVolumeClaim.Namespace", pvc.Namespace, "PersistentVolumeClaim.Name", pvc.Name, "Size", size.String())
+			if err := r.client.Update(context.TODO(), pvc); err != nil {
+				storage.Message = err.Error()
+				return false, err
+			}
+		}
+		if waiting == "" && !volumeExpanded(pvc, size) {
+			waiting = pvc.Name
+		}
+	}
+	if waiting != "" {
+		// the pods keep running with the claims, the StatefulSet is replaced once they are expanded
+		storage.Message = fmt.Sprintf("waiting for the data volume %s to be expanded", waiting)
+		return false, nil
+	}
+
+	storage.Phase = rabbitmqv1alpha1.StorageRecreatingStatefulSet
+	storage.Message = ""
+	reqLogger.Info("Deleting the StatefulSet and orphaning its pods to replace its volume claim template",
+		"StatefulSet.Namespace", live.Namespace, "StatefulSet.Name", live.Name)
+	if err := r.client.Delete(context.TODO(), live, client.PropagationPolicy(metav1.DeletePropagationOrphan)); err != nil && !errors.IsNotFound(err) {
+		storage.Message = err.Error()
+		return true, err
+	}
+	return true, nil
+}
+
+// volumeExpanded reports whether the volume of the claim has been resized to the size. The file system
+// of a volume in use is resized by the kubelet afterwards, the node doesn't have to be restarted for it
+// when the cluster supports the online expansion.
+func volumeExpanded(pvc *corev1.PersistentVolumeClaim, size resource.Quantity) bool {
+	for _, condition := range pvc.Status.Conditions {
+		if condition.Type == corev1.PersistentVolumeClaimFileSystemResizePending && condition.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	capacity := pvc.Status.Capacity[corev1.ResourceStorage]
+	return capacity.Cmp(size) >= 0
+}
@@ -0,0 +1,278 @@
+package rabbitmq
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+// newStorageTestCluster returns the test cluster with the size and the storage class of the data volumes
+func newStorageTestCluster(size, class string) *rabbitmqv1alpha1.RabbitMQ {
+	cr := newTestCluster()
+	cr.Spec.DataVolumeSize = resource.MustParse(size)
+	if class != "" {
+		cr.Spec.Storage = &rabbitmqv1alpha1.StorageSpec{StorageClassName: class}
+	}
+	return cr
+}
+
+// newTestClaims returns the claims of the data volumes of the StatefulSet pods with the capacity
+func newTestClaims(ss *v1.StatefulSet, capacity string) []runtime.Object {
+	template := statefulSetDataVolumeClaimTemplate(ss)
+	claims := []runtime.Object{}
+	for ordinal := int32(0); ordinal < *ss.Spec.Replicas; ordinal++ {
+		pvc := &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: dataVolumeClaimName(ss, ordinal), Namespace: ss.Namespace},
+			Spec:       *template.Spec.DeepCopy(),
+			Status: corev1.PersistentVolumeClaimStatus{
+				Capacity: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse(capacity)},
+			},
+		}
+		claims = append(claims, pvc)
+	}
+	return claims
+}
+
+func newTestStorageClass(name string, allowExpansion bool) *storagev1.StorageClass {
+	return &storagev1.StorageClass{
+		ObjectMeta:           metav1.ObjectMeta{Name: name},
+		AllowVolumeExpansion: &allowExpansion,
+	}
+}
+
+func TestDataVolumeClaimTemplate(t *testing.T) {
+	cr := newStorageTestCluster("10Gi", "")
+	pvc := dataVolumeClaimTemplate(cr)
+	if pvc.Spec.StorageClassName != nil || len(pvc.Spec.AccessModes) != 1 || pvc.Spec.AccessModes[0] != corev1.ReadWriteOnce {
+		t.Errorf("got defaults %+v", pvc.Spec)
+	}
+	size := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+	if size.String() != "10Gi" {
+		t.Errorf("got size %s", size.String())
+	}
+
+	cr.Spec.Storage = &rabbitmqv1alpha1.StorageSpec{
+		StorageClassName: "fast",
+		AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany},
+		Labels:           map[string]string{"backup": "daily"},
+	}
+	pvc = dataVolumeClaimTemplate(cr)
+	if storageClassOf(&pvc) != "fast" || pvc.Spec.AccessModes[0] != corev1.ReadWriteMany || pvc.Labels["backup"] != "daily" {
+		t.Errorf("got %+v", pvc)
+	}
+}
+
+func TestClaimTemplateMatches(t *testing.T) {
+	desired := dataVolumeClaimTemplate(newStorageTestCluster("1Gi", "fast"))
+	tests := []struct {
+		name   string
+		change func(*corev1.PersistentVolumeClaim)
+		want   bool
+	}{
+		{"same", func(*corev1.PersistentVolumeClaim) {}, true},
+		{"same size in other units", func(pvc *corev1.PersistentVolumeClaim) {
+			pvc.Spec.Resources.Requests[corev1.ResourceStorage] = resource.MustParse("1024Mi")
+		}, true},
+		{"empty labels", func(pvc *corev1.PersistentVolumeClaim) { pvc.Labels = map[string]string{} }, true},
+		{"size", func(pvc *corev1.PersistentVolumeClaim) {
+			pvc.Spec.Resources.Requests[corev1.ResourceStorage] = resource.MustParse("2Gi")
+		}, false},
+		{"storage class", func(pvc *corev1.PersistentVolumeClaim) { pvc.Spec.StorageClassName = nil }, false},
+		{"labels", func(pvc *corev1.PersistentVolumeClaim) { pvc.Labels = map[string]string{"backup": "daily"} }, false},
+		{"annotations", func(pvc *corev1.PersistentVolumeClaim) { pvc.Annotations = map[string]string{"owner": "team"} }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			live := desired.DeepCopy()
+			tt.change(live)
+			if got := claimTemplateMatches(live, &desired); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveStorage(t *testing.T) {
+	tests := []struct {
+		name      string
+		liveClass string
+		size      string
+		class     string
+		objs      []runtime.Object
+		// refused is a part of the message of the refused change, empty if the change is applied
+		refused string
+	}{
+		{name: "unchanged", size: "1Gi"},
+		{name: "shrink", size: "512Mi", refused: "can't shrink"},
+		{name: "other storage class", size: "1Gi", class: "fast", refused: "storage class of the data volumes can't be changed"},
+		{name: "grow without storage class", size: "2Gi", refused: "has no storage class"},
+		{
+			name:      "grow",
+			liveClass: "fast",
+			size:      "2Gi",
+			class:     "fast",
+			objs:      []runtime.Object{newTestStorageClass("fast", true)},
+		},
+		{
+			name:      "grow without expansion",
+			liveClass: "fast",
+			size:      "2Gi",
+			class:     "fast",
+			objs:      []runtime.Object{newTestStorageClass("fast", false)},
+			refused:   "doesn't allow volume expansion",
+		},
+		{
+			name:      "grow with missing storage class",
+			liveClass: "fast",
+			size:      "2Gi",
+			class:     "fast",
+			refused:   "doesn't allow volume expansion",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			live := newStatefulSet(newStorageTestCluster("1Gi", tt.liveClass), nil)
+			r := newTestReconciler(append(newTestClaims(live, "1Gi"), tt.objs...)...)
+			instance := newTestCluster()
+			// a change refused before is retried
+			instance.Status.Storage = &rabbitmqv1alpha1.StorageStatus{Phase: rabbitmqv1alpha1.StorageRefused}
+			cr := newStorageTestCluster(tt.size, tt.class)
+			if err := r.resolveStorage(instance, cr, live); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.refused == "" {
+				if instance.Status.Storage != nil {
+					t.Errorf("got status %+v", instance.Status.Storage)
+				}
+				if cr.Spec.DataVolumeSize.String() != tt.size {
+					t.Errorf("got size %s, want %s", cr.Spec.DataVolumeSize.String(), tt.size)
+				}
+				return
+			}
+			storage := instance.Status.Storage
+			if storage == nil || storage.Phase != rabbitmqv1alpha1.StorageRefused || !strings.Contains(storage.Message, tt.refused) {
+				t.Errorf("got status %+v, want refused with %q", storage, tt.refused)
+			}
+			// the StatefulSet keeps the volume claim template
+			desired := dataVolumeClaimTemplate(cr)
+			if !claimTemplateMatches(&desired, statefulSetDataVolumeClaimTemplate(live)) {
+				t.Errorf("got volume claim template %+v", desired)
+			}
+		})
+	}
+}
+
+func TestReconcileStorage(t *testing.T) {
+	tests := []struct {
+		name     string
+		size     string
+		capacity string
+		status   *rabbitmqv1alpha1.StorageStatus
+		// want is the phase of the change, empty if there is none
+		want       rabbitmqv1alpha1.StoragePhase
+		recreating bool
+	}{
+		{name: "unchanged", size: "1Gi", capacity: "1Gi"},
+		{name: "expanding", size: "2Gi", capacity: "1Gi", want: rabbitmqv1alpha1.StorageExpanding},
+		{name: "expanded", size: "2Gi", capacity: "2Gi", want: rabbitmqv1alpha1.StorageRecreatingStatefulSet, recreating: true},
+		{
+			name:     "refused",
+			size:     "2Gi",
+			capacity: "1Gi",
+			status:   &rabbitmqv1alpha1.StorageStatus{Phase: rabbitmqv1alpha1.StorageRefused},
+			want:     rabbitmqv1alpha1.StorageRefused,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			live := newStatefulSet(newStorageTestCluster("1Gi", "fast"), nil)
+			claims := newTestClaims(live, tt.capacity)
+			r := newTestReconciler(append(claims, live)...)
+			instance := newTestCluster()
+			instance.Status.Storage = tt.status
+			desired := newStatefulSet(newStorageTestCluster(tt.size, "fast"), nil)
+
+			recreating, err := r.reconcileStorage(logf.Log, instance, desired, live)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if recreating != tt.recreating {
+				t.Errorf("got recreating %v, want %v", recreating, tt.recreating)
+			}
+			phase := rabbitmqv1alpha1.StoragePhase("")
+			if instance.Status.Storage != nil {
+				phase = instance.Status.Storage.Phase
+			}
+			if phase != tt.want {
+				t.Errorf("got phase %q, want %q", phase, tt.want)
+			}
+
+			err = r.client.Get(context.TODO(), types.NamespacedName{Name: live.Name, Namespace: live.Namespace}, &v1.StatefulSet{})
+			if deleted := errors.IsNotFound(err); deleted != tt.recreating {
+				t.Errorf("got the StatefulSet deleted %v, want %v", deleted, tt.recreating)
+			}
+			if tt.want != rabbitmqv1alpha1.StorageExpanding && tt.want != rabbitmqv1alpha1.StorageRecreatingStatefulSet {
+				return
+			}
+			for _, obj := range claims {
+				pvc := &corev1.PersistentVolumeClaim{}
+				name := obj.(*corev1.PersistentVolumeClaim).Name
+				if err := r.client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: live.Namespace}, pvc); err != nil {
+					t.Fatal(err)
+				}
+				if size := pvc.Spec.Resources.Requests[corev1.ResourceStorage]; size.String() != tt.size {
+					t.Errorf("got %s requesting %s, want %s", name, size.String(), tt.size)
+				}
+			}
+		})
+	}
+}
+
+func TestVolumeExpanded(t *testing.T) {
+	size := resource.MustParse("2Gi")
+	tests := []struct {
+		name       string
+		capacity   string
+		conditions []corev1.PersistentVolumeClaimCondition
+		want       bool
+	}{
+		{name: "not yet", capacity: "1Gi"},
+		{name: "expanded", capacity: "2Gi", want: true},
+		{
+			name:       "file system resize pending",
+			capacity:   "1Gi",
+			conditions: []corev1.PersistentVolumeClaimCondition{{Type: corev1.PersistentVolumeClaimFileSystemResizePending, Status: corev1.ConditionTrue}},
+			want:       true,
+		},
+		{
+			name:       "resizing",
+			capacity:   "1Gi",
+			conditions: []corev1.PersistentVolumeClaimCondition{{Type: corev1.PersistentVolumeClaimResizing, Status: corev1.ConditionTrue}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pvc := &corev1.PersistentVolumeClaim{
+				Status: corev1.PersistentVolumeClaimStatus{
+					Capacity:   corev1.ResourceList{corev1.ResourceStorage: resource.MustParse(tt.capacity)},
+					Conditions: tt.conditions,
+				},
+			}
+			if got := volumeExpanded(pvc, size); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
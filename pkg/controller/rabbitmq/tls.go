@@ -0,0 +1,208 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	// the keys of the TLS Secret
+	tlsCertKey = corev1.TLSCertKey
+	tlsKeyKey  = corev1.TLSPrivateKeyKey
+	tlsCAKey   = "ca.crt"
+
+	// tlsMountPath is where the TLS Secret is mounted in the rabbitmq container
+	tlsMountPath = "/etc/rabbitmq-tls"
+	// interNodeTLSConfigFile is the file of the ConfigMap with the TLS options of the Erlang distribution
+	interNodeTLSConfigFile = "inter_node_tls.config"
+
+	amqpsPort  = 5671
+	httpsPort  = 15671
+	tlsVolume  = "tls"
+	tlsDistArg = "-proto_dist inet_tls -ssl_dist_optfile /etc/rabbitmq/" + interNodeTLSConfigFile
+
+	// tlsHashAnnotation is set on the pod template to the hash of the TLS Secret contents,
+	// so new certificates produce a new revision of the StatefulSet and the nodes are restarted
+	// one by one to load them
+	tlsHashAnnotation = "rabbitmq.mirantis.com/tls-hash"
+)
+
+// tlsSecret is what the configuration of the cluster needs to know about the TLS Secret
+type tlsSecret struct {
+	hash  string
+	hasCA bool
+}
+
+// reconcileTLS checks the TLS Secret of the RabbitMQ instance, it returns nil if TLS is not enabled
+func (r *ReconcileRabbitMQ) reconcileTLS(cr *rabbitmqv1alpha1.RabbitMQ) (*tlsSecret, error) {
+	if cr.Spec.TLS == nil {
+		return nil, nil
+	}
+	secret := &corev1.Secret{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: cr.Spec.TLS.SecretName, Namespace: cr.Namespace}, secret)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range []string{tlsCertKey, tlsKeyKey} {
+		if len(secret.Data[key]) == 0 {
+			return nil, fmt.Errorf("TLS secret %s has no %s", secret.Name, key)
+		}
+	}
+	tls := &tlsSecret{
+		hash:  distributionHash(secret.Data[tlsCertKey], secret.Data[tlsKeyKey], secret.Data[tlsCAKey]),
+		hasCA: len(secret.Data[tlsCAKey]) > 0,
+	}
+	if cr.Spec.TLS.InterNode && !tls.hasCA {
+		return nil, fmt.Errorf("TLS secret %s has no %s, inter-node TLS needs it to verify the peers", secret.Name, tlsCAKey)
+	}
+	return tls, nil
+}
+
+// tlsConfig returns the settings of rabbitmq.conf for the TLS listeners
+func tlsConfig(tls *tlsSecret) []confEntry {
+	if tls == nil {
+		return nil
+	}
+	entries := []confEntry{
+		{
+			comment: "## TLS listeners. See https://www.rabbitmq.com/ssl.html to learn more.",
+			key:     "listeners.ssl.default",
+			value:   fmt.Sprint(amqpsPort),
+		},
+		{key: "ssl_options.certfile", value: tlsMountPath + "/" + tlsCertKey},
+		{key: "ssl_options.keyfile", value: tlsMountPath + "/" + tlsKeyKey},
+	}
+	if tls.hasCA {
+		entries = append(entries,
+			confEntry{key: "ssl_options.cacertfile", value: tlsMountPath + "/" + tlsCAKey},
+			confEntry{key: "ssl_options.verify", value: "verify_peer"},
+			confEntry{key: "ssl_options.fail_if_no_peer_cert", value: "false"},
+		)
+	}
+	entries = append(entries,
+		confEntry{key: "management.ssl.port", value: fmt.Sprint(httpsPort)},
+		confEntry{key: "management.ssl.certfile", value: tlsMountPath + "/" + tlsCertKey},
+		confEntry{key: "management.ssl.keyfile", value: tlsMountPath + "/" + tlsKeyKey},
+	)
+	if tls.hasCA {
+		entries = append(entries, confEntry{key: "management.ssl.cacertfile", value: tlsMountPath + "/" + tlsCAKey})
+	}
+	return entries
+}
+
+// tlsProtectedConfigPrefixes are the TLS settings the operator owns, the other ssl_options,
+// e.g. the versions and the ciphers, can be set in spec.additional_config
+var tlsProtectedConfigPrefixes = []string{
+	"listeners.ssl.",
+	"ssl_options.certfile",
+	"ssl_options.keyfile",
+	"ssl_options.cacertfile",
+	"management.ssl.port",
+	"management.ssl.certfile",
+	"management.ssl.keyfile",
+	"management.ssl.cacertfile",
+}
+
+// renderInterNodeTLSConfig returns the TLS options of the Erlang distribution, both the nodes
+// accepting the connections and the nodes and CLI tools opening them verify the peer
+func renderInterNodeTLSConfig() string {
+	options := []string{
+		`{cacertfile, "` + tlsMountPath + "/" + tlsCAKey + `"}`,
+		`{certfile, "` + tlsMountPath + "/" + tlsCertKey + `"}`,
+		`{keyfile, "` + tlsMountPath + "/" + tlsKeyKey + `"}`,
+		`{secure_renegotiate, true}`,
+		`{verify, verify_peer}`,
+	}
+	server := append(append([]string{}, options...), `{fail_if_no_peer_cert, true}`)
+	return "[\n" +
+		"  {server, [\n    " + strings.Join(server, ",\n    ") + "\n  ]},\n" +
+		"  {client, [\n    " + strings.Join(options, ",\n    ") + "\n  ]}\n" +
+		"].\n"
+}
+
+// interNodeTLSHash is added to the distribution hash when the nodes talk over TLS, a node
+// can't join the nodes using the other transport
+func interNodeTLSHash(cr *rabbitmqv1alpha1.RabbitMQ) []byte {
+	if cr.Spec.TLS != nil && cr.Spec.TLS.InterNode {
+		return []byte("inter-node-tls")
+	}
+	return nil
+}
+
+// podInterNodeTLSHash is interNodeTLSHash of the transport the pod runs with
+func podInterNodeTLSHash(pod *corev1.Pod) []byte {
+	for _, c := range pod.Spec.Containers {
+		for _, env := range c.Env {
+			if env.Name == "RABBITMQ_SERVER_ADDITIONAL_ERL_ARGS" && env.Value == tlsDistArg {
+				return []byte("inter-node-tls")
+			}
+		}
+	}
+	return nil
+}
+
+// tlsServicePorts returns the ports of the TLS listeners
+func tlsServicePorts(cr *rabbitmqv1alpha1.RabbitMQ) []corev1.ServicePort {
+	if cr.Spec.TLS == nil {
+		return nil
+	}
+	return []corev1.ServicePort{
+		{
+			Name:       "amqps",
+			Protocol:   corev1.ProtocolTCP,
+			Port:       amqpsPort,
+			TargetPort: intstr.FromInt(amqpsPort),
+		},
+		{
+			Name:       "https",
+			Protocol:   corev1.ProtocolTCP,
+			Port:       httpsPort,
+			TargetPort: intstr.FromInt(httpsPort),
+		},
+	}
+}
+
+// addTLS adds the TLS ports, the TLS Secret volume and the distribution settings to the pod template
+func addTLS(cr *rabbitmqv1alpha1.RabbitMQ, spec *corev1.PodSpec, container *corev1.Container) {
+	if cr.Spec.TLS == nil {
+		return
+	}
+	container.Ports = append(container.Ports,
+		corev1.ContainerPort{
+			Name:          "amqps",
+			Protocol:      corev1.ProtocolTCP,
+			ContainerPort: amqpsPort,
+		},
+		corev1.ContainerPort{
+			Name:          "https",
+			Protocol:      corev1.ProtocolTCP,
+			ContainerPort: httpsPort,
+		},
+	)
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      tlsVolume,
+		MountPath: tlsMountPath,
+		ReadOnly:  true,
+	})
+	if cr.Spec.TLS.InterNode {
+		container.Env = append(container.Env,
+			corev1.EnvVar{Name: "RABBITMQ_SERVER_ADDITIONAL_ERL_ARGS", Value: tlsDistArg},
+			corev1.EnvVar{Name: "RABBITMQ_CTL_ERL_ARGS", Value: tlsDistArg},
+		)
+	}
+	spec.Volumes = append(spec.Volumes, corev1.Volume{
+		Name: tlsVolume,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName:  cr.Spec.TLS.SecretName,
+				DefaultMode: newInt32(volumeDefaultMode),
+			},
+		},
+	})
+}
@@ -0,0 +1,285 @@
+package rabbitmq
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newTestTLSSecret(keys ...string) *corev1.Secret {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "rmq-tls", Namespace: "ns"},
+		Data:       map[string][]byte{},
+	}
+	for _, key := range keys {
+		secret.Data[key] = []byte("PEM of " + key)
+	}
+	return secret
+}
+
+func TestReconcileTLS(t *testing.T) {
+	tests := []struct {
+		name      string
+		tls       *rabbitmqv1alpha1.TLSSpec
+		secret    *corev1.Secret
+		wantErr   bool
+		wantNil   bool
+		wantHasCA bool
+	}{
+		{
+			name:    "disabled",
+			wantNil: true,
+		},
+		{
+			name:    "missing Secret",
+			tls:     &rabbitmqv1alpha1.TLSSpec{SecretName: "rmq-tls"},
+			wantErr: true,
+		},
+		{
+			name:    "no key",
+			tls:     &rabbitmqv1alpha1.TLSSpec{SecretName: "rmq-tls"},
+			secret:  newTestTLSSecret(tlsCertKey),
+			wantErr: true,
+		},
+		{
+			name:   "without CA",
+			tls:    &rabbitmqv1alpha1.TLSSpec{SecretName: "rmq-tls"},
+			secret: newTestTLSSecret(tlsCertKey, tlsKeyKey),
+		},
+		{
+			name:      "with CA",
+			tls:       &rabbitmqv1alpha1.TLSSpec{SecretName: "rmq-tls"},
+			secret:    newTestTLSSecret(tlsCertKey, tlsKeyKey, tlsCAKey),
+			wantHasCA: true,
+		},
+		{
+			name:    "inter-node without CA",
+			tls:     &rabbitmqv1alpha1.TLSSpec{SecretName: "rmq-tls", InterNode: true},
+			secret:  newTestTLSSecret(tlsCertKey, tlsKeyKey),
+			wantErr: true,
+		},
+		{
+			name:      "inter-node",
+			tls:       &rabbitmqv1alpha1.TLSSpec{SecretName: "rmq-tls", InterNode: true},
+			secret:    newTestTLSSecret(tlsCertKey, tlsKeyKey, tlsCAKey),
+			wantHasCA: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestReconciler()
+			if tt.secret != nil {
+				r = newTestReconciler(tt.secret)
+			}
+			cr := newTestCluster()
+			cr.Spec.TLS = tt.tls
+			tls, err := r.reconcileTLS(cr)
+			switch {
+			case tt.wantErr:
+				if err == nil {
+					t.Errorf("no error")
+				}
+			case err != nil:
+				t.Fatal(err)
+			case tt.wantNil:
+				if tls != nil {
+					t.Errorf("got %+v, want nil", tls)
+				}
+			case tls == nil:
+				t.Errorf("got nil")
+			case tls.hasCA != tt.wantHasCA:
+				t.Errorf("got hasCA %v, want %v", tls.hasCA, tt.wantHasCA)
+			}
+		})
+	}
+}
+
+func TestReconcileTLSHash(t *testing.T) {
+	cr := newTestCluster()
+	cr.Spec.TLS = &rabbitmqv1alpha1.TLSSpec{SecretName: "rmq-tls"}
+	secret := newTestTLSSecret(tlsCertKey, tlsKeyKey)
+	tls, err := newTestReconciler(secret).reconcileTLS(cr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret.Data[tlsCertKey] = []byte("renewed certificate")
+	renewed, err := newTestReconciler(secret).reconcileTLS(cr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tls.hash == renewed.hash {
+		t.Errorf("the hash doesn't change with the certificate")
+	}
+}
+
+func TestRenderRabbitMQConfWithTLS(t *testing.T) {
+	tests := []struct {
+		name   string
+		tls    *tlsSecret
+		want   map[string]string
+		absent []string
+	}{
+		{
+			name: "without CA",
+			tls:  &tlsSecret{hash: "h"},
+			want: map[string]string{
+				"listeners.ssl.default":   "5671",
+				"ssl_options.certfile":    "/etc/rabbitmq-tls/tls.crt",
+				"ssl_options.keyfile":     "/etc/rabbitmq-tls/tls.key",
+				"management.ssl.port":     "15671",
+				"management.ssl.certfile": "/etc/rabbitmq-tls/tls.crt",
+				"management.ssl.keyfile":  "/etc/rabbitmq-tls/tls.key",
+			},
+			absent: []string{"ssl_options.cacertfile", "ssl_options.verify", "management.ssl.cacertfile"},
+		},
+		{
+			name: "with CA",
+			tls:  &tlsSecret{hash: "h", hasCA: true},
+			want: map[string]string{
+				"ssl_options.cacertfile":           "/etc/rabbitmq-tls/ca.crt",
+				"ssl_options.verify":               "verify_peer",
+				"ssl_options.fail_if_no_peer_cert": "false",
+				"management.ssl.cacertfile":        "/etc/rabbitmq-tls/ca.crt",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := newTestCluster()
+			cr.Spec.TLS = &rabbitmqv1alpha1.TLSSpec{SecretName: "rmq-tls"}
+			cr.Spec.AdditionalConfig = "ssl_options.versions.1 = tlsv1.2"
+			conf, err := renderRabbitMQConf(cr, tt.tls)
+			if err != nil {
+				t.Fatal(err)
+			}
+			values := confValues(t, conf)
+			for key, want := range tt.want {
+				if values[key] != want {
+					t.Errorf("got %s = %q, want %q", key, values[key], want)
+				}
+			}
+			for _, key := range tt.absent {
+				if _, ok := values[key]; ok {
+					t.Errorf("%s is set", key)
+				}
+			}
+			if values["ssl_options.versions.1"] != "tlsv1.2" {
+				t.Errorf("the TLS versions of additional_config are not kept")
+			}
+		})
+	}
+}
+
+func TestTLSSettingsAreProtected(t *testing.T) {
+	cr := newTestCluster()
+	cr.Spec.AdditionalConfig = "ssl_options.certfile = /tmp/cert.pem"
+	if _, err := parseAdditionalConfig(cr); err != nil {
+		t.Errorf("the certificate can't be set without TLS: %v", err)
+	}
+	cr.Spec.TLS = &rabbitmqv1alpha1.TLSSpec{SecretName: "rmq-tls"}
+	if _, err := parseAdditionalConfig(cr); err == nil {
+		t.Errorf("the certificate of the operator can be overridden")
+	}
+}
+
+func TestRenderInterNodeTLSConfig(t *testing.T) {
+	config := renderInterNodeTLSConfig()
+	if err := validateAdvancedConfig(config); err != nil {
+		t.Errorf("invalid Erlang term: %v\n%s", err, config)
+	}
+	server := config[strings.Index(config, "{server"):strings.Index(config, "{client")]
+	client := config[strings.Index(config, "{client"):]
+	for _, option := range []string{`{cacertfile, "/etc/rabbitmq-tls/ca.crt"}`, `{verify, verify_peer}`} {
+		if !strings.Contains(server, option) || !strings.Contains(client, option) {
+			t.Errorf("%s is not set for both the server and the client", option)
+		}
+	}
+	if !strings.Contains(server, "{fail_if_no_peer_cert, true}") || strings.Contains(client, "fail_if_no_peer_cert") {
+		t.Errorf("only the server requires the peer certificate:\n%s", config)
+	}
+}
+
+func TestAddTLS(t *testing.T) {
+	tests := []struct {
+		name      string
+		tls       *rabbitmqv1alpha1.TLSSpec
+		ports     []string
+		env       []string
+		volumes   []string
+		mountPath string
+	}{
+		{
+			name:    "disabled",
+			ports:   []string{"http", "amqp"},
+			volumes: []string{"config-volume"},
+		},
+		{
+			name:      "listeners",
+			tls:       &rabbitmqv1alpha1.TLSSpec{SecretName: "rmq-tls"},
+			ports:     []string{"http", "amqp", "amqps", "https"},
+			volumes:   []string{"config-volume", tlsVolume},
+			mountPath: tlsMountPath,
+		},
+		{
+			name:      "inter-node",
+			tls:       &rabbitmqv1alpha1.TLSSpec{SecretName: "rmq-tls", InterNode: true},
+			ports:     []string{"http", "amqp", "amqps", "https"},
+			env:       []string{"RABBITMQ_SERVER_ADDITIONAL_ERL_ARGS", "RABBITMQ_CTL_ERL_ARGS"},
+			volumes:   []string{"config-volume", tlsVolume},
+			mountPath: tlsMountPath,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := newTestCluster()
+			cr.Spec.TLS = tt.tls
+			spec := newStatefulSet(cr, nil).Spec.Template.Spec
+			container := spec.Containers[0]
+
+			ports := []string{}
+			for _, p := range container.Ports {
+				ports = append(ports, p.Name)
+			}
+			if !reflect.DeepEqual(ports, tt.ports) {
+				t.Errorf("got ports %v, want %v", ports, tt.ports)
+			}
+			for _, name := range tt.env {
+				found := false
+				for _, e := range container.Env {
+					found = found || e.Name == name && e.Value == tlsDistArg
+				}
+				if !found {
+					t.Errorf("%s is not set to the TLS distribution", name)
+				}
+			}
+			volumes := []string{}
+			for _, v := range spec.Volumes {
+				volumes = append(volumes, v.Name)
+			}
+			if !reflect.DeepEqual(volumes, tt.volumes) {
+				t.Errorf("got volumes %v, want %v", volumes, tt.volumes)
+			}
+			if tt.mountPath != "" {
+				mount := container.VolumeMounts[len(container.VolumeMounts)-1]
+				if mount.Name != tlsVolume || mount.MountPath != tt.mountPath || !mount.ReadOnly {
+					t.Errorf("got mount %+v", mount)
+				}
+			}
+		})
+	}
+}
+
+func TestTLSServicePorts(t *testing.T) {
+	cr := newTestCluster()
+	if ports := servicePortNames(newService(cr)); ports["amqps"] != 0 || ports["https"] != 0 {
+		t.Errorf("TLS ports without TLS: %v", ports)
+	}
+	cr.Spec.TLS = &rabbitmqv1alpha1.TLSSpec{SecretName: "rmq-tls"}
+	if ports := servicePortNames(newService(cr)); ports["amqps"] != amqpsPort || ports["https"] != httpsPort {
+		t.Errorf("got ports %v", ports)
+	}
+}
@@ -0,0 +1,200 @@
+package rabbitmq
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-logr/logr"
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	"github.com/toha10/rabbitmq-operator/pkg/management"
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// imageVersionRegexp matches the RabbitMQ version at the start of an image tag, e.g. 3.8.2-management
+var imageVersionRegexp = regexp.MustCompile(`^v?(\d+)\.(\d+)(?:\.(\d+))?`)
+
+// version is a RabbitMQ version, patch is -1 when the tag names the minor version only
+type version struct {
+	major, minor, patch int
+}
+
+func (v version) String() string {
+	if v.patch < 0 {
+		return fmt.Sprintf("%d.%d", v.major, v.minor)
+	}
+	return fmt.Sprintf("%d.%d.%d", v.major, v.minor, v.patch)
+}
+
+// minRollingUpgradeFrom is the oldest patch release of the previous minor version a rolling upgrade
+// to the minor version is supported from. The other nodes of a cluster in the middle of the upgrade
+// have to understand the feature flags, otherwise the upgraded node refuses to join them.
+var minRollingUpgradeFrom = map[string]version{
+	"3.8": {3, 7, 18},
+}
+
+// imageVersion parses the RabbitMQ version from the tag of the image, it reports false if the image
+// has no tag or the tag doesn't start with a version
+func imageVersion(image string) (version, bool) {
+	if strings.Contains(image, "@") {
+		return version{}, false
+	}
+	// the registry host may have a port, the tag follows the last path segment
+	name := image[strings.LastIndex(image, "/")+1:]
+	i := strings.LastIndex(name, ":")
+	if i < 0 {
+		return version{}, false
+	}
+	m := imageVersionRegexp.FindStringSubmatch(name[i+1:])
+	if m == nil {
+		return version{}, false
+	}
+	v := version{patch: -1}
+	v.major, _ = strconv.Atoi(m[1])
+	v.minor, _ = strconv.Atoi(m[2])
+	if m[3] != "" {
+		v.patch, _ = strconv.Atoi(m[3])
+	}
+	return v, true
+}
+
+// checkUpgradePath returns the reason the cluster can't be upgraded in place from one version
+// to the other, or an empty string. Nodes are restarted one at a time, so the old and the new
+// version have to run in the same cluster: the major version can't change, at most one minor
+// version can be crossed and nothing can be downgraded. The Erlang version of the image is not
+// visible in the tag and remains the responsibility of whoever picks the image.
+func checkUpgradePath(from, to version) string {
+	switch {
+	case from.major != to.major:
+		return fmt.Sprintf("upgrade from %s to %s changes the major version", from, to)
+	case to.minor < from.minor || to.minor == from.minor && to.patch >= 0 && to.patch < from.patch:
+		return fmt.Sprintf("downgrade from %s to %s is not supported", from, to)
+	case to.minor > from.minor+1:
+		return fmt.Sprintf("upgrade from %s to %s skips a minor version, upgrade to %d.%d first", from, to, from.major, from.minor+1)
+	}
+	if to.minor == from.minor+1 && from.patch >= 0 {
+		if oldest, ok := minRollingUpgradeFrom[fmt.Sprintf("%d.%d", to.major, to.minor)]; ok && from.patch < oldest.patch {
+			return fmt.Sprintf("upgrade to %s is only supported from %s or later, upgrade to it first", to, oldest)
+		}
+	}
+	return ""
+}
+
+// ValidateImageChange checks the change of the image the same way the operator checks it before
+// starting an upgrade. The images whose tags don't carry a version are not checked.
+func ValidateImageChange(from, to string) error {
+	fromVersion, fromOK := imageVersion(from)
+	toVersion, toOK := imageVersion(to)
+	if from == to || !fromOK || !toOK {
+		return nil
+	}
+	if reason := checkUpgradePath(fromVersion, toVersion); reason != "" {
+		return errors.New(reason)
+	}
+	return nil
+}
+
+// resolveImage decides the image the cluster runs. A change of spec.image starts an upgrade recorded
+// in the status of the instance; if the upgrade path is not supported, or an upgrade is still in
+// progress, the StatefulSet keeps the image it runs now.
+func resolveImage(instance, cr *rabbitmqv1alpha1.RabbitMQ, live *v1.StatefulSet) {
+	if live == nil {
+		return
+	}
+	current := statefulSetImage(live)
+	upgrade := instance.Status.Upgrade
+	if current == "" || current == cr.Spec.Image {
+		if upgrade != nil && upgrade.Phase == rabbitmqv1alpha1.UpgradeRefused {
+			instance.Status.Upgrade = nil
+		}
+		return
+	}
+	if upgrade != nil && upgrade.Phase != rabbitmqv1alpha1.UpgradeRefused && upgrade.Phase != rabbitmqv1alpha1.UpgradeCompleted {
+		// the cluster may still run the image the upgrade started from on some nodes
+		cr.Spec.Image = current
+		return
+	}
+
+	upgrade = &rabbitmqv1alpha1.UpgradeStatus{
+		FromImage: current,
+		ToImage:   cr.Spec.Image,
+		Phase:     rabbitmqv1alpha1.UpgradeRollingOut,
+	}
+	instance.Status.Upgrade = upgrade
+	from, fromOK := imageVersion(current)
+	to, toOK := imageVersion(cr.Spec.Image)
+	if fromOK {
+		upgrade.FromVersion = from.String()
+	}
+	if toOK {
+		upgrade.ToVersion = to.String()
+	}
+	if !fromOK || !toOK {
+		upgrade.Message = "the image tag doesn't carry a RabbitMQ version, the upgrade path is not checked"
+		return
+	}
+	if reason := checkUpgradePath(from, to); reason != "" {
+		upgrade.Phase = rabbitmqv1alpha1.UpgradeRefused
+		upgrade.Message = reason
+		cr.Spec.Image = current
+	}
+}
+
+// finishUpgrade completes the upgrade once every pod runs the new revision of the StatefulSet: when all
+// nodes are back in the cluster, the stable feature flags of the new version are enabled. A node running
+// the old version would refuse them, so this can't be done earlier. It reports whether the upgrade is
+// still in progress.
+func (r *ReconcileRabbitMQ) finishUpgrade(reqLogger logr.Logger, instance *rabbitmqv1alpha1.RabbitMQ, ss *v1.StatefulSet, pods []corev1.Pod, mgmt *management.Client) (bool, error) {
+	upgrade := instance.Status.Upgrade
+	if upgrade == nil || (upgrade.Phase != rabbitmqv1alpha1.UpgradeRollingOut && upgrade.Phase != rabbitmqv1alpha1.UpgradeEnablingFeatureFlags) {
+		return false, nil
+	}
+	wait := func(format string, args ...interface{}) (bool, error) {
+		upgrade.Message = fmt.Sprintf(format, args...)
+		return true, nil
+	}
+
+	if ss.Spec.Replicas != nil && int32(len(pods)) < *ss.Spec.Replicas {
+		return wait("waiting for all pods to be created")
+	}
+	for i := range pods {
+		if !isPodReady(&pods[i]) {
+			return wait("waiting for pod %s to be ready", pods[i].Name)
+		}
+	}
+	if mgmt == nil {
+		return wait("waiting for the management API")
+	}
+	reason, err := clusterHealth(mgmt, pods)
+	if err != nil {
+		return true, err
+	}
+	if reason != "" {
+		return wait("waiting for the cluster to recover: %s", reason)
+	}
+
+	upgrade.Phase = rabbitmqv1alpha1.UpgradeEnablingFeatureFlags
+	flags, err := mgmt.ListFeatureFlags()
+	if err != nil && !management.IsNotFound(err) {
+		upgrade.Message = err.Error()
+		return true, err
+	}
+	for _, flag := range flags {
+		if flag.Stability != management.FeatureFlagStable || flag.State == management.FeatureFlagEnabled {
+			continue
+		}
+		reqLogger.Info("Enabling the feature flag", "FeatureFlag", flag.Name)
+		if err := mgmt.EnableFeatureFlag(flag.Name); err != nil {
+			upgrade.Message = err.Error()
+			return true, err
+		}
+	}
+
+	reqLogger.Info("Upgrade completed", "FromImage", upgrade.FromImage, "ToImage", upgrade.ToImage)
+	upgrade.Phase = rabbitmqv1alpha1.UpgradeCompleted
+	upgrade.Message = ""
+	return false, nil
+}
@@ -0,0 +1,187 @@
+package rabbitmq
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	"github.com/toha10/rabbitmq-operator/pkg/management"
+	corev1 "k8s.io/api/core/v1"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+func TestImageVersion(t *testing.T) {
+	tests := []struct {
+		image string
+		ok    bool
+		want  version
+	}{
+		{"rabbitmq:3.7.17", true, version{3, 7, 17}},
+		{"rabbitmq:3.8.2-management", true, version{3, 8, 2}},
+		{"rabbitmq:3.8-management-alpine", true, version{3, 8, -1}},
+		{"registry.example.com:5000/rabbitmq:v3.7.18", true, version{3, 7, 18}},
+		{"registry.example.com:5000/rabbitmq", false, version{}},
+		{"rabbitmq", false, version{}},
+		{"rabbitmq:latest", false, version{}},
+		{"rabbitmq:3.8.2@sha256:abcdef", false, version{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			v, ok := imageVersion(tt.image)
+			if ok != tt.ok || v != tt.want {
+				t.Errorf("got %v, %v, want %v, %v", v, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestValidateImageChange(t *testing.T) {
+	tests := []struct {
+		from, to string
+		valid    bool
+	}{
+		{"rabbitmq:3.7.17", "rabbitmq:3.7.17", true},
+		{"rabbitmq:3.7.17", "rabbitmq:3.7.18", true},
+		{"rabbitmq:3.7.18", "rabbitmq:3.8.2", true},
+		{"rabbitmq:3.7.17", "rabbitmq:3.8.2", false},
+		{"rabbitmq:3.7-management", "rabbitmq:3.8.2", true},
+		{"rabbitmq:3.7.18", "rabbitmq:3.9.0", false},
+		{"rabbitmq:3.8.2", "rabbitmq:3.7.18", false},
+		{"rabbitmq:3.8.2", "rabbitmq:3.8.1", false},
+		{"rabbitmq:3.8.2", "rabbitmq:3.8-management", true},
+		{"rabbitmq:3.8.2", "rabbitmq:4.0.0", false},
+		{"rabbitmq:3.7.17", "rabbitmq:latest", true},
+		{"rabbitmq:latest", "rabbitmq:3.9.0", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.from+" to "+tt.to, func(t *testing.T) {
+			err := ValidateImageChange(tt.from, tt.to)
+			if (err == nil) != tt.valid {
+				t.Errorf("got error %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestResolveImage(t *testing.T) {
+	tests := []struct {
+		name    string
+		image   string
+		upgrade *rabbitmqv1alpha1.UpgradeStatus
+		// resolved is the image of the StatefulSet, phase the phase of the upgrade recorded
+		resolved string
+		phase    rabbitmqv1alpha1.UpgradePhase
+	}{
+		{"unchanged", "rabbitmq:3.7.17", nil, "rabbitmq:3.7.17", ""},
+		{"refusal cleared when reverted", "rabbitmq:3.7.17",
+			&rabbitmqv1alpha1.UpgradeStatus{Phase: rabbitmqv1alpha1.UpgradeRefused},
+			"rabbitmq:3.7.17", ""},
+		{"upgrade started", "rabbitmq:3.7.18", nil, "rabbitmq:3.7.18", rabbitmqv1alpha1.UpgradeRollingOut},
+		{"unsupported path refused", "rabbitmq:3.8.2", nil, "rabbitmq:3.7.17", rabbitmqv1alpha1.UpgradeRefused},
+		{"another upgrade waits", "rabbitmq:3.7.19",
+			&rabbitmqv1alpha1.UpgradeStatus{ToImage: "rabbitmq:3.7.18", Phase: rabbitmqv1alpha1.UpgradeRollingOut},
+			"rabbitmq:3.7.17", rabbitmqv1alpha1.UpgradeRollingOut},
+		{"image without a version", "rabbitmq:latest", nil, "rabbitmq:latest", rabbitmqv1alpha1.UpgradeRollingOut},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			live := newTestStatefulSet(3, nil)
+			live.Spec.Template.Spec.Containers[0].Image = "rabbitmq:3.7.17"
+			instance := newTestCluster()
+			instance.Spec.Image = tt.image
+			instance.Status.Upgrade = tt.upgrade
+			cr := instance.DeepCopy()
+
+			resolveImage(instance, cr, live)
+			if cr.Spec.Image != tt.resolved {
+				t.Errorf("got image %s, want %s", cr.Spec.Image, tt.resolved)
+			}
+			var phase rabbitmqv1alpha1.UpgradePhase
+			if instance.Status.Upgrade != nil {
+				phase = instance.Status.Upgrade.Phase
+			}
+			if phase != tt.phase {
+				t.Errorf("got phase %q, want %q", phase, tt.phase)
+			}
+		})
+	}
+}
+
+func TestFinishUpgrade(t *testing.T) {
+	ss := newTestStatefulSet(3, nil)
+	r := newTestReconciler(append(newTestPods(ss, nil), ss)...)
+	pods, err := r.statefulSetPods(ss)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enabled := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case req.URL.Path == "/api/nodes":
+			json.NewEncoder(w).Encode(runningNodes(pods))
+		case req.URL.Path == "/api/queues":
+			w.Write([]byte("[]"))
+		case req.URL.Path == "/api/feature-flags":
+			json.NewEncoder(w).Encode([]management.FeatureFlag{
+				{Name: "quorum_queue", State: "disabled", Stability: management.FeatureFlagStable},
+				{Name: "implicit_default_bindings", State: management.FeatureFlagEnabled, Stability: management.FeatureFlagStable},
+				{Name: "experimental", State: "disabled", Stability: "experimental"},
+			})
+		case req.Method == http.MethodPut:
+			enabled = append(enabled, req.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer server.Close()
+	mgmt := management.NewClient(server.URL, "admin", "secret")
+
+	instance := newTestCluster()
+	instance.Status.Upgrade = &rabbitmqv1alpha1.UpgradeStatus{Phase: rabbitmqv1alpha1.UpgradeRollingOut}
+
+	pods[1].Status.Conditions[0].Status = corev1.ConditionFalse
+	upgrading, err := r.finishUpgrade(logf.Log, instance, ss, pods, mgmt)
+	if err != nil || !upgrading {
+		t.Fatalf("got upgrading %v, %v with a pod not ready", upgrading, err)
+	}
+	if len(enabled) != 0 {
+		t.Errorf("feature flags enabled before all pods are ready: %v", enabled)
+	}
+
+	pods[1].Status.Conditions[0].Status = corev1.ConditionTrue
+	upgrading, err = r.finishUpgrade(logf.Log, instance, ss, pods, mgmt)
+	if err != nil || upgrading {
+		t.Fatalf("got upgrading %v, %v", upgrading, err)
+	}
+	if want := []string{"/api/feature-flags/quorum_queue/enable"}; !reflect.DeepEqual(enabled, want) {
+		t.Errorf("got enabled %v, want %v", enabled, want)
+	}
+	if phase := instance.Status.Upgrade.Phase; phase != rabbitmqv1alpha1.UpgradeCompleted {
+		t.Errorf("got phase %s, want %s", phase, rabbitmqv1alpha1.UpgradeCompleted)
+	}
+
+	if upgrading, err := r.finishUpgrade(logf.Log, instance, ss, pods, mgmt); err != nil || upgrading {
+		t.Errorf("got upgrading %v, %v after the upgrade", upgrading, err)
+	}
+}
+
+func TestFinishUpgradeWaitsForThePods(t *testing.T) {
+	ss := newTestStatefulSet(3, nil)
+	ss.Spec.Replicas = newInt32(4)
+	instance := newTestCluster()
+	instance.Status.Upgrade = &rabbitmqv1alpha1.UpgradeStatus{Phase: rabbitmqv1alpha1.UpgradeRollingOut}
+	r := newTestReconciler()
+
+	upgrading, err := r.finishUpgrade(logf.Log, instance, ss, nil, nil)
+	if err != nil || !upgrading {
+		t.Fatalf("got upgrading %v, %v", upgrading, err)
+	}
+	if msg := instance.Status.Upgrade.Message; msg != "waiting for all pods to be created" {
+		t.Errorf("got message %q", msg)
+	}
+}
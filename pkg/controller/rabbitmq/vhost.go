@@ -0,0 +1,25 @@
+package rabbitmq
+
+import (
+	"github.com/go-logr/logr"
+	"github.com/toha10/rabbitmq-operator/pkg/management"
+)
+
+// ensureVhost creates the vhost if it doesn't exist and grants the operator administrator access to it,
+// so the objects inside the vhost can be managed too. The vhost is never deleted by the operator,
+// it holds the messages of the clients; the RabbitMQVhost resource is there for managed vhosts.
+func ensureVhost(reqLogger logr.Logger, mgmt *management.Client, name string) error {
+	_, err := mgmt.GetVhost(name)
+	if err == nil {
+		return nil
+	}
+	if !management.IsNotFound(err) {
+		return err
+	}
+
+	reqLogger.Info("Creating the vhost", "Vhost", name)
+	if err := mgmt.PutVhost(&management.Vhost{Name: name}); err != nil {
+		return err
+	}
+	return mgmt.SetPermissions(name, mgmt.Username(), management.FullPermissions)
+}
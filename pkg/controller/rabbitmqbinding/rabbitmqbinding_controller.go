@@ -0,0 +1,102 @@
+package rabbitmqbinding
+
+import (
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	"github.com/toha10/rabbitmq-operator/pkg/controller/managed"
+	"github.com/toha10/rabbitmq-operator/pkg/management"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// Add creates a new RabbitMQBinding Controller and adds it to the Manager. The Manager will set fields on the Controller
+// and Start it when the Manager is Started.
+func Add(mgr manager.Manager) error {
+	return managed.Add(mgr, "rabbitmqbinding-controller", &bindingHandler{})
+}
+
+// bindingHandler manages the bindings declared by RabbitMQBinding resources
+type bindingHandler struct{}
+
+func (h *bindingHandler) NewObject() managed.Object {
+	return &rabbitmqv1alpha1.RabbitMQBinding{}
+}
+
+func (h *bindingHandler) NewList() runtime.Object {
+	return &rabbitmqv1alpha1.RabbitMQBindingList{}
+}
+
+// desiredBinding returns the binding as declared by the resource
+func desiredBinding(cr *rabbitmqv1alpha1.RabbitMQBinding) (*management.Binding, error) {
+	arguments, err := managed.DecodeArguments(cr.Spec.Arguments)
+	if err != nil {
+		return nil, err
+	}
+	return &management.Binding{
+		Source:          cr.Spec.Source,
+		Destination:     cr.Spec.Destination,
+		DestinationType: cr.BindingDestinationType(),
+		RoutingKey:      cr.Spec.RoutingKey,
+		Arguments:       arguments,
+	}, nil
+}
+
+// findBinding returns the binding between the source and the destination of the declared binding
+// with the same routing key and arguments, or nil
+func findBinding(mgmt *management.Client, vhost string, desired *management.Binding) (*management.Binding, error) {
+	bindings, err := mgmt.ListBindings(vhost, desired.Source, desired.Destination, desired.DestinationType)
+	if err != nil {
+		return nil, err
+	}
+	for i := range bindings {
+		if bindings[i].RoutingKey == desired.RoutingKey && managed.EqualArguments(bindings[i].Arguments, desired.Arguments) {
+			return &bindings[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// Sync creates the binding when it is missing, the source and the destination have to exist
+func (h *bindingHandler) Sync(req *managed.Request, obj managed.Object) error {
+	cr := obj.(*rabbitmqv1alpha1.RabbitMQBinding)
+	vhost := cr.BindingVhost()
+	desired, err := desiredBinding(cr)
+	if err != nil {
+		return err
+	}
+
+	current, err := findBinding(req.Management, vhost, desired)
+	if err != nil && !management.IsNotFound(err) {
+		return err
+	}
+	if current != nil {
+		return nil
+	}
+	req.Logger.Info("Creating the binding", "Vhost", vhost, "Source", desired.Source,
+		"Destination", desired.Destination, "DestinationType", desired.DestinationType, "RoutingKey", desired.RoutingKey)
+	return req.Management.CreateBinding(vhost, desired)
+}
+
+// Delete deletes the binding
+func (h *bindingHandler) Delete(req *managed.Request, obj managed.Object) error {
+	cr := obj.(*rabbitmqv1alpha1.RabbitMQBinding)
+	vhost := cr.BindingVhost()
+	desired, err := desiredBinding(cr)
+	if err != nil {
+		// nothing was created
+		return nil
+	}
+
+	current, err := findBinding(req.Management, vhost, desired)
+	if management.IsNotFound(err) || current == nil && err == nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	req.Logger.Info("Deleting the binding", "Vhost", vhost, "Source", current.Source,
+		"Destination", current.Destination, "RoutingKey", current.RoutingKey)
+	if err := req.Management.DeleteBinding(vhost, current); err != nil && !management.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package rabbitmqbinding
+
+import (
+	"reflect"
+	"testing"
+
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	"github.com/toha10/rabbitmq-operator/pkg/controller/managed/managedtest"
+	"github.com/toha10/rabbitmq-operator/pkg/management/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func newTestBinding(arguments string) *rabbitmqv1alpha1.RabbitMQBinding {
+	cr := &rabbitmqv1alpha1.RabbitMQBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "ns"},
+		Spec: rabbitmqv1alpha1.RabbitMQBindingSpec{
+			Source:      "events",
+			Destination: "orders",
+			RoutingKey:  "order.*",
+		},
+	}
+	if arguments != "" {
+		cr.Spec.Arguments = runtime.RawExtension{Raw: []byte(arguments)}
+	}
+	return cr
+}
+
+func TestSync(t *testing.T) {
+	tests := []struct {
+		name      string
+		arguments string
+		responses map[string]string
+		requests  []string
+	}{
+		{
+			name: "created",
+			responses: map[string]string{
+				"GET /api/bindings/%2F/e/events/q/orders": "[]",
+			},
+			requests: []string{`POST /api/bindings/%2F/e/events/q/orders {"arguments":{},"routing_key":"order.*"}`},
+		},
+		{
+			name: "in sync",
+			responses: map[string]string{
+				"GET /api/bindings/%2F/e/events/q/orders": `[{"source":"events","destination":"orders","destination_type":"queue","routing_key":"order.*","arguments":{},"properties_key":"order.*"}]`,
+			},
+			requests: []string{},
+		},
+		{
+			name:      "other arguments",
+			arguments: `{"x-priority":1}`,
+			responses: map[string]string{
+				"GET /api/bindings/%2F/e/events/q/orders": `[{"source":"events","destination":"orders","destination_type":"queue","routing_key":"order.*","arguments":{},"properties_key":"order.*"}]`,
+			},
+			requests: []string{`POST /api/bindings/%2F/e/events/q/orders {"arguments":{"x-priority":1},"routing_key":"order.*"}`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := fake.NewServer(tt.responses)
+			defer server.Close()
+			req := managedtest.NewRequest(server)
+			if err := (&bindingHandler{}).Sync(req, newTestBinding(tt.arguments)); err != nil {
+				t.Fatal(err)
+			}
+			if got := server.Requests(); !reflect.DeepEqual(got, tt.requests) {
+				t.Errorf("got requests\n%v\nwant\n%v", got, tt.requests)
+			}
+		})
+	}
+}
+
+func TestDelete(t *testing.T) {
+	tests := []struct {
+		name      string
+		arguments string
+		responses map[string]string
+		requests  []string
+	}{
+		{
+			name: "deleted",
+			responses: map[string]string{
+				"GET /api/bindings/%2F/e/events/q/orders": `[
+					{"source":"events","destination":"orders","destination_type":"queue","routing_key":"order.eu","arguments":{},"properties_key":"order.eu"},
+					{"source":"events","destination":"orders","destination_type":"queue","routing_key":"order.*","arguments":{},"properties_key":"order.*"}
+				]`,
+			},
+			requests: []string{"DELETE /api/bindings/%2F/e/events/q/orders/order.%2A"},
+		},
+		{
+			name: "missing",
+			responses: map[string]string{
+				"GET /api/bindings/%2F/e/events/q/orders": "[]",
+			},
+			requests: []string{},
+		},
+		{
+			name:      "invalid arguments",
+			arguments: `["x-priority"]`,
+			requests:  []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := fake.NewServer(tt.responses)
+			defer server.Close()
+			req := managedtest.NewRequest(server)
+			if err := (&bindingHandler{}).Delete(req, newTestBinding(tt.arguments)); err != nil {
+				t.Fatal(err)
+			}
+			if got := server.Requests(); !reflect.DeepEqual(got, tt.requests) {
+				t.Errorf("got requests\n%v\nwant\n%v", got, tt.requests)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package rabbitmqexchange
+
+import (
+	"fmt"
+	"strings"
+
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	"github.com/toha10/rabbitmq-operator/pkg/controller/managed"
+	"github.com/toha10/rabbitmq-operator/pkg/management"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// Add creates a new RabbitMQExchange Controller and adds it to the Manager. The Manager will set fields on the Controller
+// and Start it when the Manager is Started.
+func Add(mgr manager.Manager) error {
+	return managed.Add(mgr, "rabbitmqexchange-controller", &exchangeHandler{})
+}
+
+// exchangeHandler manages the exchanges declared by RabbitMQExchange resources
+type exchangeHandler struct{}
+
+func (h *exchangeHandler) NewObject() managed.Object {
+	return &rabbitmqv1alpha1.RabbitMQExchange{}
+}
+
+func (h *exchangeHandler) NewList() runtime.Object {
+	return &rabbitmqv1alpha1.RabbitMQExchangeList{}
+}
+
+// desiredExchange returns the exchange as declared by the resource
+func desiredExchange(cr *rabbitmqv1alpha1.RabbitMQExchange) (*management.Exchange, error) {
+	name := cr.ExchangeName()
+	if strings.HasPrefix(name, "amq.") {
+		return nil, fmt.Errorf("exchange names starting with amq. are reserved by RabbitMQ")
+	}
+	arguments, err := managed.DecodeArguments(cr.Spec.Arguments)
+	if err != nil {
+		return nil, err
+	}
+	return &management.Exchange{
+		Vhost:      cr.ExchangeVhost(),
+		Name:       name,
+		Type:       cr.ExchangeType(),
+		Durable:    cr.IsDurable(),
+		AutoDelete: cr.Spec.AutoDelete,
+		Arguments:  arguments,
+	}, nil
+}
+
+// differences returns the properties of the exchange in the cluster which differ from the declared ones
+func differences(current, desired *management.Exchange) []string {
+	diffs := []string{}
+	if current.Type != desired.Type {
+		diffs = append(diffs, fmt.Sprintf("type %s instead of %s", current.Type, desired.Type))
+	}
+	if current.Durable != desired.Durable {
+		diffs = append(diffs, fmt.Sprintf("durable %t instead of %t", current.Durable, desired.Durable))
+	}
+	if current.AutoDelete != desired.AutoDelete {
+		diffs = append(diffs, fmt.Sprintf("auto_delete %t instead of %t", current.AutoDelete, desired.AutoDelete))
+	}
+	if !managed.EqualArguments(current.Arguments, desired.Arguments) {
+		diffs = append(diffs, fmt.Sprintf("arguments %s instead of %s", managed.FormatArguments(current.Arguments), managed.FormatArguments(desired.Arguments)))
+	}
+	return diffs
+}
+
+// Sync declares the exchange. An existing exchange with other properties is not redeclared,
+// RabbitMQ refuses that, and the resource is marked with the Conflict condition.
+func (h *exchangeHandler) Sync(req *managed.Request, obj managed.Object) error {
+	desired, err := desiredExchange(obj.(*rabbitmqv1alpha1.RabbitMQExchange))
+	if err != nil {
+		return err
+	}
+
+	current, err := req.Management.GetExchange(desired.Vhost, desired.Name)
+	if management.IsNotFound(err) {
+		req.Logger.Info("Declaring the exchange", "Vhost", desired.Vhost, "Exchange", desired.Name)
+		return req.Management.DeclareExchange(desired)
+	}
+	if err != nil {
+		return err
+	}
+	if diffs := differences(current, desired); len(diffs) > 0 {
+		return managed.NewConflict("exchange %s in vhost %s exists with %s; delete it to have it declared as specified",
+			desired.Name, desired.Vhost, strings.Join(diffs, ", "))
+	}
+	return nil
+}
+
+// Delete deletes the exchange with its bindings. An exchange in conflict with the resource was
+// not declared by it and stays.
+func (h *exchangeHandler) Delete(req *managed.Request, obj managed.Object) error {
+	desired, err := desiredExchange(obj.(*rabbitmqv1alpha1.RabbitMQExchange))
+	if err != nil {
+		// nothing was declared
+		return nil
+	}
+	logger := req.Logger.WithValues("Vhost", desired.Vhost, "Exchange", desired.Name)
+
+	current, err := req.Management.GetExchange(desired.Vhost, desired.Name)
+	if management.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if diffs := differences(current, desired); len(diffs) > 0 {
+		logger.Info("Leaving the exchange which differs from the resource", "Differences", strings.Join(diffs, ", "))
+		return nil
+	}
+	logger.Info("Deleting the exchange")
+	if err := req.Management.DeleteExchange(desired.Vhost, desired.Name); err != nil && !management.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package rabbitmqexchange
+
+import (
+	"reflect"
+	"testing"
+
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	"github.com/toha10/rabbitmq-operator/pkg/controller/managed"
+	"github.com/toha10/rabbitmq-operator/pkg/controller/managed/managedtest"
+	"github.com/toha10/rabbitmq-operator/pkg/management/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func newTestExchange(spec rabbitmqv1alpha1.RabbitMQExchangeSpec) *rabbitmqv1alpha1.RabbitMQExchange {
+	return &rabbitmqv1alpha1.RabbitMQExchange{
+		ObjectMeta: metav1.ObjectMeta{Name: "events", Namespace: "ns"},
+		Spec:       spec,
+	}
+}
+
+func TestSync(t *testing.T) {
+	tests := []struct {
+		name      string
+		spec      rabbitmqv1alpha1.RabbitMQExchangeSpec
+		responses map[string]string
+		requests  []string
+		conflict  bool
+		wantErr   bool
+	}{
+		{
+			name: "declared",
+			spec: rabbitmqv1alpha1.RabbitMQExchangeSpec{
+				Vhost:     "shop",
+				Type:      "topic",
+				Arguments: runtime.RawExtension{Raw: []byte(`{"alternate-exchange":"unrouted"}`)},
+			},
+			requests: []string{`PUT /api/exchanges/shop/events {"type":"topic","durable":true,"auto_delete":false,"arguments":{"alternate-exchange":"unrouted"}}`},
+		},
+		{
+			name: "in sync",
+			responses: map[string]string{
+				"GET /api/exchanges/%2F/events": `{"vhost":"/","name":"events","type":"direct","durable":true,"auto_delete":false,"arguments":{}}`,
+			},
+			requests: []string{},
+		},
+		{
+			name: "other type",
+			spec: rabbitmqv1alpha1.RabbitMQExchangeSpec{Type: "fanout"},
+			responses: map[string]string{
+				"GET /api/exchanges/%2F/events": `{"vhost":"/","name":"events","type":"direct","durable":true,"auto_delete":false,"arguments":{}}`,
+			},
+			requests: []string{},
+			conflict: true,
+		},
+		{
+			name:     "reserved name",
+			spec:     rabbitmqv1alpha1.RabbitMQExchangeSpec{Name: "amq.events"},
+			requests: []string{},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := fake.NewServer(tt.responses)
+			defer server.Close()
+			req := managedtest.NewRequest(server)
+			err := (&exchangeHandler{}).Sync(req, newTestExchange(tt.spec))
+			_, conflict := err.(*managed.ConflictError)
+			switch {
+			case conflict != tt.conflict:
+				t.Errorf("got error %v, want a conflict %v", err, tt.conflict)
+			case !conflict && (err != nil) != tt.wantErr:
+				t.Errorf("got error %v, want an error %v", err, tt.wantErr)
+			}
+			if got := server.Requests(); !reflect.DeepEqual(got, tt.requests) {
+				t.Errorf("got requests\n%v\nwant\n%v", got, tt.requests)
+			}
+		})
+	}
+}
+
+func TestDelete(t *testing.T) {
+	tests := []struct {
+		name      string
+		responses map[string]string
+		requests  []string
+	}{
+		{
+			name: "deleted",
+			responses: map[string]string{
+				"GET /api/exchanges/%2F/events": `{"vhost":"/","name":"events","type":"direct","durable":true,"auto_delete":false,"arguments":{}}`,
+			},
+			requests: []string{"DELETE /api/exchanges/%2F/events"},
+		},
+		{
+			name: "in conflict",
+			responses: map[string]string{
+				"GET /api/exchanges/%2F/events": `{"vhost":"/","name":"events","type":"topic","durable":true,"auto_delete":false,"arguments":{}}`,
+			},
+			requests: []string{},
+		},
+		{
+			name:     "missing",
+			requests: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := fake.NewServer(tt.responses)
+			defer server.Close()
+			req := managedtest.NewRequest(server)
+			if err := (&exchangeHandler{}).Delete(req, newTestExchange(rabbitmqv1alpha1.RabbitMQExchangeSpec{})); err != nil {
+				t.Fatal(err)
+			}
+			if got := server.Requests(); !reflect.DeepEqual(got, tt.requests) {
+				t.Errorf("got requests\n%v\nwant\n%v", got, tt.requests)
+			}
+		})
+	}
+}
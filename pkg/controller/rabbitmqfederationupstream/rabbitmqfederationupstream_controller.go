@@ -0,0 +1,113 @@
+package rabbitmqfederationupstream
+
+import (
+	"fmt"
+
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	"github.com/toha10/rabbitmq-operator/pkg/controller/managed"
+	"github.com/toha10/rabbitmq-operator/pkg/management"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// Add creates a new RabbitMQFederationUpstream Controller and adds it to the Manager. The Manager will set fields on the Controller
+// and Start it when the Manager is Started.
+func Add(mgr manager.Manager) error {
+	return managed.Add(mgr, "rabbitmqfederationupstream-controller", &upstreamHandler{})
+}
+
+// upstreamHandler manages the federation upstreams declared by RabbitMQFederationUpstream resources
+type upstreamHandler struct{}
+
+func (h *upstreamHandler) NewObject() managed.Object {
+	return &rabbitmqv1alpha1.RabbitMQFederationUpstream{}
+}
+
+func (h *upstreamHandler) NewList() runtime.Object {
+	return &rabbitmqv1alpha1.RabbitMQFederationUpstreamList{}
+}
+
+// Watch requeues the RabbitMQFederationUpstream when the Secret with its URI changes
+func (h *upstreamHandler) Watch(mgr manager.Manager, c controller.Controller) error {
+	return managed.WatchReferencedSecrets(mgr, c, h, func(obj managed.Object) []string {
+		return []string{obj.(*rabbitmqv1alpha1.RabbitMQFederationUpstream).Spec.URISecret.Name}
+	})
+}
+
+// upstreamValue returns the value of the federation-upstream parameter with the URI read from the Secret
+func upstreamValue(req *managed.Request, cr *rabbitmqv1alpha1.RabbitMQFederationUpstream) (map[string]interface{}, error) {
+	spec := &cr.Spec
+	uri, err := managed.SecretValue(req.Client, cr.Namespace, spec.URISecret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the upstream URI: %v", err)
+	}
+	value := map[string]interface{}{
+		"uri":      uri,
+		"ack-mode": "on-confirm",
+	}
+	if spec.AckMode != "" {
+		value["ack-mode"] = spec.AckMode
+	}
+	if spec.Exchange != "" {
+		value["exchange"] = spec.Exchange
+	}
+	if spec.Queue != "" {
+		value["queue"] = spec.Queue
+	}
+	if spec.PrefetchCount != nil {
+		value["prefetch-count"] = *spec.PrefetchCount
+	}
+	if spec.ReconnectDelay != nil {
+		value["reconnect-delay"] = *spec.ReconnectDelay
+	}
+	if spec.MaxHops != nil {
+		value["max-hops"] = *spec.MaxHops
+	}
+	if spec.Expires != nil {
+		value["expires"] = *spec.Expires
+	}
+	if spec.MessageTTL != nil {
+		value["message-ttl"] = *spec.MessageTTL
+	}
+	if spec.TrustUserID {
+		value["trust-user-id"] = true
+	}
+	return value, nil
+}
+
+// Sync sets the upstream when it is missing or differs from the resource, the federation links
+// using it restart with the new settings
+func (h *upstreamHandler) Sync(req *managed.Request, obj managed.Object) error {
+	cr := obj.(*rabbitmqv1alpha1.RabbitMQFederationUpstream)
+	vhost, name := cr.UpstreamVhost(), cr.UpstreamName()
+	desired, err := upstreamValue(req, cr)
+	if err != nil {
+		return err
+	}
+
+	current, err := req.Management.GetParameter(management.FederationUpstreamComponent, vhost, name)
+	switch {
+	case management.IsNotFound(err):
+		req.Logger.Info("Creating the federation upstream", "Vhost", vhost, "Upstream", name)
+	case err != nil:
+		return err
+	default:
+		if managed.EqualValues(current, desired) {
+			return nil
+		}
+		req.Logger.Info("Updating the federation upstream which differs from the resource", "Vhost", vhost, "Upstream", name)
+	}
+	return req.Management.PutParameter(management.FederationUpstreamComponent, vhost, name, desired)
+}
+
+// Delete deletes the upstream, the links of the exchanges and queues federated with it stop
+func (h *upstreamHandler) Delete(req *managed.Request, obj managed.Object) error {
+	cr := obj.(*rabbitmqv1alpha1.RabbitMQFederationUpstream)
+	vhost, name := cr.UpstreamVhost(), cr.UpstreamName()
+	req.Logger.Info("Deleting the federation upstream", "Vhost", vhost, "Upstream", name)
+	if err := req.Management.DeleteParameter(management.FederationUpstreamComponent, vhost, name); err != nil && !management.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
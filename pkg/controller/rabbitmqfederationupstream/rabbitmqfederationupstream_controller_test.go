@@ -0,0 +1,127 @@
+package rabbitmqfederationupstream
+
+import (
+	"reflect"
+	"testing"
+
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	"github.com/toha10/rabbitmq-operator/pkg/controller/managed/managedtest"
+	"github.com/toha10/rabbitmq-operator/pkg/management/fake"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// newURISecret returns the Secret holding the URI of the upstream
+func newURISecret() *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "eu", Namespace: "ns"},
+		Data:       map[string][]byte{"uri": []byte("amqp://app:secret@eu/orders")},
+	}
+}
+
+func newTestUpstream(spec rabbitmqv1alpha1.RabbitMQFederationUpstreamSpec) *rabbitmqv1alpha1.RabbitMQFederationUpstream {
+	spec.URISecret = corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "eu"}, Key: "uri"}
+	return &rabbitmqv1alpha1.RabbitMQFederationUpstream{
+		ObjectMeta: metav1.ObjectMeta{Name: "eu", Namespace: "ns"},
+		Spec:       spec,
+	}
+}
+
+func TestUpstreamValue(t *testing.T) {
+	maxHops := int32(2)
+	expires := int64(3600000)
+	tests := []struct {
+		name string
+		spec rabbitmqv1alpha1.RabbitMQFederationUpstreamSpec
+		want map[string]interface{}
+	}{
+		{
+			name: "defaults",
+			want: map[string]interface{}{"uri": "amqp://app:secret@eu/orders", "ack-mode": "on-confirm"},
+		},
+		{
+			name: "all settings",
+			spec: rabbitmqv1alpha1.RabbitMQFederationUpstreamSpec{
+				Exchange:    "events",
+				AckMode:     "no-ack",
+				MaxHops:     &maxHops,
+				Expires:     &expires,
+				TrustUserID: true,
+			},
+			want: map[string]interface{}{
+				"uri":           "amqp://app:secret@eu/orders",
+				"ack-mode":      "no-ack",
+				"exchange":      "events",
+				"max-hops":      int32(2),
+				"expires":       int64(3600000),
+				"trust-user-id": true,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := fake.NewServer(nil)
+			defer server.Close()
+			got, err := upstreamValue(managedtest.NewRequest(server, newURISecret()), newTestUpstream(tt.spec))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpstreamValueWithoutSecret(t *testing.T) {
+	server := fake.NewServer(nil)
+	defer server.Close()
+	cr := newTestUpstream(rabbitmqv1alpha1.RabbitMQFederationUpstreamSpec{})
+	cr.Spec.URISecret.Name = "missing"
+	if _, err := upstreamValue(managedtest.NewRequest(server, newURISecret()), cr); err == nil {
+		t.Errorf("no error for a missing Secret")
+	}
+}
+
+func TestSync(t *testing.T) {
+	current := `{"value":{"ack-mode":"on-confirm","uri":"amqp://app:secret@eu/orders"}}`
+	put := `PUT /api/parameters/federation-upstream/%2F/eu ` + current
+	tests := []struct {
+		name      string
+		responses map[string]string
+		requests  []string
+	}{
+		{"created", nil, []string{put}},
+		{"in sync", map[string]string{"GET /api/parameters/federation-upstream/%2F/eu": current}, []string{}},
+		{
+			name:      "changed in the cluster",
+			responses: map[string]string{"GET /api/parameters/federation-upstream/%2F/eu": `{"value":{"ack-mode":"no-ack","uri":"amqp://app:secret@eu/orders"}}`},
+			requests:  []string{put},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := fake.NewServer(tt.responses)
+			defer server.Close()
+			cr := newTestUpstream(rabbitmqv1alpha1.RabbitMQFederationUpstreamSpec{})
+			if err := (&upstreamHandler{}).Sync(managedtest.NewRequest(server, newURISecret()), cr); err != nil {
+				t.Fatal(err)
+			}
+			if got := server.Requests(); !reflect.DeepEqual(got, tt.requests) {
+				t.Errorf("got requests\n%v\nwant\n%v", got, tt.requests)
+			}
+		})
+	}
+}
+
+func TestDelete(t *testing.T) {
+	server := fake.NewServer(nil)
+	defer server.Close()
+	cr := newTestUpstream(rabbitmqv1alpha1.RabbitMQFederationUpstreamSpec{Vhost: "orders"})
+	if err := (&upstreamHandler{}).Delete(managedtest.NewRequest(server, newURISecret()), cr); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := server.Requests(), []string{"DELETE /api/parameters/federation-upstream/orders/eu"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got requests %v, want %v", got, want)
+	}
+}
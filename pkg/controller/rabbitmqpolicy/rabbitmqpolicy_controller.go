@@ -0,0 +1,128 @@
+package rabbitmqpolicy
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	"github.com/toha10/rabbitmq-operator/pkg/controller/managed"
+	"github.com/toha10/rabbitmq-operator/pkg/management"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// Add creates a new RabbitMQPolicy Controller and adds it to the Manager. The Manager will set fields on the Controller
+// and Start it when the Manager is Started.
+func Add(mgr manager.Manager) error {
+	return managed.Add(mgr, "rabbitmqpolicy-controller", &policyHandler{
+		kind:           management.UserPolicy,
+		defaultApplyTo: "all",
+		newObject:      func() managed.Object { return &rabbitmqv1alpha1.RabbitMQPolicy{} },
+		newList:        func() runtime.Object { return &rabbitmqv1alpha1.RabbitMQPolicyList{} },
+	})
+}
+
+// AddOperatorPolicy creates a new RabbitMQOperatorPolicy Controller and adds it to the Manager
+func AddOperatorPolicy(mgr manager.Manager) error {
+	return managed.Add(mgr, "rabbitmqoperatorpolicy-controller", &policyHandler{
+		kind:           management.OperatorPolicy,
+		defaultApplyTo: "queues",
+		newObject:      func() managed.Object { return &rabbitmqv1alpha1.RabbitMQOperatorPolicy{} },
+		newList:        func() runtime.Object { return &rabbitmqv1alpha1.RabbitMQOperatorPolicyList{} },
+	})
+}
+
+// policyHandler manages the policies or the operator policies, the resources of both kinds share the spec
+type policyHandler struct {
+	kind           management.PolicyKind
+	defaultApplyTo string
+	newObject      func() managed.Object
+	newList        func() runtime.Object
+}
+
+func (h *policyHandler) NewObject() managed.Object {
+	return h.newObject()
+}
+
+func (h *policyHandler) NewList() runtime.Object {
+	return h.newList()
+}
+
+// policySpec returns the spec of a RabbitMQPolicy or a RabbitMQOperatorPolicy
+func policySpec(obj managed.Object) *rabbitmqv1alpha1.PolicySpec {
+	switch cr := obj.(type) {
+	case *rabbitmqv1alpha1.RabbitMQPolicy:
+		return &cr.Spec
+	case *rabbitmqv1alpha1.RabbitMQOperatorPolicy:
+		return &cr.Spec
+	}
+	panic(fmt.Sprintf("unexpected policy resource %T", obj))
+}
+
+// policyName returns the name of the policy in the cluster
+func policyName(obj managed.Object) string {
+	if name := policySpec(obj).Name; name != "" {
+		return name
+	}
+	return obj.GetName()
+}
+
+// desiredPolicy returns the policy as declared by the resource
+func (h *policyHandler) desiredPolicy(obj managed.Object) (*management.Policy, error) {
+	spec := policySpec(obj)
+	policy := &management.Policy{
+		Vhost:      spec.PolicyVhost(),
+		Name:       policyName(obj),
+		Pattern:    spec.Pattern,
+		ApplyTo:    spec.ApplyTo,
+		Priority:   spec.Priority,
+		Definition: map[string]interface{}{},
+	}
+	if policy.ApplyTo == "" {
+		policy.ApplyTo = h.defaultApplyTo
+	}
+	if len(spec.Definition.Raw) > 0 {
+		if err := json.Unmarshal(spec.Definition.Raw, &policy.Definition); err != nil {
+			return nil, fmt.Errorf("definition is not a JSON object: %v", err)
+		}
+	}
+	return policy, nil
+}
+
+// Sync sets the policy when it is missing or differs from the resource, which reverts
+// the changes made to it in the cluster
+func (h *policyHandler) Sync(req *managed.Request, obj managed.Object) error {
+	desired, err := h.desiredPolicy(obj)
+	if err != nil {
+		return err
+	}
+	logger := req.Logger.WithValues("Vhost", desired.Vhost, "Policy", desired.Name)
+
+	current, err := req.Management.GetPolicy(h.kind, desired.Vhost, desired.Name)
+	switch {
+	case management.IsNotFound(err):
+		logger.Info("Creating the policy", "Kind", h.kind)
+	case err != nil:
+		return err
+	default:
+		current.Vhost = desired.Vhost
+		current.Name = desired.Name
+		if reflect.DeepEqual(current, desired) {
+			return nil
+		}
+		logger.Info("Updating the policy which differs from the resource", "Kind", h.kind)
+	}
+	return req.Management.PutPolicy(h.kind, desired)
+}
+
+// Delete deletes the policy
+func (h *policyHandler) Delete(req *managed.Request, obj managed.Object) error {
+	vhost := policySpec(obj).PolicyVhost()
+	name := policyName(obj)
+	req.Logger.Info("Deleting the policy", "Kind", h.kind, "Vhost", vhost, "Policy", name)
+	if err := req.Management.DeletePolicy(h.kind, vhost, name); err != nil && !management.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package rabbitmqpolicy
+
+import (
+	"reflect"
+	"testing"
+
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	"github.com/toha10/rabbitmq-operator/pkg/controller/managed/managedtest"
+	"github.com/toha10/rabbitmq-operator/pkg/management"
+	"github.com/toha10/rabbitmq-operator/pkg/management/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestSync(t *testing.T) {
+	tests := []struct {
+		name      string
+		handler   *policyHandler
+		spec      rabbitmqv1alpha1.PolicySpec
+		responses map[string]string
+		requests  []string
+	}{
+		{
+			name:    "created",
+			handler: &policyHandler{kind: management.UserPolicy, defaultApplyTo: "all"},
+			spec: rabbitmqv1alpha1.PolicySpec{
+				Pattern:    "^orders",
+				Definition: runtime.RawExtension{Raw: []byte(`{"ha-mode":"all"}`)},
+			},
+			requests: []string{
+				`PUT /api/policies/%2F/ha {"pattern":"^orders","apply-to":"all","priority":0,"definition":{"ha-mode":"all"}}`,
+			},
+		},
+		{
+			name:    "operator policy created",
+			handler: &policyHandler{kind: management.OperatorPolicy, defaultApplyTo: "queues"},
+			spec: rabbitmqv1alpha1.PolicySpec{
+				Name:       "limits",
+				Vhost:      "orders",
+				Pattern:    ".*",
+				Priority:   1,
+				Definition: runtime.RawExtension{Raw: []byte(`{"max-length":100}`)},
+			},
+			requests: []string{
+				`PUT /api/operator-policies/orders/limits {"pattern":".*","apply-to":"queues","priority":1,"definition":{"max-length":100}}`,
+			},
+		},
+		{
+			name:    "in sync",
+			handler: &policyHandler{kind: management.UserPolicy, defaultApplyTo: "all"},
+			spec: rabbitmqv1alpha1.PolicySpec{
+				Pattern:    "^orders",
+				Definition: runtime.RawExtension{Raw: []byte(`{"ha-mode":"all"}`)},
+			},
+			responses: map[string]string{
+				"GET /api/policies/%2F/ha": `{"vhost":"/","name":"ha","pattern":"^orders","apply-to":"all","priority":0,"definition":{"ha-mode":"all"}}`,
+			},
+			requests: []string{},
+		},
+		{
+			name:    "changed in the cluster",
+			handler: &policyHandler{kind: management.UserPolicy, defaultApplyTo: "all"},
+			spec: rabbitmqv1alpha1.PolicySpec{
+				Pattern:    "^orders",
+				Definition: runtime.RawExtension{Raw: []byte(`{"ha-mode":"all"}`)},
+			},
+			responses: map[string]string{
+				"GET /api/policies/%2F/ha": `{"vhost":"/","name":"ha","pattern":"^orders","apply-to":"all","priority":0,"definition":{"ha-mode":"exactly","ha-params":2}}`,
+			},
+			requests: []string{
+				`PUT /api/policies/%2F/ha {"pattern":"^orders","apply-to":"all","priority":0,"definition":{"ha-mode":"all"}}`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := fake.NewServer(tt.responses)
+			defer server.Close()
+			cr := &rabbitmqv1alpha1.RabbitMQPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "ha", Namespace: "ns"},
+				Spec:       tt.spec,
+			}
+			req := managedtest.NewRequest(server)
+			if err := tt.handler.Sync(req, cr); err != nil {
+				t.Fatal(err)
+			}
+			if got := server.Requests(); !reflect.DeepEqual(got, tt.requests) {
+				t.Errorf("got requests\n%v\nwant\n%v", got, tt.requests)
+			}
+		})
+	}
+}
+
+func TestSyncRefusesInvalidDefinition(t *testing.T) {
+	server := fake.NewServer(nil)
+	defer server.Close()
+	cr := &rabbitmqv1alpha1.RabbitMQPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "ha", Namespace: "ns"},
+		Spec: rabbitmqv1alpha1.PolicySpec{
+			Pattern:    ".*",
+			Definition: runtime.RawExtension{Raw: []byte(`["ha-mode"]`)},
+		},
+	}
+	req := managedtest.NewRequest(server)
+	if err := (&policyHandler{kind: management.UserPolicy}).Sync(req, cr); err == nil {
+		t.Errorf("no error for a definition which is not an object")
+	}
+}
+
+func TestDelete(t *testing.T) {
+	server := fake.NewServer(nil)
+	defer server.Close()
+	cr := &rabbitmqv1alpha1.RabbitMQOperatorPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "limits", Namespace: "ns"},
+		Spec:       rabbitmqv1alpha1.PolicySpec{Vhost: "orders"},
+	}
+	req := managedtest.NewRequest(server)
+	if err := (&policyHandler{kind: management.OperatorPolicy}).Delete(req, cr); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := server.Requests(), []string{"DELETE /api/operator-policies/orders/limits"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got requests %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,143 @@
+package rabbitmqqueue
+
+import (
+	"fmt"
+	"strings"
+
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	"github.com/toha10/rabbitmq-operator/pkg/controller/managed"
+	"github.com/toha10/rabbitmq-operator/pkg/management"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// queueTypeArgument is the queue argument selecting the type of the queue
+const queueTypeArgument = "x-queue-type"
+
+// Add creates a new RabbitMQQueue Controller and adds it to the Manager. The Manager will set fields on the Controller
+// and Start it when the Manager is Started.
+func Add(mgr manager.Manager) error {
+	return managed.Add(mgr, "rabbitmqqueue-controller", &queueHandler{})
+}
+
+// queueHandler manages the queues declared by RabbitMQQueue resources
+type queueHandler struct{}
+
+func (h *queueHandler) NewObject() managed.Object {
+	return &rabbitmqv1alpha1.RabbitMQQueue{}
+}
+
+func (h *queueHandler) NewList() runtime.Object {
+	return &rabbitmqv1alpha1.RabbitMQQueueList{}
+}
+
+// queueArguments returns the declared arguments of the queue without the queue type
+func queueArguments(cr *rabbitmqv1alpha1.RabbitMQQueue) (map[string]interface{}, error) {
+	arguments, err := managed.DecodeArguments(cr.Spec.Arguments)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := arguments[queueTypeArgument]; ok {
+		return nil, fmt.Errorf("the queue type is set with the type field, not the %s argument", queueTypeArgument)
+	}
+	switch cr.Spec.Type {
+	case "", rabbitmqv1alpha1.ClassicQueue:
+	case rabbitmqv1alpha1.QuorumQueue, rabbitmqv1alpha1.StreamQueue:
+		if !cr.IsDurable() || cr.Spec.AutoDelete {
+			return nil, fmt.Errorf("%s queues are always durable and can't be auto-deleted", cr.Spec.Type)
+		}
+	default:
+		return nil, fmt.Errorf("unknown queue type %q", cr.Spec.Type)
+	}
+	return arguments, nil
+}
+
+// differences returns the properties of the queue in the cluster which differ from the declared ones
+func differences(cr *rabbitmqv1alpha1.RabbitMQQueue, arguments map[string]interface{}, queue *management.Queue) []string {
+	diffs := []string{}
+	if cr.Spec.Type != "" && queue.Type != cr.Spec.Type {
+		diffs = append(diffs, fmt.Sprintf("type %s instead of %s", queue.Type, cr.Spec.Type))
+	}
+	if queue.Durable != cr.IsDurable() {
+		diffs = append(diffs, fmt.Sprintf("durable %t instead of %t", queue.Durable, cr.IsDurable()))
+	}
+	if queue.AutoDelete != cr.Spec.AutoDelete {
+		diffs = append(diffs, fmt.Sprintf("auto_delete %t instead of %t", queue.AutoDelete, cr.Spec.AutoDelete))
+	}
+	current := map[string]interface{}{}
+	for k, v := range queue.Arguments {
+		if k != queueTypeArgument {
+			current[k] = v
+		}
+	}
+	if !managed.EqualArguments(current, arguments) {
+		diffs = append(diffs, fmt.Sprintf("arguments %s instead of %s", managed.FormatArguments(current), managed.FormatArguments(arguments)))
+	}
+	return diffs
+}
+
+// Sync declares the queue. An existing queue with other properties is neither redeclared, RabbitMQ
+// refuses that, nor deleted with its messages; the resource is marked with the Conflict condition.
+func (h *queueHandler) Sync(req *managed.Request, obj managed.Object) error {
+	cr := obj.(*rabbitmqv1alpha1.RabbitMQQueue)
+	vhost, name := cr.QueueVhost(), cr.QueueName()
+	arguments, err := queueArguments(cr)
+	if err != nil {
+		return err
+	}
+
+	queue, err := req.Management.GetQueue(vhost, name)
+	if management.IsNotFound(err) {
+		req.Logger.Info("Declaring the queue", "Vhost", vhost, "Queue", name, "Type", cr.Spec.Type)
+		declared := map[string]interface{}{}
+		for k, v := range arguments {
+			declared[k] = v
+		}
+		if cr.Spec.Type != "" {
+			declared[queueTypeArgument] = cr.Spec.Type
+		}
+		return req.Management.DeclareQueue(vhost, name, cr.IsDurable(), cr.Spec.AutoDelete, declared)
+	}
+	if err != nil {
+		return err
+	}
+	if diffs := differences(cr, arguments, queue); len(diffs) > 0 {
+		return managed.NewConflict("queue %s in vhost %s exists with %s; it holds %d messages and is not redeclared, delete it to have it declared as specified",
+			name, vhost, strings.Join(diffs, ", "), queue.Messages)
+	}
+	return nil
+}
+
+// Delete deletes the queue. A queue with messages is only deleted when the resource allows it,
+// otherwise the resource stays until the queue is drained. A queue in conflict with the resource
+// was not declared by it and stays.
+func (h *queueHandler) Delete(req *managed.Request, obj managed.Object) error {
+	cr := obj.(*rabbitmqv1alpha1.RabbitMQQueue)
+	vhost, name := cr.QueueVhost(), cr.QueueName()
+	logger := req.Logger.WithValues("Vhost", vhost, "Queue", name)
+
+	queue, err := req.Management.GetQueue(vhost, name)
+	if management.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if arguments, err := queueArguments(cr); err != nil {
+		// nothing was declared
+		return nil
+	} else if diffs := differences(cr, arguments, queue); len(diffs) > 0 {
+		logger.Info("Leaving the queue which differs from the resource", "Differences", strings.Join(diffs, ", "))
+		return nil
+	}
+	if queue.Messages > 0 && !cr.Spec.DeleteWithMessages {
+		return fmt.Errorf("queue %s still holds %d messages, it is deleted once drained or when delete_with_messages is set", name, queue.Messages)
+	}
+
+	logger.Info("Deleting the queue", "Messages", queue.Messages)
+	// the message count of the management API lags behind, the broker checks the queue is empty again
+	if err := req.Management.DeleteQueue(vhost, name, !cr.Spec.DeleteWithMessages); err != nil && !management.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
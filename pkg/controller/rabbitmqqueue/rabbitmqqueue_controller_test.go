@@ -0,0 +1,198 @@
+package rabbitmqqueue
+
+import (
+	"reflect"
+	"testing"
+
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	"github.com/toha10/rabbitmq-operator/pkg/controller/managed"
+	"github.com/toha10/rabbitmq-operator/pkg/controller/managed/managedtest"
+	"github.com/toha10/rabbitmq-operator/pkg/management/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func newBool(b bool) *bool {
+	return &b
+}
+
+func newTestQueue(spec rabbitmqv1alpha1.RabbitMQQueueSpec) *rabbitmqv1alpha1.RabbitMQQueue {
+	return &rabbitmqv1alpha1.RabbitMQQueue{
+		ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "ns"},
+		Spec:       spec,
+	}
+}
+
+func TestQueueArguments(t *testing.T) {
+	tests := []struct {
+		name string
+		spec rabbitmqv1alpha1.RabbitMQQueueSpec
+		want map[string]interface{}
+	}{
+		{
+			name: "no arguments",
+			want: map[string]interface{}{},
+		},
+		{
+			name: "quorum queue",
+			spec: rabbitmqv1alpha1.RabbitMQQueueSpec{
+				Type:      rabbitmqv1alpha1.QuorumQueue,
+				Arguments: runtime.RawExtension{Raw: []byte(`{"x-max-length":100}`)},
+			},
+			want: map[string]interface{}{"x-max-length": float64(100)},
+		},
+		{
+			name: "queue type argument",
+			spec: rabbitmqv1alpha1.RabbitMQQueueSpec{Arguments: runtime.RawExtension{Raw: []byte(`{"x-queue-type":"quorum"}`)}},
+		},
+		{
+			name: "transient quorum queue",
+			spec: rabbitmqv1alpha1.RabbitMQQueueSpec{Type: rabbitmqv1alpha1.QuorumQueue, Durable: newBool(false)},
+		},
+		{
+			name: "auto-deleted stream",
+			spec: rabbitmqv1alpha1.RabbitMQQueueSpec{Type: rabbitmqv1alpha1.StreamQueue, AutoDelete: true},
+		},
+		{
+			name: "unknown type",
+			spec: rabbitmqv1alpha1.RabbitMQQueueSpec{Type: "lazy"},
+		},
+		{
+			name: "arguments not an object",
+			spec: rabbitmqv1alpha1.RabbitMQQueueSpec{Arguments: runtime.RawExtension{Raw: []byte(`[]`)}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := queueArguments(newTestQueue(tt.spec))
+			if tt.want == nil {
+				if err == nil {
+					t.Errorf("no error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSync(t *testing.T) {
+	tests := []struct {
+		name      string
+		spec      rabbitmqv1alpha1.RabbitMQQueueSpec
+		responses map[string]string
+		requests  []string
+		conflict  bool
+	}{
+		{
+			name:     "declared",
+			spec:     rabbitmqv1alpha1.RabbitMQQueueSpec{Type: rabbitmqv1alpha1.QuorumQueue},
+			requests: []string{`PUT /api/queues/%2F/orders {"arguments":{"x-queue-type":"quorum"},"auto_delete":false,"durable":true}`},
+		},
+		{
+			name: "in sync",
+			spec: rabbitmqv1alpha1.RabbitMQQueueSpec{Type: rabbitmqv1alpha1.QuorumQueue},
+			responses: map[string]string{
+				"GET /api/queues/%2F/orders": `{"name":"orders","vhost":"/","type":"quorum","durable":true,"arguments":{"x-queue-type":"quorum"}}`,
+			},
+			requests: []string{},
+		},
+		{
+			name: "other type",
+			spec: rabbitmqv1alpha1.RabbitMQQueueSpec{Type: rabbitmqv1alpha1.QuorumQueue},
+			responses: map[string]string{
+				"GET /api/queues/%2F/orders": `{"name":"orders","vhost":"/","type":"classic","durable":true,"messages":3}`,
+			},
+			requests: []string{},
+			conflict: true,
+		},
+		{
+			name: "other arguments",
+			spec: rabbitmqv1alpha1.RabbitMQQueueSpec{Arguments: runtime.RawExtension{Raw: []byte(`{"x-max-length":100}`)}},
+			responses: map[string]string{
+				"GET /api/queues/%2F/orders": `{"name":"orders","vhost":"/","type":"classic","durable":true,"arguments":{"x-max-length":10}}`,
+			},
+			requests: []string{},
+			conflict: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := fake.NewServer(tt.responses)
+			defer server.Close()
+			req := managedtest.NewRequest(server)
+			err := (&queueHandler{}).Sync(req, newTestQueue(tt.spec))
+			if _, ok := err.(*managed.ConflictError); ok != tt.conflict {
+				t.Errorf("got error %v, want a conflict %v", err, tt.conflict)
+			} else if err != nil && !tt.conflict {
+				t.Fatal(err)
+			}
+			if got := server.Requests(); !reflect.DeepEqual(got, tt.requests) {
+				t.Errorf("got requests\n%v\nwant\n%v", got, tt.requests)
+			}
+		})
+	}
+}
+
+func TestDelete(t *testing.T) {
+	tests := []struct {
+		name      string
+		spec      rabbitmqv1alpha1.RabbitMQQueueSpec
+		responses map[string]string
+		requests  []string
+		wantErr   bool
+	}{
+		{
+			name: "empty",
+			responses: map[string]string{
+				"GET /api/queues/%2F/orders": `{"name":"orders","vhost":"/","type":"classic","durable":true}`,
+			},
+			requests: []string{"DELETE /api/queues/%2F/orders?if-empty=true"},
+		},
+		{
+			name: "with messages",
+			responses: map[string]string{
+				"GET /api/queues/%2F/orders": `{"name":"orders","vhost":"/","type":"classic","durable":true,"messages":3}`,
+			},
+			requests: []string{},
+			wantErr:  true,
+		},
+		{
+			name: "deleted with messages",
+			spec: rabbitmqv1alpha1.RabbitMQQueueSpec{DeleteWithMessages: true},
+			responses: map[string]string{
+				"GET /api/queues/%2F/orders": `{"name":"orders","vhost":"/","type":"classic","durable":true,"messages":3}`,
+			},
+			requests: []string{"DELETE /api/queues/%2F/orders"},
+		},
+		{
+			name: "in conflict",
+			responses: map[string]string{
+				"GET /api/queues/%2F/orders": `{"name":"orders","vhost":"/","type":"classic","durable":false}`,
+			},
+			requests: []string{},
+		},
+		{
+			name:     "missing",
+			requests: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := fake.NewServer(tt.responses)
+			defer server.Close()
+			req := managedtest.NewRequest(server)
+			if err := (&queueHandler{}).Delete(req, newTestQueue(tt.spec)); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want an error %v", err, tt.wantErr)
+			}
+			if got := server.Requests(); !reflect.DeepEqual(got, tt.requests) {
+				t.Errorf("got requests\n%v\nwant\n%v", got, tt.requests)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package rabbitmqshovel
+
+import (
+	"fmt"
+
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	"github.com/toha10/rabbitmq-operator/pkg/controller/managed"
+	"github.com/toha10/rabbitmq-operator/pkg/management"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// amqpProtocol is the protocol of the shovel endpoints
+const amqpProtocol = "amqp091"
+
+// Add creates a new RabbitMQShovel Controller and adds it to the Manager. The Manager will set fields on the Controller
+// and Start it when the Manager is Started.
+func Add(mgr manager.Manager) error {
+	return managed.Add(mgr, "rabbitmqshovel-controller", &shovelHandler{})
+}
+
+// shovelHandler manages the dynamic shovels declared by RabbitMQShovel resources
+type shovelHandler struct{}
+
+func (h *shovelHandler) NewObject() managed.Object {
+	return &rabbitmqv1alpha1.RabbitMQShovel{}
+}
+
+func (h *shovelHandler) NewList() runtime.Object {
+	return &rabbitmqv1alpha1.RabbitMQShovelList{}
+}
+
+// Watch requeues the RabbitMQShovel when a Secret with the URI of its source or destination changes
+func (h *shovelHandler) Watch(mgr manager.Manager, c controller.Controller) error {
+	return managed.WatchReferencedSecrets(mgr, c, h, func(obj managed.Object) []string {
+		cr := obj.(*rabbitmqv1alpha1.RabbitMQShovel)
+		return []string{cr.Spec.Source.URISecret.Name, cr.Spec.Destination.URISecret.Name}
+	})
+}
+
+// endpointValue adds the settings of the endpoint to the value of the shovel, prefix is src or dest
+func endpointValue(req *managed.Request, cr *rabbitmqv1alpha1.RabbitMQShovel, endpoint *rabbitmqv1alpha1.ShovelEndpoint, prefix string, value map[string]interface{}) error {
+	if endpoint.Queue != "" && endpoint.Exchange != "" {
+		return fmt.Errorf("the %s of the shovel can't have both a queue and an exchange", prefix)
+	}
+	uri, err := managed.SecretValue(req.Client, cr.Namespace, endpoint.URISecret)
+	if err != nil {
+		return fmt.Errorf("failed to read the %s URI: %v", prefix, err)
+	}
+	value[prefix+"-protocol"] = amqpProtocol
+	value[prefix+"-uri"] = uri
+	if endpoint.Queue != "" {
+		value[prefix+"-queue"] = endpoint.Queue
+	}
+	if endpoint.Exchange != "" {
+		value[prefix+"-exchange"] = endpoint.Exchange
+	}
+	if endpoint.ExchangeKey != "" {
+		value[prefix+"-exchange-key"] = endpoint.ExchangeKey
+	}
+	return nil
+}
+
+// shovelValue returns the value of the shovel parameter with the URIs read from the Secrets
+func shovelValue(req *managed.Request, cr *rabbitmqv1alpha1.RabbitMQShovel) (map[string]interface{}, error) {
+	if cr.Spec.Source.Queue == "" && cr.Spec.Source.Exchange == "" {
+		return nil, fmt.Errorf("the source of the shovel needs a queue or an exchange")
+	}
+	value := map[string]interface{}{}
+	if err := endpointValue(req, cr, &cr.Spec.Source, "src", value); err != nil {
+		return nil, err
+	}
+	if err := endpointValue(req, cr, &cr.Spec.Destination, "dest", value); err != nil {
+		return nil, err
+	}
+
+	value["ack-mode"] = "on-confirm"
+	if cr.Spec.AckMode != "" {
+		value["ack-mode"] = cr.Spec.AckMode
+	}
+	if cr.Spec.PrefetchCount != nil {
+		value["src-prefetch-count"] = *cr.Spec.PrefetchCount
+	}
+	if cr.Spec.ReconnectDelay != nil {
+		value["reconnect-delay"] = *cr.Spec.ReconnectDelay
+	}
+	return value, nil
+}
+
+// Sync sets the shovel when it is missing or differs from the resource, the shovel restarts
+// with the new settings
+func (h *shovelHandler) Sync(req *managed.Request, obj managed.Object) error {
+	cr := obj.(*rabbitmqv1alpha1.RabbitMQShovel)
+	vhost, name := cr.ShovelVhost(), cr.ShovelName()
+	desired, err := shovelValue(req, cr)
+	if err != nil {
+		return err
+	}
+
+	current, err := req.Management.GetParameter(management.ShovelComponent, vhost, name)
+	switch {
+	case management.IsNotFound(err):
+		req.Logger.Info("Creating the shovel", "Vhost", vhost, "Shovel", name)
+	case err != nil:
+		return err
+	default:
+		if managed.EqualValues(current, desired) {
+			return nil
+		}
+		req.Logger.Info("Updating the shovel which differs from the resource", "Vhost", vhost, "Shovel", name)
+	}
+	return req.Management.PutParameter(management.ShovelComponent, vhost, name, desired)
+}
+
+// Delete deletes the shovel
+func (h *shovelHandler) Delete(req *managed.Request, obj managed.Object) error {
+	cr := obj.(*rabbitmqv1alpha1.RabbitMQShovel)
+	vhost, name := cr.ShovelVhost(), cr.ShovelName()
+	req.Logger.Info("Deleting the shovel", "Vhost", vhost, "Shovel", name)
+	if err := req.Management.DeleteParameter(management.ShovelComponent, vhost, name); err != nil && !management.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package rabbitmquser
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	"github.com/toha10/rabbitmq-operator/pkg/controller/managed"
+	"github.com/toha10/rabbitmq-operator/pkg/controller/rabbitmq"
+	"github.com/toha10/rabbitmq-operator/pkg/management"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	// the keys of the credentials Secret
+	usernameKey = "username"
+	passwordKey = "password"
+	hostKey     = "host"
+	portKey     = "port"
+	// uriKey is the URI of the first vhost of the permissions, the URIs of all of them
+	// are under the keys with the uriKey prefix followed by a dot and the vhost name
+	uriKey = "uri"
+
+	amqpPort = 5672
+)
+
+// invalidKeyChars are the characters not allowed in the keys of a Secret
+var invalidKeyChars = regexp.MustCompile(`[^-._a-zA-Z0-9]`)
+
+// Add creates a new RabbitMQUser Controller and adds it to the Manager. The Manager will set fields on the Controller
+// and Start it when the Manager is Started.
+func Add(mgr manager.Manager) error {
+	return managed.Add(mgr, "rabbitmquser-controller", &userHandler{})
+}
+
+// userHandler manages the users declared by RabbitMQUser resources
+type userHandler struct{}
+
+func (h *userHandler) NewObject() managed.Object {
+	return &rabbitmqv1alpha1.RabbitMQUser{}
+}
+
+func (h *userHandler) NewList() runtime.Object {
+	return &rabbitmqv1alpha1.RabbitMQUserList{}
+}
+
+// Watch requeues the RabbitMQUser when its credentials Secret changes
+func (h *userHandler) Watch(mgr manager.Manager, c controller.Controller) error {
+	return c.Watch(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestForOwner{
+		IsController: true,
+		OwnerType:    &rabbitmqv1alpha1.RabbitMQUser{},
+	})
+}
+
+// secretName returns the name of the Secret with the credentials of the user
+func secretName(cr *rabbitmqv1alpha1.RabbitMQUser) string {
+	return cr.Name + "-credentials"
+}
+
+// amqpURI returns the URI clients connect to the vhost of the cluster with
+func amqpURI(cluster *rabbitmqv1alpha1.RabbitMQ, username, password, vhost string) string {
+	return fmt.Sprintf("amqp://%s@%s:%d/%s", url.UserPassword(username, password).String(), rabbitmq.ClientHost(cluster), amqpPort, url.PathEscape(vhost))
+}
+
+// uriKeyForVhost returns the key of the URI of the vhost in the credentials Secret
+func uriKeyForVhost(vhost string) string {
+	if vhost == "/" {
+		return uriKey + ".default"
+	}
+	return uriKey + "." + invalidKeyChars.ReplaceAllString(vhost, "_")
+}
+
+// secretData returns the contents of the credentials Secret
+func secretData(cluster *rabbitmqv1alpha1.RabbitMQ, cr *rabbitmqv1alpha1.RabbitMQUser, password string) map[string][]byte {
+	username := cr.Username()
+	data := map[string][]byte{
+		usernameKey: []byte(username),
+		passwordKey: []byte(password),
+		hostKey:     []byte(rabbitmq.ClientHost(cluster)),
+		portKey:     []byte(fmt.Sprint(amqpPort)),
+	}
+	vhosts := []string{}
+	for _, p := range cr.Spec.Permissions {
+		vhosts = append(vhosts, p.Vhost)
+	}
+	if len(vhosts) == 0 {
+		vhosts = append(vhosts, "/")
+	}
+	data[uriKey] = []byte(amqpURI(cluster, username, password, vhosts[0]))
+	for _, vhost := range vhosts {
+		data[uriKeyForVhost(vhost)] = []byte(amqpURI(cluster, username, password, vhost))
+	}
+	return data
+}
+
+// reconcileSecret makes sure the credentials Secret of the user exists and returns the password.
+// The password is generated once and kept, the other keys follow the spec.
+func (h *userHandler) reconcileSecret(req *managed.Request, cr *rabbitmqv1alpha1.RabbitMQUser) (string, error) {
+	secret := &corev1.Secret{}
+	err := req.Client.Get(context.TODO(), types.NamespacedName{Name: secretName(cr), Namespace: cr.Namespace}, secret)
+	if err != nil && !errors.IsNotFound(err) {
+		return "", err
+	}
+
+	if errors.IsNotFound(err) {
+		password, err := rabbitmq.GeneratePassword()
+		if err != nil {
+			return "", err
+		}
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      secretName(cr),
+				Namespace: cr.Namespace,
+			},
+			Type: corev1.SecretTypeOpaque,
+			Data: secretData(req.Cluster, cr, password),
+		}
+		if err := controllerutil.SetControllerReference(cr, secret, req.Scheme); err != nil {
+			return "", err
+		}
+		req.Logger.Info("Creating the credentials Secret", "Secret.Namespace", secret.Namespace, "Secret.Name", secret.Name)
+		return password, req.Client.Create(context.TODO(), secret)
+	}
+
+	password := string(secret.Data[passwordKey])
+	if password == "" {
+		return "", fmt.Errorf("secret %s has no %q key, delete it to generate a new password", secret.Name, passwordKey)
+	}
+	data := secretData(req.Cluster, cr, password)
+	if !reflect.DeepEqual(secret.Data, data) {
+		req.Logger.Info("Updating the credentials Secret", "Secret.Namespace", secret.Namespace, "Secret.Name", secret.Name)
+		secret.Data = data
+		if err := req.Client.Update(context.TODO(), secret); err != nil {
+			return "", err
+		}
+	}
+	return password, nil
+}
+
+// Sync creates the user with the password of the credentials Secret, sets its tags and
+// makes its permissions match the spec
+func (h *userHandler) Sync(req *managed.Request, obj managed.Object) error {
+	cr := obj.(*rabbitmqv1alpha1.RabbitMQUser)
+	mgmt := req.Management
+	username := cr.Username()
+	if username == mgmt.Username() {
+		return fmt.Errorf("user %s is reserved for the operator", username)
+	}
+
+	password, err := h.reconcileSecret(req, cr)
+	if err != nil {
+		return err
+	}
+	// the password can't be read back from the cluster, so the user is always written
+	if err := mgmt.PutUser(username, password, cr.Spec.Tags); err != nil {
+		return err
+	}
+
+	current, err := mgmt.ListUserPermissions(username)
+	if err != nil {
+		return err
+	}
+	desired := map[string]management.Permissions{}
+	for _, p := range cr.Spec.Permissions {
+		desired[p.Vhost] = management.Permissions{Configure: p.Configure, Write: p.Write, Read: p.Read}
+	}
+	for _, p := range current {
+		if _, ok := desired[p.Vhost]; ok {
+			continue
+		}
+		req.Logger.Info("Revoking the permissions of the user", "User", username, "Vhost", p.Vhost)
+		if err := mgmt.ClearPermissions(p.Vhost, username); err != nil && !management.IsNotFound(err) {
+			return err
+		}
+	}
+	for vhost, permissions := range desired {
+		found := false
+		for _, p := range current {
+			if p.Vhost == vhost && p.Permissions == permissions {
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+		req.Logger.Info("Setting the permissions of the user", "User", username, "Vhost", vhost)
+		if err := mgmt.SetPermissions(vhost, username, permissions); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete deletes the user, its credentials Secret is garbage collected with the resource
+func (h *userHandler) Delete(req *managed.Request, obj managed.Object) error {
+	cr := obj.(*rabbitmqv1alpha1.RabbitMQUser)
+	username := cr.Username()
+	if username == req.Management.Username() {
+		return nil
+	}
+	req.Logger.Info("Deleting the user", "User", username)
+	if err := req.Management.DeleteUser(username); err != nil && !management.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package rabbitmqvhost
+
+import (
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	"github.com/toha10/rabbitmq-operator/pkg/controller/managed"
+	"github.com/toha10/rabbitmq-operator/pkg/management"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// Add creates a new RabbitMQVhost Controller and adds it to the Manager. The Manager will set fields on the Controller
+// and Start it when the Manager is Started.
+func Add(mgr manager.Manager) error {
+	return managed.Add(mgr, "rabbitmqvhost-controller", &vhostHandler{})
+}
+
+// vhostHandler manages the vhosts declared by RabbitMQVhost resources
+type vhostHandler struct{}
+
+func (h *vhostHandler) NewObject() managed.Object {
+	return &rabbitmqv1alpha1.RabbitMQVhost{}
+}
+
+func (h *vhostHandler) NewList() runtime.Object {
+	return &rabbitmqv1alpha1.RabbitMQVhostList{}
+}
+
+// Sync creates the vhost, grants the operator administrator access to it and sets its limits
+func (h *vhostHandler) Sync(req *managed.Request, obj managed.Object) error {
+	cr := obj.(*rabbitmqv1alpha1.RabbitMQVhost)
+	mgmt := req.Management
+	name := cr.VhostName()
+
+	vhost, err := mgmt.GetVhost(name)
+	if err != nil && !management.IsNotFound(err) {
+		return err
+	}
+	if vhost == nil || (cr.Spec.DefaultQueueType != "" && vhost.DefaultQueueType != cr.Spec.DefaultQueueType) {
+		req.Logger.Info("Creating or updating the vhost", "Vhost", name)
+		if err := mgmt.PutVhost(&management.Vhost{Name: name, DefaultQueueType: cr.Spec.DefaultQueueType}); err != nil {
+			return err
+		}
+	}
+	if err := mgmt.SetPermissions(name, mgmt.Username(), management.FullPermissions); err != nil {
+		return err
+	}
+
+	current, err := mgmt.GetVhostLimits(name)
+	if err != nil {
+		return err
+	}
+	desired := map[string]*int32{}
+	if cr.Spec.Limits != nil {
+		desired[management.MaxConnectionsLimit] = cr.Spec.Limits.MaxConnections
+		desired[management.MaxQueuesLimit] = cr.Spec.Limits.MaxQueues
+	}
+	for _, limit := range []string{management.MaxConnectionsLimit, management.MaxQueuesLimit} {
+		value, set := current[limit]
+		switch {
+		case desired[limit] != nil && (!set || value != int64(*desired[limit])):
+			req.Logger.Info("Setting the vhost limit", "Vhost", name, "Limit", limit, "Value", *desired[limit])
+			if err := mgmt.SetVhostLimit(name, limit, int64(*desired[limit])); err != nil {
+				return err
+			}
+		case desired[limit] == nil && set:
+			req.Logger.Info("Removing the vhost limit", "Vhost", name, "Limit", limit)
+			if err := mgmt.DeleteVhostLimit(name, limit); err != nil && !management.IsNotFound(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Delete deletes the vhost with all its queues and messages. The default vhost stays.
+func (h *vhostHandler) Delete(req *managed.Request, obj managed.Object) error {
+	cr := obj.(*rabbitmqv1alpha1.RabbitMQVhost)
+	name := cr.VhostName()
+	if name == "/" {
+		return nil
+	}
+	req.Logger.Info("Deleting the vhost", "Vhost", name)
+	if err := req.Management.DeleteVhost(name); err != nil && !management.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package rabbitmqvhost
+
+import (
+	"reflect"
+	"testing"
+
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	"github.com/toha10/rabbitmq-operator/pkg/controller/managed/managedtest"
+	"github.com/toha10/rabbitmq-operator/pkg/management/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newInt32(i int32) *int32 {
+	return &i
+}
+
+func TestSync(t *testing.T) {
+	tests := []struct {
+		name      string
+		spec      rabbitmqv1alpha1.RabbitMQVhostSpec
+		responses map[string]string
+		requests  []string
+	}{
+		{
+			name: "created",
+			spec: rabbitmqv1alpha1.RabbitMQVhostSpec{
+				DefaultQueueType: "quorum",
+				Limits:           &rabbitmqv1alpha1.VhostLimits{MaxQueues: newInt32(10)},
+			},
+			responses: map[string]string{"GET /api/vhost-limits/orders": "[]"},
+			requests: []string{
+				`PUT /api/vhosts/orders {"default_queue_type":"quorum"}`,
+				`PUT /api/permissions/orders/admin {"configure":".*","write":".*","read":".*"}`,
+				`PUT /api/vhost-limits/orders/max-queues {"value":10}`,
+			},
+		},
+		{
+			name: "in sync",
+			spec: rabbitmqv1alpha1.RabbitMQVhostSpec{
+				Limits: &rabbitmqv1alpha1.VhostLimits{MaxQueues: newInt32(10)},
+			},
+			responses: map[string]string{
+				"GET /api/vhosts/orders":       `{"name":"orders","default_queue_type":"classic"}`,
+				"GET /api/vhost-limits/orders": `[{"vhost":"orders","value":{"max-queues":10}}]`,
+			},
+			requests: []string{
+				`PUT /api/permissions/orders/admin {"configure":".*","write":".*","read":".*"}`,
+			},
+		},
+		{
+			name: "limits changed and removed",
+			spec: rabbitmqv1alpha1.RabbitMQVhostSpec{
+				Limits: &rabbitmqv1alpha1.VhostLimits{MaxConnections: newInt32(100)},
+			},
+			responses: map[string]string{
+				"GET /api/vhosts/orders":       `{"name":"orders"}`,
+				"GET /api/vhost-limits/orders": `[{"vhost":"orders","value":{"max-connections":50,"max-queues":10}}]`,
+			},
+			requests: []string{
+				`PUT /api/permissions/orders/admin {"configure":".*","write":".*","read":".*"}`,
+				`PUT /api/vhost-limits/orders/max-connections {"value":100}`,
+				`DELETE /api/vhost-limits/orders/max-queues`,
+			},
+		},
+		{
+			name: "default queue type changed",
+			spec: rabbitmqv1alpha1.RabbitMQVhostSpec{DefaultQueueType: "quorum"},
+			responses: map[string]string{
+				"GET /api/vhosts/orders":       `{"name":"orders","default_queue_type":"classic"}`,
+				"GET /api/vhost-limits/orders": "[]",
+			},
+			requests: []string{
+				`PUT /api/vhosts/orders {"default_queue_type":"quorum"}`,
+				`PUT /api/permissions/orders/admin {"configure":".*","write":".*","read":".*"}`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := fake.NewServer(tt.responses)
+			defer server.Close()
+			cr := &rabbitmqv1alpha1.RabbitMQVhost{
+				ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "ns"},
+				Spec:       tt.spec,
+			}
+			req := managedtest.NewRequest(server)
+			if err := (&vhostHandler{}).Sync(req, cr); err != nil {
+				t.Fatal(err)
+			}
+			if got := server.Requests(); !reflect.DeepEqual(got, tt.requests) {
+				t.Errorf("got requests\n%v\nwant\n%v", got, tt.requests)
+			}
+		})
+	}
+}
+
+func TestDelete(t *testing.T) {
+	tests := []struct {
+		name     string
+		vhost    string
+		requests []string
+	}{
+		{"named vhost", "orders", []string{"DELETE /api/vhosts/orders"}},
+		{"default vhost kept", "/", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := fake.NewServer(nil)
+			defer server.Close()
+			cr := &rabbitmqv1alpha1.RabbitMQVhost{
+				ObjectMeta: metav1.ObjectMeta{Name: "vhost", Namespace: "ns"},
+				Spec:       rabbitmqv1alpha1.RabbitMQVhostSpec{Name: tt.vhost},
+			}
+			req := managedtest.NewRequest(server)
+			if err := (&vhostHandler{}).Delete(req, cr); err != nil {
+				t.Fatal(err)
+			}
+			if got := server.Requests(); !reflect.DeepEqual(got, tt.requests) {
+				t.Errorf("got requests %v, want %v", got, tt.requests)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+// Package management is a client of the RabbitMQ management HTTP API
+package management
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Port is the port the management plugin listens on
+const Port = 15672
+
+// Client calls the management HTTP API of a RabbitMQ cluster
+type Client struct {
+	baseURL    string
+	username   string
+	password   string
+	httpClient *http.Client
+}
+
+// NewClient returns a client of the management API at baseURL, e.g. http://rabbitmq.default.svc:15672
+func NewClient(baseURL, username, password string) *Client {
+	return &Client{
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		username: username,
+		password: password,
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}
+}
+
+// Username returns the name of the user the client authenticates as
+func (c *Client) Username() string {
+	return c.username
+}
+
+// Error is returned when the management API responds with an error status
+type Error struct {
+	StatusCode int
+	Method     string
+	Path       string
+	Reason     string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s %s: %d %s", e.Method, e.Path, e.StatusCode, e.Reason)
+}
+
+// IsNotFound reports whether the error is a 404 response of the management API
+func IsNotFound(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.StatusCode == http.StatusNotFound
+}
+
+// IsUnauthorized reports whether the error is a 401 response of the management API
+func IsUnauthorized(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.StatusCode == http.StatusUnauthorized
+}
+
+// escape escapes a path segment, the default vhost "/" becomes "%2F"
+func escape(segment string) string {
+	return url.PathEscape(segment)
+}
+
+// do sends a request with the JSON encoded body and decodes the JSON response into result
+func (c *Client) do(method, path string, body, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.username, c.password)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 400 {
+		apiErr := &Error{StatusCode: resp.StatusCode, Method: method, Path: path}
+		var reason struct {
+			Error  string `json:"error"`
+			Reason string `json:"reason"`
+		}
+		if json.Unmarshal(data, &reason) == nil && reason.Reason != "" {
+			apiErr.Reason = reason.Reason
+		} else {
+			apiErr.Reason = strings.TrimSpace(string(data))
+		}
+		return apiErr
+	}
+	if result == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, result)
+}
+
+// Whoami returns the name of the authenticated user
+func (c *Client) Whoami() (string, error) {
+	var user struct {
+		Name string `json:"name"`
+	}
+	err := c.do(http.MethodGet, "/api/whoami", nil, &user)
+	return user.Name, err
+}
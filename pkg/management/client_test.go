@@ -0,0 +1,143 @@
+package management
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// request is a request received by the test server
+type request struct {
+	method, path, body, contentType string
+}
+
+// newTestServer returns a client of a server answering with the status and the body,
+// and the requests the server receives
+func newTestServer(t *testing.T, status int, body string) (*Client, *[]request) {
+	requests := &[]request{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if user, password, ok := req.BasicAuth(); !ok || user != "admin" || password != "secret" {
+			t.Errorf("got credentials %q, %q", user, password)
+		}
+		data, _ := ioutil.ReadAll(req.Body)
+		*requests = append(*requests, request{req.Method, req.URL.EscapedPath(), string(data), req.Header.Get("Content-Type")})
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return NewClient(server.URL+"/", "admin", "secret"), requests
+}
+
+func TestClientDecodesTheResponse(t *testing.T) {
+	c, requests := newTestServer(t, http.StatusOK, `{"name":"admin","tags":"administrator"}`)
+	name, err := c.Whoami()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "admin" {
+		t.Errorf("got %q, want admin", name)
+	}
+	if want := []request{{method: http.MethodGet, path: "/api/whoami"}}; !reflect.DeepEqual(*requests, want) {
+		t.Errorf("got requests %+v, want %+v", *requests, want)
+	}
+}
+
+func TestClientEncodesTheBody(t *testing.T) {
+	c, requests := newTestServer(t, http.StatusCreated, "")
+	if err := c.PutVhost(&Vhost{Name: "/", DefaultQueueType: "quorum"}); err != nil {
+		t.Fatal(err)
+	}
+	want := []request{{http.MethodPut, "/api/vhosts/%2F", `{"default_queue_type":"quorum"}`, "application/json"}}
+	if !reflect.DeepEqual(*requests, want) {
+		t.Errorf("got requests %+v, want %+v", *requests, want)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		body         string
+		reason       string
+		notFound     bool
+		unauthorized bool
+	}{
+		{"not found", http.StatusNotFound, `{"error":"Object Not Found","reason":"Not Found"}`, "Not Found", true, false},
+		{"unauthorized", http.StatusUnauthorized, `{"error":"not_authorised","reason":"Login failed"}`, "Login failed", false, true},
+		{"plain text", http.StatusBadRequest, "bad request\n", "bad request", false, false},
+		{"server error", http.StatusInternalServerError, `{"error":"internal"}`, `{"error":"internal"}`, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newTestServer(t, tt.status, tt.body)
+			_, err := c.GetVhost("v")
+			apiErr, ok := err.(*Error)
+			if !ok {
+				t.Fatalf("got error %v, want an *Error", err)
+			}
+			if apiErr.StatusCode != tt.status || apiErr.Reason != tt.reason || apiErr.Path != "/api/vhosts/v" {
+				t.Errorf("got %+v", apiErr)
+			}
+			if IsNotFound(err) != tt.notFound {
+				t.Errorf("got IsNotFound %v, want %v", IsNotFound(err), tt.notFound)
+			}
+			if IsUnauthorized(err) != tt.unauthorized {
+				t.Errorf("got IsUnauthorized %v, want %v", IsUnauthorized(err), tt.unauthorized)
+			}
+		})
+	}
+}
+
+func TestGetVhostLimits(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want map[string]int64
+	}{
+		{"no limits", "[]", map[string]int64{}},
+		{"limits", `[{"vhost":"v","value":{"max-connections":10,"max-queues":5}}]`,
+			map[string]int64{MaxConnectionsLimit: 10, MaxQueuesLimit: 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newTestServer(t, http.StatusOK, tt.body)
+			limits, err := c.GetVhostLimits("v")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(limits, tt.want) {
+				t.Errorf("got %v, want %v", limits, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueueReplicas(t *testing.T) {
+	tests := []struct {
+		name                       string
+		queue                      Queue
+		replicated, unsynchronised bool
+	}{
+		{"classic", Queue{Node: "a"}, false, false},
+		{"mirrored", Queue{Node: "a", SlaveNodes: []string{"b"}, SynchronisedSlaveNodes: []string{"b"}}, true, false},
+		{"mirror not synchronised", Queue{Node: "a", SlaveNodes: []string{"b", "c"}, SynchronisedSlaveNodes: []string{"b"}}, true, true},
+		{"quorum", Queue{Node: "a", Members: []string{"a", "b", "c"}, Online: []string{"a", "b", "c"}}, true, false},
+		{"quorum member offline", Queue{Node: "a", Members: []string{"a", "b", "c"}, Online: []string{"a", "b"}}, true, true},
+		{"single quorum member", Queue{Node: "a", Members: []string{"a"}, Online: []string{"a"}}, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.queue.Replicated(); got != tt.replicated {
+				t.Errorf("got replicated %v, want %v", got, tt.replicated)
+			}
+			if got := tt.queue.Unsynchronised(); got != tt.unsynchronised {
+				t.Errorf("got unsynchronised %v, want %v", got, tt.unsynchronised)
+			}
+			if !tt.queue.HasMember("a") || tt.queue.HasMember("d") {
+				t.Errorf("wrong members")
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package management
+
+import (
+	"net/http"
+)
+
+// Node is a member of the cluster
+type Node struct {
+	Name    string `json:"name"`
+	Running bool   `json:"running"`
+	// MemAlarm and DiskFreeAlarm are raised when the node blocks publishers
+	MemAlarm      bool `json:"mem_alarm"`
+	DiskFreeAlarm bool `json:"disk_free_alarm"`
+	// BeingDrained is set while the node is in maintenance mode
+	BeingDrained bool `json:"being_drained"`
+}
+
+// Queue is a queue as listed by the management API, the mirroring and
+// membership fields are set for the replicated queue types only
+type Queue struct {
+	Name    string `json:"name"`
+	Vhost   string `json:"vhost"`
+	Type    string `json:"type"`
+	Durable bool   `json:"durable"`
+	// AutoDelete, Arguments and Messages are only returned for a single queue
+	AutoDelete bool                   `json:"auto_delete"`
+	Arguments  map[string]interface{} `json:"arguments,omitempty"`
+	Messages   int64                  `json:"messages"`
+	// Node is the node of the classic queue master or the quorum queue leader
+	Node string `json:"node"`
+	// SlaveNodes and SynchronisedSlaveNodes are the mirrors of a classic mirrored queue
+	SlaveNodes             []string `json:"slave_nodes"`
+	SynchronisedSlaveNodes []string `json:"synchronised_slave_nodes"`
+	// Members and Online are the members of a quorum queue
+	Members []string `json:"members"`
+	Online  []string `json:"online"`
+}
+
+// ListNodes returns the members of the cluster
+func (c *Client) ListNodes() ([]Node, error) {
+	nodes := []Node{}
+	err := c.do(http.MethodGet, "/api/nodes", nil, &nodes)
+	return nodes, err
+}
+
+// ListQueues returns the queues of all vhosts
+func (c *Client) ListQueues() ([]Queue, error) {
+	queues := []Queue{}
+	err := c.do(http.MethodGet, "/api/queues?columns=name,vhost,type,durable,node,slave_nodes,synchronised_slave_nodes,members,online", nil, &queues)
+	return queues, err
+}
+
+// Unsynchronised reports whether some replicas of the queue don't have a copy of its contents
+func (q *Queue) Unsynchronised() bool {
+	return len(q.SynchronisedSlaveNodes) < len(q.SlaveNodes) || len(q.Online) < len(q.Members)
+}
+
+// Replicated reports whether the queue has copies of its contents on other nodes
+func (q *Queue) Replicated() bool {
+	return len(q.SlaveNodes) > 0 || len(q.Members) > 1
+}
+
+// HasMember reports whether the queue has its master, a mirror or a quorum member on the node
+func (q *Queue) HasMember(node string) bool {
+	if q.Node == node {
+		return true
+	}
+	for _, nodes := range [][]string{q.SlaveNodes, q.Members} {
+		for _, n := range nodes {
+			if n == node {
+				return true
+			}
+		}
+	}
+	return false
+}
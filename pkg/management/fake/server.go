@@ -0,0 +1,86 @@
+// Package fake serves a management API answering with canned responses and recording the changes
+// made through it, for the tests of the code calling the API
+package fake
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/toha10/rabbitmq-operator/pkg/management"
+)
+
+// Server is a fake management API
+type Server struct {
+	*httptest.Server
+
+	mu sync.Mutex
+	// responses are the bodies of the responses by "<method> <path>"
+	responses map[string]string
+	requests  []string
+}
+
+// NewServer starts a Server, it has to be closed by the caller. The responses are the bodies of the
+// responses by "<method> <path>", the path is escaped as sent, e.g. "GET /api/vhosts/%2F". The GET
+// requests without a response get 404, the other requests 204.
+func NewServer(responses map[string]string) *Server {
+	s := &Server{responses: map[string]string{}}
+	for k, v := range responses {
+		s.responses[k] = v
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// ManagementClient returns a client of the Server
+func (s *Server) ManagementClient() *management.Client {
+	return management.NewClient(s.URL, "admin", "secret")
+}
+
+// SetResponse sets the body of the response to "<method> <path>"
+func (s *Server) SetResponse(request, body string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responses[request] = body
+}
+
+// Requests returns the requests other than GET received so far as "<method> <path> <body>",
+// the body is left out when empty
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.requests...)
+}
+
+func (s *Server) handle(w http.ResponseWriter, req *http.Request) {
+	if user, password, ok := req.BasicAuth(); !ok || user != "admin" || password != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error":"not_authorised","reason":"Login failed"}`))
+		return
+	}
+	path := req.URL.EscapedPath()
+	if req.URL.RawQuery != "" {
+		path += "?" + req.URL.RawQuery
+	}
+	key := req.Method + " " + path
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if req.Method != http.MethodGet {
+		body, _ := ioutil.ReadAll(req.Body)
+		s.requests = append(s.requests, strings.TrimSpace(key+" "+string(body)))
+	}
+	response, ok := s.responses[key]
+	switch {
+	case ok:
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(response))
+	case req.Method == http.MethodGet:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":"Object Not Found","reason":"Not Found"}`))
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,32 @@
+package management
+
+import (
+	"net/http"
+)
+
+const (
+	// FeatureFlagEnabled is the state of an enabled feature flag
+	FeatureFlagEnabled = "enabled"
+	// FeatureFlagStable is the stability of a feature flag safe to enable in production
+	FeatureFlagStable = "stable"
+)
+
+// FeatureFlag is a feature flag of the cluster, available since RabbitMQ 3.8
+type FeatureFlag struct {
+	Name      string `json:"name"`
+	State     string `json:"state"`
+	Stability string `json:"stability"`
+}
+
+// ListFeatureFlags returns the feature flags known to the cluster, the API
+// responds with 404 on the versions without feature flags
+func (c *Client) ListFeatureFlags() ([]FeatureFlag, error) {
+	flags := []FeatureFlag{}
+	err := c.do(http.MethodGet, "/api/feature-flags", nil, &flags)
+	return flags, err
+}
+
+// EnableFeatureFlag enables the feature flag on all nodes of the cluster
+func (c *Client) EnableFeatureFlag(name string) error {
+	return c.do(http.MethodPut, "/api/feature-flags/"+escape(name)+"/enable", struct{}{}, nil)
+}
@@ -0,0 +1,39 @@
+package management
+
+import (
+	"net/http"
+)
+
+// components of the runtime parameters
+const (
+	ShovelComponent             = "shovel"
+	FederationUpstreamComponent = "federation-upstream"
+)
+
+// parameter is a runtime parameter of a vhost, e.g. a dynamic shovel or a federation upstream
+type parameter struct {
+	Value map[string]interface{} `json:"value"`
+}
+
+func parameterPath(component, vhost, name string) string {
+	return "/api/parameters/" + escape(component) + "/" + escape(vhost) + "/" + escape(name)
+}
+
+// GetParameter returns the value of the runtime parameter
+func (c *Client) GetParameter(component, vhost, name string) (map[string]interface{}, error) {
+	p := &parameter{}
+	if err := c.do(http.MethodGet, parameterPath(component, vhost, name), nil, p); err != nil {
+		return nil, err
+	}
+	return p.Value, nil
+}
+
+// PutParameter creates or updates the runtime parameter, the plugin of the component has to be enabled
+func (c *Client) PutParameter(component, vhost, name string, value map[string]interface{}) error {
+	return c.do(http.MethodPut, parameterPath(component, vhost, name), &parameter{Value: value}, nil)
+}
+
+// DeleteParameter deletes the runtime parameter
+func (c *Client) DeleteParameter(component, vhost, name string) error {
+	return c.do(http.MethodDelete, parameterPath(component, vhost, name), nil, nil)
+}
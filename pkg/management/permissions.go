@@ -0,0 +1,25 @@
+package management
+
+import (
+	"net/http"
+)
+
+// Permissions are the regular expressions matching the resources of a vhost a user can access
+type Permissions struct {
+	Configure string `json:"configure"`
+	Write     string `json:"write"`
+	Read      string `json:"read"`
+}
+
+// FullPermissions grant access to all resources of a vhost
+var FullPermissions = Permissions{Configure: ".*", Write: ".*", Read: ".*"}
+
+// SetPermissions sets the permissions of the user in the vhost
+func (c *Client) SetPermissions(vhost, user string, permissions Permissions) error {
+	return c.do(http.MethodPut, "/api/permissions/"+escape(vhost)+"/"+escape(user), permissions, nil)
+}
+
+// ClearPermissions revokes the permissions of the user in the vhost
+func (c *Client) ClearPermissions(vhost, user string) error {
+	return c.do(http.MethodDelete, "/api/permissions/"+escape(vhost)+"/"+escape(user), nil, nil)
+}
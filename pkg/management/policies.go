@@ -0,0 +1,51 @@
+package management
+
+import (
+	"net/http"
+)
+
+// PolicyKind selects the policies set by the users or the operator policies set by the administrators
+type PolicyKind string
+
+const (
+	// UserPolicy is a policy
+	UserPolicy PolicyKind = "policies"
+	// OperatorPolicy is an operator policy, it limits what the policies can set
+	OperatorPolicy PolicyKind = "operator-policies"
+)
+
+// Policy is a policy of a vhost
+type Policy struct {
+	Vhost      string                 `json:"vhost,omitempty"`
+	Name       string                 `json:"name,omitempty"`
+	Pattern    string                 `json:"pattern"`
+	ApplyTo    string                 `json:"apply-to,omitempty"`
+	Priority   int32                  `json:"priority"`
+	Definition map[string]interface{} `json:"definition"`
+}
+
+func policyPath(kind PolicyKind, vhost, name string) string {
+	return "/api/" + string(kind) + "/" + escape(vhost) + "/" + escape(name)
+}
+
+// GetPolicy returns the policy of the kind
+func (c *Client) GetPolicy(kind PolicyKind, vhost, name string) (*Policy, error) {
+	policy := &Policy{}
+	if err := c.do(http.MethodGet, policyPath(kind, vhost, name), nil, policy); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// PutPolicy creates or replaces the policy of the kind
+func (c *Client) PutPolicy(kind PolicyKind, policy *Policy) error {
+	body := *policy
+	body.Vhost = ""
+	body.Name = ""
+	return c.do(http.MethodPut, policyPath(kind, policy.Vhost, policy.Name), &body, nil)
+}
+
+// DeletePolicy deletes the policy of the kind
+func (c *Client) DeletePolicy(kind PolicyKind, vhost, name string) error {
+	return c.do(http.MethodDelete, policyPath(kind, vhost, name), nil, nil)
+}
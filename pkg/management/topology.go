@@ -0,0 +1,123 @@
+package management
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// Exchange is an exchange of a vhost
+type Exchange struct {
+	Vhost      string                 `json:"vhost,omitempty"`
+	Name       string                 `json:"name,omitempty"`
+	Type       string                 `json:"type"`
+	Durable    bool                   `json:"durable"`
+	AutoDelete bool                   `json:"auto_delete"`
+	Arguments  map[string]interface{} `json:"arguments"`
+}
+
+// Binding routes the messages from the source exchange to the destination queue or exchange
+type Binding struct {
+	Source          string                 `json:"source"`
+	Destination     string                 `json:"destination"`
+	DestinationType string                 `json:"destination_type"`
+	RoutingKey      string                 `json:"routing_key"`
+	Arguments       map[string]interface{} `json:"arguments"`
+	// PropertiesKey identifies the binding among the bindings between the same source and destination
+	PropertiesKey string `json:"properties_key,omitempty"`
+}
+
+// binding destination types
+const (
+	DestinationQueue    = "queue"
+	DestinationExchange = "exchange"
+)
+
+func exchangePath(vhost, name string) string {
+	return "/api/exchanges/" + escape(vhost) + "/" + escape(name)
+}
+
+func queuePath(vhost, name string) string {
+	return "/api/queues/" + escape(vhost) + "/" + escape(name)
+}
+
+// GetExchange returns the exchange
+func (c *Client) GetExchange(vhost, name string) (*Exchange, error) {
+	exchange := &Exchange{}
+	if err := c.do(http.MethodGet, exchangePath(vhost, name), nil, exchange); err != nil {
+		return nil, err
+	}
+	return exchange, nil
+}
+
+// DeclareExchange declares the exchange, it fails if an exchange with other properties exists
+func (c *Client) DeclareExchange(exchange *Exchange) error {
+	body := *exchange
+	body.Vhost = ""
+	body.Name = ""
+	return c.do(http.MethodPut, exchangePath(exchange.Vhost, exchange.Name), &body, nil)
+}
+
+// DeleteExchange deletes the exchange with its bindings
+func (c *Client) DeleteExchange(vhost, name string) error {
+	return c.do(http.MethodDelete, exchangePath(vhost, name), nil, nil)
+}
+
+// GetQueue returns the queue
+func (c *Client) GetQueue(vhost, name string) (*Queue, error) {
+	queue := &Queue{}
+	if err := c.do(http.MethodGet, queuePath(vhost, name), nil, queue); err != nil {
+		return nil, err
+	}
+	return queue, nil
+}
+
+// DeclareQueue declares the queue, it fails if a queue with other properties exists.
+// The type of the queue is set with the x-queue-type argument.
+func (c *Client) DeclareQueue(vhost, name string, durable, autoDelete bool, arguments map[string]interface{}) error {
+	body := map[string]interface{}{
+		"durable":     durable,
+		"auto_delete": autoDelete,
+		"arguments":   arguments,
+	}
+	return c.do(http.MethodPut, queuePath(vhost, name), body, nil)
+}
+
+// DeleteQueue deletes the queue, if ifEmpty is set only when it has no messages
+func (c *Client) DeleteQueue(vhost, name string, ifEmpty bool) error {
+	path := queuePath(vhost, name)
+	if ifEmpty {
+		path += "?if-empty=true"
+	}
+	return c.do(http.MethodDelete, path, nil, nil)
+}
+
+// bindingsPath returns the path of the bindings between the source exchange and the destination
+func bindingsPath(vhost, source, destination, destinationType string) string {
+	kind := "q"
+	if destinationType == DestinationExchange {
+		kind = "e"
+	}
+	return "/api/bindings/" + escape(vhost) + "/e/" + escape(source) + "/" + kind + "/" + escape(destination)
+}
+
+// ListBindings returns the bindings between the source exchange and the destination
+func (c *Client) ListBindings(vhost, source, destination, destinationType string) ([]Binding, error) {
+	bindings := []Binding{}
+	err := c.do(http.MethodGet, bindingsPath(vhost, source, destination, destinationType), nil, &bindings)
+	return bindings, err
+}
+
+// CreateBinding binds the destination to the source exchange
+func (c *Client) CreateBinding(vhost string, binding *Binding) error {
+	body := map[string]interface{}{
+		"routing_key": binding.RoutingKey,
+		"arguments":   binding.Arguments,
+	}
+	return c.do(http.MethodPost, bindingsPath(vhost, binding.Source, binding.Destination, binding.DestinationType), body, nil)
+}
+
+// DeleteBinding deletes the binding identified by its properties key
+func (c *Client) DeleteBinding(vhost string, binding *Binding) error {
+	path := bindingsPath(vhost, binding.Source, binding.Destination, binding.DestinationType) + "/" + url.PathEscape(binding.PropertiesKey)
+	return c.do(http.MethodDelete, path, nil, nil)
+}
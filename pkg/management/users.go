@@ -0,0 +1,33 @@
+package management
+
+import (
+	"net/http"
+	"strings"
+)
+
+// UserPermissions are the permissions of a user in a vhost
+type UserPermissions struct {
+	Vhost string `json:"vhost"`
+	Permissions
+}
+
+// PutUser creates the user or updates its password and tags
+func (c *Client) PutUser(name, password string, tags []string) error {
+	body := map[string]string{
+		"password": password,
+		"tags":     strings.Join(tags, ","),
+	}
+	return c.do(http.MethodPut, "/api/users/"+escape(name), body, nil)
+}
+
+// DeleteUser deletes the user with all its permissions
+func (c *Client) DeleteUser(name string) error {
+	return c.do(http.MethodDelete, "/api/users/"+escape(name), nil, nil)
+}
+
+// ListUserPermissions returns the permissions of the user in all vhosts
+func (c *Client) ListUserPermissions(name string) ([]UserPermissions, error) {
+	permissions := []UserPermissions{}
+	err := c.do(http.MethodGet, "/api/users/"+escape(name)+"/permissions", nil, &permissions)
+	return permissions, err
+}
@@ -0,0 +1,65 @@
+package management
+
+import (
+	"net/http"
+)
+
+// Vhost is a virtual host of the cluster
+type Vhost struct {
+	Name             string `json:"name"`
+	DefaultQueueType string `json:"default_queue_type,omitempty"`
+}
+
+// vhost limits
+const (
+	MaxConnectionsLimit = "max-connections"
+	MaxQueuesLimit      = "max-queues"
+)
+
+// GetVhost returns the vhost
+func (c *Client) GetVhost(name string) (*Vhost, error) {
+	vhost := &Vhost{}
+	if err := c.do(http.MethodGet, "/api/vhosts/"+escape(name), nil, vhost); err != nil {
+		return nil, err
+	}
+	return vhost, nil
+}
+
+// PutVhost creates the vhost or updates its settings
+func (c *Client) PutVhost(vhost *Vhost) error {
+	body := map[string]string{}
+	if vhost.DefaultQueueType != "" {
+		body["default_queue_type"] = vhost.DefaultQueueType
+	}
+	return c.do(http.MethodPut, "/api/vhosts/"+escape(vhost.Name), body, nil)
+}
+
+// DeleteVhost deletes the vhost with everything in it
+func (c *Client) DeleteVhost(name string) error {
+	return c.do(http.MethodDelete, "/api/vhosts/"+escape(name), nil, nil)
+}
+
+// GetVhostLimits returns the limits set on the vhost by name
+func (c *Client) GetVhostLimits(vhost string) (map[string]int64, error) {
+	var limits []struct {
+		Value map[string]int64 `json:"value"`
+	}
+	if err := c.do(http.MethodGet, "/api/vhost-limits/"+escape(vhost), nil, &limits); err != nil {
+		return nil, err
+	}
+	if len(limits) == 0 {
+		return map[string]int64{}, nil
+	}
+	return limits[0].Value, nil
+}
+
+// SetVhostLimit sets the limit of the vhost
+func (c *Client) SetVhostLimit(vhost, limit string, value int64) error {
+	body := map[string]int64{"value": value}
+	return c.do(http.MethodPut, "/api/vhost-limits/"+escape(vhost)+"/"+escape(limit), body, nil)
+}
+
+// DeleteVhostLimit removes the limit of the vhost
+func (c *Client) DeleteVhostLimit(vhost, limit string) error {
+	return c.do(http.MethodDelete, "/api/vhost-limits/"+escape(vhost)+"/"+escape(limit), nil, nil)
+}
@@ -0,0 +1,58 @@
+package objects
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/toha10/rabbitmq-operator/pkg/controller/managed"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/runtime/inject"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission/types"
+)
+
+// Validator is the admission handler refusing the changes of the resources of one kind
+// which would leave their old object behind in the cluster
+type Validator struct {
+	decoder   types.Decoder
+	newObject func() managed.Object
+}
+
+var _ admission.Handler = &Validator{}
+var _ inject.Decoder = &Validator{}
+
+// NewValidator returns a Validator of the kind of resources newObject returns empty
+func NewValidator(newObject func() managed.Object) *Validator {
+	return &Validator{newObject: newObject}
+}
+
+// InjectDecoder injects the decoder of the admission requests
+func (v *Validator) InjectDecoder(d types.Decoder) error {
+	v.decoder = d
+	return nil
+}
+
+// Handle validates the resource of the request
+func (v *Validator) Handle(ctx context.Context, req types.Request) types.Response {
+	if req.AdmissionRequest.Operation != admissionv1beta1.Update {
+		return admission.ValidationResponse(true, "")
+	}
+	obj := v.newObject()
+	if err := v.decoder.Decode(req, obj); err != nil {
+		return admission.ErrorResponse(http.StatusBadRequest, err)
+	}
+	// the finalizers of a resource being deleted have to be removable whatever its spec is
+	if obj.GetDeletionTimestamp() != nil {
+		return admission.ValidationResponse(true, "")
+	}
+	old := v.newObject()
+	if err := json.Unmarshal(req.AdmissionRequest.OldObject.Raw, old); err != nil {
+		return admission.ErrorResponse(http.StatusBadRequest, err)
+	}
+
+	if allErrs := ValidateUpdate(old, obj); len(allErrs) > 0 {
+		return admission.ValidationResponse(false, allErrs.ToAggregate().Error())
+	}
+	return admission.ValidationResponse(true, "")
+}
@@ -0,0 +1,72 @@
+package objects
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/toha10/rabbitmq-operator/pkg/apis"
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	"github.com/toha10/rabbitmq-operator/pkg/controller/managed"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission/types"
+)
+
+func TestValidator(t *testing.T) {
+	s := runtime.NewScheme()
+	if err := apis.AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	decoder, err := admission.NewDecoder(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := NewValidator(func() managed.Object { return &rabbitmqv1alpha1.RabbitMQVhost{} })
+	if err := v.InjectDecoder(decoder); err != nil {
+		t.Fatal(err)
+	}
+
+	vhost := func(name string) *rabbitmqv1alpha1.RabbitMQVhost {
+		return &rabbitmqv1alpha1.RabbitMQVhost{
+			TypeMeta:   metav1.TypeMeta{APIVersion: rabbitmqv1alpha1.SchemeGroupVersion.String(), Kind: "RabbitMQVhost"},
+			ObjectMeta: meta("orders"),
+			Spec:       rabbitmqv1alpha1.RabbitMQVhostSpec{Cluster: "rmq", Name: name},
+		}
+	}
+	deleted := vhost("billing")
+	now := metav1.Now()
+	deleted.DeletionTimestamp = &now
+
+	tests := []struct {
+		name      string
+		operation admissionv1beta1.Operation
+		old, obj  *rabbitmqv1alpha1.RabbitMQVhost
+		allowed   bool
+	}{
+		{"create", admissionv1beta1.Create, nil, vhost("billing"), true},
+		{"update", admissionv1beta1.Update, vhost(""), vhost("orders"), true},
+		{"rename", admissionv1beta1.Update, vhost(""), vhost("billing"), false},
+		{"deleted", admissionv1beta1.Update, vhost(""), deleted, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &admissionv1beta1.AdmissionRequest{Operation: tt.operation}
+			var err error
+			if req.Object.Raw, err = json.Marshal(tt.obj); err != nil {
+				t.Fatal(err)
+			}
+			if tt.old != nil {
+				if req.OldObject.Raw, err = json.Marshal(tt.old); err != nil {
+					t.Fatal(err)
+				}
+			}
+			resp := v.Handle(context.TODO(), types.Request{AdmissionRequest: req})
+			if resp.Response.Allowed != tt.allowed {
+				t.Errorf("got allowed %v, want %v: %v", resp.Response.Allowed, tt.allowed, resp.Response.Result)
+			}
+		})
+	}
+}
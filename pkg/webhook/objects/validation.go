@@ -0,0 +1,88 @@
+// Package objects validates the resources declaring objects inside RabbitMQ clusters, e.g. vhosts
+package objects
+
+import (
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	"github.com/toha10/rabbitmq-operator/pkg/controller/managed"
+	apivalidation "k8s.io/apimachinery/pkg/api/validation"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// ValidateUpdate checks the change of a resource declaring an object inside a RabbitMQ cluster.
+// The object is found in the cluster by what identifies it, e.g. its name and vhost. The operator
+// would declare a new object on a change of them and leave the old one behind, so they can't be
+// changed, and neither can the cluster. The names left empty in the spec are compared as defaulted.
+func ValidateUpdate(old, obj managed.Object) field.ErrorList {
+	specPath := field.NewPath("spec")
+	allErrs := apivalidation.ValidateImmutableField(obj.ClusterName(), old.ClusterName(), specPath.Child("cluster"))
+
+	switch obj := obj.(type) {
+	case *rabbitmqv1alpha1.RabbitMQVhost:
+		old := old.(*rabbitmqv1alpha1.RabbitMQVhost)
+		allErrs = append(allErrs, apivalidation.ValidateImmutableField(obj.VhostName(), old.VhostName(), specPath.Child("name"))...)
+	case *rabbitmqv1alpha1.RabbitMQUser:
+		old := old.(*rabbitmqv1alpha1.RabbitMQUser)
+		allErrs = append(allErrs, apivalidation.ValidateImmutableField(obj.Username(), old.Username(), specPath.Child("name"))...)
+	case *rabbitmqv1alpha1.RabbitMQPolicy:
+		old := old.(*rabbitmqv1alpha1.RabbitMQPolicy)
+		allErrs = append(allErrs, validatePolicyUpdate(&obj.Spec, &old.Spec, obj, old, specPath)...)
+	case *rabbitmqv1alpha1.RabbitMQOperatorPolicy:
+		old := old.(*rabbitmqv1alpha1.RabbitMQOperatorPolicy)
+		allErrs = append(allErrs, validatePolicyUpdate(&obj.Spec, &old.Spec, obj, old, specPath)...)
+	case *rabbitmqv1alpha1.RabbitMQQueue:
+		old := old.(*rabbitmqv1alpha1.RabbitMQQueue)
+		allErrs = append(allErrs, apivalidation.ValidateImmutableField(obj.QueueName(), old.QueueName(), specPath.Child("name"))...)
+		allErrs = append(allErrs, apivalidation.ValidateImmutableField(obj.QueueVhost(), old.QueueVhost(), specPath.Child("vhost"))...)
+	case *rabbitmqv1alpha1.RabbitMQExchange:
+		old := old.(*rabbitmqv1alpha1.RabbitMQExchange)
+		allErrs = append(allErrs, apivalidation.ValidateImmutableField(obj.ExchangeName(), old.ExchangeName(), specPath.Child("name"))...)
+		allErrs = append(allErrs, apivalidation.ValidateImmutableField(obj.ExchangeVhost(), old.ExchangeVhost(), specPath.Child("vhost"))...)
+	case *rabbitmqv1alpha1.RabbitMQBinding:
+		old := old.(*rabbitmqv1alpha1.RabbitMQBinding)
+		allErrs = append(allErrs, validateBindingUpdate(obj, old, specPath)...)
+	case *rabbitmqv1alpha1.RabbitMQShovel:
+		old := old.(*rabbitmqv1alpha1.RabbitMQShovel)
+		allErrs = append(allErrs, apivalidation.ValidateImmutableField(obj.ShovelName(), old.ShovelName(), specPath.Child("name"))...)
+		allErrs = append(allErrs, apivalidation.ValidateImmutableField(obj.ShovelVhost(), old.ShovelVhost(), specPath.Child("vhost"))...)
+	case *rabbitmqv1alpha1.RabbitMQFederationUpstream:
+		old := old.(*rabbitmqv1alpha1.RabbitMQFederationUpstream)
+		allErrs = append(allErrs, apivalidation.ValidateImmutableField(obj.UpstreamName(), old.UpstreamName(), specPath.Child("name"))...)
+		allErrs = append(allErrs, apivalidation.ValidateImmutableField(obj.UpstreamVhost(), old.UpstreamVhost(), specPath.Child("vhost"))...)
+	}
+	return allErrs
+}
+
+// validateBindingUpdate checks none of the fields of a binding changed, a binding is identified by all of them
+func validateBindingUpdate(obj, old *rabbitmqv1alpha1.RabbitMQBinding, specPath *field.Path) field.ErrorList {
+	allErrs := apivalidation.ValidateImmutableField(obj.BindingVhost(), old.BindingVhost(), specPath.Child("vhost"))
+	allErrs = append(allErrs, apivalidation.ValidateImmutableField(obj.Spec.Source, old.Spec.Source, specPath.Child("source"))...)
+	allErrs = append(allErrs, apivalidation.ValidateImmutableField(obj.Spec.Destination, old.Spec.Destination, specPath.Child("destination"))...)
+	allErrs = append(allErrs, apivalidation.ValidateImmutableField(obj.BindingDestinationType(), old.BindingDestinationType(), specPath.Child("destination_type"))...)
+	allErrs = append(allErrs, apivalidation.ValidateImmutableField(obj.Spec.RoutingKey, old.Spec.RoutingKey, specPath.Child("routing_key"))...)
+	return append(allErrs, apivalidation.ValidateImmutableField(decodedArguments(obj.Spec.Arguments), decodedArguments(old.Spec.Arguments), specPath.Child("arguments"))...)
+}
+
+// decodedArguments returns the arguments decoded, so that a change of their formatting is not a
+// change of the binding, or the raw arguments when they are not a JSON object
+func decodedArguments(raw runtime.RawExtension) interface{} {
+	arguments, err := managed.DecodeArguments(raw)
+	if err != nil {
+		return string(raw.Raw)
+	}
+	return arguments
+}
+
+// validatePolicyUpdate checks the name and the vhost of a policy or an operator policy did not change
+func validatePolicyUpdate(spec, oldSpec *rabbitmqv1alpha1.PolicySpec, obj, old managed.Object, specPath *field.Path) field.ErrorList {
+	allErrs := apivalidation.ValidateImmutableField(nameOrDefault(spec.Name, obj), nameOrDefault(oldSpec.Name, old), specPath.Child("name"))
+	return append(allErrs, apivalidation.ValidateImmutableField(spec.PolicyVhost(), oldSpec.PolicyVhost(), specPath.Child("vhost"))...)
+}
+
+// nameOrDefault returns the name set in the spec, or the name of the resource it defaults to
+func nameOrDefault(name string, obj managed.Object) string {
+	if name != "" {
+		return name
+	}
+	return obj.GetName()
+}
@@ -0,0 +1,246 @@
+package objects
+
+import (
+	"strings"
+	"testing"
+
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	"github.com/toha10/rabbitmq-operator/pkg/controller/managed"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// updateTest is a change of a resource and the path of the field it is refused for, empty if allowed
+type updateTest struct {
+	name   string
+	update func(obj managed.Object)
+	field  string
+}
+
+// runUpdateTests validates the updates of the resource newObject returns
+func runUpdateTests(t *testing.T, newObject func() managed.Object, tests []updateTest) {
+	t.Helper()
+	tests = append([]updateTest{
+		{"unchanged", func(obj managed.Object) {}, ""},
+		{"labels", func(obj managed.Object) { obj.SetLabels(map[string]string{"team": "a"}) }, ""},
+	}, tests...)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := newObject()
+			obj := old.DeepCopyObject().(managed.Object)
+			tt.update(obj)
+			err := ValidateUpdate(old, obj).ToAggregate()
+			switch {
+			case tt.field == "" && err != nil:
+				t.Errorf("got error %v", err)
+			case tt.field != "" && err == nil:
+				t.Errorf("no error for %s", tt.field)
+			case tt.field != "" && !strings.HasPrefix(err.Error(), tt.field+": "):
+				t.Errorf("got error %v, want an error for %s", err, tt.field)
+			}
+		})
+	}
+}
+
+func meta(name string) metav1.ObjectMeta {
+	return metav1.ObjectMeta{Name: name, Namespace: "ns"}
+}
+
+func TestValidateVhostUpdate(t *testing.T) {
+	newObject := func() managed.Object {
+		return &rabbitmqv1alpha1.RabbitMQVhost{
+			ObjectMeta: meta("orders"),
+			Spec:       rabbitmqv1alpha1.RabbitMQVhostSpec{Cluster: "rmq"},
+		}
+	}
+	vhost := func(obj managed.Object) *rabbitmqv1alpha1.RabbitMQVhost {
+		return obj.(*rabbitmqv1alpha1.RabbitMQVhost)
+	}
+	runUpdateTests(t, newObject, []updateTest{
+		{"cluster", func(obj managed.Object) { vhost(obj).Spec.Cluster = "other" }, "spec.cluster"},
+		{"name", func(obj managed.Object) { vhost(obj).Spec.Name = "billing" }, "spec.name"},
+		{"default name set", func(obj managed.Object) { vhost(obj).Spec.Name = "orders" }, ""},
+		{"limits", func(obj managed.Object) {
+			vhost(obj).Spec.Limits = &rabbitmqv1alpha1.VhostLimits{}
+		}, ""},
+	})
+}
+
+func TestValidateUserUpdate(t *testing.T) {
+	newObject := func() managed.Object {
+		return &rabbitmqv1alpha1.RabbitMQUser{
+			ObjectMeta: meta("app"),
+			Spec:       rabbitmqv1alpha1.RabbitMQUserSpec{Cluster: "rmq", Name: "app-user"},
+		}
+	}
+	user := func(obj managed.Object) *rabbitmqv1alpha1.RabbitMQUser {
+		return obj.(*rabbitmqv1alpha1.RabbitMQUser)
+	}
+	runUpdateTests(t, newObject, []updateTest{
+		{"cluster", func(obj managed.Object) { user(obj).Spec.Cluster = "other" }, "spec.cluster"},
+		{"name", func(obj managed.Object) { user(obj).Spec.Name = "other" }, "spec.name"},
+		{"name reset to the default", func(obj managed.Object) { user(obj).Spec.Name = "" }, "spec.name"},
+		{"tags and permissions", func(obj managed.Object) {
+			user(obj).Spec.Tags = []string{"management"}
+			user(obj).Spec.Permissions = []rabbitmqv1alpha1.UserPermissions{{Vhost: "/", Read: ".*"}}
+		}, ""},
+	})
+}
+
+func TestValidatePolicyUpdate(t *testing.T) {
+	kinds := []struct {
+		name      string
+		newObject func(spec rabbitmqv1alpha1.PolicySpec) managed.Object
+		spec      func(obj managed.Object) *rabbitmqv1alpha1.PolicySpec
+	}{
+		{
+			name: "policy",
+			newObject: func(spec rabbitmqv1alpha1.PolicySpec) managed.Object {
+				return &rabbitmqv1alpha1.RabbitMQPolicy{ObjectMeta: meta("ha"), Spec: spec}
+			},
+			spec: func(obj managed.Object) *rabbitmqv1alpha1.PolicySpec {
+				return &obj.(*rabbitmqv1alpha1.RabbitMQPolicy).Spec
+			},
+		},
+		{
+			name: "operator policy",
+			newObject: func(spec rabbitmqv1alpha1.PolicySpec) managed.Object {
+				return &rabbitmqv1alpha1.RabbitMQOperatorPolicy{ObjectMeta: meta("ha"), Spec: spec}
+			},
+			spec: func(obj managed.Object) *rabbitmqv1alpha1.PolicySpec {
+				return &obj.(*rabbitmqv1alpha1.RabbitMQOperatorPolicy).Spec
+			},
+		},
+	}
+	for _, k := range kinds {
+		t.Run(k.name, func(t *testing.T) {
+			newObject := func() managed.Object {
+				return k.newObject(rabbitmqv1alpha1.PolicySpec{Cluster: "rmq", Pattern: "^orders"})
+			}
+			spec := k.spec
+			runUpdateTests(t, newObject, []updateTest{
+				{"cluster", func(obj managed.Object) { spec(obj).Cluster = "other" }, "spec.cluster"},
+				{"name", func(obj managed.Object) { spec(obj).Name = "other" }, "spec.name"},
+				{"default name set", func(obj managed.Object) { spec(obj).Name = "ha" }, ""},
+				{"vhost", func(obj managed.Object) { spec(obj).Vhost = "orders" }, "spec.vhost"},
+				{"default vhost set", func(obj managed.Object) { spec(obj).Vhost = "/" }, ""},
+				{"pattern and definition", func(obj managed.Object) {
+					spec(obj).Pattern = "^billing"
+					spec(obj).Priority = 1
+					spec(obj).Definition.Raw = []byte(`{"max-length":100}`)
+				}, ""},
+			})
+		})
+	}
+}
+
+func TestValidateQueueUpdate(t *testing.T) {
+	newObject := func() managed.Object {
+		return &rabbitmqv1alpha1.RabbitMQQueue{
+			ObjectMeta: meta("orders"),
+			Spec:       rabbitmqv1alpha1.RabbitMQQueueSpec{Cluster: "rmq", Vhost: "shop"},
+		}
+	}
+	queue := func(obj managed.Object) *rabbitmqv1alpha1.RabbitMQQueue {
+		return obj.(*rabbitmqv1alpha1.RabbitMQQueue)
+	}
+	runUpdateTests(t, newObject, []updateTest{
+		{"cluster", func(obj managed.Object) { queue(obj).Spec.Cluster = "other" }, "spec.cluster"},
+		{"name", func(obj managed.Object) { queue(obj).Spec.Name = "billing" }, "spec.name"},
+		{"default name set", func(obj managed.Object) { queue(obj).Spec.Name = "orders" }, ""},
+		{"vhost", func(obj managed.Object) { queue(obj).Spec.Vhost = "" }, "spec.vhost"},
+		{"delete with messages", func(obj managed.Object) { queue(obj).Spec.DeleteWithMessages = true }, ""},
+	})
+}
+
+func TestValidateExchangeUpdate(t *testing.T) {
+	newObject := func() managed.Object {
+		return &rabbitmqv1alpha1.RabbitMQExchange{
+			ObjectMeta: meta("events"),
+			Spec:       rabbitmqv1alpha1.RabbitMQExchangeSpec{Cluster: "rmq"},
+		}
+	}
+	exchange := func(obj managed.Object) *rabbitmqv1alpha1.RabbitMQExchange {
+		return obj.(*rabbitmqv1alpha1.RabbitMQExchange)
+	}
+	runUpdateTests(t, newObject, []updateTest{
+		{"cluster", func(obj managed.Object) { exchange(obj).Spec.Cluster = "other" }, "spec.cluster"},
+		{"name", func(obj managed.Object) { exchange(obj).Spec.Name = "other" }, "spec.name"},
+		{"vhost", func(obj managed.Object) { exchange(obj).Spec.Vhost = "shop" }, "spec.vhost"},
+		{"default vhost set", func(obj managed.Object) { exchange(obj).Spec.Vhost = "/" }, ""},
+	})
+}
+
+func TestValidateBindingUpdate(t *testing.T) {
+	newObject := func() managed.Object {
+		return &rabbitmqv1alpha1.RabbitMQBinding{
+			ObjectMeta: meta("orders"),
+			Spec: rabbitmqv1alpha1.RabbitMQBindingSpec{
+				Cluster:     "rmq",
+				Source:      "events",
+				Destination: "orders",
+				RoutingKey:  "order.*",
+				Arguments:   runtime.RawExtension{Raw: []byte(`{"x-match":"all","region":"eu"}`)},
+			},
+		}
+	}
+	binding := func(obj managed.Object) *rabbitmqv1alpha1.RabbitMQBinding {
+		return obj.(*rabbitmqv1alpha1.RabbitMQBinding)
+	}
+	runUpdateTests(t, newObject, []updateTest{
+		{"cluster", func(obj managed.Object) { binding(obj).Spec.Cluster = "other" }, "spec.cluster"},
+		{"vhost", func(obj managed.Object) { binding(obj).Spec.Vhost = "shop" }, "spec.vhost"},
+		{"default vhost set", func(obj managed.Object) { binding(obj).Spec.Vhost = "/" }, ""},
+		{"source", func(obj managed.Object) { binding(obj).Spec.Source = "other" }, "spec.source"},
+		{"destination", func(obj managed.Object) { binding(obj).Spec.Destination = "other" }, "spec.destination"},
+		{"destination type", func(obj managed.Object) { binding(obj).Spec.DestinationType = "exchange" }, "spec.destination_type"},
+		{"default destination type set", func(obj managed.Object) { binding(obj).Spec.DestinationType = "queue" }, ""},
+		{"routing key", func(obj managed.Object) { binding(obj).Spec.RoutingKey = "order.eu" }, "spec.routing_key"},
+		{"arguments", func(obj managed.Object) {
+			binding(obj).Spec.Arguments.Raw = []byte(`{"x-match":"any","region":"eu"}`)
+		}, "spec.arguments"},
+		{"arguments removed", func(obj managed.Object) { binding(obj).Spec.Arguments.Raw = nil }, "spec.arguments"},
+		{"arguments reformatted", func(obj managed.Object) {
+			binding(obj).Spec.Arguments.Raw = []byte(`{ "region": "eu", "x-match": "all" }`)
+		}, ""},
+	})
+}
+
+func TestValidateShovelUpdate(t *testing.T) {
+	newObject := func() managed.Object {
+		return &rabbitmqv1alpha1.RabbitMQShovel{
+			ObjectMeta: meta("orders"),
+			Spec:       rabbitmqv1alpha1.RabbitMQShovelSpec{Cluster: "rmq", Vhost: "shop"},
+		}
+	}
+	shovel := func(obj managed.Object) *rabbitmqv1alpha1.RabbitMQShovel {
+		return obj.(*rabbitmqv1alpha1.RabbitMQShovel)
+	}
+	runUpdateTests(t, newObject, []updateTest{
+		{"cluster", func(obj managed.Object) { shovel(obj).Spec.Cluster = "other" }, "spec.cluster"},
+		{"name", func(obj managed.Object) { shovel(obj).Spec.Name = "other" }, "spec.name"},
+		{"vhost", func(obj managed.Object) { shovel(obj).Spec.Vhost = "/" }, "spec.vhost"},
+		{"endpoints", func(obj managed.Object) {
+			shovel(obj).Spec.Source.Queue = "orders"
+			shovel(obj).Spec.Destination.Exchange = "archive"
+		}, ""},
+	})
+}
+
+func TestValidateUpstreamUpdate(t *testing.T) {
+	newObject := func() managed.Object {
+		return &rabbitmqv1alpha1.RabbitMQFederationUpstream{
+			ObjectMeta: meta("eu"),
+			Spec:       rabbitmqv1alpha1.RabbitMQFederationUpstreamSpec{Cluster: "rmq", Name: "eu-west"},
+		}
+	}
+	upstream := func(obj managed.Object) *rabbitmqv1alpha1.RabbitMQFederationUpstream {
+		return obj.(*rabbitmqv1alpha1.RabbitMQFederationUpstream)
+	}
+	runUpdateTests(t, newObject, []updateTest{
+		{"cluster", func(obj managed.Object) { upstream(obj).Spec.Cluster = "other" }, "spec.cluster"},
+		{"name", func(obj managed.Object) { upstream(obj).Spec.Name = "eu-east" }, "spec.name"},
+		{"vhost", func(obj managed.Object) { upstream(obj).Spec.Vhost = "shop" }, "spec.vhost"},
+		{"exchange", func(obj managed.Object) { upstream(obj).Spec.Exchange = "events" }, ""},
+	})
+}
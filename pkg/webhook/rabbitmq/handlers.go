@@ -0,0 +1,89 @@
+package rabbitmq
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/runtime/inject"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission/types"
+)
+
+// Validator is the admission handler refusing invalid RabbitMQ resources and unsafe changes of them
+type Validator struct {
+	decoder types.Decoder
+}
+
+var _ admission.Handler = &Validator{}
+var _ inject.Decoder = &Validator{}
+
+// InjectDecoder injects the decoder of the admission requests
+func (v *Validator) InjectDecoder(d types.Decoder) error {
+	v.decoder = d
+	return nil
+}
+
+// Handle validates the RabbitMQ resource of the request
+func (v *Validator) Handle(ctx context.Context, req types.Request) types.Response {
+	cr := &rabbitmqv1alpha1.RabbitMQ{}
+	if err := v.decoder.Decode(req, cr); err != nil {
+		return admission.ErrorResponse(http.StatusBadRequest, err)
+	}
+	// the finalizers of a resource being deleted have to be removable whatever its spec is
+	if cr.DeletionTimestamp != nil {
+		return admission.ValidationResponse(true, "")
+	}
+
+	var old *rabbitmqv1alpha1.RabbitMQ
+	if req.AdmissionRequest.Operation == admissionv1beta1.Update {
+		old = &rabbitmqv1alpha1.RabbitMQ{}
+		if err := json.Unmarshal(req.AdmissionRequest.OldObject.Raw, old); err != nil {
+			return admission.ErrorResponse(http.StatusBadRequest, err)
+		}
+		// the metadata and the status of a resource accepted by an older version of the rules
+		// have to stay updatable
+		if reflect.DeepEqual(old.Spec, cr.Spec) {
+			return admission.ValidationResponse(true, "")
+		}
+		old.SetDefaults()
+	}
+	cr.SetDefaults()
+
+	allErrs := ValidateCreate(cr)
+	if old != nil {
+		allErrs = ValidateUpdate(old, cr)
+	}
+	if len(allErrs) > 0 {
+		return admission.ValidationResponse(false, allErrs.ToAggregate().Error())
+	}
+	return admission.ValidationResponse(true, "")
+}
+
+// Defaulter is the admission handler filling in the defaults of RabbitMQ resources
+type Defaulter struct {
+	decoder types.Decoder
+}
+
+var _ admission.Handler = &Defaulter{}
+var _ inject.Decoder = &Defaulter{}
+
+// InjectDecoder injects the decoder of the admission requests
+func (d *Defaulter) InjectDecoder(decoder types.Decoder) error {
+	d.decoder = decoder
+	return nil
+}
+
+// Handle responds with the patch setting the defaults of the RabbitMQ resource of the request
+func (d *Defaulter) Handle(ctx context.Context, req types.Request) types.Response {
+	cr := &rabbitmqv1alpha1.RabbitMQ{}
+	if err := d.decoder.Decode(req, cr); err != nil {
+		return admission.ErrorResponse(http.StatusBadRequest, err)
+	}
+	defaulted := cr.DeepCopy()
+	defaulted.SetDefaults()
+	return admission.PatchResponse(cr, defaulted)
+}
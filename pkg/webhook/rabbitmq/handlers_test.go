@@ -0,0 +1,121 @@
+package rabbitmq
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/toha10/rabbitmq-operator/pkg/apis"
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission/types"
+)
+
+// newTestDecoder returns the decoder the webhook server injects into the handlers
+func newTestDecoder(t *testing.T) types.Decoder {
+	s := runtime.NewScheme()
+	if err := apis.AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	decoder, err := admission.NewDecoder(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decoder
+}
+
+// newTestRequest returns the admission request of the operation on the resource
+func newTestRequest(t *testing.T, operation admissionv1beta1.Operation, old, cr *rabbitmqv1alpha1.RabbitMQ) types.Request {
+	cr.APIVersion = rabbitmqv1alpha1.SchemeGroupVersion.String()
+	cr.Kind = "RabbitMQ"
+	req := &admissionv1beta1.AdmissionRequest{Operation: operation}
+	var err error
+	if req.Object.Raw, err = json.Marshal(cr); err != nil {
+		t.Fatal(err)
+	}
+	if old != nil {
+		if req.OldObject.Raw, err = json.Marshal(old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return types.Request{AdmissionRequest: req}
+}
+
+func TestValidator(t *testing.T) {
+	v := &Validator{}
+	if err := v.InjectDecoder(newTestDecoder(t)); err != nil {
+		t.Fatal(err)
+	}
+	// the spec as written by the user, without the defaults
+	undefaulted := func(replicas int32) *rabbitmqv1alpha1.RabbitMQ {
+		return &rabbitmqv1alpha1.RabbitMQ{
+			ObjectMeta: metav1.ObjectMeta{Name: "rmq", Namespace: "ns"},
+			Spec:       rabbitmqv1alpha1.RabbitMQSpec{Replicas: replicas, DiscoveryService: "rmq-client"},
+		}
+	}
+	deleted := undefaulted(1)
+	now := metav1.Now()
+	deleted.DeletionTimestamp = &now
+	// a spec refused by the rules, accepted before they were introduced
+	invalid := func() *rabbitmqv1alpha1.RabbitMQ {
+		cr := undefaulted(3)
+		cr.Spec.DiscoveryService = cr.Name
+		return cr
+	}
+	labelled := invalid()
+	labelled.Labels = map[string]string{"team": "billing"}
+	rescaled := invalid()
+	rescaled.Spec.Replicas = 4
+
+	tests := []struct {
+		name      string
+		operation admissionv1beta1.Operation
+		old, cr   *rabbitmqv1alpha1.RabbitMQ
+		allowed   bool
+	}{
+		{"create", admissionv1beta1.Create, nil, undefaulted(3), true},
+		{"update", admissionv1beta1.Update, undefaulted(3), undefaulted(2), true},
+		{"unsafe scale-down", admissionv1beta1.Update, undefaulted(5), undefaulted(2), false},
+		{"deleted", admissionv1beta1.Update, undefaulted(5), deleted, true},
+		{"spec unchanged", admissionv1beta1.Update, invalid(), labelled, true},
+		{"spec changed", admissionv1beta1.Update, invalid(), rescaled, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := v.Handle(context.TODO(), newTestRequest(t, tt.operation, tt.old, tt.cr))
+			if resp.Response.Allowed != tt.allowed {
+				t.Errorf("got allowed %v, want %v: %v", resp.Response.Allowed, tt.allowed, resp.Response.Result)
+			}
+		})
+	}
+}
+
+func TestDefaulter(t *testing.T) {
+	d := &Defaulter{}
+	if err := d.InjectDecoder(newTestDecoder(t)); err != nil {
+		t.Fatal(err)
+	}
+	cr := &rabbitmqv1alpha1.RabbitMQ{
+		ObjectMeta: metav1.ObjectMeta{Name: "rmq", Namespace: "ns"},
+		Spec:       rabbitmqv1alpha1.RabbitMQSpec{Replicas: 3, Image: "rabbitmq:3.7.18"},
+	}
+	resp := d.Handle(context.TODO(), newTestRequest(t, admissionv1beta1.Create, nil, cr))
+	if !resp.Response.Allowed {
+		t.Fatalf("request refused: %v", resp.Response.Result)
+	}
+	patched := map[string]bool{}
+	for _, p := range resp.Patches {
+		patched[p.Path] = true
+	}
+	for _, path := range []string{"/spec/service_account", "/spec/data_volume_size", "/spec/persistence"} {
+		if !patched[path] {
+			t.Errorf("no patch of %s in %v", path, resp.Patches)
+		}
+	}
+	if patched["/spec/image"] {
+		t.Errorf("the image of the spec has been replaced")
+	}
+}
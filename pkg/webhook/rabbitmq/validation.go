@@ -0,0 +1,51 @@
+package rabbitmq
+
+import (
+	"fmt"
+
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	"github.com/toha10/rabbitmq-operator/pkg/controller/rabbitmq"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// ValidateCreate checks the rules of a new RabbitMQ resource the CRD schema can't express.
+// The defaults are expected to be applied already.
+func ValidateCreate(cr *rabbitmqv1alpha1.RabbitMQ) field.ErrorList {
+	allErrs := field.ErrorList{}
+	specPath := field.NewPath("spec")
+
+	if cr.Spec.DiscoveryService == cr.Name {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("discovery_service"), cr.Spec.DiscoveryService,
+			"clashes with the headless service of the cluster, which is named after the resource"))
+	}
+	if err := rabbitmq.ValidateConfig(cr); err != nil {
+		allErrs = append(allErrs, field.Invalid(specPath, "", err.Error()))
+	}
+	return allErrs
+}
+
+// ValidateUpdate checks the change of a RabbitMQ resource. The changes the operator can't carry
+// out safely are refused: the data volumes can't shrink, the client Service can't be replaced,
+// a scale-down can remove at most half of the members and the image can only move along a
+// supported upgrade path.
+func ValidateUpdate(old, cr *rabbitmqv1alpha1.RabbitMQ) field.ErrorList {
+	allErrs := ValidateCreate(cr)
+	specPath := field.NewPath("spec")
+
+	if cr.Spec.DiscoveryService != old.Spec.DiscoveryService {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("discovery_service"), "field is immutable"))
+	}
+	if cr.Spec.DataVolumeSize.Cmp(old.Spec.DataVolumeSize) < 0 {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("data_volume_size"),
+			fmt.Sprintf("data volumes can't shrink from %s", old.Spec.DataVolumeSize.String())))
+	}
+	// the members are removed one by one, at most half of them, rounded down, go in a single change
+	if keep := (old.Spec.Replicas + 1) / 2; cr.Spec.Replicas < keep {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("replicas"), cr.Spec.Replicas,
+			fmt.Sprintf("scaling down from %d members has to keep at least %d of them, scale down in several steps", old.Spec.Replicas, keep)))
+	}
+	if err := rabbitmq.ValidateImageChange(old.Spec.Image, cr.Spec.Image); err != nil {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("image"), cr.Spec.Image, err.Error()))
+	}
+	return allErrs
+}
@@ -0,0 +1,102 @@
+package rabbitmq
+
+import (
+	"strings"
+	"testing"
+
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newTestCluster(replicas int32) *rabbitmqv1alpha1.RabbitMQ {
+	cr := &rabbitmqv1alpha1.RabbitMQ{
+		ObjectMeta: metav1.ObjectMeta{Name: "rmq", Namespace: "ns"},
+		Spec: rabbitmqv1alpha1.RabbitMQSpec{
+			Replicas:         replicas,
+			Image:            "rabbitmq:3.7.18",
+			DiscoveryService: "rmq-client",
+		},
+	}
+	cr.SetDefaults()
+	return cr
+}
+
+func TestValidateCreate(t *testing.T) {
+	tests := []struct {
+		name string
+		spec func(cr *rabbitmqv1alpha1.RabbitMQ)
+		// field is the path of the invalid field, empty for a valid resource
+		field string
+	}{
+		{"valid", func(cr *rabbitmqv1alpha1.RabbitMQ) {}, ""},
+		{"discovery service named after the resource", func(cr *rabbitmqv1alpha1.RabbitMQ) {
+			cr.Spec.DiscoveryService = "rmq"
+		}, "spec.discovery_service"},
+		{"protected configuration", func(cr *rabbitmqv1alpha1.RabbitMQ) {
+			cr.Spec.AdditionalConfig = "cluster_formation.peer_discovery_backend = classic"
+		}, "spec"},
+		{"invalid plugin", func(cr *rabbitmqv1alpha1.RabbitMQ) {
+			cr.Spec.Plugins = []string{"rabbitmq_top]."}
+		}, "spec"},
+		{"unbalanced advanced configuration", func(cr *rabbitmqv1alpha1.RabbitMQ) {
+			cr.Spec.AdvancedConfig = "[{rabbit, []}."
+		}, "spec"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := newTestCluster(3)
+			tt.spec(cr)
+			checkErrors(t, ValidateCreate(cr).ToAggregate(), tt.field)
+		})
+	}
+}
+
+func TestValidateUpdate(t *testing.T) {
+	tests := []struct {
+		name     string
+		replicas int32
+		update   func(cr *rabbitmqv1alpha1.RabbitMQ)
+		field    string
+	}{
+		{"unchanged", 3, func(cr *rabbitmqv1alpha1.RabbitMQ) {}, ""},
+		{"scale up", 3, func(cr *rabbitmqv1alpha1.RabbitMQ) { cr.Spec.Replicas = 7 }, ""},
+		{"scale down from 2 to 1", 2, func(cr *rabbitmqv1alpha1.RabbitMQ) { cr.Spec.Replicas = 1 }, ""},
+		{"scale down from 3 to 2", 3, func(cr *rabbitmqv1alpha1.RabbitMQ) { cr.Spec.Replicas = 2 }, ""},
+		{"scale down from 3 to 1", 3, func(cr *rabbitmqv1alpha1.RabbitMQ) { cr.Spec.Replicas = 1 }, "spec.replicas"},
+		{"scale down from 4 to 2", 4, func(cr *rabbitmqv1alpha1.RabbitMQ) { cr.Spec.Replicas = 2 }, ""},
+		{"scale down from 4 to 1", 4, func(cr *rabbitmqv1alpha1.RabbitMQ) { cr.Spec.Replicas = 1 }, "spec.replicas"},
+		{"scale down from 5 to 3", 5, func(cr *rabbitmqv1alpha1.RabbitMQ) { cr.Spec.Replicas = 3 }, ""},
+		{"scale down from 5 to 2", 5, func(cr *rabbitmqv1alpha1.RabbitMQ) { cr.Spec.Replicas = 2 }, "spec.replicas"},
+		{"patch upgrade", 3, func(cr *rabbitmqv1alpha1.RabbitMQ) { cr.Spec.Image = "rabbitmq:3.7.19" }, ""},
+		{"minor upgrade", 3, func(cr *rabbitmqv1alpha1.RabbitMQ) { cr.Spec.Image = "rabbitmq:3.8.2" }, ""},
+		{"minor version skipped", 3, func(cr *rabbitmqv1alpha1.RabbitMQ) { cr.Spec.Image = "rabbitmq:3.9.0" }, "spec.image"},
+		{"downgrade", 3, func(cr *rabbitmqv1alpha1.RabbitMQ) { cr.Spec.Image = "rabbitmq:3.7.17" }, "spec.image"},
+		{"image without a version", 3, func(cr *rabbitmqv1alpha1.RabbitMQ) { cr.Spec.Image = "example.com/rabbitmq:stable" }, ""},
+		{"discovery service changed", 3, func(cr *rabbitmqv1alpha1.RabbitMQ) { cr.Spec.DiscoveryService = "amqp" }, "spec.discovery_service"},
+		{"data volumes grown", 3, func(cr *rabbitmqv1alpha1.RabbitMQ) { cr.Spec.DataVolumeSize = resource.MustParse("2Gi") }, ""},
+		{"data volumes shrunk", 3, func(cr *rabbitmqv1alpha1.RabbitMQ) { cr.Spec.DataVolumeSize = resource.MustParse("512Mi") }, "spec.data_volume_size"},
+		{"invalid new spec", 3, func(cr *rabbitmqv1alpha1.RabbitMQ) { cr.Spec.AdvancedConfig = "[" }, "spec"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := newTestCluster(tt.replicas)
+			cr := old.DeepCopy()
+			tt.update(cr)
+			checkErrors(t, ValidateUpdate(old, cr).ToAggregate(), tt.field)
+		})
+	}
+}
+
+// checkErrors checks that the validation has failed on the field only, or has passed if field is empty
+func checkErrors(t *testing.T, err error, field string) {
+	t.Helper()
+	switch {
+	case field == "" && err != nil:
+		t.Errorf("got error %v", err)
+	case field != "" && err == nil:
+		t.Errorf("no error for %s", field)
+	case field != "" && !strings.HasPrefix(err.Error(), field+":"):
+		t.Errorf("got error %v, want an error for %s", err, field)
+	}
+}
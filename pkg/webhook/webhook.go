@@ -0,0 +1,124 @@
+// Package webhook serves the admission webhooks of the operator
+package webhook
+
+import (
+	rabbitmqv1alpha1 "github.com/toha10/rabbitmq-operator/pkg/apis/rabbitmq/v1alpha1"
+	"github.com/toha10/rabbitmq-operator/pkg/controller/managed"
+	"github.com/toha10/rabbitmq-operator/pkg/webhook/objects"
+	"github.com/toha10/rabbitmq-operator/pkg/webhook/rabbitmq"
+	admissionregistrationv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission/builder"
+)
+
+const (
+	serverName = "rabbitmq-operator-webhook"
+	// certDir is where the server keeps the certificates it generates
+	certDir = "/tmp/rabbitmq-operator-webhook-certs"
+)
+
+// objectKinds are the resources declaring objects inside the clusters, their changes are validated too
+var objectKinds = []struct {
+	// kind is the lower-case name of the kind, the path of the webhook
+	kind string
+	// resource is the plural name of the kind, the webhook is named after it
+	resource  string
+	newObject func() managed.Object
+}{
+	{"rabbitmqvhost", "rabbitmqvhosts", func() managed.Object { return &rabbitmqv1alpha1.RabbitMQVhost{} }},
+	{"rabbitmquser", "rabbitmqusers", func() managed.Object { return &rabbitmqv1alpha1.RabbitMQUser{} }},
+	{"rabbitmqpolicy", "rabbitmqpolicies", func() managed.Object { return &rabbitmqv1alpha1.RabbitMQPolicy{} }},
+	{"rabbitmqoperatorpolicy", "rabbitmqoperatorpolicies", func() managed.Object { return &rabbitmqv1alpha1.RabbitMQOperatorPolicy{} }},
+	{"rabbitmqqueue", "rabbitmqqueues", func() managed.Object { return &rabbitmqv1alpha1.RabbitMQQueue{} }},
+	{"rabbitmqexchange", "rabbitmqexchanges", func() managed.Object { return &rabbitmqv1alpha1.RabbitMQExchange{} }},
+	{"rabbitmqbinding", "rabbitmqbindings", func() managed.Object { return &rabbitmqv1alpha1.RabbitMQBinding{} }},
+	{"rabbitmqshovel", "rabbitmqshovels", func() managed.Object { return &rabbitmqv1alpha1.RabbitMQShovel{} }},
+	{"rabbitmqfederationupstream", "rabbitmqfederationupstreams", func() managed.Object { return &rabbitmqv1alpha1.RabbitMQFederationUpstream{} }},
+}
+
+// Options configure the webhook server
+type Options struct {
+	// Port is the port the server listens on
+	Port int32
+	// Namespace is the namespace of the operator, the Service of the webhooks and
+	// the Secret with their certificates are created there
+	Namespace string
+	// Selector selects the pods of the operator behind the Service of the webhooks
+	Selector map[string]string
+}
+
+// AddToManager adds the webhook server to the Manager. The server generates its own certificates,
+// keeps them in a Secret and registers the webhook configurations with the CA bundle.
+func AddToManager(mgr manager.Manager, opts Options) error {
+	operations := []admissionregistrationv1beta1.OperationType{
+		admissionregistrationv1beta1.Create,
+		admissionregistrationv1beta1.Update,
+	}
+	validating, err := builder.NewWebhookBuilder().
+		Name("validating.rabbitmqs.rabbitmq.mirantis.com").
+		Path("/validate-rabbitmq").
+		Validating().
+		Operations(operations...).
+		FailurePolicy(admissionregistrationv1beta1.Fail).
+		WithManager(mgr).
+		ForType(&rabbitmqv1alpha1.RabbitMQ{}).
+		Handlers(&rabbitmq.Validator{}).
+		Build()
+	if err != nil {
+		return err
+	}
+	mutating, err := builder.NewWebhookBuilder().
+		Name("mutating.rabbitmqs.rabbitmq.mirantis.com").
+		Path("/mutate-rabbitmq").
+		Mutating().
+		Operations(operations...).
+		FailurePolicy(admissionregistrationv1beta1.Fail).
+		WithManager(mgr).
+		ForType(&rabbitmqv1alpha1.RabbitMQ{}).
+		Handlers(&rabbitmq.Defaulter{}).
+		Build()
+	if err != nil {
+		return err
+	}
+	webhooks := []webhook.Webhook{validating, mutating}
+	for _, k := range objectKinds {
+		wh, err := builder.NewWebhookBuilder().
+			Name("validating." + k.resource + ".rabbitmq.mirantis.com").
+			Path("/validate-" + k.kind).
+			Validating().
+			Operations(admissionregistrationv1beta1.Update).
+			FailurePolicy(admissionregistrationv1beta1.Fail).
+			WithManager(mgr).
+			ForType(k.newObject()).
+			Handlers(objects.NewValidator(k.newObject)).
+			Build()
+		if err != nil {
+			return err
+		}
+		webhooks = append(webhooks, wh)
+	}
+
+	server, err := webhook.NewServer(serverName, mgr, webhook.ServerOptions{
+		Port:    opts.Port,
+		CertDir: certDir,
+		BootstrapOptions: &webhook.BootstrapOptions{
+			MutatingWebhookConfigName:   "rabbitmq-operator-mutating",
+			ValidatingWebhookConfigName: "rabbitmq-operator-validating",
+			Secret: &apitypes.NamespacedName{
+				Namespace: opts.Namespace,
+				Name:      serverName + "-cert",
+			},
+			Service: &webhook.Service{
+				Namespace: opts.Namespace,
+				Name:      serverName,
+				Selectors: opts.Selector,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return server.Register(webhooks...)
+}